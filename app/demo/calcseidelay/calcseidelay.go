@@ -0,0 +1,409 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// 测量lalserver各协议输出的延迟。
+//
+// lalserver需开启配置 debug.latency_sei_enable ，lalserver会在视频帧输入时注入记录了本地时间的SEI，
+// 本程序同时从多路（不同协议的）输出拉流，读取SEI，与本地时间比较，分别统计每路的延迟：
+// - ingest: lalserver收到该帧 -> 本程序收到该帧
+// - capture: 该帧的采集时间 -> 本程序收到该帧，只有输入流能提供采集时间时才有（比如rtsp输入流收到了RTCP SR），也即端到端延迟
+//
+// 注意，如果本程序和lalserver（或采集端）不在同一台机器，需要保证机器间时钟同步。
+//
+// 支持的拉流协议：rtmp, httpflv, httpts, rtsp, hls
+//
+
+type PullType int
+
+const (
+	PullTypeUnknown PullType = iota
+	PullTypeRtmp
+	PullTypeHttpflv
+	PullTypeHttpts
+	PullTypeRtsp
+	PullTypeHls
+)
+
+func (pt PullType) Readable() string {
+	switch pt {
+	case PullTypeUnknown:
+		return "unknown"
+	case PullTypeRtmp:
+		return "rtmp"
+	case PullTypeHttpflv:
+		return "httpflv"
+	case PullTypeHttpts:
+		return "httpts"
+	case PullTypeRtsp:
+		return "rtsp"
+	case PullTypeHls:
+		return "hls"
+	}
+
+	// never reach here
+	return "fxxk"
+}
+
+func parsePullType(u string) PullType {
+	switch {
+	case strings.HasPrefix(u, "rtmp"):
+		return PullTypeRtmp
+	case strings.HasPrefix(u, "rtsp"):
+		return PullTypeRtsp
+	case strings.HasSuffix(u, ".flv"):
+		return PullTypeHttpflv
+	case strings.HasSuffix(u, ".ts"):
+		return PullTypeHttpts
+	case strings.HasSuffix(u, ".m3u8"):
+		return PullTypeHls
+	}
+	return PullTypeUnknown
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type DelayStat struct {
+	url      string
+	pullType PullType
+
+	mu            sync.Mutex
+	ingestDelays  []int64
+	captureDelays []int64
+}
+
+func (s *DelayStat) OnLatencySei(info remux.LatencySeiInfo) {
+	now := time.Now().UnixNano() / 1e6
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ingestDelays = append(s.ingestDelays, now-info.IngestUnixMs)
+	if info.CaptureUnixMs != 0 {
+		s.captureDelays = append(s.captureDelays, now-info.CaptureUnixMs)
+	}
+}
+
+func (s *DelayStat) OnRtmpMsg(msg base.RtmpMsg) {
+	if msg.Header.MsgTypeId != base.RtmpTypeIdVideo || len(msg.Payload) < 5 || msg.Payload[1] != base.RtmpAvcPacketTypeNalu {
+		return
+	}
+	nals, err := avc.SplitNaluAvcc(msg.Payload[5:])
+	if err != nil {
+		return
+	}
+	if info, ok := remux.FindLatencySei(nals, msg.VideoCodecId() == base.RtmpCodecIdHevc); ok {
+		s.OnLatencySei(info)
+	}
+}
+
+func (s *DelayStat) OnAnnexbFrame(b []byte) {
+	nals, err := avc.SplitNaluAnnexb(b)
+	if err != nil {
+		return
+	}
+	// h264和h265的SEI nalu header不会互相误判，所以两种都尝试一下
+	if info, ok := remux.FindLatencySei(nals, false); ok {
+		s.OnLatencySei(info)
+	} else if info, ok = remux.FindLatencySei(nals, true); ok {
+		s.OnLatencySei(info)
+	}
+}
+
+func (s *DelayStat) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprintf("%-7s ingest(%s) capture(%s) url=%s",
+		s.pullType.Readable(), summary(s.ingestDelays), summary(s.captureDelays), s.url)
+}
+
+func summary(delays []int64) string {
+	if len(delays) == 0 {
+		return "n=0"
+	}
+	sorted := make([]int64, len(delays))
+	copy(sorted, delays)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum int64
+	for _, d := range sorted {
+		sum += d
+	}
+	return fmt.Sprintf("n=%d, avg=%d, min=%d, p50=%d, p90=%d, max=%d",
+		len(sorted), sum/int64(len(sorted)), sorted[0], sorted[len(sorted)/2], sorted[len(sorted)*9/10], sorted[len(sorted)-1])
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// tsVideoDemuxer 从ts流中取出视频PES，只支持lal生成的ts（视频固定为 mpegts.PidVideo ）
+type tsVideoDemuxer struct {
+	onFrame func(b []byte)
+
+	buf []byte
+	pes []byte
+}
+
+func (d *tsVideoDemuxer) Feed(b []byte) {
+	d.buf = append(d.buf, b...)
+	for len(d.buf) >= 188 {
+		if d.buf[0] != 0x47 {
+			// 重新同步
+			d.buf = d.buf[1:]
+			continue
+		}
+		d.feedPacket(d.buf[:188])
+		d.buf = d.buf[188:]
+	}
+}
+
+func (d *tsVideoDemuxer) feedPacket(packet []byte) {
+	h := mpegts.ParseTsPacketHeader(packet)
+	if h.Pid != mpegts.PidVideo {
+		return
+	}
+	index := 4
+	switch h.Adaptation {
+	case mpegts.AdaptationFieldControlNo:
+	case mpegts.AdaptationFieldControlFollowed:
+		index += 1 + int(mpegts.ParseTsPacketAdaptation(packet[4:]).Length)
+	default:
+		return
+	}
+	if index >= len(packet) {
+		return
+	}
+	if h.PayloadUnitStart == 1 {
+		d.flush()
+	}
+	d.pes = append(d.pes, packet[index:]...)
+}
+
+func (d *tsVideoDemuxer) flush() {
+	if len(d.pes) > 19 {
+		_, length := mpegts.ParsePes(d.pes)
+		if length < len(d.pes) {
+			d.onFrame(d.pes[length:])
+		}
+	}
+	d.pes = d.pes[:0]
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func pullRtmp(s *DelayStat) error {
+	session := rtmp.NewPullSession().WithOnReadRtmpAvMsg(s.OnRtmpMsg)
+	if err := session.Pull(s.url); err != nil {
+		return err
+	}
+	return <-session.WaitChan()
+}
+
+func pullHttpflv(s *DelayStat) error {
+	session := httpflv.NewPullSession()
+	if err := session.Pull(s.url, func(tag httpflv.Tag) {
+		s.OnRtmpMsg(remux.FlvTag2RtmpMsg(tag))
+	}); err != nil {
+		return err
+	}
+	return <-session.WaitChan()
+}
+
+func pullRtsp(s *DelayStat) error {
+	remuxer := remux.NewAvPacket2RtmpRemuxer().WithOnRtmpMsg(s.OnRtmpMsg)
+	session := rtsp.NewPullSession(remuxer, func(option *rtsp.PullSessionOption) {
+		option.PullTimeoutMs = 5000
+	})
+	if err := session.Pull(s.url); err != nil {
+		return err
+	}
+	return <-session.WaitChan()
+}
+
+func pullHttpts(s *DelayStat) error {
+	resp, err := http.Get(s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	d := &tsVideoDemuxer{onFrame: s.OnAnnexbFrame}
+	buf := make([]byte, 188*64)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			d.Feed(buf[:n])
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func pullHls(s *DelayStat) error {
+	m3u8Url, err := url.Parse(s.url)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	d := &tsVideoDemuxer{onFrame: s.OnAnnexbFrame}
+	for {
+		resp, err := http.Get(s.url)
+		if err != nil {
+			return err
+		}
+		var tsUrls []string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			ref, err := url.Parse(line)
+			if err != nil {
+				continue
+			}
+			tsUrls = append(tsUrls, m3u8Url.ResolveReference(ref).String())
+		}
+		resp.Body.Close()
+
+		// 第一次拉取m3u8时，只拉取最新的ts，避免旧ts带来的额外延迟
+		if len(seen) == 0 && len(tsUrls) > 1 {
+			for _, tsUrl := range tsUrls[:len(tsUrls)-1] {
+				seen[tsUrl] = true
+			}
+		}
+		for _, tsUrl := range tsUrls {
+			if seen[tsUrl] {
+				continue
+			}
+			seen[tsUrl] = true
+			tsResp, err := http.Get(tsUrl)
+			if err != nil {
+				nazalog.Warnf("get ts failed. url=%s, err=%+v", tsUrl, err)
+				continue
+			}
+			b, err := ioutil.ReadAll(tsResp.Body)
+			tsResp.Body.Close()
+			if err != nil {
+				nazalog.Warnf("read ts failed. url=%s, err=%+v", tsUrl, err)
+				continue
+			}
+			d.Feed(b)
+			d.flush()
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func main() {
+	_ = nazalog.Init(func(option *nazalog.Option) {
+		option.AssertBehavior = nazalog.AssertFatal
+	})
+	defer nazalog.Sync()
+	base.LogoutStartInfo()
+
+	urls, durationSec := parseFlag()
+
+	var stats []*DelayStat
+	for _, u := range urls {
+		s := &DelayStat{
+			url:      u,
+			pullType: parsePullType(u),
+		}
+		stats = append(stats, s)
+
+		go func() {
+			var err error
+			switch s.pullType {
+			case PullTypeRtmp:
+				err = pullRtmp(s)
+			case PullTypeHttpflv:
+				err = pullHttpflv(s)
+			case PullTypeHttpts:
+				err = pullHttpts(s)
+			case PullTypeRtsp:
+				err = pullRtsp(s)
+			case PullTypeHls:
+				err = pullHls(s)
+			}
+			nazalog.Infof("pull done. type=%s, url=%s, err=%+v", s.pullType.Readable(), s.url, err)
+		}()
+	}
+
+	start := time.Now()
+	for {
+		time.Sleep(5 * time.Second)
+		for _, s := range stats {
+			nazalog.Infof("delay. %s", s.String())
+		}
+		if durationSec > 0 && time.Since(start) >= time.Duration(durationSec)*time.Second {
+			break
+		}
+	}
+}
+
+type urlList []string
+
+func (l *urlList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *urlList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func parseFlag() (urls []string, durationSec int) {
+	var i urlList
+	flag.Var(&i, "i", "specify pull url, can be specified multiple times. rtmp/httpflv/httpts/rtsp/hls")
+	d := flag.Int("d", 0, "specify duration in seconds, 0 means forever")
+	flag.Parse()
+	valid := len(i) > 0
+	for _, u := range i {
+		if parsePullType(u) == PullTypeUnknown {
+			valid = false
+		}
+	}
+	if !valid {
+		flag.Usage()
+		_, _ = fmt.Fprintf(os.Stderr, `Example:
+  %s -i rtmp://127.0.0.1:1935/live/test110 -i http://127.0.0.1:8080/live/test110.flv -i http://127.0.0.1:8080/live/test110.ts -i rtsp://127.0.0.1:5544/live/test110 -i http://127.0.0.1:8080/hls/test110.m3u8 -d 60
+`, os.Args[0])
+		base.OsExitAndWaitPressIfWindows(1)
+	}
+	return i, *d
+}
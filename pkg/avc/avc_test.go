@@ -354,3 +354,34 @@ func TestTryParseSeqHeader(t *testing.T) {
 	err = avc.TryParseSeqHeader(payload)
 	assert.Equal(t, nil, err)
 }
+
+func TestSei(t *testing.T) {
+	uuid := [avc.SeiUuidLen]byte{0xdc, 0x45, 0xe9, 0xbd, 0xe6, 0xd9, 0x48, 0xb7, 0x96, 0x2c, 0xd8, 0x20, 0xd9, 0x23, 0xee, 0xef}
+	// 包含需要插入防竞争字节的数据，以及长度超过255的数据
+	data := append([]byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x00}, bytes.Repeat([]byte{0xab}, 300)...)
+	msgs := []avc.SeiMessage{
+		avc.BuildUserDataUnregistered(uuid, data),
+		{PayloadType: avc.SeiPayloadTypeUserDataRegisteredItuTT35, Payload: []byte{0xb5, 0x00, 0x31}},
+	}
+
+	nalu := avc.BuildSei(msgs)
+	assert.Equal(t, avc.NaluTypeSei, avc.ParseNaluType(nalu[0]))
+	assert.Equal(t, -1, bytes.Index(nalu, []byte{0x00, 0x00, 0x01}))
+
+	ret, err := avc.ParseSei(nalu)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, msgs, ret)
+
+	uuid2, data2, err := avc.ParseUserDataUnregistered(ret[0].Payload)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uuid, uuid2)
+	assert.Equal(t, data, data2)
+
+	// x264生成的sei的开头部分，数据被截断，所以返回错误
+	golden, _ := hex.DecodeString("0605ffff40dc45e9bde6d948b7962cd820d923eeef78323634")
+	ret, err = avc.ParseSei(golden)
+	assert.IsNotNil(t, err)
+
+	assert.Equal(t, []byte{0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x03, 0x00}, avc.Rbsp2Nal([]byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x00}))
+	assert.Equal(t, []byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x00}, avc.Nal2Rbsp([]byte{0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x03, 0x00}))
+}
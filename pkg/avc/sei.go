@@ -0,0 +1,221 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package avc
+
+import (
+	"bytes"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazaerrors"
+)
+
+// SEI payload type
+//
+// ISO-14496-10.pdf
+// D.1 SEI payload syntax
+//
+const (
+	SeiPayloadTypeUserDataRegisteredItuTT35 = 4
+	SeiPayloadTypeUserDataUnregistered      = 5
+)
+
+const SeiUuidLen = 16
+
+// ATSC A/53 Part 4 (CEA-608/708字幕)
+//
+// user_data_registered_itu_t_t35:
+// itu_t_t35_country_code(0xB5) | itu_t_t35_provider_code(0x0031) | user_identifier("GA94") | user_data_type_code(0x03) | cc_data...
+//
+var a53CaptionPrefix = []byte{0xB5, 0x00, 0x31, 'G', 'A', '9', '4', 0x03}
+
+// SeiMessage 对应一个sei_message，h264和h265通用
+//
+type SeiMessage struct {
+	PayloadType int
+	Payload     []byte
+}
+
+// ParseSei 解析SEI nalu中的所有sei_message
+//
+// @param nalu: 不包含start code或长度前缀，包含1字节的nalu header
+//
+// @return 返回的内存块为内部独立新申请
+//
+func ParseSei(nalu []byte) ([]SeiMessage, error) {
+	if len(nalu) < 2 {
+		return nil, nazaerrors.Wrap(base.ErrShortBuffer)
+	}
+	if ParseNaluType(nalu[0]) != NaluTypeSei {
+		return nil, nazaerrors.Wrap(base.ErrAvc)
+	}
+	return ParseSeiRbsp(Nal2Rbsp(nalu[1:]))
+}
+
+// ParseSeiRbsp 解析sei_rbsp
+//
+// @param rbsp: 已去除nalu header以及防竞争字节
+//
+// @return 注意，返回的sei_message的Payload引用的是参数`rbsp`的内存块
+//
+func ParseSeiRbsp(rbsp []byte) (msgs []SeiMessage, err error) {
+	i := 0
+	for i < len(rbsp) {
+		// rbsp_trailing_bits
+		if rbsp[i] == 0x80 && i == len(rbsp)-1 {
+			break
+		}
+
+		payloadType, n := readSeiValue(rbsp[i:])
+		if n == 0 {
+			return nil, nazaerrors.Wrap(base.ErrShortBuffer)
+		}
+		i += n
+
+		payloadSize, n := readSeiValue(rbsp[i:])
+		if n == 0 {
+			return nil, nazaerrors.Wrap(base.ErrShortBuffer)
+		}
+		i += n
+
+		if i+payloadSize > len(rbsp) {
+			return nil, nazaerrors.Wrap(base.ErrShortBuffer)
+		}
+		msgs = append(msgs, SeiMessage{
+			PayloadType: payloadType,
+			Payload:     rbsp[i : i+payloadSize],
+		})
+		i += payloadSize
+	}
+	return msgs, nil
+}
+
+// BuildSei 将sei_message打包成SEI nalu
+//
+// @return 不包含start code或长度前缀，包含1字节的nalu header，已插入防竞争字节
+//
+func BuildSei(msgs []SeiMessage) []byte {
+	nal := Rbsp2Nal(BuildSeiRbsp(msgs))
+	out := make([]byte, 1+len(nal))
+	out[0] = NaluTypeSei
+	copy(out[1:], nal)
+	return out
+}
+
+// BuildSeiRbsp 将sei_message打包成sei_rbsp，包含rbsp_trailing_bits
+//
+func BuildSeiRbsp(msgs []SeiMessage) []byte {
+	var out []byte
+	for _, msg := range msgs {
+		out = appendSeiValue(out, msg.PayloadType)
+		out = appendSeiValue(out, len(msg.Payload))
+		out = append(out, msg.Payload...)
+	}
+	return append(out, 0x80)
+}
+
+// ParseUserDataUnregistered 解析user_data_unregistered类型的sei_message的payload
+//
+// @return data: 注意，引用的是参数`payload`的内存块
+//
+func ParseUserDataUnregistered(payload []byte) (uuid [SeiUuidLen]byte, data []byte, err error) {
+	if len(payload) < SeiUuidLen {
+		return uuid, nil, nazaerrors.Wrap(base.ErrShortBuffer)
+	}
+	copy(uuid[:], payload)
+	return uuid, payload[SeiUuidLen:], nil
+}
+
+// BuildUserDataUnregistered 构造user_data_unregistered类型的sei_message
+//
+func BuildUserDataUnregistered(uuid [SeiUuidLen]byte, data []byte) SeiMessage {
+	payload := make([]byte, SeiUuidLen+len(data))
+	copy(payload, uuid[:])
+	copy(payload[SeiUuidLen:], data)
+	return SeiMessage{
+		PayloadType: SeiPayloadTypeUserDataUnregistered,
+		Payload:     payload,
+	}
+}
+
+// IsA53CaptionSei sei_message是否是ATSC A/53格式的CEA-608/708字幕数据
+//
+func IsA53CaptionSei(msg SeiMessage) bool {
+	return msg.PayloadType == SeiPayloadTypeUserDataRegisteredItuTT35 && bytes.HasPrefix(msg.Payload, a53CaptionPrefix)
+}
+
+// Nal2Rbsp 去除防竞争字节，也即将`0x00 0x00 0x03`中的`0x03`去除
+//
+// @param nal: 调用方保证不包含start code或长度前缀
+//
+// @return 返回的内存块为内部独立新申请
+//
+func Nal2Rbsp(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeroCount := 0
+	for _, b := range nal {
+		if zeroCount >= 2 && b == 0x03 {
+			zeroCount = 0
+			continue
+		}
+		out = append(out, b)
+		if b == 0x00 {
+			zeroCount++
+		} else {
+			zeroCount = 0
+		}
+	}
+	return out
+}
+
+// Rbsp2Nal 插入防竞争字节，也即在`0x00 0x00`后面跟着`0x00`到`0x03`时，插入`0x03`
+//
+// @return 返回的内存块为内部独立新申请
+//
+func Rbsp2Nal(rbsp []byte) []byte {
+	out := make([]byte, 0, len(rbsp)+len(rbsp)/64)
+	zeroCount := 0
+	for _, b := range rbsp {
+		if zeroCount >= 2 && b <= 0x03 {
+			out = append(out, 0x03)
+			zeroCount = 0
+		}
+		out = append(out, b)
+		if b == 0x00 {
+			zeroCount++
+		} else {
+			zeroCount = 0
+		}
+	}
+	return out
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// readSeiValue 读取payloadType或payloadSize，也即若干个0xFF加上最后一个字节的累加值
+//
+// @return n: 读取的字节数，为0表示数据不足
+//
+func readSeiValue(b []byte) (v int, n int) {
+	for n < len(b) {
+		v += int(b[n])
+		n++
+		if b[n-1] != 0xFF {
+			return v, n
+		}
+	}
+	return 0, 0
+}
+
+func appendSeiValue(out []byte, v int) []byte {
+	for v >= 0xFF {
+		out = append(out, 0xFF)
+		v -= 0xFF
+	}
+	return append(out, uint8(v))
+}
@@ -53,6 +53,15 @@ type AvPacket struct {
 	Timestamp   int64 // 如无特殊说明，此字段是Dts
 	Pts         int64
 	Payload     []byte
+
+	// CaptureTimeMs 可选字段，帧的绝对采集时间，unix时间戳，单位毫秒。为0时表示未知
+	//
+	// 来源：
+	// - rtsp: 通过RTCP SR中NTP时间和RTP时间戳的对应关系计算得到，收到第一个SR之前为0
+	// - gb28181: 以第一个PS pack header中的SCR为参考点，映射到本地时间
+	// - customize pub: 由业务方填写
+	//
+	CaptureTimeMs int64
 }
 
 func (packet *AvPacket) IsAudio() bool {
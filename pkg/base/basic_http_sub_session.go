@@ -108,6 +108,8 @@ func (session *BasicHttpSubSession) StreamName() string {
 		suffix = ".flv"
 	case SessionTypeTsSub:
 		suffix = ".ts"
+	case SessionTypeFmp4Sub:
+		suffix = ".mp4"
 	default:
 		Log.Warnf("[%s] acquire stream name but protocol unknown.", session.UniqueKey())
 	}
@@ -84,6 +84,14 @@ func NewBasicSessionStat(sessionType SessionType, remoteAddr string) BasicSessio
 		s.stat.SessionId = GenUkPsPubSession()
 		s.stat.BaseType = SessionBaseTypePubStr
 		s.stat.Protocol = SessionProtocolPsStr
+	case SessionTypeHlsSub:
+		s.stat.SessionId = GenUkHlsSubSession()
+		s.stat.BaseType = SessionBaseTypeSubStr
+		s.stat.Protocol = SessionProtocolHlsStr
+	case SessionTypeFmp4Sub:
+		s.stat.SessionId = GenUkFmp4SubSession()
+		s.stat.BaseType = SessionBaseTypeSubStr
+		s.stat.Protocol = SessionProtocolFmp4Str
 	}
 	return s
 }
@@ -0,0 +1,63 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import "time"
+
+// CaptureClock 将流上的相对时间戳映射为绝对时间（unix时间戳，单位毫秒）
+//
+// 以某个时间戳和某个绝对时间作为参考点，之后的时间戳通过 参考点绝对时间 + (时间戳 - 参考点时间戳) 计算得到。
+// 也即绝对时间随着源端的时钟前进，而不是随着本地收到数据的时间前进，网络抖动不会影响计算结果。
+//
+// 参考点优先使用源端携带的绝对时间（比如rtmp的`onFI`），见 Anchor；源端没有携带时，见 Calc 的退化逻辑。
+//
+// 注意，非并发安全。
+//
+type CaptureClock struct {
+	anchored     bool
+	anchorTs     int64
+	anchorUnixMs int64
+}
+
+// Anchor 设置参考点
+//
+// @param ts:     流上的时间戳，单位毫秒
+// @param unixMs: ts对应的绝对时间，unix时间戳，单位毫秒
+//
+func (c *CaptureClock) Anchor(ts int64, unixMs int64) {
+	c.anchored = true
+	c.anchorTs = ts
+	c.anchorUnixMs = unixMs
+}
+
+func (c *CaptureClock) IsAnchored() bool {
+	return c.anchored
+}
+
+// Calc 计算时间戳对应的绝对时间
+//
+// 如果还没有设置过参考点，则退化为使用`ts`和当前本地时间作为参考点，
+// 此时计算结果是数据到达本地的时间，而不是源端的采集时间，之后调用 Anchor 可以修正参考点
+//
+// @param ts: 流上的时间戳，单位毫秒
+//
+// @return 绝对时间，unix时间戳，单位毫秒
+//
+func (c *CaptureClock) Calc(ts int64) int64 {
+	if !c.anchored {
+		c.Anchor(ts, time.Now().UnixNano()/1e6)
+	}
+	return c.anchorUnixMs + (ts - c.anchorTs)
+}
+
+// Reset 清除参考点，比如输入流的时间戳发生了跳变时
+//
+func (c *CaptureClock) Reset() {
+	c.anchored = false
+}
@@ -0,0 +1,34 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestCaptureClock(t *testing.T) {
+	var c CaptureClock
+	assert.Equal(t, false, c.IsAnchored())
+
+	c.Anchor(1000, 1660000000000)
+	assert.Equal(t, int64(1660000000000), c.Calc(1000))
+	assert.Equal(t, int64(1660000000040), c.Calc(1040))
+	assert.Equal(t, int64(1659999999960), c.Calc(960))
+
+	c.Reset()
+	assert.Equal(t, false, c.IsAnchored())
+	before := time.Now().UnixNano() / 1e6
+	v := c.Calc(5000)
+	after := time.Now().UnixNano() / 1e6
+	assert.Equal(t, true, v >= before && v <= after)
+	assert.Equal(t, v+100, c.Calc(5100))
+}
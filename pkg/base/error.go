@@ -37,15 +37,37 @@ var (
 	ErrSessionNotStarted = errors.New("lal.base: session has not been started yet")
 
 	ErrInvalidUrl = errors.New("lal.base: invalid url")
+
+	ErrDiskFreeNotSupported = errors.New("lal.base: get disk free space not supported on this platform")
 )
 
+// ----- pkg/dash ------------------------------------------------------------------------------------------------------
+
+var ErrDash = errors.New("lal.dash: fxxk")
+
+// ----- pkg/fmp4 ------------------------------------------------------------------------------------------------------
+
+var ErrFmp4 = errors.New("lal.fmp4: fxxk")
+
 // ----- pkg/hevc ------------------------------------------------------------------------------------------------------
 
 var ErrHevc = errors.New("lal.hevc: fxxk")
 
 // ----- pkg/hls -------------------------------------------------------------------------------------------------------
 
-var ErrHls = errors.New("lal.hls: fxxk")
+var (
+	ErrHls = errors.New("lal.hls: fxxk")
+
+	ErrHlsSubSessionKicked = errors.New("lal.hls: sub session has been kicked")
+)
+
+// ----- pkg/httpflv ---------------------------------------------------------------------------------------------------
+
+var ErrHttpflv = errors.New("lal.httpflv: fxxk")
+
+// ----- pkg/mpegts ----------------------------------------------------------------------------------------------------
+
+var ErrMpegts = errors.New("lal.mpegts: fxxk")
 
 // ----- pkg/rtmp ------------------------------------------------------------------------------------------------------
 
@@ -73,11 +95,30 @@ var (
 
 var ErrSdp = errors.New("lal.sdp: fxxk")
 
+// ----- pkg/storage ---------------------------------------------------------------------------------------------------
+
+var (
+	ErrStorage          = errors.New("lal.storage: fxxk")
+	ErrStorageQueueFull = errors.New("lal.storage: upload queue full")
+	ErrStorageDisposed  = errors.New("lal.storage: uploader disposed")
+)
+
 // ----- pkg/logic -------------------------------------------------------------------------------------------------------
 
 var (
 	ErrDupInStream = errors.New("lal.logic: in stream already exist at group")
 
+	ErrRecordNoInStream      = errors.New("lal.logic: record failed since group has no in stream")
+	ErrRecordInvalidFormat   = errors.New("lal.logic: record failed since format invalid")
+	ErrRecordAlreadyStarted  = errors.New("lal.logic: record already started")
+	ErrRecordNotStarted      = errors.New("lal.logic: record not started")
+	ErrRecordOpenFileFailed  = errors.New("lal.logic: record open file failed")
+	ErrRecordDiskLow         = errors.New("lal.logic: record refused since disk space low")
+	ErrRecordCatalogDisabled = errors.New("lal.logic: record catalog disabled")
+	ErrRecordNotFound        = errors.New("lal.logic: record not found")
+	ErrRecordExportEmpty     = errors.New("lal.logic: record export failed since no frame in time range")
+	ErrRecordExportNoFlv     = errors.New("lal.logic: record export failed since only flv records can be used as source, enable record flv")
+
 	ErrSimpleAuthParamNotFound = errors.New("lal.logic: simple auth failed since url param lal_secret not found")
 	ErrSimpleAuthFailed        = errors.New("lal.logic: simple auth failed since url param lal_secret invalid")
 )
@@ -32,14 +32,36 @@ type LalInfo struct {
 }
 
 type StatGroup struct {
-	StreamName  string    `json:"stream_name"`
-	AudioCodec  string    `json:"audio_codec"`
-	VideoCodec  string    `json:"video_codec"`
-	VideoWidth  int       `json:"video_width"`
-	VideoHeight int       `json:"video_height"`
-	StatPub     StatPub   `json:"pub"`
-	StatSubs    []StatSub `json:"subs"` // TODO(chef): [opt] 增加数量字段，因为这里不一定全部放入
-	StatPull    StatPull  `json:"pull"`
+	StreamName        string       `json:"stream_name"`
+	AudioCodec        string       `json:"audio_codec"`
+	VideoCodec        string       `json:"video_codec"`
+	VideoWidth        int          `json:"video_width"`
+	VideoHeight       int          `json:"video_height"`
+	HasClosedCaptions bool         `json:"has_closed_captions"` // 视频流中是否携带CEA-608/708字幕
+	StatPub           StatPub      `json:"pub"`
+	StatSubs          []StatSub    `json:"subs"` // TODO(chef): [opt] 增加数量字段，因为这里不一定全部放入
+	StatPull          StatPull     `json:"pull"`
+	StatRecords       []StatRecord `json:"records"` // 正在进行的录制
+}
+
+type StatRecord struct {
+	Format    string `json:"format"`     // RecordFormatFlv 等
+	Path      string `json:"path"`       // 正在写入的文件，hls为m3u8所在目录
+	StartTime string `json:"start_time"` // 开始写入该文件的时间，hls为开启录制的时间
+	OnDemand  bool   `json:"on_demand"`  // 是否通过http api开启
+}
+
+// RecordItem 录制目录中的一个录制文件，或者一个hls分片
+//
+type RecordItem struct {
+	AppName    string  `json:"app_name"`
+	StreamName string  `json:"stream_name"`
+	Format     string  `json:"format"`
+	Path       string  `json:"path"`
+	Size       int64   `json:"size"`       // 单位字节
+	Duration   float64 `json:"duration"`   // 单位秒
+	StartTime  string  `json:"start_time"` // 格式见 RecordTimeLayout
+	EndTime    string  `json:"end_time"`
 }
 
 type StatSession struct {
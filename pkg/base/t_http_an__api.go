@@ -44,6 +44,97 @@ type ApiCtrlStartRtpPubReq struct {
 	DebugDumpPacket string `json:"debug_dump_packet"`
 }
 
+// ApiCtrlSetHlsVariantGroupReq
+//
+// 设置HLS多码率分组，StreamNames为空时删除该分组
+//
+type ApiCtrlSetHlsVariantGroupReq struct {
+	Name        string   `json:"name"`
+	StreamNames []string `json:"stream_names"`
+}
+
+// ApiCtrlInjectTimedMetadataReq
+//
+// 向流中注入定时元数据，以ID3 TXXX帧的形式写入mpegts（hls、http-ts、ts录制），需要开启 hls.timed_metadata_enable
+//
+type ApiCtrlInjectTimedMetadataReq struct {
+	StreamName  string `json:"stream_name"`
+	Description string `json:"description"` // TXXX帧的描述，可以为空
+	Value       string `json:"value"`       // TXXX帧的内容，比如文本或json
+}
+
+// ApiCtrlSpliceReq
+//
+// 向流中插入SCTE-35广告信令，需要开启 hls.scte35_enable
+//
+type ApiCtrlSpliceReq struct {
+	StreamName   string `json:"stream_name"`
+	Command      string `json:"command"`        // SpliceCommandInsert 或 SpliceCommandTimeSignal ，为空时使用 SpliceCommandInsert
+	EventId      uint32 `json:"event_id"`       // splice_event_id
+	Pts          int64  `json:"pts"`            // 信令生效的时间点，单位90kHz，小于0表示立即，实际在该时间点之后的第一个视频关键帧处生效
+	DurationMs   int    `json:"duration_ms"`    // 广告时长，为0表示不指定
+	OutOfNetwork bool   `json:"out_of_network"` // true表示进入广告，false表示返回节目
+	AutoReturn   bool   `json:"auto_return"`    // 进入广告并且指定了广告时长时，到时后自动插入返回节目的信令
+}
+
+const (
+	SpliceCommandInsert     = "splice_insert"
+	SpliceCommandTimeSignal = "time_signal"
+)
+
+// ApiCtrlStartRecordReq
+//
+// 对一个正在输入的流开启录制，流的输入结束时录制也随之结束
+//
+type ApiCtrlStartRecordReq struct {
+	StreamName     string `json:"stream_name"`
+	Format         string `json:"format"`           // RecordFormatFlv RecordFormatMpegts RecordFormatMp4 RecordFormatHls
+	OutPath        string `json:"out_path"`         // 录制目录，为空时使用配置文件中对应格式的录制目录
+	MaxDurationSec int    `json:"max_duration_sec"` // 录制时长达到后自动停止，为0表示不自动停止
+}
+
+type ApiCtrlStopRecordReq struct {
+	StreamName string `json:"stream_name"`
+	Format     string `json:"format"`
+}
+
+// ApiRecordListReq
+//
+// 查询录制目录中的录制文件，通过url参数传入，所有字段都是可选的
+//
+type ApiRecordListReq struct {
+	StreamName string `json:"stream_name"`
+	Format     string `json:"format"`     // RecordFormatFlv RecordFormatMpegts RecordFormatMp4 RecordFormatHls
+	StartTime  string `json:"start_time"` // 与 StartTime 和 EndTime 有交集的文件，格式见 RecordTimeLayout
+	EndTime    string `json:"end_time"`
+}
+
+// ApiRecordExportReq
+//
+// 将一个时间段内的录制文件拼接为一个文件，不重新编码。
+// 以flv录制文件为源，从起始时间前最近的视频关键帧开始。
+//
+// 注意，只支持以flv录制文件为源（导出的格式可以是flv、ts、mp4），需要开启flv录制。
+// 时间段内只有ts、mp4录制文件时，返回 ErrorCodeRecordExportNoFlv
+//
+type ApiRecordExportReq struct {
+	StreamName string `json:"stream_name"`
+	Format     string `json:"format"` // 导出的文件格式，RecordFormatFlv RecordFormatMpegts RecordFormatMp4
+	StartTime  string `json:"start_time"`
+	EndTime    string `json:"end_time"`
+}
+
+// RecordTimeLayout 录制相关的时间的格式，本地时间
+//
+const RecordTimeLayout = "2006-01-02 15:04:05.999"
+
+const (
+	RecordFormatFlv    = "flv"
+	RecordFormatMpegts = "ts"
+	RecordFormatMp4    = "mp4"
+	RecordFormatHls    = "hls"
+)
+
 // ----- response ------------------------------------------------------------------------------------------------------
 
 const (
@@ -57,6 +148,15 @@ const (
 	ErrorCodeSessionNotFound = 1003
 	DespSessionNotFound      = "session not found"
 
+	ErrorCodeTimedMetadataNotReady = 1004
+	DespTimedMetadataNotReady      = "timed metadata disabled or stream not ready"
+	ErrorCodeSpliceNotReady        = 1005
+	DespSpliceNotReady             = "scte35 disabled or stream not ready"
+	ErrorCodeRecordFail            = 1006 // desp为具体的错误原因
+	ErrorCodeRecordNotFound        = 1007
+	DespRecordNotFound             = "record not found"
+	ErrorCodeRecordExportNoFlv     = 1008 // 导出录制片段时，时间段内有录制文件，但是没有可以作为源的flv录制文件，desp中包含找到的录制文件格式
+
 	ErrorCodeStartRelayPullFail = 2001
 	ErrorCodeListenUdpPortFail  = 2002
 )
@@ -110,3 +210,15 @@ type ApiCtrlStartRtpPub struct {
 		Port       int    `json:"port"`
 	}
 }
+
+type ApiRecordList struct {
+	HttpResponseBasic
+	Data struct {
+		Records []RecordItem `json:"records"`
+	} `json:"data"`
+}
+
+type ApiRecordExport struct {
+	HttpResponseBasic
+	Data *RecordItem `json:"data"`
+}
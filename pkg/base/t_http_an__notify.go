@@ -88,6 +88,49 @@ type HlsMakeTsInfo struct {
 	Duration       float64 `json:"duration"`
 }
 
+// RecordFileDoneInfo 一个录制文件写入完成
+//
+type RecordFileDoneInfo struct {
+	EventCommonInfo
+
+	AppName    string  `json:"app_name"`
+	StreamName string  `json:"stream_name"`
+	Format     string  `json:"format"`     // RecordFormatFlv RecordFormatMpegts RecordFormatMp4
+	Path       string  `json:"path"`       // 包含录制目录的文件名
+	Size       int64   `json:"size"`       // 单位字节
+	Duration   float64 `json:"duration"`   // 由音视频时间戳计算，单位秒
+	StartTime  string  `json:"start_time"` // 开始写入文件的时间
+	EndTime    string  `json:"end_time"`   // 文件写入完成的时间
+}
+
+// RecordDiskGuardInfo 录制目录所在磁盘的剩余空间低于水位线时暂停录制，恢复后继续录制
+//
+type RecordDiskGuardInfo struct {
+	EventCommonInfo
+
+	Event       string `json:"event"`        // "pause" 或 "resume"
+	Path        string `json:"path"`         // 剩余空间最少的录制目录
+	FreeMb      int64  `json:"free_mb"`      // 剩余空间，单位MB
+	WatermarkMb int64  `json:"watermark_mb"` // 水位线，单位MB
+}
+
+const (
+	RecordDiskGuardEventPause  = "pause"
+	RecordDiskGuardEventResume = "resume"
+)
+
+// OnvifMetadataInfo rtsp输入流中onvif metadata数据轨道的一个完整xml文档
+//
+type OnvifMetadataInfo struct {
+	EventCommonInfo
+
+	SessionId  string `json:"session_id"`
+	AppName    string `json:"app_name"`
+	StreamName string `json:"stream_name"`
+	Timestamp  int64  `json:"timestamp"` // 单位毫秒，由rtp时间戳换算而来
+	Metadata   string `json:"metadata"`  // xml文档
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func Session2PubStartInfo(session ISession) PubStartInfo {
@@ -91,6 +91,15 @@ type RtmpHeader struct {
 type RtmpMsg struct {
 	Header  RtmpHeader
 	Payload []byte // Payload不包含Header内容。如果需要将RtmpMsg序列化成RTMP chunk，可调用rtmp.ChunkDivider相关的函数
+
+	// CaptureTimeMs 可选字段，帧的绝对采集时间，unix时间戳，单位毫秒。为0时表示未知
+	//
+	// 注意，该字段不会序列化到RTMP chunk中，只在lal内部流转。
+	// 对于rtmp输入，以推流端的时间戳为准，通过 CaptureClock 映射为绝对时间：
+	// 推流端发送了`onFI`（携带推流端的系统时间）时，以`onFI`作为锚点；
+	// 否则退化为以第一个消息到达时的本地时间作为锚点，此时得到的时间包含了首个消息的网络传输延迟，并且依赖本地时钟
+	//
+	CaptureTimeMs int64
 }
 
 func (msg RtmpMsg) IsAvcKeySeqHeader() bool {
@@ -127,6 +136,7 @@ func (msg RtmpMsg) VideoCodecId() uint8 {
 
 func (msg RtmpMsg) Clone() (ret RtmpMsg) {
 	ret.Header = msg.Header
+	ret.CaptureTimeMs = msg.CaptureTimeMs
 	ret.Payload = make([]byte, len(msg.Payload))
 	copy(ret.Payload, msg.Payload)
 	return
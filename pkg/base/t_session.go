@@ -11,7 +11,7 @@ package base
 // ----- 所有session -----
 //
 // server.pub:  rtmp(ServerSession), rtsp(PubSession)
-// server.sub:  rtmp(ServerSession), rtsp(SubSession), flv(SubSession), ts(SubSession), 还有一个比较特殊的hls
+// server.sub:  rtmp(ServerSession), rtsp(SubSession), flv(SubSession), ts(SubSession), fmp4(SubSession), 还有一个比较特殊的hls(SubSession)，没有对应的长连接
 //
 // client.push: rtmp(PushSession), rtsp(PushSession)
 // client.pull: rtmp(PullSession), rtsp(PullSession), flv(PullSession)
@@ -39,6 +39,8 @@ const (
 	SessionTypeFlvPull           SessionType = SessionProtocolFlv<<8 | SessionBaseTypePull
 	SessionTypeTsSub             SessionType = SessionProtocolTs<<8 | SessionBaseTypeSub
 	SessionTypePsPub             SessionType = SessionProtocolPs<<8 | SessionBaseTypePub
+	SessionTypeHlsSub            SessionType = SessionProtocolHls<<8 | SessionBaseTypeSub
+	SessionTypeFmp4Sub           SessionType = SessionProtocolFmp4<<8 | SessionBaseTypeSub
 
 	SessionProtocolCustomize = 1
 	SessionProtocolRtmp      = 2
@@ -46,6 +48,8 @@ const (
 	SessionProtocolFlv       = 4
 	SessionProtocolTs        = 5
 	SessionProtocolPs        = 6
+	SessionProtocolHls       = 7
+	SessionProtocolFmp4      = 8
 
 	SessionBaseTypePubSub = 1
 	SessionBaseTypePub    = 2
@@ -59,6 +63,8 @@ const (
 	SessionProtocolFlvStr       = "FLV"
 	SessionProtocolTsStr        = "TS"
 	SessionProtocolPsStr        = "PS"
+	SessionProtocolHlsStr       = "HLS"
+	SessionProtocolFmp4Str      = "FMP4"
 
 	SessionBaseTypePubSubStr = "PUBSUB"
 	SessionBaseTypePubStr    = "PUB"
@@ -23,12 +23,16 @@ const (
 	UkPreFlvPullSession             = SessionProtocolFlvStr + SessionBaseTypePullStr      // "FLVPULL"
 	UkPreTsSubSession               = SessionProtocolTsStr + SessionBaseTypePubSubStr     // "TSSUB"
 	UkPrePsPubSession               = SessionProtocolPsStr + SessionBaseTypePubStr        // "PSPUB"
+	UkPreHlsSubSession              = SessionProtocolHlsStr + SessionBaseTypeSubStr       // "HLSSUB"
+	UkPreFmp4SubSession             = SessionProtocolFmp4Str + SessionBaseTypeSubStr      // "FMP4SUB"
 
 	UkPreRtspServerCommandSession = "RTSPSRVCMD" // 这个不暴露给上层
 
 	UkPreGroup              = "GROUP"
 	UkPreHlsMuxer           = "HLSMUXER"
+	UkPreDashMuxer          = "DASHMUXER"
 	UkPreRtmp2MpegtsRemuxer = "RTMP2MPEGTS"
+	UkPreRtmp2Fmp4Remuxer   = "RTMP2FMP4"
 )
 
 //func GenUk(prefix string) string {
@@ -87,6 +91,14 @@ func GenUkPsPubSession() string {
 	return siUkPsPubSession.GenUniqueKey()
 }
 
+func GenUkHlsSubSession() string {
+	return siUkHlsSubSession.GenUniqueKey()
+}
+
+func GenUkFmp4SubSession() string {
+	return siUkFmp4SubSession.GenUniqueKey()
+}
+
 func GenUkGroup() string {
 	return siUkGroup.GenUniqueKey()
 }
@@ -95,10 +107,18 @@ func GenUkHlsMuxer() string {
 	return siUkHlsMuxer.GenUniqueKey()
 }
 
+func GenUkDashMuxer() string {
+	return siUkDashMuxer.GenUniqueKey()
+}
+
 func GenUkRtmp2MpegtsRemuxer() string {
 	return siUkRtmp2MpegtsRemuxer.GenUniqueKey()
 }
 
+func GenUkRtmp2Fmp4Remuxer() string {
+	return siUkRtmp2Fmp4Remuxer.GenUniqueKey()
+}
+
 var (
 	siUkCustomizePubSession      *unique.SingleGenerator
 	siUkRtmpServerSession        *unique.SingleGenerator
@@ -113,10 +133,14 @@ var (
 	siUkTsSubSession             *unique.SingleGenerator
 	siUkFlvPullSession           *unique.SingleGenerator
 	siUkPsPubSession             *unique.SingleGenerator
+	siUkHlsSubSession            *unique.SingleGenerator
+	siUkFmp4SubSession           *unique.SingleGenerator
 
 	siUkGroup              *unique.SingleGenerator
 	siUkHlsMuxer           *unique.SingleGenerator
+	siUkDashMuxer          *unique.SingleGenerator
 	siUkRtmp2MpegtsRemuxer *unique.SingleGenerator
+	siUkRtmp2Fmp4Remuxer   *unique.SingleGenerator
 )
 
 func init() {
@@ -133,8 +157,12 @@ func init() {
 	siUkTsSubSession = unique.NewSingleGenerator(UkPreTsSubSession)
 	siUkFlvPullSession = unique.NewSingleGenerator(UkPreFlvPullSession)
 	siUkPsPubSession = unique.NewSingleGenerator(UkPrePsPubSession)
+	siUkHlsSubSession = unique.NewSingleGenerator(UkPreHlsSubSession)
+	siUkFmp4SubSession = unique.NewSingleGenerator(UkPreFmp4SubSession)
 
 	siUkGroup = unique.NewSingleGenerator(UkPreGroup)
 	siUkHlsMuxer = unique.NewSingleGenerator(UkPreHlsMuxer)
+	siUkDashMuxer = unique.NewSingleGenerator(UkPreDashMuxer)
 	siUkRtmp2MpegtsRemuxer = unique.NewSingleGenerator(UkPreRtmp2MpegtsRemuxer)
+	siUkRtmp2Fmp4Remuxer = unique.NewSingleGenerator(UkPreRtmp2Fmp4Remuxer)
 }
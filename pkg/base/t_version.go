@@ -75,12 +75,18 @@ var (
 	// LalHlsTsServer e.g. lal0.12.3
 	LalHlsTsServer string
 
+	// LalDashServer e.g. lal0.12.3
+	LalDashServer string
+
 	// LalRtspOptionsResponseServer e.g. lal0.12.3
 	LalRtspOptionsResponseServer string
 
 	// LalHttptsSubSessionServer e.g. lal0.12.3
 	LalHttptsSubSessionServer string
 
+	// LalHttpfmp4SubSessionServer e.g. lal0.12.3
+	LalHttpfmp4SubSessionServer string
+
 	// LalHttpApiServer e.g. lal0.12.3
 	LalHttpApiServer string
 
@@ -121,6 +127,9 @@ var (
 // - httpts sub
 //     - `server:`
 //
+// - httpfmp4 sub
+//     - `server:`
+//
 // - http api
 //     - `server:`
 
@@ -135,8 +144,10 @@ func init() {
 	LalHttpflvSubSessionServer = LalLibraryName + LalVersionDot
 	LalHlsM3u8Server = LalLibraryName + LalVersionDot
 	LalHlsTsServer = LalLibraryName + LalVersionDot
+	LalDashServer = LalLibraryName + LalVersionDot
 	LalRtspOptionsResponseServer = LalLibraryName + LalVersionDot
 	LalHttptsSubSessionServer = LalLibraryName + LalVersionDot
+	LalHttpfmp4SubSessionServer = LalLibraryName + LalVersionDot
 	LalHttpApiServer = LalLibraryName + LalVersionDot
 
 	LalHttpflvPullSessionUa = LalLibraryName + "/" + LalVersionDot
@@ -0,0 +1,130 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package dash_test
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/dash"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestMuxer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_dash")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	sps, _ := hex.DecodeString("67640032ad84010c20086100430802184010c200843b5014005ad370101014000003000400000300ca100002")
+	pps, _ := hex.DecodeString("68ee3cb0")
+	vsh, err := avc.BuildSeqHeaderFromSpsPps(sps, pps)
+	assert.Equal(t, nil, err)
+
+	makeMsg := func(typeId uint8, ts uint32, payload []byte) base.RtmpMsg {
+		var msg base.RtmpMsg
+		msg.Header.MsgTypeId = typeId
+		msg.Header.TimestampAbs = ts
+		msg.Header.MsgLen = uint32(len(payload))
+		msg.Payload = payload
+		return msg
+	}
+
+	config := &dash.MuxerConfig{
+		OutPath:            dir,
+		FragmentDurationMs: 2000,
+		FragmentNum:        3,
+		DeleteThreshold:    1,
+		CleanupMode:        dash.CleanupModeAsap,
+	}
+	m := dash.NewMuxer("test110", config)
+	m.Start()
+	assert.Equal(t, filepath.Join(dir, "test110", "dash"), m.OutPath())
+
+	m.FeedRtmpMessage(makeMsg(base.RtmpTypeIdVideo, 0, vsh))
+	m.FeedRtmpMessage(makeMsg(base.RtmpTypeIdAudio, 0, []byte{0xAF, base.RtmpAacPacketTypeSeqHeader, 0x12, 0x10}))
+
+	// 10秒数据，视频25帧每秒，每2秒一个关键帧
+	feed := func(fromMs, toMs uint32) {
+		nextAudio := fromMs
+		for ts := fromMs; ts < toMs; ts += 40 {
+			for ; nextAudio <= ts; nextAudio += 23 {
+				m.FeedRtmpMessage(makeMsg(base.RtmpTypeIdAudio, nextAudio, []byte{0xAF, base.RtmpAacPacketTypeRaw, 0x21, 0x00}))
+			}
+			if (ts-fromMs)%2000 == 0 {
+				m.FeedRtmpMessage(makeMsg(base.RtmpTypeIdVideo, ts, []byte{base.RtmpAvcKeyFrame, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88}))
+			} else {
+				m.FeedRtmpMessage(makeMsg(base.RtmpTypeIdVideo, ts, []byte{base.RtmpAvcInterFrame, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a}))
+			}
+		}
+	}
+	feed(0, 10000)
+
+	mpdFilename := filepath.Join(dir, "test110", "dash", "manifest.mpd")
+	content, err := ioutil.ReadFile(mpdFilename)
+	assert.Equal(t, nil, err)
+	mpd := string(content)
+	assert.Equal(t, true, strings.Contains(mpd, `type="dynamic"`))
+	assert.Equal(t, true, strings.Contains(mpd, `codecs="avc1.640032"`))
+	assert.Equal(t, true, strings.Contains(mpd, `codecs="mp4a.40.2"`))
+	assert.Equal(t, true, strings.Contains(mpd, `initialization="video-init-0.mp4"`))
+	assert.Equal(t, 1, strings.Count(mpd, "<Period "))
+
+	// 视频每个segment 2秒，MPD中保留最近3个
+	videoSet := mpd[strings.Index(mpd, `contentType="video"`):strings.Index(mpd, `contentType="audio"`)]
+	assert.Equal(t, 3, strings.Count(videoSet, "<S "))
+	assert.Equal(t, 3, strings.Count(videoSet, `d="180000"`))
+	assert.Equal(t, true, strings.Contains(videoSet, `startNumber="1"`))
+
+	// MPD中的segment都存在，过期的segment已经被删除
+	for i := 1; i <= 3; i++ {
+		_, err = os.Stat(filepath.Join(dir, "test110", "dash", "video-"+string(rune('0'+i))+".m4s"))
+		assert.Equal(t, nil, err)
+	}
+	_, err = os.Stat(filepath.Join(dir, "test110", "dash", "video-4.m4s"))
+	assert.IsNotNil(t, err)
+
+	// 编码参数变化，开启新的period
+	vsh2 := append([]byte(nil), vsh...)
+	vsh2[len(vsh2)-1] ^= 0xFF
+	m.FeedRtmpMessage(makeMsg(base.RtmpTypeIdVideo, 10000, vsh2))
+	feed(10000, 14000)
+	m.Dispose()
+
+	content, err = ioutil.ReadFile(mpdFilename)
+	assert.Equal(t, nil, err)
+	mpd = string(content)
+	assert.Equal(t, true, strings.Contains(mpd, `type="static"`))
+	assert.Equal(t, true, strings.Contains(mpd, `initialization="video-init-1.mp4"`))
+	assert.Equal(t, true, strings.Contains(mpd, `<Period id="1" start="PT10.000S">`))
+	assert.Equal(t, 2, len(regexp.MustCompile(`<Period id="\d+"`).FindAllString(mpd, -1)))
+
+	// 通过http读取
+	handler := dash.NewServerHandler(dir)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/dash/test110/manifest.mpd", nil))
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "application/dash+xml", resp.Header().Get("Content-Type"))
+	assert.Equal(t, mpd, resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/dash/test110/video-init-1.mp4", nil))
+	assert.Equal(t, 200, resp.Code)
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/dash/test110/playlist.m3u8", nil))
+	assert.Equal(t, 404, resp.Code)
+}
@@ -0,0 +1,140 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package dash
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// 参考文档：
+// ISO_IEC_23009-1 Dynamic adaptive streaming over HTTP (DASH) Part 1: Media presentation description and segment formats
+// DASH-IF IOP Guidelines
+//
+
+const mpdTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// writeMpd 写MPD文件，每生成一个segment时调用
+//
+func (m *Muxer) writeMpd() {
+	content := m.buildMpd()
+	if content == nil {
+		return
+	}
+	if err := fslCtx.WriteFile(m.mpdFilenameBak, content, 0666); err != nil {
+		Log.Errorf("[%s] write mpd file error. err=%+v", m.UniqueKey, err)
+		return
+	}
+	if err := fslCtx.Rename(m.mpdFilenameBak, m.mpdFilename); err != nil {
+		Log.Errorf("[%s] rename mpd file error. err=%+v", m.UniqueKey, err)
+	}
+}
+
+// buildMpd
+//
+// @return 还没有任何segment时返回nil
+//
+func (m *Muxer) buildMpd() []byte {
+	videoSegments := m.video.windowSegments()
+	audioSegments := m.audio.windowSegments()
+	if len(videoSegments) == 0 && len(audioSegments) == 0 {
+		return nil
+	}
+
+	// 淘汰已经不在MPD中的period
+	minPeriod := m.currPeriod().id
+	if len(videoSegments) > 0 && videoSegments[0].period < minPeriod {
+		minPeriod = videoSegments[0].period
+	}
+	if len(audioSegments) > 0 && audioSegments[0].period < minPeriod {
+		minPeriod = audioSegments[0].period
+	}
+	for len(m.periods) > 1 && m.periods[0].id < minPeriod {
+		m.periods = m.periods[1:]
+	}
+
+	fragmentDuration := float64(m.config.FragmentDurationMs) / 1000
+
+	var buf bytes.Buffer
+	buf.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	buf.WriteString("<MPD xmlns=\"urn:mpeg:dash:schema:mpd:2011\" profiles=\"urn:mpeg:dash:profile:isoff-live:2011\"")
+	if m.ended {
+		buf.WriteString(fmt.Sprintf(" type=\"static\" mediaPresentationDuration=\"%s\"", formatDuration(m.presentationEnd())))
+	} else {
+		buf.WriteString(" type=\"dynamic\"")
+		buf.WriteString(fmt.Sprintf(" availabilityStartTime=\"%s\"", m.availabilityStartTime.UTC().Format(mpdTimeLayout)))
+		buf.WriteString(fmt.Sprintf(" publishTime=\"%s\"", Clock.Now().UTC().Format(mpdTimeLayout)))
+		buf.WriteString(fmt.Sprintf(" minimumUpdatePeriod=\"%s\"", formatDuration(fragmentDuration)))
+		buf.WriteString(fmt.Sprintf(" timeShiftBufferDepth=\"%s\"", formatDuration(fragmentDuration*float64(m.config.FragmentNum))))
+		buf.WriteString(fmt.Sprintf(" suggestedPresentationDelay=\"%s\"", formatDuration(fragmentDuration*3)))
+	}
+	buf.WriteString(fmt.Sprintf(" minBufferTime=\"%s\">\n", formatDuration(fragmentDuration)))
+
+	for _, p := range m.periods {
+		video := filterSegmentsByPeriod(videoSegments, p.id)
+		audio := filterSegmentsByPeriod(audioSegments, p.id)
+		if len(video) == 0 && len(audio) == 0 {
+			continue
+		}
+
+		buf.WriteString(fmt.Sprintf("  <Period id=\"%d\" start=\"%s\">\n", p.id, formatDuration(p.start)))
+		if len(video) > 0 {
+			writeAdaptationSet(&buf, 0, "video", m.video, video, p.startTs)
+		}
+		if len(audio) > 0 {
+			writeAdaptationSet(&buf, 1, "audio", m.audio, audio, p.startTs)
+		}
+		buf.WriteString("  </Period>\n")
+	}
+
+	buf.WriteString("</MPD>\n")
+	return buf.Bytes()
+}
+
+func writeAdaptationSet(buf *bytes.Buffer, id int, contentType string, t *trackMuxer, segments []segmentInfo, presentationTimeOffset uint64) {
+	buf.WriteString(fmt.Sprintf("    <AdaptationSet id=\"%d\" contentType=\"%s\" mimeType=\"%s/mp4\" segmentAlignment=\"true\" startWithSAP=\"1\">\n",
+		id, contentType, contentType))
+	buf.WriteString(fmt.Sprintf("      <Representation id=\"%s\" codecs=\"%s\" bandwidth=\"%d\">\n", t.typ, segments[0].codec, t.bandwidth))
+	buf.WriteString(fmt.Sprintf("        <SegmentTemplate timescale=\"90000\" presentationTimeOffset=\"%d\" initialization=\"%s\" media=\"%s-$Number$.m4s\" startNumber=\"%d\">\n",
+		presentationTimeOffset, segments[0].initFilename, t.typ, segments[0].number))
+	buf.WriteString("          <SegmentTimeline>\n")
+	for _, seg := range segments {
+		buf.WriteString(fmt.Sprintf("            <S t=\"%d\" d=\"%d\"/>\n", seg.startTs, seg.duration))
+	}
+	buf.WriteString("          </SegmentTimeline>\n")
+	buf.WriteString("        </SegmentTemplate>\n")
+	buf.WriteString("      </Representation>\n")
+	buf.WriteString("    </AdaptationSet>\n")
+}
+
+// presentationEnd 最后一个period结束的时间，单位秒
+//
+func (m *Muxer) presentationEnd() float64 {
+	p := m.currPeriod()
+	if !p.hasStart && len(m.periods) > 1 {
+		p = &m.periods[len(m.periods)-2]
+	}
+	return p.start + p.duration()
+}
+
+func filterSegmentsByPeriod(segments []segmentInfo, period int) []segmentInfo {
+	var ret []segmentInfo
+	for _, seg := range segments {
+		if seg.period == period {
+			ret = append(ret, seg)
+		}
+	}
+	return ret
+}
+
+// formatDuration xs:duration格式，比如`PT1.500S`
+//
+func formatDuration(seconds float64) string {
+	return fmt.Sprintf("PT%.3fS", seconds)
+}
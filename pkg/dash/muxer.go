@@ -0,0 +1,388 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package dash
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
+	"github.com/q191201771/lal/pkg/remux"
+)
+
+// MuxerConfig
+//
+// 各字段的含义与 hls.MuxerConfig 中的同名字段一致，lalserver中直接使用hls的配置
+//
+type MuxerConfig struct {
+	OutPath            string // 根目录，单个流的文件存放在 GetMuxerOutPath 中
+	FragmentDurationMs int    // 单个segment的目标时长
+	FragmentNum        int    // MPD中segment的数量
+	DeleteThreshold    int    // CleanupModeAsap 时，超出 FragmentNum 多少个segment后删除文件
+	CleanupMode        int
+}
+
+const (
+	CleanupModeNever    = 0
+	CleanupModeInTheEnd = 1
+	CleanupModeAsap     = 2
+)
+
+const (
+	mpdFileName = "manifest.mpd"
+	outPathName = "dash"
+
+	trackTypeVideo = "video"
+	trackTypeAudio = "audio"
+
+	// 时间戳跳跃的阈值，超过后开启新的period
+	negMaxJumpTs = 1000 * 90
+)
+
+// GetMuxerOutPath 单个流的文件目录，<rootOutPath>/<streamName>/dash
+//
+// 与hls共用根目录，但是使用独立的子目录，避免文件名冲突
+//
+func GetMuxerOutPath(rootOutPath string, streamName string) string {
+	return filepath.Join(rootOutPath, streamName, outPathName)
+}
+
+// Muxer
+//
+// 输入rtmp流，输出MPEG-DASH（dynamic MPD + fmp4）至文件中
+//
+// 视频和音频分别使用独立的AdaptationSet，各自生成初始化分片以及媒体分片：
+//
+// - manifest.mpd      MPD文件，使用SegmentTemplate+SegmentTimeline，媒体分片使用$Number$寻址
+// - video-init-0.mp4  视频初始化分片，编码参数变化时序号加1，并开启新的period
+// - video-0.m4s       视频媒体分片
+// - audio-init-0.mp4  音频初始化分片
+// - audio-0.m4s       音频媒体分片
+//
+type Muxer struct {
+	UniqueKey string
+
+	streamName     string // const after init
+	outPath        string // const after init
+	mpdFilename    string // const after init
+	mpdFilenameBak string // const after init
+
+	config *MuxerConfig
+
+	videoRemuxer *remux.Rtmp2Fmp4Remuxer
+	audioRemuxer *remux.Rtmp2Fmp4Remuxer
+	video        *trackMuxer
+	audio        *trackMuxer
+
+	periods []periodInfo // 最后一个元素为当前的period
+
+	hasBase               bool
+	availabilityStartTime time.Time // 收到第一个媒体分片时的时间
+
+	ended bool
+}
+
+type periodInfo struct {
+	id       int
+	hasStart bool
+	startTs  uint64  // period中第一个segment的时间戳，单位（毫秒*90），也即presentationTimeOffset
+	endTs    uint64  // period中segment结束的最大时间戳，单位（毫秒*90）
+	start    float64 // period相对于availabilityStartTime的开始时间，单位秒
+}
+
+// duration period中已生成的segment的时长，单位秒
+//
+func (p *periodInfo) duration() float64 {
+	if p.endTs <= p.startTs {
+		return 0
+	}
+	return float64(p.endTs-p.startTs) / 90000
+}
+
+type segmentInfo struct {
+	number       int
+	period       int
+	startTs      uint64 // 单位（毫秒*90）
+	duration     uint64 // 单位（毫秒*90）
+	filename     string
+	initFilename string
+	codec        string
+}
+
+// trackMuxer 单个轨道（视频或音频），实现 remux.IRtmp2Fmp4RemuxerObserver
+//
+type trackMuxer struct {
+	m   *Muxer
+	typ string
+
+	pendingCodec string // 最近一次收到的seq header对应的codecs，生成初始化分片时生效
+	codec        string
+	initId       int
+	initFilename string // 为空表示还没有收到初始化分片
+	number       int    // 下一个segment的序号
+	bandwidth    int    // 所有segment中最大的码率，单位bit/s
+
+	opened    bool
+	curr      segmentInfo
+	currEndTs uint64
+	buf       []byte
+
+	segments []segmentInfo // 已经生成的segment，包含MPD中的以及等待删除的
+}
+
+func NewMuxer(streamName string, config *MuxerConfig) *Muxer {
+	uk := base.GenUkDashMuxer()
+	op := GetMuxerOutPath(config.OutPath, streamName)
+	mpdFilename := filepath.Join(op, mpdFileName)
+	m := &Muxer{
+		UniqueKey:      uk,
+		streamName:     streamName,
+		outPath:        op,
+		mpdFilename:    mpdFilename,
+		mpdFilenameBak: fmt.Sprintf("%s.bak", mpdFilename),
+		config:         config,
+		periods:        []periodInfo{{id: 0}},
+	}
+	m.video = &trackMuxer{m: m, typ: trackTypeVideo}
+	m.audio = &trackMuxer{m: m, typ: trackTypeAudio}
+	// 视频和音频使用各自的remuxer，生成只包含单个轨道的fmp4
+	m.videoRemuxer = remux.NewRtmp2Fmp4Remuxer(m.video)
+	m.audioRemuxer = remux.NewRtmp2Fmp4Remuxer(m.audio)
+	Log.Infof("[%s] lifecycle new dash muxer. muxer=%p, streamName=%s", uk, m, streamName)
+	return m
+}
+
+func (m *Muxer) Start() {
+	Log.Infof("[%s] start dash muxer.", m.UniqueKey)
+	err := fslCtx.MkdirAll(m.outPath, 0777)
+	Log.Assert(nil, err)
+}
+
+// Dispose 将内部缓存的数据全部写入文件，并将MPD更新为static类型
+//
+func (m *Muxer) Dispose() {
+	Log.Infof("[%s] lifecycle dispose dash muxer.", m.UniqueKey)
+	m.videoRemuxer.Dispose()
+	m.audioRemuxer.Dispose()
+	m.video.closeSegment()
+	m.audio.closeSegment()
+	m.ended = true
+	m.writeMpd()
+}
+
+// FeedRtmpMessage
+//
+// @param msg: 函数调用结束后，内部不持有msg中的内存块
+//
+func (m *Muxer) FeedRtmpMessage(msg base.RtmpMsg) {
+	switch msg.Header.MsgTypeId {
+	case base.RtmpTypeIdVideo:
+		if msg.IsAvcKeySeqHeader() || msg.IsHevcKeySeqHeader() {
+			track := fmp4.VideoTrack{Codec: base.VideoCodecAvc, DecoderConfigurationRecord: msg.Payload[5:]}
+			if msg.IsHevcKeySeqHeader() {
+				track.Codec = base.VideoCodecHevc
+			}
+			m.video.pendingCodec = track.CodecString()
+		}
+		m.videoRemuxer.FeedRtmpMessage(msg)
+	case base.RtmpTypeIdAudio:
+		if msg.IsAacSeqHeader() {
+			track := fmp4.AudioTrack{Asc: msg.Payload[2:]}
+			m.audio.pendingCodec = track.CodecString()
+		}
+		m.audioRemuxer.FeedRtmpMessage(msg)
+	}
+}
+
+func (m *Muxer) OutPath() string {
+	return m.outPath
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (m *Muxer) currPeriod() *periodInfo {
+	return &m.periods[len(m.periods)-1]
+}
+
+// startNewPeriod 编码参数变化，或者时间戳发生跳跃时，结束所有轨道当前的segment，后续的segment放入新的period中
+//
+func (m *Muxer) startNewPeriod() {
+	m.video.closeSegment()
+	m.audio.closeSegment()
+	m.periods = append(m.periods, periodInfo{id: m.currPeriod().id + 1})
+	Log.Infof("[%s] start new period. id=%d", m.UniqueKey, m.currPeriod().id)
+}
+
+// onSegmentOpen 第一个segment的开始时间决定了period的开始时间
+//
+func (m *Muxer) onSegmentOpen(ts uint64) {
+	now := Clock.Now()
+	if !m.hasBase {
+		m.availabilityStartTime = now
+		m.hasBase = true
+	}
+
+	p := m.currPeriod()
+	if p.hasStart {
+		// 音视频轨道的第一个segment的时间戳可能不同，取较小值，保证period中所有segment的时间戳都不小于presentationTimeOffset
+		if ts < p.startTs {
+			p.startTs = ts
+		}
+		return
+	}
+	p.hasStart = true
+	p.startTs = ts
+	p.endTs = ts
+	// 注意，新period的开始时间优先使用墙上时间，使得时间戳跳跃后，MPD的时间轴依然与墙上时间保持一致，
+	// 但不能早于上一个period结束的时间
+	p.start = now.Sub(m.availabilityStartTime).Seconds()
+	if len(m.periods) > 1 {
+		prev := &m.periods[len(m.periods)-2]
+		if prevEnd := prev.start + prev.duration(); p.start < prevEnd {
+			p.start = prevEnd
+		}
+	}
+}
+
+func (m *Muxer) onSegmentClose() {
+	m.writeMpd()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (t *trackMuxer) OnFmp4InitSegment(b []byte) {
+	filename := fmt.Sprintf("%s-init-%d.mp4", t.typ, t.initId)
+	t.initId++
+	if err := fslCtx.WriteFile(filepath.Join(t.m.outPath, filename), b, 0666); err != nil {
+		Log.Errorf("[%s] write init segment file error. err=%+v", t.m.UniqueKey, err)
+		return
+	}
+
+	// 编码参数发生变化
+	if t.initFilename != "" {
+		t.m.startNewPeriod()
+	}
+	t.initFilename = filename
+	t.codec = t.pendingCodec
+}
+
+func (t *trackMuxer) OnFmp4Fragment(b []byte, startTs uint64, endTs uint64, boundary bool) {
+	if t.initFilename == "" {
+		return
+	}
+
+	if t.opened {
+		// 时间戳发生跳跃，SegmentTimeline无法表示，开启新的period
+		maxJump := uint64(t.m.config.FragmentDurationMs * 90 * 10)
+		if (startTs > t.currEndTs && startTs-t.currEndTs > maxJump) || (t.currEndTs > startTs && t.currEndTs-startTs > negMaxJumpTs) {
+			Log.Warnf("[%s] %s timestamp jump. currEndTs=%d, startTs=%d", t.m.UniqueKey, t.typ, t.currEndTs, startTs)
+			t.m.startNewPeriod()
+		}
+	}
+
+	if t.opened && boundary && t.currEndTs-t.curr.startTs >= uint64(t.m.config.FragmentDurationMs*90) {
+		t.closeSegment()
+	}
+
+	if !t.opened {
+		// segment需要从视频关键帧开始
+		if !boundary {
+			return
+		}
+		t.openSegment(startTs)
+	}
+
+	t.buf = append(t.buf, b...)
+	if endTs > t.currEndTs {
+		t.currEndTs = endTs
+	}
+}
+
+func (t *trackMuxer) openSegment(ts uint64) {
+	t.m.onSegmentOpen(ts)
+
+	t.curr = segmentInfo{
+		number:       t.number,
+		period:       t.m.currPeriod().id,
+		startTs:      ts,
+		filename:     fmt.Sprintf("%s-%d.m4s", t.typ, t.number),
+		initFilename: t.initFilename,
+		codec:        t.codec,
+	}
+	t.number++
+	t.opened = true
+	t.currEndTs = ts
+	t.buf = nil
+}
+
+func (t *trackMuxer) closeSegment() {
+	if !t.opened {
+		return
+	}
+	t.opened = false
+
+	t.curr.duration = t.currEndTs - t.curr.startTs
+	if err := fslCtx.WriteFile(filepath.Join(t.m.outPath, t.curr.filename), t.buf, 0666); err != nil {
+		Log.Errorf("[%s] write segment file error. err=%+v", t.m.UniqueKey, err)
+	}
+	if t.curr.duration > 0 {
+		bandwidth := int(uint64(len(t.buf)*8) * 90000 / t.curr.duration)
+		if bandwidth > t.bandwidth {
+			t.bandwidth = bandwidth
+		}
+	}
+	t.buf = nil
+
+	for i := range t.m.periods {
+		if p := &t.m.periods[i]; p.id == t.curr.period && t.currEndTs > p.endTs {
+			p.endTs = t.currEndTs
+		}
+	}
+
+	t.segments = append(t.segments, t.curr)
+	t.cleanup()
+	t.m.onSegmentClose()
+}
+
+// cleanup 淘汰过期的segment，CleanupModeAsap 时同时删除文件
+//
+func (t *trackMuxer) cleanup() {
+	for len(t.segments) > t.m.config.FragmentNum+t.m.config.DeleteThreshold {
+		seg := t.segments[0]
+		t.segments = t.segments[1:]
+		if t.m.config.CleanupMode != CleanupModeAsap {
+			continue
+		}
+
+		t.removeFile(seg.filename)
+		// 初始化分片不再被使用时，也一并删除
+		if seg.initFilename != t.initFilename && (len(t.segments) == 0 || t.segments[0].initFilename != seg.initFilename) {
+			t.removeFile(seg.initFilename)
+		}
+	}
+}
+
+func (t *trackMuxer) removeFile(filename string) {
+	filenameWithPath := filepath.Join(t.m.outPath, filename)
+	if err := fslCtx.Remove(filenameWithPath); err != nil {
+		Log.Warnf("[%s] remove stale file failed. filename=%s, err=%+v", t.m.UniqueKey, filenameWithPath, err)
+	}
+}
+
+// windowSegments MPD中的segment，也即最近的 FragmentNum 个
+//
+func (t *trackMuxer) windowSegments() []segmentInfo {
+	n := len(t.segments) - t.m.config.FragmentNum
+	if n < 0 {
+		n = 0
+	}
+	return t.segments[n:]
+}
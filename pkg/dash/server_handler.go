@@ -0,0 +1,95 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package dash
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+type RequestInfo struct {
+	StreamName       string
+	FileNameWithPath string
+}
+
+// GetRequestInfo
+//
+// RequestURI example:
+// uri                            -> StreamName FileNameWithPath
+// /dash/test110/manifest.mpd     -> test110    {rootOutPath}/test110/dash/manifest.mpd
+// /dash/test110/video-init-0.mp4 -> test110    {rootOutPath}/test110/dash/video-init-0.mp4
+// /dash/test110/video-3.m4s      -> test110    {rootOutPath}/test110/dash/video-3.m4s
+//
+func GetRequestInfo(urlCtx base.UrlContext, rootOutPath string) (ri RequestInfo) {
+	uriItems := strings.Split(urlCtx.Path, "/")
+	if len(uriItems) < 3 || urlCtx.LastItemOfPath == "" {
+		return
+	}
+	ri.StreamName = uriItems[len(uriItems)-2]
+	if ri.StreamName == "" || ri.StreamName == "." || ri.StreamName == ".." {
+		ri.StreamName = ""
+		return
+	}
+	ri.FileNameWithPath = filepath.Join(GetMuxerOutPath(rootOutPath, ri.StreamName), urlCtx.LastItemOfPath)
+	return
+}
+
+type ServerHandler struct {
+	outPath string
+}
+
+func NewServerHandler(outPath string) *ServerHandler {
+	return &ServerHandler{
+		outPath: outPath,
+	}
+}
+
+func (s *ServerHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	urlCtx, err := base.ParseUrl(base.ParseHttpRequest(req), 80)
+	if err != nil {
+		Log.Errorf("parse url. err=%+v", err)
+		return
+	}
+	s.ServeHTTPWithUrlCtx(resp, urlCtx)
+}
+
+func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, urlCtx base.UrlContext) {
+	filetype := urlCtx.GetFileType()
+
+	ri := GetRequestInfo(urlCtx, s.outPath)
+	if (filetype != "mpd" && filetype != "m4s" && filetype != "mp4") || ri.StreamName == "" || ri.FileNameWithPath == "" {
+		Log.Warnf("invalid dash request. url=%+v, request=%+v", urlCtx, ri)
+		resp.WriteHeader(404)
+		return
+	}
+
+	content, err := ReadFile(ri.FileNameWithPath)
+	if err != nil {
+		Log.Warnf("read dash file failed. request=%+v, err=%+v", ri, err)
+		resp.WriteHeader(404)
+		return
+	}
+
+	switch filetype {
+	case "mpd":
+		resp.Header().Add("Content-Type", "application/dash+xml")
+	case "m4s":
+		resp.Header().Add("Content-Type", "video/iso.segment")
+	case "mp4":
+		resp.Header().Add("Content-Type", "video/mp4")
+	}
+	resp.Header().Add("Server", base.LalDashServer)
+	resp.Header().Add("Cache-Control", "no-cache")
+	resp.Header().Add("Access-Control-Allow-Origin", "*")
+
+	_, _ = resp.Write(content)
+}
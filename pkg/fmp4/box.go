@@ -0,0 +1,96 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+	"github.com/q191201771/naza/pkg/nazaerrors"
+)
+
+// boxWriter 顺序写入box，box的size字段在box写完后回填
+//
+type boxWriter struct {
+	b []byte
+}
+
+// start 开始写一个box，返回该box的起始位置，box写完后需调用 end
+//
+func (w *boxWriter) start(typ string) int {
+	pos := len(w.b)
+	w.u32(0)
+	w.b = append(w.b, typ...)
+	return pos
+}
+
+// startFull 开始写一个full box
+//
+func (w *boxWriter) startFull(typ string, version uint8, flags uint32) int {
+	pos := w.start(typ)
+	w.u32(uint32(version)<<24 | flags&0xFFFFFF)
+	return pos
+}
+
+func (w *boxWriter) end(pos int) {
+	bele.BePutUint32(w.b[pos:], uint32(len(w.b)-pos))
+}
+
+func (w *boxWriter) u8(v uint8) {
+	w.b = append(w.b, v)
+}
+
+func (w *boxWriter) u16(v uint16) {
+	w.b = append(w.b, uint8(v>>8), uint8(v))
+}
+
+func (w *boxWriter) u24(v uint32) {
+	w.b = append(w.b, uint8(v>>16), uint8(v>>8), uint8(v))
+}
+
+func (w *boxWriter) u32(v uint32) {
+	w.b = append(w.b, uint8(v>>24), uint8(v>>16), uint8(v>>8), uint8(v))
+}
+
+func (w *boxWriter) u64(v uint64) {
+	w.u32(uint32(v >> 32))
+	w.u32(uint32(v))
+}
+
+func (w *boxWriter) bytes(b []byte) {
+	w.b = append(w.b, b...)
+}
+
+func (w *boxWriter) zeros(n int) {
+	for i := 0; i < n; i++ {
+		w.b = append(w.b, 0)
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// IterateBox 遍历同一层级的box
+//
+// @param b: 一个或多个连续的box
+//
+// @param fn: typ为box类型，payload为不包含size和type的box内容
+//
+func IterateBox(b []byte, fn func(typ string, payload []byte)) error {
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nazaerrors.Wrap(base.ErrShortBuffer)
+		}
+		size := int(bele.BeUint32(b))
+		if size < 8 || size > len(b) {
+			return nazaerrors.Wrap(base.ErrShortBuffer)
+		}
+		fn(string(b[4:8]), b[8:size])
+		b = b[size:]
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// 参考文档：
+// RFC 6381 The 'Codecs' and 'Profiles' Parameters for "Bucket" Media Types
+// ISO_IEC_14496-15 Annex E.3 (hvc1)
+//
+
+// CodecString 生成RFC 6381格式的codecs字符串，比如`avc1.64001f`、`hvc1.1.6.L93.B0`，用于DASH MPD的codecs属性、HLS的CODECS属性
+//
+// 注意，只依赖 VideoTrack.Codec 和 VideoTrack.DecoderConfigurationRecord 两个字段，解析失败时返回空字符串
+//
+func (t *VideoTrack) CodecString() string {
+	r := t.DecoderConfigurationRecord
+	if t.Codec == base.VideoCodecAvc {
+		if len(r) < 4 {
+			return ""
+		}
+		// AVCProfileIndication, profile_compatibility, AVCLevelIndication
+		return fmt.Sprintf("avc1.%02x%02x%02x", r[1], r[2], r[3])
+	}
+
+	if len(r) < 13 {
+		return ""
+	}
+	profileSpace := r[1] >> 6
+	tier := (r[1] >> 5) & 1
+	profileIdc := r[1] & 0x1F
+	compat := uint32(r[2])<<24 | uint32(r[3])<<16 | uint32(r[4])<<8 | uint32(r[5])
+	level := r[12]
+
+	var sb strings.Builder
+	sb.WriteString("hvc1.")
+	if profileSpace > 0 {
+		sb.WriteByte('A' + profileSpace - 1)
+	}
+	sb.WriteString(fmt.Sprintf("%d.", profileIdc))
+
+	// general_profile_compatibility_flags按位逆序后以十六进制表示
+	var reversed uint32
+	for i := 0; i < 32; i++ {
+		reversed = reversed<<1 | (compat>>uint(i))&1
+	}
+	sb.WriteString(fmt.Sprintf("%X.", reversed))
+
+	if tier == 0 {
+		sb.WriteString("L")
+	} else {
+		sb.WriteString("H")
+	}
+	sb.WriteString(fmt.Sprintf("%d", level))
+
+	// general_constraint_indicator_flags，去掉末尾为0的字节
+	constraint := r[6:12]
+	n := len(constraint)
+	for n > 0 && constraint[n-1] == 0 {
+		n--
+	}
+	for i := 0; i < n; i++ {
+		sb.WriteString(fmt.Sprintf(".%02X", constraint[i]))
+	}
+	return sb.String()
+}
+
+// CodecString 生成RFC 6381格式的codecs字符串，比如`mp4a.40.2`
+//
+// 注意，只依赖 AudioTrack.Asc 字段
+//
+func (t *AudioTrack) CodecString() string {
+	if len(t.Asc) < 1 {
+		return ""
+	}
+	// audioObjectType
+	return fmt.Sprintf("mp4a.40.%d", t.Asc[0]>>3)
+}
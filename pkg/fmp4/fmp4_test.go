@@ -0,0 +1,131 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4_test
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+func boxTypes(t *testing.T, b []byte) (types []string, payloads map[string][]byte) {
+	payloads = make(map[string][]byte)
+	err := fmp4.IterateBox(b, func(typ string, payload []byte) {
+		types = append(types, typ)
+		payloads[typ] = payload
+	})
+	assert.Equal(t, nil, err)
+	return
+}
+
+func TestBuildInitSegment(t *testing.T) {
+	_, err := fmp4.BuildInitSegment(nil, nil)
+	assert.IsNotNil(t, err)
+
+	video := &fmp4.VideoTrack{
+		Codec:                      base.VideoCodecAvc,
+		Width:                      1280,
+		Height:                     720,
+		DecoderConfigurationRecord: []byte{0x01, 0x64, 0x00, 0x1f, 0xff, 0xe1},
+	}
+	audio := &fmp4.AudioTrack{
+		SampleRate:   44100,
+		ChannelCount: 2,
+		Asc:          []byte{0x12, 0x10},
+	}
+	b, err := fmp4.BuildInitSegment(video, audio)
+	assert.Equal(t, nil, err)
+
+	types, payloads := boxTypes(t, b)
+	assert.Equal(t, []string{"ftyp", "moov"}, types)
+	types, payloads = boxTypes(t, payloads["moov"])
+	assert.Equal(t, []string{"mvhd", "trak", "trak", "mvex"}, types)
+	types, _ = boxTypes(t, payloads["mvex"])
+	assert.Equal(t, []string{"trex", "trex"}, types)
+
+	b, err = fmp4.BuildInitSegment(&fmp4.VideoTrack{Codec: base.VideoCodecHevc}, nil)
+	assert.Equal(t, nil, err)
+	_, payloads = boxTypes(t, b)
+	types, _ = boxTypes(t, payloads["moov"])
+	assert.Equal(t, []string{"mvhd", "trak", "mvex"}, types)
+}
+
+func TestBuildFragment(t *testing.T) {
+	tracks := []fmp4.TrackFragment{
+		{
+			TrackId:             fmp4.TrackIdVideo,
+			BaseMediaDecodeTime: 9000,
+			Samples: []fmp4.Sample{
+				{Duration: 3600, IsKey: true, Data: []byte{0, 0, 0, 1, 0x65}},
+				{Duration: 3600, CtsOffset: 3600, Data: []byte{0, 0, 0, 1, 0x41}},
+			},
+		},
+		{
+			TrackId:             fmp4.TrackIdAudio,
+			BaseMediaDecodeTime: 4410,
+			Samples: []fmp4.Sample{
+				{Duration: 1024, IsKey: true, Data: []byte{0x21, 0x22}},
+			},
+		},
+		{
+			TrackId: 3,
+		},
+	}
+	b := fmp4.BuildFragment(7, tracks)
+
+	types, payloads := boxTypes(t, b)
+	assert.Equal(t, []string{"moof", "mdat"}, types)
+	assert.Equal(t, []byte{0, 0, 0, 1, 0x65, 0, 0, 0, 1, 0x41, 0x21, 0x22}, payloads["mdat"])
+	moofSize := len(payloads["moof"]) + 8
+
+	var trafs [][]byte
+	err := fmp4.IterateBox(payloads["moof"], func(typ string, payload []byte) {
+		if typ == "mfhd" {
+			assert.Equal(t, uint32(7), bele.BeUint32(payload[4:]))
+		}
+		if typ == "traf" {
+			trafs = append(trafs, payload)
+		}
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(trafs))
+
+	// 检查trun中的data_offset指向mdat中对应的数据
+	expectedOffsets := []int{moofSize + 8, moofSize + 8 + 10}
+	expectedDecodeTimes := []uint64{9000, 4410}
+	for i, traf := range trafs {
+		types, payloads := boxTypes(t, traf)
+		assert.Equal(t, []string{"tfhd", "tfdt", "trun"}, types)
+		assert.Equal(t, expectedDecodeTimes[i], bele.BeUint64(payloads["tfdt"][4:]))
+		assert.Equal(t, uint32(len(tracks[i].Samples)), bele.BeUint32(payloads["trun"][4:]))
+		offset := int(bele.BeUint32(payloads["trun"][8:]))
+		assert.Equal(t, expectedOffsets[i], offset)
+		assert.Equal(t, tracks[i].Samples[0].Data, b[offset:offset+len(tracks[i].Samples[0].Data)])
+	}
+}
+
+func TestCodecString(t *testing.T) {
+	video := &fmp4.VideoTrack{
+		Codec:                      base.VideoCodecAvc,
+		DecoderConfigurationRecord: []byte{0x01, 0x64, 0x00, 0x1f, 0xff, 0xe1},
+	}
+	assert.Equal(t, "avc1.64001f", video.CodecString())
+
+	video = &fmp4.VideoTrack{
+		Codec:                      base.VideoCodecHevc,
+		DecoderConfigurationRecord: []byte{0x01, 0x01, 0x60, 0x00, 0x00, 0x00, 0xb0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x5d},
+	}
+	assert.Equal(t, "hvc1.1.6.L93.B0", video.CodecString())
+
+	audio := &fmp4.AudioTrack{Asc: []byte{0x12, 0x10}}
+	assert.Equal(t, "mp4a.40.2", audio.CodecString())
+}
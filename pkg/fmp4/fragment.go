@@ -0,0 +1,103 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4
+
+import "github.com/q191201771/naza/pkg/bele"
+
+// Sample 一个音频帧或视频帧
+//
+type Sample struct {
+	Duration  uint32 // 单位为轨道的timescale
+	CtsOffset int32  // pts - dts，单位为轨道的timescale
+	IsKey     bool
+	Data      []byte // 视频为avcc格式（4字节长度前缀），音频为aac裸数据
+}
+
+// TrackFragment 一个轨道在一个分片中的数据
+//
+type TrackFragment struct {
+	TrackId             uint32
+	BaseMediaDecodeTime uint64 // 第一个sample的dts，单位为轨道的timescale
+	Samples             []Sample
+}
+
+// BuildFragment 生成媒体分片，也即moof+mdat
+//
+// @param sequenceNumber: mfhd中的sequence_number，从1开始递增
+//
+// @param tracks: 没有sample的轨道会被忽略
+//
+// @return 内存块为内部独立新申请
+//
+func BuildFragment(sequenceNumber uint32, tracks []TrackFragment) []byte {
+	var w boxWriter
+
+	moof := w.start("moof")
+	mfhd := w.startFull("mfhd", 0, 0)
+	w.u32(sequenceNumber)
+	w.end(mfhd)
+
+	// trun中的data_offset需要等moof写完才知道，先记录位置，最后回填
+	var dataOffsetPos []int
+	var dataSize []int
+	for _, t := range tracks {
+		if len(t.Samples) == 0 {
+			continue
+		}
+
+		traf := w.start("traf")
+		tfhd := w.startFull("tfhd", 0, 0x020000) // default-base-is-moof
+		w.u32(t.TrackId)
+		w.end(tfhd)
+
+		tfdt := w.startFull("tfdt", 1, 0)
+		w.u64(t.BaseMediaDecodeTime)
+		w.end(tfdt)
+
+		// data-offset-present | sample-duration-present | sample-size-present | sample-flags-present | sample-composition-time-offsets-present
+		trun := w.startFull("trun", 1, 0x000001|0x000100|0x000200|0x000400|0x000800)
+		w.u32(uint32(len(t.Samples)))
+		dataOffsetPos = append(dataOffsetPos, len(w.b))
+		w.u32(0)
+		size := 0
+		for _, s := range t.Samples {
+			w.u32(s.Duration)
+			w.u32(uint32(len(s.Data)))
+			if s.IsKey {
+				w.u32(sampleFlagsKey)
+			} else {
+				w.u32(sampleFlagsNonKey)
+			}
+			w.u32(uint32(s.CtsOffset))
+			size += len(s.Data)
+		}
+		dataSize = append(dataSize, size)
+		w.end(trun)
+
+		w.end(traf)
+	}
+	w.end(moof)
+
+	// data_offset是相对于moof起始位置的偏移，mdat的box header为8字节
+	offset := len(w.b) + 8
+	for i, pos := range dataOffsetPos {
+		bele.BePutUint32(w.b[pos:], uint32(offset))
+		offset += dataSize[i]
+	}
+
+	mdat := w.start("mdat")
+	for _, t := range tracks {
+		for _, s := range t.Samples {
+			w.bytes(s.Data)
+		}
+	}
+	w.end(mdat)
+
+	return w.b
+}
@@ -0,0 +1,357 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazaerrors"
+)
+
+// 参考文档：
+// ISO_IEC_14496-12 ISO base media file format
+// ISO_IEC_14496-14 MP4 file format
+// ISO_IEC_14496-15 Carriage of NAL unit structured video
+// ISO_IEC_23000-19 Common media application format (CMAF)
+//
+
+const (
+	TrackIdVideo = 1
+	TrackIdAudio = 2
+
+	VideoTimescale = 90000
+)
+
+const (
+	// sample_flags
+	sampleFlagsKey    = 0x02000000 // sample_depends_on=2
+	sampleFlagsNonKey = 0x01010000 // sample_depends_on=1, sample_is_non_sync_sample=1
+)
+
+// VideoTrack 视频轨道信息
+//
+type VideoTrack struct {
+	Codec  string // base.VideoCodecAvc 或 base.VideoCodecHevc
+	Width  int
+	Height int
+
+	// DecoderConfigurationRecord AVCDecoderConfigurationRecord或HEVCDecoderConfigurationRecord，
+	// 也即rtmp video seq header去除前5个字节
+	DecoderConfigurationRecord []byte
+}
+
+// AudioTrack 音频轨道信息，目前只支持aac
+//
+type AudioTrack struct {
+	SampleRate   int
+	ChannelCount int
+	Asc          []byte // AudioSpecificConfig，也即rtmp audio seq header去除前2个字节
+}
+
+// BuildInitSegment 生成初始化分片，也即ftyp+moov
+//
+// @param video: 为nil表示没有视频
+// @param audio: 为nil表示没有音频
+//
+// @return 内存块为内部独立新申请
+//
+func BuildInitSegment(video *VideoTrack, audio *AudioTrack) ([]byte, error) {
+	if video == nil && audio == nil {
+		return nil, nazaerrors.Wrap(base.ErrFmp4)
+	}
+	if video != nil && video.Codec != base.VideoCodecAvc && video.Codec != base.VideoCodecHevc {
+		return nil, nazaerrors.Wrap(base.ErrFmp4)
+	}
+
+	var w boxWriter
+
+	ftyp := w.start("ftyp")
+	w.bytes([]byte("iso6")) // major_brand
+	w.u32(0)                // minor_version
+	w.bytes([]byte("iso6cmfcisommp41"))
+	w.end(ftyp)
+
+	moov := w.start("moov")
+	writeMvhd(&w, 0, TrackIdAudio+1)
+	if video != nil {
+		writeVideoTrak(&w, video)
+	}
+	if audio != nil {
+		writeAudioTrak(&w, audio)
+	}
+	mvex := w.start("mvex")
+	if video != nil {
+		writeTrex(&w, TrackIdVideo)
+	}
+	if audio != nil {
+		writeTrex(&w, TrackIdAudio)
+	}
+	w.end(mvex)
+	w.end(moov)
+
+	return w.b, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// writeMvhd
+//
+// @param duration: 单位毫秒
+//
+func writeMvhd(w *boxWriter, duration uint32, nextTrackId uint32) {
+	mvhd := w.startFull("mvhd", 0, 0)
+	w.u32(0)          // creation_time
+	w.u32(0)          // modification_time
+	w.u32(1000)       // timescale
+	w.u32(duration)   // duration
+	w.u32(0x00010000) // rate
+	w.u16(0x0100)     // volume
+	w.zeros(10)       // reserved
+	writeMatrix(w)
+	w.zeros(24)        // pre_defined
+	w.u32(nextTrackId) // next_track_ID
+	w.end(mvhd)
+}
+
+func writeMatrix(w *boxWriter) {
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		w.u32(v)
+	}
+}
+
+// writeTkhd
+//
+// @param duration: 单位为mvhd的timescale，也即毫秒
+//
+func writeTkhd(w *boxWriter, trackId uint32, volume uint16, width, height int, duration uint32) {
+	tkhd := w.startFull("tkhd", 0, 0x3) // track_enabled | track_in_movie
+	w.u32(0)                            // creation_time
+	w.u32(0)                            // modification_time
+	w.u32(trackId)
+	w.u32(0)        // reserved
+	w.u32(duration) // duration
+	w.zeros(8)
+	w.u16(0) // layer
+	w.u16(0) // alternate_group
+	w.u16(volume)
+	w.u16(0) // reserved
+	writeMatrix(w)
+	w.u32(uint32(width) << 16)
+	w.u32(uint32(height) << 16)
+	w.end(tkhd)
+}
+
+// writeMdhd
+//
+// @param duration: 单位为timescale，超过32位时使用version 1
+//
+func writeMdhd(w *boxWriter, timescale uint32, duration uint64) {
+	if duration > 0xFFFFFFFF {
+		mdhd := w.startFull("mdhd", 1, 0)
+		w.u64(0) // creation_time
+		w.u64(0) // modification_time
+		w.u32(timescale)
+		w.u64(duration)
+		w.u16(0x55C4) // language und
+		w.u16(0)      // pre_defined
+		w.end(mdhd)
+		return
+	}
+
+	mdhd := w.startFull("mdhd", 0, 0)
+	w.u32(0) // creation_time
+	w.u32(0) // modification_time
+	w.u32(timescale)
+	w.u32(uint32(duration))
+	w.u16(0x55C4) // language und
+	w.u16(0)      // pre_defined
+	w.end(mdhd)
+}
+
+func writeHdlr(w *boxWriter, handlerType string, name string) {
+	hdlr := w.startFull("hdlr", 0, 0)
+	w.u32(0) // pre_defined
+	w.bytes([]byte(handlerType))
+	w.zeros(12) // reserved
+	w.bytes([]byte(name))
+	w.u8(0)
+	w.end(hdlr)
+}
+
+func writeDinf(w *boxWriter) {
+	dinf := w.start("dinf")
+	dref := w.startFull("dref", 0, 0)
+	w.u32(1) // entry_count
+	url := w.startFull("url ", 0, 1)
+	w.end(url)
+	w.end(dref)
+	w.end(dinf)
+}
+
+// writeEmptySampleTables fmp4中moov里的sample table都为空，sample信息在moof中
+//
+func writeEmptySampleTables(w *boxWriter) {
+	for _, typ := range []string{"stts", "stsc", "stco"} {
+		pos := w.startFull(typ, 0, 0)
+		w.u32(0) // entry_count
+		w.end(pos)
+	}
+	stsz := w.startFull("stsz", 0, 0)
+	w.u32(0) // sample_size
+	w.u32(0) // sample_count
+	w.end(stsz)
+}
+
+func writeTrex(w *boxWriter, trackId uint32) {
+	trex := w.startFull("trex", 0, 0)
+	w.u32(trackId)
+	w.u32(1) // default_sample_description_index
+	w.u32(0) // default_sample_duration
+	w.u32(0) // default_sample_size
+	w.u32(0) // default_sample_flags
+	w.end(trex)
+}
+
+func writeVideoTrak(w *boxWriter, video *VideoTrack) {
+	trak := w.start("trak")
+	writeTkhd(w, TrackIdVideo, 0, video.Width, video.Height, 0)
+	mdia := w.start("mdia")
+	writeMdhd(w, VideoTimescale, 0)
+	writeHdlr(w, "vide", "VideoHandler")
+	minf := w.start("minf")
+	vmhd := w.startFull("vmhd", 0, 1)
+	w.u16(0)   // graphicsmode
+	w.zeros(6) // opcolor
+	w.end(vmhd)
+	writeDinf(w)
+	stbl := w.start("stbl")
+	stsd := w.startFull("stsd", 0, 0)
+	w.u32(1) // entry_count
+
+	sampleEntryType, configType := "avc1", "avcC"
+	if video.Codec == base.VideoCodecHevc {
+		sampleEntryType, configType = "hvc1", "hvcC"
+	}
+	entry := w.start(sampleEntryType)
+	w.zeros(6) // reserved
+	w.u16(1)   // data_reference_index
+	w.zeros(16)
+	w.u16(uint16(video.Width))
+	w.u16(uint16(video.Height))
+	w.u32(0x00480000) // horizresolution 72 dpi
+	w.u32(0x00480000) // vertresolution 72 dpi
+	w.u32(0)          // reserved
+	w.u16(1)          // frame_count
+	w.zeros(32)       // compressorname
+	w.u16(0x0018)     // depth
+	w.u16(0xFFFF)     // pre_defined
+	config := w.start(configType)
+	w.bytes(video.DecoderConfigurationRecord)
+	w.end(config)
+	w.end(entry)
+
+	w.end(stsd)
+	writeEmptySampleTables(w)
+	w.end(stbl)
+	w.end(minf)
+	w.end(mdia)
+	w.end(trak)
+}
+
+func writeAudioTrak(w *boxWriter, audio *AudioTrack) {
+	trak := w.start("trak")
+	writeTkhd(w, TrackIdAudio, 0x0100, 0, 0, 0)
+	mdia := w.start("mdia")
+	writeMdhd(w, uint32(audio.SampleRate), 0)
+	writeHdlr(w, "soun", "SoundHandler")
+	minf := w.start("minf")
+	smhd := w.startFull("smhd", 0, 0)
+	w.u16(0) // balance
+	w.u16(0) // reserved
+	w.end(smhd)
+	writeDinf(w)
+	stbl := w.start("stbl")
+	stsd := w.startFull("stsd", 0, 0)
+	w.u32(1) // entry_count
+
+	mp4a := w.start("mp4a")
+	w.zeros(6) // reserved
+	w.u16(1)   // data_reference_index
+	w.zeros(8) // reserved
+	w.u16(uint16(audio.ChannelCount))
+	w.u16(16) // samplesize
+	w.u16(0)  // pre_defined
+	w.u16(0)  // reserved
+	w.u32(uint32(audio.SampleRate) << 16)
+	writeEsds(w, audio.Asc)
+	w.end(mp4a)
+
+	w.end(stsd)
+	writeEmptySampleTables(w)
+	w.end(stbl)
+	w.end(minf)
+	w.end(mdia)
+	w.end(trak)
+}
+
+// writeEsds
+//
+// ISO_IEC_14496-1 7.2.6.5 ES_Descriptor
+//
+func writeEsds(w *boxWriter, asc []byte) {
+	esds := w.startFull("esds", 0, 0)
+
+	// DecoderSpecificInfo
+	dsi := len(asc)
+	// DecoderConfigDescriptor
+	dcd := 13 + descriptorHeaderLen(dsi) + dsi
+	// SLConfigDescriptor
+	sl := 1
+	// ES_Descriptor
+	es := 3 + descriptorHeaderLen(dcd) + dcd + descriptorHeaderLen(sl) + sl
+
+	writeDescriptorHeader(w, 0x03, es)
+	w.u16(TrackIdAudio) // ES_ID
+	w.u8(0)             // flags
+
+	writeDescriptorHeader(w, 0x04, dcd)
+	w.u8(0x40) // objectTypeIndication: Audio ISO/IEC 14496-3
+	w.u8(0x15) // streamType(6b)=5 audio, upStream(1b)=0, reserved(1b)=1
+	w.u24(0)   // bufferSizeDB
+	w.u32(0)   // maxBitrate
+	w.u32(0)   // avgBitrate
+
+	writeDescriptorHeader(w, 0x05, dsi)
+	w.bytes(asc)
+
+	writeDescriptorHeader(w, 0x06, sl)
+	w.u8(0x02) // predefined: reserved for use in MP4 files
+
+	w.end(esds)
+}
+
+func descriptorHeaderLen(size int) int {
+	n := 2
+	for size >= 0x80 {
+		size >>= 7
+		n++
+	}
+	return n
+}
+
+func writeDescriptorHeader(w *boxWriter, tag uint8, size int) {
+	w.u8(tag)
+	n := descriptorHeaderLen(size) - 1
+	for i := n - 1; i >= 0; i-- {
+		v := uint8(size>>(7*uint(i))) & 0x7F
+		if i != 0 {
+			v |= 0x80
+		}
+		w.u8(v)
+	}
+}
@@ -0,0 +1,835 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+	"github.com/q191201771/naza/pkg/nazaerrors"
+)
+
+// 将fmp4（ftyp+moov+若干moof/mdat）转换为普通mp4（ftyp+moov+mdat），moov在文件头部，方便播放器、编辑工具快速打开
+//
+// - moov中的stsd等描述信息原样保留，sample信息由moof中的trun生成stts、ctts、stss、stsc、stsz、stco
+// - 每个trun中的数据作为一个chunk，mdat中的数据按照在源文件中的顺序原样拷贝，不会整个读入内存
+// - tfdt与前面sample累加的时长不一致时，修改前一个sample的时长，保证时间轴连续
+// - 以所有轨道中最早的dts为0点，开始较晚的轨道使用edit list补齐
+// - 不完整的最后一个moof/mdat（比如写文件过程中进程崩溃）会被忽略
+//
+
+const (
+	tfhdBaseDataOffsetPresent         = 0x000001
+	tfhdSampleDescriptionIndexPresent = 0x000002
+	tfhdDefaultSampleDurationPresent  = 0x000008
+	tfhdDefaultSampleSizePresent      = 0x000010
+	tfhdDefaultSampleFlagsPresent     = 0x000020
+	tfhdDefaultBaseIsMoof             = 0x020000
+
+	trunDataOffsetPresent       = 0x000001
+	trunFirstSampleFlagsPresent = 0x000004
+	trunSampleDurationPresent   = 0x000100
+	trunSampleSizePresent       = 0x000200
+	trunSampleFlagsPresent      = 0x000400
+	trunSampleCtsPresent        = 0x000800
+
+	sampleIsNonSyncSample = 0x00010000
+)
+
+// DefragmentFile 将fmp4文件转换为普通mp4文件
+//
+// @param srcFilename: fmp4文件
+// @param dstFilename: 生成的mp4文件，不能与srcFilename相同
+//
+func DefragmentFile(srcFilename string, dstFilename string) error {
+	src, err := os.Open(srcFilename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.Create(dstFilename)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(dst, 64*1024)
+	if err = Defragment(src, fi.Size(), bw); err == nil {
+		err = bw.Flush()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Defragment 将fmp4转换为普通mp4
+//
+// @param r:    fmp4数据
+// @param size: fmp4数据的大小
+// @param w:    写入生成的mp4
+//
+func Defragment(r io.ReaderAt, size int64, w io.Writer) error {
+	var tracks []*progressiveTrack
+	for pos := int64(0); pos < size; {
+		typ, headerSize, boxSize, err := readBoxHeader(r, pos, size)
+		if err != nil {
+			Log.Warnf("incomplete box at the end of fmp4, ignore. pos=%d, size=%d", pos, size)
+			break
+		}
+
+		switch typ {
+		case "moov":
+			b, err := readAt(r, pos+headerSize, boxSize-headerSize)
+			if err != nil {
+				return err
+			}
+			if tracks, err = parseProgressiveMoov(b); err != nil {
+				return err
+			}
+		case "moof":
+			if tracks == nil {
+				return nazaerrors.Wrap(base.ErrFmp4)
+			}
+			b, err := readAt(r, pos+headerSize, boxSize-headerSize)
+			if err != nil {
+				return err
+			}
+			if err = parseProgressiveMoof(b, pos, size, tracks); err != nil {
+				Log.Warnf("invalid moof in fmp4, ignore the rest. pos=%d, err=%+v", pos, err)
+				pos = size
+				continue
+			}
+		}
+		pos += boxSize
+	}
+
+	// 没有sample的轨道不写入
+	var valid []*progressiveTrack
+	for _, t := range tracks {
+		if len(t.sizes) != 0 {
+			valid = append(valid, t)
+		}
+	}
+	if len(valid) == 0 {
+		return nazaerrors.Wrap(base.ErrFmp4)
+	}
+	return writeProgressive(r, valid, w)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type progressiveBox struct {
+	typ     string
+	payload []byte
+}
+
+type progressiveTrack struct {
+	trackId   uint32
+	timescale uint32
+	volume    uint16
+	width     int
+	height    int
+
+	hdlr        progressiveBox
+	mediaHeader progressiveBox // vmhd、smhd等
+	dinf        progressiveBox
+	stsd        progressiveBox
+
+	// trex
+	defaultDuration uint32
+	defaultSize     uint32
+	defaultFlags    uint32
+
+	sizes      []uint32
+	durations  []uint32
+	ctsOffsets []int32
+	syncs      []uint32 // 关键帧的sample序号，从1开始
+	hasCts     bool
+	hasNonSync bool
+	chunks     []*progressiveChunk
+
+	hasDts   bool
+	firstDts uint64
+	nextDts  uint64 // 已解析的sample结束的时间
+}
+
+type progressiveChunk struct {
+	srcOffset   int64
+	size        int64
+	sampleCount uint32
+
+	dstOffset int64 // 相对于mdat payload的起始位置
+}
+
+func readBoxHeader(r io.ReaderAt, pos int64, fileSize int64) (typ string, headerSize int64, boxSize int64, err error) {
+	if pos+8 > fileSize {
+		return "", 0, 0, nazaerrors.Wrap(base.ErrShortBuffer)
+	}
+	var h [16]byte
+	if _, err = r.ReadAt(h[:8], pos); err != nil {
+		return
+	}
+	typ = string(h[4:8])
+	headerSize = 8
+	boxSize = int64(bele.BeUint32(h[:]))
+	switch boxSize {
+	case 0:
+		boxSize = fileSize - pos
+	case 1:
+		if pos+16 > fileSize {
+			return "", 0, 0, nazaerrors.Wrap(base.ErrShortBuffer)
+		}
+		if _, err = r.ReadAt(h[8:16], pos+8); err != nil {
+			return
+		}
+		headerSize = 16
+		boxSize = int64(bele.BeUint64(h[8:]))
+	}
+	if boxSize < headerSize || pos+boxSize > fileSize {
+		return "", 0, 0, nazaerrors.Wrap(base.ErrShortBuffer)
+	}
+	return
+}
+
+func readAt(r io.ReaderAt, pos int64, n int64) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := r.ReadAt(b, pos)
+	return b, err
+}
+
+func parseProgressiveMoov(moov []byte) (tracks []*progressiveTrack, err error) {
+	type trex struct {
+		duration, size, flags uint32
+	}
+	trexs := make(map[uint32]trex)
+
+	iterErr := IterateBox(moov, func(typ string, payload []byte) {
+		switch typ {
+		case "trak":
+			t, e := parseProgressiveTrak(payload)
+			if e != nil {
+				err = e
+				return
+			}
+			tracks = append(tracks, t)
+		case "mvex":
+			_ = IterateBox(payload, func(typ string, p []byte) {
+				if typ == "trex" && len(p) >= 24 {
+					trexs[bele.BeUint32(p[4:])] = trex{
+						duration: bele.BeUint32(p[12:]),
+						size:     bele.BeUint32(p[16:]),
+						flags:    bele.BeUint32(p[20:]),
+					}
+				}
+			})
+		}
+	})
+	if iterErr != nil {
+		return nil, iterErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 0 {
+		return nil, nazaerrors.Wrap(base.ErrFmp4)
+	}
+
+	for _, t := range tracks {
+		if v, ok := trexs[t.trackId]; ok {
+			t.defaultDuration = v.duration
+			t.defaultSize = v.size
+			t.defaultFlags = v.flags
+		}
+	}
+	return tracks, nil
+}
+
+func parseProgressiveTrak(trak []byte) (*progressiveTrack, error) {
+	t := &progressiveTrack{}
+	err := IterateBox(trak, func(typ string, payload []byte) {
+		switch typ {
+		case "tkhd":
+			// version 0的字段为32位，version 1的时间相关字段为64位
+			idPos, volumePos := 12, 36
+			if len(payload) > 0 && payload[0] == 1 {
+				idPos, volumePos = 20, 48
+			}
+			if len(payload) < volumePos+2+36+8 {
+				return
+			}
+			t.trackId = bele.BeUint32(payload[idPos:])
+			t.volume = bele.BeUint16(payload[volumePos:])
+			t.width = int(bele.BeUint32(payload[len(payload)-8:]) >> 16)
+			t.height = int(bele.BeUint32(payload[len(payload)-4:]) >> 16)
+		case "mdia":
+			_ = IterateBox(payload, func(typ string, p []byte) {
+				switch typ {
+				case "mdhd":
+					pos := 12
+					if len(p) > 0 && p[0] == 1 {
+						pos = 20
+					}
+					if len(p) >= pos+4 {
+						t.timescale = bele.BeUint32(p[pos:])
+					}
+				case "hdlr":
+					t.hdlr = progressiveBox{typ, p}
+				case "minf":
+					_ = IterateBox(p, func(typ string, p []byte) {
+						switch typ {
+						case "vmhd", "smhd", "sthd", "nmhd":
+							t.mediaHeader = progressiveBox{typ, p}
+						case "dinf":
+							t.dinf = progressiveBox{typ, p}
+						case "stbl":
+							_ = IterateBox(p, func(typ string, p []byte) {
+								if typ == "stsd" {
+									t.stsd = progressiveBox{typ, p}
+								}
+							})
+						}
+					})
+				}
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if t.trackId == 0 || t.timescale == 0 || t.stsd.typ == "" {
+		return nil, nazaerrors.Wrap(base.ErrFmp4)
+	}
+	return t, nil
+}
+
+// progressiveTraf 一个traf解析出的sample信息，所有traf都解析成功后再合并到轨道中
+//
+type progressiveTraf struct {
+	track      *progressiveTrack
+	hasTfdt    bool
+	tfdt       uint64
+	sizes      []uint32
+	durations  []uint32
+	ctsOffsets []int32
+	flags      []uint32
+	chunks     []*progressiveChunk
+}
+
+func parseProgressiveMoof(moof []byte, moofPos int64, fileSize int64, tracks []*progressiveTrack) error {
+	var (
+		trafs   []*progressiveTraf
+		err     error
+		dataEnd = moofPos // 上一个traf数据结束的位置
+	)
+	iterErr := IterateBox(moof, func(typ string, payload []byte) {
+		if typ != "traf" || err != nil {
+			return
+		}
+		var traf *progressiveTraf
+		traf, dataEnd, err = parseProgressiveTraf(payload, moofPos, dataEnd, fileSize, tracks)
+		if traf != nil {
+			trafs = append(trafs, traf)
+		}
+	})
+	if iterErr != nil {
+		return iterErr
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, traf := range trafs {
+		traf.track.append(traf)
+	}
+	return nil
+}
+
+func parseProgressiveTraf(traf []byte, moofPos int64, prevDataEnd int64, fileSize int64, tracks []*progressiveTrack) (out *progressiveTraf, dataEnd int64, err error) {
+	dataEnd = prevDataEnd
+
+	var (
+		track                                      *progressiveTrack
+		baseDataOffset                             int64
+		defaultDuration, defaultSize, defaultFlags uint32
+		hasTfhd                                    bool
+	)
+	out = &progressiveTraf{}
+
+	iterErr := IterateBox(traf, func(typ string, p []byte) {
+		if err != nil {
+			return
+		}
+		switch typ {
+		case "tfhd":
+			if len(p) < 8 {
+				err = nazaerrors.Wrap(base.ErrShortBuffer)
+				return
+			}
+			flags := bele.BeUint32(p) & 0xFFFFFF
+			trackId := bele.BeUint32(p[4:])
+			for _, t := range tracks {
+				if t.trackId == trackId {
+					track = t
+				}
+			}
+			if track == nil {
+				err = nazaerrors.Wrap(base.ErrFmp4)
+				return
+			}
+			defaultDuration, defaultSize, defaultFlags = track.defaultDuration, track.defaultSize, track.defaultFlags
+
+			// 数据偏移的基准位置
+			if flags&tfhdDefaultBaseIsMoof != 0 {
+				baseDataOffset = moofPos
+			} else {
+				baseDataOffset = prevDataEnd
+			}
+			pos := 8
+			readU32 := func() uint32 {
+				if len(p) < pos+4 {
+					err = nazaerrors.Wrap(base.ErrShortBuffer)
+					return 0
+				}
+				v := bele.BeUint32(p[pos:])
+				pos += 4
+				return v
+			}
+			if flags&tfhdBaseDataOffsetPresent != 0 {
+				if len(p) < pos+8 {
+					err = nazaerrors.Wrap(base.ErrShortBuffer)
+					return
+				}
+				baseDataOffset = int64(bele.BeUint64(p[pos:]))
+				pos += 8
+			}
+			if flags&tfhdSampleDescriptionIndexPresent != 0 {
+				readU32()
+			}
+			if flags&tfhdDefaultSampleDurationPresent != 0 {
+				defaultDuration = readU32()
+			}
+			if flags&tfhdDefaultSampleSizePresent != 0 {
+				defaultSize = readU32()
+			}
+			if flags&tfhdDefaultSampleFlagsPresent != 0 {
+				defaultFlags = readU32()
+			}
+			hasTfhd = true
+			dataEnd = baseDataOffset
+		case "tfdt":
+			if len(p) < 8 {
+				err = nazaerrors.Wrap(base.ErrShortBuffer)
+				return
+			}
+			if p[0] == 1 {
+				if len(p) < 12 {
+					err = nazaerrors.Wrap(base.ErrShortBuffer)
+					return
+				}
+				out.tfdt = bele.BeUint64(p[4:])
+			} else {
+				out.tfdt = uint64(bele.BeUint32(p[4:]))
+			}
+			out.hasTfdt = true
+		case "trun":
+			if !hasTfhd {
+				err = nazaerrors.Wrap(base.ErrFmp4)
+				return
+			}
+			dataEnd, err = out.parseTrun(p, baseDataOffset, dataEnd, fileSize, defaultDuration, defaultSize, defaultFlags)
+		}
+	})
+	if iterErr != nil {
+		return nil, prevDataEnd, iterErr
+	}
+	if err != nil {
+		return nil, prevDataEnd, err
+	}
+	if track == nil {
+		return nil, prevDataEnd, nil
+	}
+	out.track = track
+	return out, dataEnd, nil
+}
+
+// parseTrun
+//
+// @param dataPos: 没有data_offset时，数据的起始位置
+//
+// @return 该trun数据结束的位置
+//
+func (traf *progressiveTraf) parseTrun(p []byte, baseDataOffset int64, dataPos int64, fileSize int64,
+	defaultDuration, defaultSize, defaultFlags uint32) (int64, error) {
+
+	if len(p) < 8 {
+		return 0, nazaerrors.Wrap(base.ErrShortBuffer)
+	}
+	version := p[0]
+	flags := bele.BeUint32(p) & 0xFFFFFF
+	count := bele.BeUint32(p[4:])
+	pos := 8
+	if flags&trunDataOffsetPresent != 0 {
+		if len(p) < pos+4 {
+			return 0, nazaerrors.Wrap(base.ErrShortBuffer)
+		}
+		dataPos = baseDataOffset + int64(int32(bele.BeUint32(p[pos:])))
+		pos += 4
+	}
+	var firstFlags uint32
+	hasFirstFlags := flags&trunFirstSampleFlagsPresent != 0
+	if hasFirstFlags {
+		if len(p) < pos+4 {
+			return 0, nazaerrors.Wrap(base.ErrShortBuffer)
+		}
+		firstFlags = bele.BeUint32(p[pos:])
+		pos += 4
+	}
+
+	fieldCount := 0
+	for _, f := range []uint32{trunSampleDurationPresent, trunSampleSizePresent, trunSampleFlagsPresent, trunSampleCtsPresent} {
+		if flags&f != 0 {
+			fieldCount++
+		}
+	}
+	if uint64(len(p)-pos) < uint64(count)*uint64(fieldCount)*4 {
+		return 0, nazaerrors.Wrap(base.ErrShortBuffer)
+	}
+
+	chunk := &progressiveChunk{
+		srcOffset:   dataPos,
+		sampleCount: count,
+	}
+	for i := uint32(0); i < count; i++ {
+		duration, size, sampleFlags, cts := defaultDuration, defaultSize, defaultFlags, int32(0)
+		if i == 0 && hasFirstFlags {
+			sampleFlags = firstFlags
+		}
+		if flags&trunSampleDurationPresent != 0 {
+			duration = bele.BeUint32(p[pos:])
+			pos += 4
+		}
+		if flags&trunSampleSizePresent != 0 {
+			size = bele.BeUint32(p[pos:])
+			pos += 4
+		}
+		if flags&trunSampleFlagsPresent != 0 {
+			sampleFlags = bele.BeUint32(p[pos:])
+			pos += 4
+		}
+		if flags&trunSampleCtsPresent != 0 {
+			v := bele.BeUint32(p[pos:])
+			if version == 0 {
+				// version 0时为无符号数，超出int32范围的值不合理，直接截断
+				if v > 0x7FFFFFFF {
+					v = 0x7FFFFFFF
+				}
+			}
+			cts = int32(v)
+			pos += 4
+		}
+		traf.sizes = append(traf.sizes, size)
+		traf.durations = append(traf.durations, duration)
+		traf.ctsOffsets = append(traf.ctsOffsets, cts)
+		traf.flags = append(traf.flags, sampleFlags)
+		chunk.size += int64(size)
+	}
+
+	if chunk.srcOffset < 0 || chunk.srcOffset+chunk.size > fileSize {
+		return 0, nazaerrors.Wrap(base.ErrShortBuffer)
+	}
+	if count != 0 {
+		traf.chunks = append(traf.chunks, chunk)
+	}
+	return chunk.srcOffset + chunk.size, nil
+}
+
+func (t *progressiveTrack) append(traf *progressiveTraf) {
+	if len(traf.sizes) == 0 {
+		return
+	}
+
+	if !t.hasDts {
+		t.firstDts = traf.tfdt
+		t.nextDts = traf.tfdt
+		t.hasDts = true
+	} else if traf.hasTfdt && traf.tfdt != t.nextDts && len(t.durations) != 0 {
+		// 时间轴不连续，修改上一个sample的时长
+		last := len(t.durations) - 1
+		lastDts := t.nextDts - uint64(t.durations[last])
+		if traf.tfdt > lastDts && traf.tfdt-lastDts <= 0xFFFFFFFF {
+			t.durations[last] = uint32(traf.tfdt - lastDts)
+			t.nextDts = traf.tfdt
+		}
+	}
+
+	for i := range traf.sizes {
+		t.sizes = append(t.sizes, traf.sizes[i])
+		t.durations = append(t.durations, traf.durations[i])
+		t.ctsOffsets = append(t.ctsOffsets, traf.ctsOffsets[i])
+		if traf.ctsOffsets[i] != 0 {
+			t.hasCts = true
+		}
+		if traf.flags[i]&sampleIsNonSyncSample != 0 {
+			t.hasNonSync = true
+		} else {
+			t.syncs = append(t.syncs, uint32(len(t.sizes)))
+		}
+		t.nextDts += uint64(traf.durations[i])
+	}
+	t.chunks = append(t.chunks, traf.chunks...)
+}
+
+func (t *progressiveTrack) mediaDuration() uint64 {
+	return t.nextDts - t.firstDts
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func writeProgressive(r io.ReaderAt, tracks []*progressiveTrack, w io.Writer) error {
+	// 按照在源文件中的顺序排列chunk，计算在mdat中的位置
+	var chunks []*progressiveChunk
+	for _, t := range tracks {
+		chunks = append(chunks, t.chunks...)
+	}
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].srcOffset < chunks[j].srcOffset
+	})
+	var mdatPayloadSize int64
+	for _, c := range chunks {
+		c.dstOffset = mdatPayloadSize
+		mdatPayloadSize += c.size
+	}
+
+	var fw boxWriter
+	ftyp := fw.start("ftyp")
+	fw.bytes([]byte("isom")) // major_brand
+	fw.u32(0x200)            // minor_version
+	fw.bytes([]byte("isomiso2iso6mp41"))
+	fw.end(ftyp)
+
+	mdatHeaderSize := int64(8)
+	if mdatPayloadSize+8 > 0xFFFFFFFF {
+		mdatHeaderSize = 16
+	}
+
+	// 先使用stco计算moov的大小，文件超过4G时改为co64
+	moov := buildProgressiveMoov(tracks, 0, false)
+	total := int64(len(fw.b)+len(moov)) + mdatHeaderSize + mdatPayloadSize
+	co64 := total > 0xFFFFFFFF
+	if co64 {
+		moov = buildProgressiveMoov(tracks, 0, true)
+	}
+	mdatPayloadStart := int64(len(fw.b)+len(moov)) + mdatHeaderSize
+	moov = buildProgressiveMoov(tracks, mdatPayloadStart, co64)
+
+	if _, err := w.Write(fw.b); err != nil {
+		return err
+	}
+	if _, err := w.Write(moov); err != nil {
+		return err
+	}
+	mdatHeader := make([]byte, mdatHeaderSize)
+	if mdatHeaderSize == 16 {
+		bele.BePutUint32(mdatHeader, 1)
+		bele.BePutUint64(mdatHeader[8:], uint64(mdatPayloadSize+16))
+	} else {
+		bele.BePutUint32(mdatHeader, uint32(mdatPayloadSize+8))
+	}
+	copy(mdatHeader[4:], "mdat")
+	if _, err := w.Write(mdatHeader); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if _, err := io.Copy(w, io.NewSectionReader(r, c.srcOffset, c.size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildProgressiveMoov
+//
+// @param mdatPayloadStart: mdat payload在生成文件中的位置，用于计算chunk的偏移
+//
+func buildProgressiveMoov(tracks []*progressiveTrack, mdatPayloadStart int64, co64 bool) []byte {
+	// 各轨道开始时间，单位毫秒
+	startMs := make([]uint64, len(tracks))
+	minStartMs := uint64(0)
+	for i, t := range tracks {
+		startMs[i] = t.firstDts * 1000 / uint64(t.timescale)
+		if i == 0 || startMs[i] < minStartMs {
+			minStartMs = startMs[i]
+		}
+	}
+
+	var (
+		movieDuration uint64
+		nextTrackId   uint32
+	)
+	trackDurations := make([]uint64, len(tracks))
+	for i, t := range tracks {
+		trackDurations[i] = startMs[i] - minStartMs + t.mediaDuration()*1000/uint64(t.timescale)
+		if trackDurations[i] > movieDuration {
+			movieDuration = trackDurations[i]
+		}
+		if t.trackId >= nextTrackId {
+			nextTrackId = t.trackId + 1
+		}
+	}
+
+	var w boxWriter
+	moov := w.start("moov")
+	writeMvhd(&w, uint32(movieDuration), nextTrackId)
+	for i, t := range tracks {
+		trak := w.start("trak")
+		writeTkhd(&w, t.trackId, t.volume, t.width, t.height, uint32(trackDurations[i]))
+
+		if delayMs := startMs[i] - minStartMs; delayMs > 0 {
+			edts := w.start("edts")
+			elst := w.startFull("elst", 0, 0)
+			w.u32(2) // entry_count
+			// 空的edit，用于表示轨道开始前的时长
+			w.u32(uint32(delayMs))
+			w.u32(0xFFFFFFFF) // media_time -1
+			w.u32(0x00010000) // media_rate
+			w.u32(uint32(trackDurations[i] - delayMs))
+			w.u32(0)
+			w.u32(0x00010000)
+			w.end(elst)
+			w.end(edts)
+		}
+
+		mdia := w.start("mdia")
+		writeMdhd(&w, t.timescale, t.mediaDuration())
+		writeProgressiveBox(&w, t.hdlr)
+		minf := w.start("minf")
+		writeProgressiveBox(&w, t.mediaHeader)
+		writeProgressiveBox(&w, t.dinf)
+		stbl := w.start("stbl")
+		writeProgressiveBox(&w, t.stsd)
+		t.writeSampleTables(&w, mdatPayloadStart, co64)
+		w.end(stbl)
+		w.end(minf)
+		w.end(mdia)
+		w.end(trak)
+	}
+	w.end(moov)
+	return w.b
+}
+
+func writeProgressiveBox(w *boxWriter, box progressiveBox) {
+	if box.typ == "" {
+		return
+	}
+	pos := w.start(box.typ)
+	w.bytes(box.payload)
+	w.end(pos)
+}
+
+func (t *progressiveTrack) writeSampleTables(w *boxWriter, mdatPayloadStart int64, co64 bool) {
+	// stts
+	var sttsEntries [][2]uint32
+	for _, d := range t.durations {
+		if n := len(sttsEntries); n != 0 && sttsEntries[n-1][1] == d {
+			sttsEntries[n-1][0]++
+		} else {
+			sttsEntries = append(sttsEntries, [2]uint32{1, d})
+		}
+	}
+	stts := w.startFull("stts", 0, 0)
+	w.u32(uint32(len(sttsEntries)))
+	for _, e := range sttsEntries {
+		w.u32(e[0])
+		w.u32(e[1])
+	}
+	w.end(stts)
+
+	// ctts
+	if t.hasCts {
+		var version uint8
+		var cttsEntries [][2]uint32
+		for _, c := range t.ctsOffsets {
+			if c < 0 {
+				version = 1
+			}
+			if n := len(cttsEntries); n != 0 && cttsEntries[n-1][1] == uint32(c) {
+				cttsEntries[n-1][0]++
+			} else {
+				cttsEntries = append(cttsEntries, [2]uint32{1, uint32(c)})
+			}
+		}
+		ctts := w.startFull("ctts", version, 0)
+		w.u32(uint32(len(cttsEntries)))
+		for _, e := range cttsEntries {
+			w.u32(e[0])
+			w.u32(e[1])
+		}
+		w.end(ctts)
+	}
+
+	// stss，所有sample都是关键帧时不需要
+	if t.hasNonSync {
+		stss := w.startFull("stss", 0, 0)
+		w.u32(uint32(len(t.syncs)))
+		for _, s := range t.syncs {
+			w.u32(s)
+		}
+		w.end(stss)
+	}
+
+	// stsc
+	var stscEntries [][2]uint32 // first_chunk, samples_per_chunk
+	for i, c := range t.chunks {
+		if n := len(stscEntries); n == 0 || stscEntries[n-1][1] != c.sampleCount {
+			stscEntries = append(stscEntries, [2]uint32{uint32(i + 1), c.sampleCount})
+		}
+	}
+	stsc := w.startFull("stsc", 0, 0)
+	w.u32(uint32(len(stscEntries)))
+	for _, e := range stscEntries {
+		w.u32(e[0])
+		w.u32(e[1])
+		w.u32(1) // sample_description_index
+	}
+	w.end(stsc)
+
+	// stsz
+	stsz := w.startFull("stsz", 0, 0)
+	w.u32(0) // sample_size
+	w.u32(uint32(len(t.sizes)))
+	for _, s := range t.sizes {
+		w.u32(s)
+	}
+	w.end(stsz)
+
+	// stco / co64
+	if co64 {
+		co := w.startFull("co64", 0, 0)
+		w.u32(uint32(len(t.chunks)))
+		for _, c := range t.chunks {
+			w.u64(uint64(mdatPayloadStart + c.dstOffset))
+		}
+		w.end(co)
+	} else {
+		co := w.startFull("stco", 0, 0)
+		w.u32(uint32(len(t.chunks)))
+		for _, c := range t.chunks {
+			w.u32(uint32(mdatPayloadStart + c.dstOffset))
+		}
+		w.end(co)
+	}
+}
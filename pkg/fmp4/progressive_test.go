@@ -0,0 +1,165 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package fmp4_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// findBox 按路径查找box，比如 "moov/trak/mdia"，index指定同名box中的第几个
+//
+func findBox(t *testing.T, b []byte, path []string, index int) []byte {
+	var out []byte
+	n := 0
+	err := fmp4.IterateBox(b, func(typ string, payload []byte) {
+		if typ != path[0] || out != nil {
+			return
+		}
+		if len(path) > 1 {
+			out = findBox(t, payload, path[1:], index)
+			return
+		}
+		if n == index {
+			out = payload
+		}
+		n++
+	})
+	assert.Equal(t, nil, err)
+	return out
+}
+
+func TestDefragment(t *testing.T) {
+	video := &fmp4.VideoTrack{
+		Codec:                      base.VideoCodecAvc,
+		Width:                      1280,
+		Height:                     720,
+		DecoderConfigurationRecord: []byte{0x01, 0x64, 0x00, 0x1f, 0xff, 0xe1},
+	}
+	audio := &fmp4.AudioTrack{
+		SampleRate:   48000,
+		ChannelCount: 2,
+		Asc:          []byte{0x11, 0x90},
+	}
+	fmp4File, err := fmp4.BuildInitSegment(video, audio)
+	assert.Equal(t, nil, err)
+
+	// 视频从0开始，音频从100毫秒开始。第二个分片的视频tfdt与前面累加的时长不一致
+	fmp4File = append(fmp4File, fmp4.BuildFragment(1, []fmp4.TrackFragment{
+		{
+			TrackId:             fmp4.TrackIdVideo,
+			BaseMediaDecodeTime: 0,
+			Samples: []fmp4.Sample{
+				{Duration: 3600, IsKey: true, Data: []byte{1, 1}},
+				{Duration: 3600, CtsOffset: 3600, Data: []byte{1, 2}},
+			},
+		},
+		{
+			TrackId:             fmp4.TrackIdAudio,
+			BaseMediaDecodeTime: 4800,
+			Samples: []fmp4.Sample{
+				{Duration: 1024, IsKey: true, Data: []byte{2, 1, 1}},
+			},
+		},
+	})...)
+	fmp4File = append(fmp4File, fmp4.BuildFragment(2, []fmp4.TrackFragment{
+		{
+			TrackId:             fmp4.TrackIdVideo,
+			BaseMediaDecodeTime: 9000,
+			Samples: []fmp4.Sample{
+				{Duration: 3600, IsKey: true, Data: []byte{1, 3}},
+			},
+		},
+		{
+			TrackId:             fmp4.TrackIdAudio,
+			BaseMediaDecodeTime: 5824,
+			Samples: []fmp4.Sample{
+				{Duration: 1024, IsKey: true, Data: []byte{2, 2, 2}},
+			},
+		},
+	})...)
+	completeSize := len(fmp4File)
+
+	// 模拟写文件过程中崩溃，最后一个分片不完整
+	last := fmp4.BuildFragment(3, []fmp4.TrackFragment{
+		{
+			TrackId:             fmp4.TrackIdVideo,
+			BaseMediaDecodeTime: 12600,
+			Samples:             []fmp4.Sample{{Duration: 3600, Data: []byte{1, 4}}},
+		},
+	})
+	fmp4File = append(fmp4File, last[:len(last)-1]...)
+
+	var out bytes.Buffer
+	err = fmp4.Defragment(bytes.NewReader(fmp4File), int64(len(fmp4File)), &out)
+	assert.Equal(t, nil, err)
+	b := out.Bytes()
+
+	types, payloads := boxTypes(t, b)
+	assert.Equal(t, []string{"ftyp", "moov", "mdat"}, types)
+	assert.Equal(t, []byte{1, 1, 1, 2, 2, 1, 1, 1, 3, 2, 2, 2}, payloads["mdat"])
+	types, _ = boxTypes(t, payloads["moov"])
+	assert.Equal(t, []string{"mvhd", "trak", "trak"}, types)
+
+	// 视频轨道，第二个sample的时长被修改为5400，使得第三个sample的dts与tfdt一致
+	stts := findBox(t, b, []string{"moov", "trak", "mdia", "minf", "stbl", "stts"}, 0)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 1, 0, 0, 0x0E, 0x10, 0, 0, 0, 1, 0, 0, 0x15, 0x18, 0, 0, 0, 1, 0, 0, 0x0E, 0x10}, stts)
+	mdhd := findBox(t, b, []string{"moov", "trak", "mdia", "mdhd"}, 0)
+	assert.Equal(t, uint32(12600), bele.BeUint32(mdhd[16:]))
+	ctts := findBox(t, b, []string{"moov", "trak", "mdia", "minf", "stbl", "ctts"}, 0)
+	assert.Equal(t, uint32(3), bele.BeUint32(ctts[4:]))
+	stss := findBox(t, b, []string{"moov", "trak", "mdia", "minf", "stbl", "stss"}, 0)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 3}, stss)
+
+	// chunk偏移指向mdat中对应的数据
+	stco := findBox(t, b, []string{"moov", "trak", "mdia", "minf", "stbl", "stco"}, 0)
+	assert.Equal(t, uint32(2), bele.BeUint32(stco[4:]))
+	assert.Equal(t, []byte{1, 1}, b[bele.BeUint32(stco[8:]):][:2])
+	assert.Equal(t, []byte{1, 3}, b[bele.BeUint32(stco[12:]):][:2])
+
+	// 音频轨道晚开始100毫秒，使用edit list补齐
+	var audioTrak []byte
+	_ = fmp4.IterateBox(payloads["moov"], func(typ string, payload []byte) {
+		if typ == "trak" {
+			audioTrak = payload
+		}
+	})
+	elst := findBox(t, audioTrak, []string{"edts", "elst"}, 0)
+	assert.Equal(t, uint32(2), bele.BeUint32(elst[4:]))
+	assert.Equal(t, uint32(100), bele.BeUint32(elst[8:]))
+	assert.Equal(t, uint32(0xFFFFFFFF), bele.BeUint32(elst[12:]))
+	stbl := findBox(t, audioTrak, []string{"mdia", "minf", "stbl"}, 0)
+	types, _ = boxTypes(t, stbl)
+	assert.Equal(t, []string{"stsd", "stts", "stsc", "stsz", "stco"}, types)
+
+	// 通过文件转换，结果一致
+	dir, err := ioutil.TempDir("", "lal_fmp4_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "in.mp4")
+	dst := filepath.Join(dir, "out.mp4")
+	assert.Equal(t, nil, ioutil.WriteFile(src, fmp4File[:completeSize], 0666))
+	assert.Equal(t, nil, fmp4.DefragmentFile(src, dst))
+	fileContent, err := ioutil.ReadFile(dst)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, b, fileContent)
+
+	// 没有媒体分片
+	init, _ := fmp4.BuildInitSegment(video, nil)
+	err = fmp4.Defragment(bytes.NewReader(init), int64(len(init)), &out)
+	assert.IsNotNil(t, err)
+}
@@ -11,6 +11,8 @@ package gb28181
 import (
 	"bytes"
 	"encoding/hex"
+	"time"
+
 	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/h2645"
 	"github.com/q191201771/lal/pkg/rtprtcp"
@@ -42,6 +44,9 @@ type PsUnpacker struct {
 	preAudioRtpts int64
 	preVideoRtpts int64
 
+	// 以第一个pack header中的SCR作为参考点，映射到本地时间，用于计算帧的绝对采集时间
+	captureClock base.CaptureClock
+
 	onAvPacket base.OnAvPacketFunc
 }
 
@@ -67,6 +72,7 @@ func NewPsUnpacker() *PsUnpacker {
 //	Pts         int64      pts，单位毫秒
 //	Payload     []byte     对于视频，h264和h265是AnnexB格式
 //                         对于音频，AAC是前面携带adts的格式
+//	CaptureTimeMs int64    绝对采集时间，unix时间戳，单位毫秒。以第一个pack header中的SCR为参考点映射到本地时间
 //
 func (p *PsUnpacker) WithOnAvPacket(onAvPacket base.OnAvPacketFunc) *PsUnpacker {
 	p.onAvPacket = onAvPacket
@@ -170,7 +176,7 @@ func (p *PsUnpacker) FeedRtpBody(rtpBody []byte, rtpts uint32) {
 		switch code {
 		case psPackStartCodePackHeader:
 			//nazalog.Debugf("----------pack header----------")
-			consumed = parsePackHeader(rb, i)
+			consumed = p.parsePackHeader(rb, i)
 		case psPackStartCodeSystemHeader:
 			//nazalog.Debugf("----------system header----------")
 			// 2.5.3.5 System header
@@ -343,10 +349,11 @@ func (p *PsUnpacker) parseAvStream(code int, rtpts uint32, rb []byte, index int)
 					} else {
 						if p.preAudioRtpts != int64(rtpts) {
 							p.onAvPacket(&base.AvPacket{
-								PayloadType: p.audioPayloadType,
-								Timestamp:   p.preAudioDts / 90,
-								Pts:         p.preAudioPts / 90,
-								Payload:     p.audioBuf,
+								PayloadType:   p.audioPayloadType,
+								Timestamp:     p.preAudioDts / 90,
+								Pts:           p.preAudioPts / 90,
+								Payload:       p.audioBuf,
+								CaptureTimeMs: p.captureClock.Calc(p.preAudioDts / 90),
 							})
 							p.audioBuf = nil
 						} else {
@@ -359,10 +366,11 @@ func (p *PsUnpacker) parseAvStream(code int, rtpts uint32, rb []byte, index int)
 			} else {
 				if pts != p.preAudioPts && p.preAudioPts >= 0 {
 					p.onAvPacket(&base.AvPacket{
-						PayloadType: p.audioPayloadType,
-						Timestamp:   p.preAudioDts / 90,
-						Pts:         p.preAudioPts / 90,
-						Payload:     p.audioBuf,
+						PayloadType:   p.audioPayloadType,
+						Timestamp:     p.preAudioDts / 90,
+						Pts:           p.preAudioPts / 90,
+						Payload:       p.audioBuf,
+						CaptureTimeMs: p.captureClock.Calc(p.preAudioDts / 90),
 					})
 					p.audioBuf = nil
 				} else {
@@ -429,14 +437,14 @@ func (p *PsUnpacker) parseAvStream(code int, rtpts uint32, rb []byte, index int)
 
 // parsePackHeader 注意，`rb[index:]`为待解析的内存块
 //
-func parsePackHeader(rb []byte, index int) int {
+func (p *PsUnpacker) parsePackHeader(rb []byte, index int) int {
 	// 2.5.3.3 Pack layer of Program Stream
 	// Table 2-33 - Program Stream pack header
 
 	i := index
 	// TODO(chef): 这里按MPEG-2处理，还需要处理MPEG-1 202206
 
-	// skip system clock reference(SCR)
+	// system clock reference(SCR)
 	// skip PES program mux rate
 	i += 6 + 3
 	if len(rb) <= i {
@@ -444,6 +452,11 @@ func parsePackHeader(rb []byte, index int) int {
 		return -1
 	}
 
+	if !p.captureClock.IsAnchored() {
+		scr := readScrBase(rb[index:])
+		p.captureClock.Anchor(int64(scr/90), time.Now().UnixNano()/1e6)
+	}
+
 	// skip stuffing
 	l := int(rb[i] & 0x7)
 	i += 1 + l
@@ -451,6 +464,28 @@ func parsePackHeader(rb []byte, index int) int {
 	return i - index
 }
 
+// readScrBase 读取pack header中SCR的system_clock_reference_base部分，单位为90kHz
+//
+// '01'                                 [2b]
+// system_clock_reference_base [32..30] [3b]
+// marker_bit                           [1b]
+// system_clock_reference_base [29..15] [15b]
+// marker_bit                           [1b]
+// system_clock_reference_base [14..0]  [15b]
+// marker_bit                           [1b]
+// system_clock_reference_extension     [9b]
+// marker_bit                           [1b]
+//
+func readScrBase(b []byte) uint64 {
+	return uint64(b[0]>>3&0x7)<<30 |
+		uint64(b[0]&0x3)<<28 |
+		uint64(b[1])<<20 |
+		uint64(b[2]>>3)<<15 |
+		uint64(b[2]&0x3)<<13 |
+		uint64(b[3])<<5 |
+		uint64(b[4]>>3)
+}
+
 func parsePackStreamBody(rb []byte, index int) int {
 	i := index
 
@@ -476,6 +511,8 @@ func (p *PsUnpacker) iterateNaluByStartCode(code int, pts, dts int64) {
 		return
 	}
 
+	captureTimeMs := p.captureClock.Calc(dts / 90)
+
 	nextPos := startPos
 	nalu := p.videoBuf[:0]
 	for startPos >= 0 {
@@ -491,10 +528,11 @@ func (p *PsUnpacker) iterateNaluByStartCode(code int, pts, dts int64) {
 		startPos = nextPos
 
 		p.onAvPacket(&base.AvPacket{
-			PayloadType: p.videoPayloadType,
-			Timestamp:   dts / 90,
-			Pts:         pts / 90,
-			Payload:     nalu,
+			PayloadType:   p.videoPayloadType,
+			Timestamp:     dts / 90,
+			Pts:           pts / 90,
+			Payload:       nalu,
+			CaptureTimeMs: captureTimeMs,
 		})
 
 		if nextPos >= 0 {
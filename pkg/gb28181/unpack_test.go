@@ -18,6 +18,7 @@ import (
 	"testing"
 
 	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/naza/pkg/assert"
 	"github.com/q191201771/naza/pkg/nazabytes"
 	"github.com/q191201771/naza/pkg/nazalog"
 )
@@ -110,6 +111,12 @@ var hevcNalu = []byte{
 	0x00, 0x00, 0x01, 0x26, 0x01, 0x83,
 }
 
+func TestReadScrBase(t *testing.T) {
+	// 取自goldenRtpList中pack header的SCR字段
+	b, _ := hex.DecodeString("5789b48d6401")
+	assert.Equal(t, uint64(3097170348), readScrBase(b))
+}
+
 func TestPsUnpacker2(t *testing.T) {
 	// 解析别人提供的一些测试数据，开发阶段用
 	//test1()
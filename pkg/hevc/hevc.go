@@ -9,10 +9,9 @@
 package hevc
 
 import (
-	"bytes"
-
 	"github.com/q191201771/naza/pkg/nazaerrors"
 
+	"github.com/q191201771/lal/pkg/avc"
 	"github.com/q191201771/lal/pkg/base"
 
 	"github.com/q191201771/naza/pkg/nazabits"
@@ -642,9 +641,6 @@ func newContext() *Context {
 }
 
 func nal2rbsp(nal []byte) []byte {
-	// TODO chef:
-	// 1. 输出应该可由外部申请
-	// 2. 替换性能
-	// 3. 该函数应该放入avc中
-	return bytes.Replace(nal, []byte{0x0, 0x0, 0x3}, []byte{0x0, 0x0}, -1)
+	// TODO chef: 输出应该可由外部申请
+	return avc.Nal2Rbsp(nal)
 }
@@ -11,6 +11,7 @@ package hevc_test
 import (
 	"testing"
 
+	"github.com/q191201771/lal/pkg/avc"
 	"github.com/q191201771/lal/pkg/hevc"
 	"github.com/q191201771/naza/pkg/assert"
 )
@@ -97,3 +98,20 @@ func TestParseSps(t *testing.T) {
 	err := hevc.ParseSps(goldenSps2, &ctx)
 	assert.Equal(t, nil, err)
 }
+
+func TestSei(t *testing.T) {
+	msgs := []avc.SeiMessage{
+		{PayloadType: avc.SeiPayloadTypeUserDataUnregistered, Payload: []byte{0x00, 0x00, 0x00, 0x01, 0x02}},
+	}
+	for _, suffix := range []bool{false, true} {
+		nalu := hevc.BuildSei(msgs, suffix)
+		if suffix {
+			assert.Equal(t, hevc.NaluTypeSeiSuffix, hevc.ParseNaluType(nalu[0]))
+		} else {
+			assert.Equal(t, hevc.NaluTypeSei, hevc.ParseNaluType(nalu[0]))
+		}
+		ret, err := hevc.ParseSei(nalu)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, msgs, ret)
+	}
+}
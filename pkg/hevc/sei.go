@@ -0,0 +1,54 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hevc
+
+import (
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazaerrors"
+)
+
+// ParseSei 解析SEI nalu（prefix或suffix）中的所有sei_message
+//
+// sei_message的格式与h264相同，所以复用 avc.SeiMessage 以及 avc.ParseSeiRbsp
+//
+// @param nalu: 不包含start code或长度前缀，包含2字节的nalu header
+//
+// @return 返回的内存块为内部独立新申请
+//
+func ParseSei(nalu []byte) ([]avc.SeiMessage, error) {
+	if len(nalu) < 3 {
+		return nil, nazaerrors.Wrap(base.ErrShortBuffer)
+	}
+	t := ParseNaluType(nalu[0])
+	if t != NaluTypeSei && t != NaluTypeSeiSuffix {
+		return nil, nazaerrors.Wrap(base.ErrHevc)
+	}
+	return avc.ParseSeiRbsp(avc.Nal2Rbsp(nalu[2:]))
+}
+
+// BuildSei 将sei_message打包成SEI nalu
+//
+// @param suffix: true则构造suffix SEI，false则构造prefix SEI
+//
+// @return 不包含start code或长度前缀，包含2字节的nalu header，已插入防竞争字节
+//
+func BuildSei(msgs []avc.SeiMessage, suffix bool) []byte {
+	t := NaluTypeSei
+	if suffix {
+		t = NaluTypeSeiSuffix
+	}
+	nal := avc.Rbsp2Nal(avc.BuildSeiRbsp(msgs))
+	out := make([]byte, 2+len(nal))
+	// forbidden_zero_bit(1) nal_unit_type(6) nuh_layer_id(6) nuh_temporal_id_plus1(3)
+	out[0] = t << 1
+	out[1] = 1
+	copy(out[2:], nal)
+	return out
+}
@@ -0,0 +1,85 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"fmt"
+
+	"github.com/q191201771/lal/pkg/mpegts"
+)
+
+// 广告插入（SCTE-35）
+//
+// 收到SCTE-35信令后，在下一个边界处（也即关键帧处）开启新分片，并将信令写入新分片的开头。
+// splice_insert在m3u8中标记为`#EXT-X-CUE-OUT`（进入广告）或`#EXT-X-CUE-IN`（返回节目），
+// time_signal只对齐分片，不在m3u8中标记
+//
+
+type pendingCue struct {
+	info    mpegts.Scte35SpliceInfo
+	packets []byte
+}
+
+func (m *Muxer) feedScte35(tsPackets []byte, frame *mpegts.Frame) {
+	info, err := mpegts.ParseScte35Section(frame.Raw)
+	if err != nil {
+		Log.Warnf("[%s] parse scte35 section failed. err=%+v", m.UniqueKey, err)
+		return
+	}
+	if m.pendingCue != nil {
+		Log.Warnf("[%s] drop pending scte35. info=%+v", m.UniqueKey, m.pendingCue.info)
+	}
+	Log.Infof("[%s] feed scte35. info=%+v", m.UniqueKey, info)
+	m.pendingCue = &pendingCue{
+		info:    info,
+		packets: append([]byte(nil), tsPackets...),
+	}
+}
+
+// applyPendingCue 新分片打开时调用，记录cue信息并写入SCTE-35信令
+//
+func (m *Muxer) applyPendingCue(frag *fragmentInfo) error {
+	frag.cueOut = false
+	frag.cueOutDuration = 0
+	frag.cueIn = false
+
+	if m.pendingCue == nil {
+		return nil
+	}
+	cue := m.pendingCue
+	m.pendingCue = nil
+
+	if cue.info.CommandType == mpegts.Scte35CommandSpliceInsert && !cue.info.Cancel {
+		if cue.info.OutOfNetwork {
+			frag.cueOut = true
+			frag.cueOutDuration = float64(cue.info.Duration) / 90000
+		} else {
+			frag.cueIn = true
+		}
+	}
+
+	if err := m.fragment.WriteFile(cue.packets); err != nil {
+		return err
+	}
+	m.fragBytes += len(cue.packets)
+	return nil
+}
+
+func buildCueTag(frag *fragmentInfo) string {
+	if frag.cueOut {
+		if frag.cueOutDuration > 0 {
+			return fmt.Sprintf("#EXT-X-CUE-OUT:DURATION=%.3f\n", frag.cueOutDuration)
+		}
+		return "#EXT-X-CUE-OUT\n"
+	}
+	if frag.cueIn {
+		return "#EXT-X-CUE-IN\n"
+	}
+	return ""
+}
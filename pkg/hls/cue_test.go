@@ -0,0 +1,96 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestMuxerCue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_cue")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	config := &hls.MuxerConfig{
+		OutPath:            dir,
+		FragmentDurationMs: 2000,
+		FragmentNum:        6,
+		DeleteThreshold:    6,
+		CleanupMode:        hls.CleanupModeNever,
+	}
+	m := hls.NewMuxer("test110", config, &mockMuxerObserver{})
+	m.Start()
+	patpmt, err := mpegts.AppendScte35StreamToPatPmt(mpegts.FixedFragmentHeader)
+	assert.Equal(t, nil, err)
+	m.FeedPatPmt(patpmt)
+
+	feedScte35 := func(info mpegts.Scte35SpliceInfo) {
+		section := mpegts.PackScte35Section(info)
+		packets, _ := mpegts.PackSection(mpegts.PidScte35, 0, section)
+		m.FeedMpegts(packets, &mpegts.Frame{Pts: info.PtsTime, Dts: info.PtsTime, Pid: mpegts.PidScte35, Raw: section}, false)
+	}
+
+	// 关键帧间隔0.5秒，分片时长2秒，在1秒处进入广告，2.5秒处返回节目
+	for i := uint64(0); i <= 12; i++ {
+		ts := i * 45000
+		if i == 2 {
+			feedScte35(mpegts.Scte35SpliceInfo{CommandType: mpegts.Scte35CommandSpliceInsert, EventId: 1, OutOfNetwork: true, PtsTime: ts, Duration: 135000})
+		}
+		if i == 5 {
+			feedScte35(mpegts.Scte35SpliceInfo{CommandType: mpegts.Scte35CommandSpliceInsert, EventId: 1, PtsTime: ts})
+		}
+		frame := &mpegts.Frame{
+			Pts: ts,
+			Dts: ts,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: true,
+			Raw: []byte{0, 0, 0, 1, 0x65, 0x88, byte(i)},
+		}
+		m.FeedMpegts(frame.Pack(), frame, true)
+	}
+	m.Dispose()
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "test110", "record.m3u8"))
+	assert.Equal(t, nil, err)
+	lines := strings.Split(string(content), "\n")
+	var tags []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#EXTINF") || strings.HasPrefix(line, "#EXT-X-CUE") {
+			tags = append(tags, line)
+		}
+	}
+	assert.Equal(t, []string{
+		"#EXTINF:1.000,",
+		"#EXT-X-CUE-OUT:DURATION=1.500",
+		"#EXTINF:1.500,",
+		"#EXT-X-CUE-IN",
+		"#EXTINF:2.000,",
+		"#EXTINF:1.500,",
+	}, tags)
+
+	// 信令写在分片的开头
+	frags := listFragments(string(content))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "test110", frags[1]))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, patpmt, b[:376])
+	assert.Equal(t, []byte{0x47, 0x41, 0x04}, b[376:379])
+
+	live, err := ioutil.ReadFile(filepath.Join(dir, "test110", "playlist.m3u8"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(live), "#EXT-X-CUE-OUT:DURATION=1.500\n#EXT-X-PROGRAM-DATE-TIME"))
+}
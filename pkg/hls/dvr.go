@@ -0,0 +1,83 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DVR（时移）：
+//
+// - 配置 MuxerConfig.DvrWindowMs 后，额外生成dvr m3u8，包含最近一段时间（比如2小时）内的所有fragment，播放器可以在窗口内拖动
+// - live m3u8依然只包含 MuxerConfig.FragmentNum 个fragment，两者互不影响
+// - CleanupModeAsap 模式下，fragment文件在离开时移窗口，并且离开live m3u8的删除阈值后才删除
+//
+
+// updateDvr closeFragment中调用，将刚关闭的fragment加入时移窗口，淘汰过期的fragment，并更新dvr m3u8
+//
+func (m *Muxer) updateDvr(frag *fragmentInfo, isLast bool) {
+	f := *frag
+	f.parts = nil
+	m.dvrFrags = append(m.dvrFrags, f)
+
+	window := float64(m.config.DvrWindowMs) / 1000
+	var total float64
+	for i := range m.dvrFrags {
+		total += m.dvrFrags[i].duration
+	}
+	for len(m.dvrFrags) > 1 && total-m.dvrFrags[0].duration >= window {
+		evicted := m.dvrFrags[0]
+		m.dvrFrags = m.dvrFrags[1:]
+		total -= evicted.duration
+
+		// 注意，已经离开live m3u8删除阈值的fragment，在离开时才被跳过删除，所以需要在这里删除，见 getDeleteFrag
+		if m.config.CleanupMode == CleanupModeAsap && evicted.id < m.frag-m.config.DeleteThreshold-1 {
+			if err := m.removeFragment(evicted.filename); err != nil {
+				Log.Warnf("[%s] remove stale dvr fragment file failed. filename=%s, err=%+v", m.UniqueKey, evicted.filename, err)
+			}
+		}
+	}
+
+	m.writeDvrPlaylist(isLast)
+}
+
+// isInDvrWindow fragment是否还在时移窗口内
+//
+func (m *Muxer) isInDvrWindow(id int) bool {
+	return len(m.dvrFrags) > 0 && id >= m.dvrFrags[0].id
+}
+
+func (m *Muxer) writeDvrPlaylist(isLast bool) {
+	maxFrag := float64(m.config.FragmentDurationMs) / 1000
+	for i := range m.dvrFrags {
+		if m.dvrFrags[i].duration > maxFrag {
+			maxFrag = m.dvrFrags[i].duration + 0.5
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", m.playlistVersion()))
+	buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(maxFrag)))
+	buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n\n", m.dvrFrags[0].id))
+
+	var fw playlistFragWriter
+	for i := range m.dvrFrags {
+		fw.write(&buf, &m.dvrFrags[i])
+	}
+
+	if isLast {
+		buf.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	if err := writeM3u8File(buf.Bytes(), m.dvrPlaylistFilename, m.dvrPlaylistFilenameBak); err != nil {
+		Log.Errorf("[%s] write dvr m3u8 file error. err=%+v", m.UniqueKey, err)
+	}
+}
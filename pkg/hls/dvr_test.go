@@ -0,0 +1,179 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func listFragments(content string) (frags []string) {
+	for _, line := range strings.Split(content, "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			frags = append(frags, line)
+		}
+	}
+	return
+}
+
+func TestMuxerDvr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_dvr")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	config := &hls.MuxerConfig{
+		OutPath:            dir,
+		FragmentDurationMs: 1000,
+		FragmentNum:        2,
+		DeleteThreshold:    1,
+		CleanupMode:        hls.CleanupModeAsap,
+		DvrWindowMs:        4000,
+	}
+	m := hls.NewMuxer("test110", config, &mockMuxerObserver{})
+	m.Start()
+	m.FeedPatPmt(mpegts.FixedFragmentHeader)
+
+	// 每个分片1秒，共生成9个完整的分片
+	for i := uint64(0); i < 10; i++ {
+		frame := &mpegts.Frame{
+			Pts: i * 90000,
+			Dts: i * 90000,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: true,
+			Raw: []byte{0, 0, 0, 1, 0x65, 0x88, byte(i)},
+		}
+		m.FeedMpegts(frame.Pack(), frame, true)
+
+		if i == 9 {
+			break
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, "test110", "playlist.m3u8"))
+		if i > 0 {
+			assert.Equal(t, nil, err)
+			assert.Equal(t, true, len(listFragments(string(content))) <= 2)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "test110", "dvr.m3u8"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(content), "#EXT-X-MEDIA-SEQUENCE:5\n"))
+	assert.Equal(t, false, strings.Contains(string(content), "#EXT-X-ENDLIST"))
+
+	// 时移窗口内的分片都保留，窗口外并且不在live m3u8中的分片被删除
+	dvrFrags := listFragments(string(content))
+	assert.Equal(t, 4, len(dvrFrags))
+	for _, frag := range dvrFrags {
+		_, err := os.Stat(filepath.Join(dir, "test110", frag))
+		assert.Equal(t, nil, err)
+	}
+	fis, err := ioutil.ReadDir(filepath.Join(dir, "test110"))
+	assert.Equal(t, nil, err)
+	var tsNum int
+	for _, fi := range fis {
+		if strings.HasSuffix(fi.Name(), ".ts") {
+			tsNum++
+		}
+	}
+	// 4个时移窗口内的分片，以及正在写的分片
+	assert.Equal(t, 5, tsNum)
+
+	m.Dispose()
+	content, err = ioutil.ReadFile(filepath.Join(dir, "test110", "dvr.m3u8"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.HasSuffix(string(content), "#EXT-X-ENDLIST\n"))
+}
+
+func TestBuildVodClipPlaylist(t *testing.T) {
+	golden := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:5
+#EXT-X-MEDIA-SEQUENCE:0
+
+#EXT-X-PROGRAM-DATE-TIME:2022-07-01T14:00:00.000Z
+#EXTINF:4.000,
+a-0.ts
+#EXTINF:4.500,
+a-1.ts
+#EXT-X-DISCONTINUITY
+#EXT-X-PROGRAM-DATE-TIME:2022-07-01T15:00:00.000Z
+#EXTINF:4.000,
+a-2.ts
+#EXTINF:4.000,
+a-3.ts
+`
+
+	// 相对偏移
+	out, err := hls.BuildVodClipPlaylist([]byte(golden), "5", "9")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:5
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+
+#EXT-X-PROGRAM-DATE-TIME:2022-07-01T14:00:04.000Z
+#EXTINF:4.500,
+a-1.ts
+#EXT-X-DISCONTINUITY
+#EXT-X-PROGRAM-DATE-TIME:2022-07-01T15:00:00.000Z
+#EXTINF:4.000,
+a-2.ts
+#EXT-X-ENDLIST
+`, string(out))
+
+	// 墙上时间，没有`#EXT-X-PROGRAM-DATE-TIME`的分片根据前一个分片推算
+	out, err = hls.BuildVodClipPlaylist([]byte(golden), "2022-07-01T15:00:05Z", "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"a-3.ts"}, listFragments(string(out)))
+	assert.Equal(t, true, strings.Contains(string(out), "#EXT-X-PROGRAM-DATE-TIME:2022-07-01T15:00:04.000Z\n"))
+
+	out, err = hls.BuildVodClipPlaylist([]byte(golden), "", "2022-07-01T14:00:04Z")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"a-0.ts"}, listFragments(string(out)))
+
+	_, err = hls.BuildVodClipPlaylist([]byte(golden), "abc", "")
+	assert.IsNotNil(t, err)
+	_, err = hls.BuildVodClipPlaylist([]byte(golden), "100", "")
+	assert.IsNotNil(t, err)
+
+	// 通过http请求
+	dir, err := ioutil.TempDir("", "lal_hls_clip")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	assert.Equal(t, nil, os.MkdirAll(filepath.Join(dir, "test110"), 0777))
+	assert.Equal(t, nil, ioutil.WriteFile(filepath.Join(dir, "test110", "record.m3u8"), []byte(golden), 0666))
+
+	handler := hls.NewServerHandler(dir)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/hls/test110/record.m3u8?start=0&end=4", nil))
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, []string{"a-0.ts"}, listFragments(resp.Body.String()))
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/hls/test110/record.m3u8?start=abc", nil))
+	assert.Equal(t, 400, resp.Code)
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/hls/test110/record.m3u8?start=100", nil))
+	assert.Equal(t, 404, resp.Code)
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/hls/test110/record.m3u8?token=abc", nil))
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, golden, resp.Body.String())
+}
@@ -0,0 +1,231 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/aac"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/mpegts"
+)
+
+// 分片加密：
+//
+// - 开启后，流开始时以及每隔 MuxerConfig.KeyRotateFragmentNum 个分片，通过 IKeyProvider 获取新的密钥（异步提前获取，见 IKeyProvider ）
+// - 密钥写入与分片相同的目录下，文件名格式为{liveid}-{timestamp}-{keyid}.key，m3u8中通过`#EXT-X-KEY`的URI引用
+// - live、dvr以及record m3u8中都不再有fragment引用的key文件被删除
+// - 目前只支持ts分片，分片格式为fmp4时不加密
+//
+
+// fragmentKey 记录分片使用的密钥信息，多个分片可能共用同一个
+type fragmentKey struct {
+	method   string
+	filename string // key文件名，写入`#EXT-X-KEY`的URI字段
+	key      []byte
+	iv       []byte
+}
+
+// SetKeyProvider 设置分片加密的密钥提供方，覆盖根据配置创建的内置密钥提供方，见 NewKeyProvider
+//
+// 注意，需要在 Start 之前调用
+//
+func (m *Muxer) SetKeyProvider(p IKeyProvider) {
+	m.keyProvider = p
+}
+
+// encryptMethod 获取当前分片使用的加密方式
+//
+func (m *Muxer) encryptMethod() string {
+	if m.config.EncryptMethod == EncryptMethodNone || m.isFmp4() {
+		return EncryptMethodNone
+	}
+	// SAMPLE-AES的ts格式只支持H264，H265降级为整体加密
+	if m.config.EncryptMethod == EncryptMethodSampleAes && bytes.Equal(m.patpmt, mpegts.FixedFragmentHeaderHevc) {
+		return EncryptMethodAes128
+	}
+	return m.config.EncryptMethod
+}
+
+// startKeyFetcherIfNeeded Start 中调用，密钥提供方可能阻塞时，提前获取第一个密钥
+//
+func (m *Muxer) startKeyFetcherIfNeeded() {
+	if m.config.EncryptMethod == EncryptMethodNone || m.isFmp4() || m.keyProvider == nil {
+		return
+	}
+	if p, ok := m.keyProvider.(INonBlockingKeyProvider); ok && p.NonBlocking() {
+		return
+	}
+	m.keyFetcher = newKeyFetcher(m.keyProvider, m.streamName)
+	m.keyFetcher.fetch(m.keyId)
+}
+
+// nextKey 获取序号为 m.keyId 的密钥
+//
+// @return ok: 为false表示异步获取中，还没有获取到
+//
+func (m *Muxer) nextKey() (ki KeyInfo, ok bool, err error) {
+	if m.keyFetcher == nil {
+		ki, err = m.keyProvider.GetKey(m.streamName, m.keyId)
+		return ki, err == nil, err
+	}
+	return m.keyFetcher.take(m.keyId)
+}
+
+// updateKeyIfNeeded 开启新的分片前调用，必要时更换新的密钥并写入key文件
+//
+// 注意，该函数在上层的数据处理流程中调用（比如持有group的锁），不能阻塞，见 IKeyProvider
+//
+func (m *Muxer) updateKeyIfNeeded(method string) error {
+	reuse := m.currKey != nil && m.currKey.method == method
+	if reuse && (m.config.KeyRotateFragmentNum <= 0 || m.keyFragCount < m.config.KeyRotateFragmentNum) {
+		m.keyFragCount++
+		return nil
+	}
+
+	if m.keyProvider == nil {
+		return fmt.Errorf("%w. encryption enabled but no key provider. method=%s", base.ErrHls, method)
+	}
+	ki, ok, err := m.nextKey()
+	if !ok {
+		// 轮换时下一个密钥还没有获取到，继续使用当前的密钥
+		if reuse {
+			Log.Warnf("[%s] next hls key not ready, keep using current key. keyId=%d, err=%v", m.UniqueKey, m.keyId, err)
+			m.keyFragCount++
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%w. hls key not ready. keyId=%d", base.ErrHls, m.keyId)
+		}
+		return err
+	}
+	if len(ki.Key) != keySize {
+		return fmt.Errorf("%w. invalid key length. len=%d", base.ErrHls, len(ki.Key))
+	}
+	if ki.Iv == nil {
+		if ki.Iv, err = randomIv(); err != nil {
+			return err
+		}
+	} else if len(ki.Iv) != keySize {
+		return fmt.Errorf("%w. invalid iv length. len=%d", base.ErrHls, len(ki.Iv))
+	}
+
+	filename := fmt.Sprintf("%s-%d-%d.key", m.streamName, int(Clock.Now().UnixNano()/1e6), m.keyId)
+	if err = fslCtx.WriteFile(PathStrategy.GetTsFileNameWithPath(m.outPath, filename), ki.Key, 0666); err != nil {
+		return err
+	}
+	Log.Infof("[%s] hls key updated. method=%s, keyId=%d, filename=%s", m.UniqueKey, method, m.keyId, filename)
+
+	m.currKey = &fragmentKey{
+		method:   method,
+		filename: filename,
+		key:      ki.Key,
+		iv:       ki.Iv,
+	}
+	m.keyFiles[filename] = struct{}{}
+	m.keyId++
+	m.keyFragCount = 1
+
+	// 提前获取下一个密钥
+	if m.keyFetcher != nil && m.config.KeyRotateFragmentNum > 0 {
+		m.keyFetcher.fetch(m.keyId)
+	}
+	return nil
+}
+
+// removeStaleKeyFiles closeFragment中调用，删除live、dvr以及record m3u8中都不再有fragment引用的key文件
+//
+func (m *Muxer) removeStaleKeyFiles() {
+	if len(m.keyFiles) == 0 {
+		return
+	}
+
+	inUse := make(map[string]struct{})
+	if m.currKey != nil {
+		inUse[m.currKey.filename] = struct{}{}
+	}
+	// 注意，环形队列中包含了已经离开live m3u8，但还在删除阈值内的fragment
+	for i := range m.frags {
+		if m.frags[i].key != nil {
+			inUse[m.frags[i].key.filename] = struct{}{}
+		}
+	}
+	for i := range m.dvrFrags {
+		if m.dvrFrags[i].key != nil {
+			inUse[m.dvrFrags[i].key.filename] = struct{}{}
+		}
+	}
+	for filename := range m.recordKeyFiles {
+		inUse[filename] = struct{}{}
+	}
+
+	for filename := range m.keyFiles {
+		if _, ok := inUse[filename]; ok {
+			continue
+		}
+		delete(m.keyFiles, filename)
+		if err := fslCtx.Remove(PathStrategy.GetTsFileNameWithPath(m.outPath, filename)); err != nil {
+			Log.Warnf("[%s] remove stale key file failed. filename=%s, err=%+v", m.UniqueKey, filename, err)
+		}
+	}
+}
+
+// updateRecordKeyFiles 记录record m3u8中引用的key文件，之前流程（比如上一次推流）写入的key文件也纳入删除的判断
+//
+func (m *Muxer) updateRecordKeyFiles(content []byte) {
+	m.recordKeyFiles = make(map[string]struct{})
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.HasPrefix(line, "#EXT-X-KEY:") {
+			continue
+		}
+		if filename := parseTagAttr(line, "URI"); filename != "" {
+			m.recordKeyFiles[filename] = struct{}{}
+			m.keyFiles[filename] = struct{}{}
+		}
+	}
+}
+
+// sampleAesRepack 加密帧数据，并使用 Muxer 自身的continuity_counter重新打包成ts
+//
+func (m *Muxer) sampleAesRepack(frame *mpegts.Frame) []byte {
+	f := *frame
+	if f.Sid == mpegts.StreamIdAudio {
+		f.Raw = m.sampleAes.encryptAdts(f.Raw)
+		f.Cc = m.audioCc
+		packets := f.Pack()
+		m.audioCc = f.Cc
+		return packets
+	}
+	f.Raw = m.sampleAes.encryptAvc(f.Raw)
+	f.Cc = m.videoCc
+	packets := f.Pack()
+	m.videoCc = f.Cc
+	return packets
+}
+
+// updateAscIfNeeded 记录AAC的AudioSpecificConfig，SAMPLE-AES的PMT中需要使用
+//
+func (m *Muxer) updateAscIfNeeded(frame *mpegts.Frame) {
+	if m.asc != nil || frame.Sid != mpegts.StreamIdAudio || m.config.EncryptMethod != EncryptMethodSampleAes {
+		return
+	}
+	if asc, err := aac.MakeAscWithAdtsHeader(frame.Raw); err == nil {
+		m.asc = asc
+	}
+}
+
+func buildKeyTag(key *fragmentKey) string {
+	if key == nil {
+		return "#EXT-X-KEY:METHOD=NONE\n"
+	}
+	return fmt.Sprintf("#EXT-X-KEY:METHOD=%s,URI=\"%s\",IV=0x%s\n", key.method, key.filename, hex.EncodeToString(key.iv))
+}
@@ -0,0 +1,314 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+var keyTagRegexp = regexp.MustCompile(`#EXT-X-KEY:METHOD=([A-Z0-9-]+),URI="([^"]+)",IV=0x([0-9a-f]{32})`)
+
+func TestMuxerAes128(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_aes")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	keyHex := "000102030405060708090a0b0c0d0e0f"
+	keyFile := filepath.Join(dir, "hls.key")
+	assert.Equal(t, nil, ioutil.WriteFile(keyFile, []byte(keyHex+"\n"), 0666))
+
+	config := &hls.MuxerConfig{
+		OutPath:              dir,
+		FragmentDurationMs:   1000,
+		FragmentNum:          6,
+		DeleteThreshold:      6,
+		CleanupMode:          hls.CleanupModeNever,
+		EncryptMethod:        hls.EncryptMethodAes128,
+		KeyRotateFragmentNum: 2,
+		KeyFile:              keyFile,
+	}
+	m := hls.NewMuxer("test110", config, &mockMuxerObserver{})
+	m.Start()
+	m.FeedPatPmt(mpegts.FixedFragmentHeader)
+
+	// 6个分片，每个分片一个关键帧
+	var clear [][]byte
+	for i := uint64(0); i < 6; i++ {
+		frame := &mpegts.Frame{
+			Pts: i * 90000,
+			Dts: i * 90000,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: true,
+			Raw: []byte{0, 0, 0, 1, 0x65, 0x88, byte(i)},
+		}
+		packets := frame.Pack()
+		m.FeedMpegts(packets, frame, true)
+		clear = append(clear, append(append([]byte(nil), mpegts.FixedFragmentHeader...), packets...))
+	}
+	m.Dispose()
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "test110", "record.m3u8"))
+	assert.Equal(t, nil, err)
+	lines := strings.Split(string(content), "\n")
+
+	// 每2个分片更换一次密钥
+	keyTags := keyTagRegexp.FindAllStringSubmatch(string(content), -1)
+	assert.Equal(t, 3, len(keyTags))
+
+	var segment int
+	var iv []byte
+	for _, line := range lines {
+		if sm := keyTagRegexp.FindStringSubmatch(line); sm != nil {
+			assert.Equal(t, hls.EncryptMethodAes128, sm[1])
+			b, err := ioutil.ReadFile(filepath.Join(dir, "test110", sm[2]))
+			assert.Equal(t, nil, err)
+			assert.Equal(t, keyHex, hex.EncodeToString(b))
+			iv, _ = hex.DecodeString(sm[3])
+			continue
+		}
+		if !strings.HasSuffix(line, ".ts") {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, "test110", line))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 0, len(b)%aes.BlockSize)
+		key, _ := hex.DecodeString(keyHex)
+		block, _ := aes.NewCipher(key)
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(b, b)
+		padding := int(b[len(b)-1])
+		assert.Equal(t, clear[segment], b[:len(b)-padding])
+		segment++
+	}
+	assert.Equal(t, 6, segment)
+
+	// 通过http读取key，m3u8请求的参数追加到key的URI后面
+	handler := hls.NewServerHandler(dir)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/hls/test110/record.m3u8?token=abc", nil))
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, true, strings.Contains(resp.Body.String(), `.key?token=abc",IV=`))
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/hls/test110/"+keyTags[0][2]+"?token=abc", nil))
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, keyHex, hex.EncodeToString(resp.Body.Bytes()))
+}
+
+type fixedKeyProvider struct {
+	key []byte
+	iv  []byte
+}
+
+func (p *fixedKeyProvider) NonBlocking() bool {
+	return true
+}
+
+func (p *fixedKeyProvider) GetKey(streamName string, keyId int) (hls.KeyInfo, error) {
+	return hls.KeyInfo{Key: p.key, Iv: p.iv}, nil
+}
+
+func TestMuxerSampleAes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_sample_aes")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	kp := &fixedKeyProvider{
+		key: bytes.Repeat([]byte{0x11}, 16),
+		iv:  bytes.Repeat([]byte{0x22}, 16),
+	}
+	config := &hls.MuxerConfig{
+		OutPath:            dir,
+		FragmentDurationMs: 1000,
+		FragmentNum:        3,
+		DeleteThreshold:    3,
+		CleanupMode:        hls.CleanupModeNever,
+		EncryptMethod:      hls.EncryptMethodSampleAes,
+	}
+	m := hls.NewMuxer("test110", config, &mockMuxerObserver{})
+	m.SetKeyProvider(kp)
+	m.Start()
+	m.FeedPatPmt(mpegts.FixedFragmentHeader)
+
+	// 64字节的IDR nal，第33到48字节加密，其余为明文
+	nal := make([]byte, 64)
+	nal[0] = 0x65
+	for i := 1; i < len(nal); i++ {
+		nal[i] = byte(i)
+	}
+	// 一个ADTS帧，ADTS头之后的16字节为明文，之后的1个完整块加密，末尾8字节为明文
+	adts := []byte{0xff, 0xf1, 0x50, 0x80, 0x05, 0x7f, 0xfc}
+	adtsPayload := bytes.Repeat([]byte{0x33}, 40)
+	adts = append(adts, adtsPayload...)
+	adts[3] = 0x80 | byte(len(adts)>>11)
+	adts[4] = byte(len(adts) >> 3)
+	adts[5] = byte(len(adts)<<5) | 0x1f
+
+	audio := &mpegts.Frame{Pts: 0, Dts: 0, Pid: mpegts.PidAudio, Sid: mpegts.StreamIdAudio, Raw: adts}
+	m.FeedMpegts(audio.Pack(), audio, false)
+	video := &mpegts.Frame{Pts: 0, Dts: 0, Pid: mpegts.PidVideo, Sid: mpegts.StreamIdVideo, Key: true, Raw: append([]byte{0, 0, 0, 1}, nal...)}
+	m.FeedMpegts(video.Pack(), video, true)
+	audio.Pts, audio.Dts = 1000, 1000
+	m.FeedMpegts(audio.Pack(), audio, false)
+	m.Dispose()
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "test110", "record.m3u8"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(content), "#EXT-X-VERSION:5\n"))
+	sm := keyTagRegexp.FindStringSubmatch(string(content))
+	assert.Equal(t, hls.EncryptMethodSampleAes, sm[1])
+	assert.Equal(t, hex.EncodeToString(kp.iv), sm[3])
+
+	var tsFilename string
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasSuffix(line, ".ts") {
+			tsFilename = line
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "test110", tsFilename))
+	assert.Equal(t, nil, err)
+
+	// PMT中使用加密的stream_type，并且在音频的描述子中携带AudioSpecificConfig
+	pmt := b[188 : 188*2]
+	assert.Equal(t, true, bytes.Contains(pmt, []byte{0xdb, 0xe1, 0x00}))
+	assert.Equal(t, true, bytes.Contains(pmt, []byte{0xcf, 0xe1, 0x01}))
+	assert.Equal(t, true, bytes.Contains(pmt, []byte("zaac\x00\x00\x01\x02\x12\x10")))
+
+	block, _ := aes.NewCipher(kp.key)
+	decrypt := func(in []byte) []byte {
+		out := make([]byte, len(in))
+		cipher.NewCBCDecrypter(block, kp.iv).CryptBlocks(out, in)
+		return out
+	}
+
+	// 视频
+	pos := bytes.Index(b, nal[:32])
+	assert.Equal(t, true, pos > 0)
+	assert.Equal(t, nal[32:48], decrypt(b[pos+32:pos+48]))
+	assert.Equal(t, nal[48:], b[pos+48:pos+64])
+
+	// 音频
+	pos = bytes.Index(b, adts[:7+16])
+	assert.Equal(t, true, pos > 0)
+	assert.Equal(t, adtsPayload[16:32], decrypt(b[pos+23:pos+39]))
+	assert.Equal(t, adtsPayload[32:], b[pos+39:pos+47])
+}
+
+// blockingKeyProvider 每次 GetKey 都阻塞，直到 release 中读取到数据或者 release 被关闭
+//
+type blockingKeyProvider struct {
+	release chan struct{}
+}
+
+func (p *blockingKeyProvider) GetKey(streamName string, keyId int) (hls.KeyInfo, error) {
+	<-p.release
+	return hls.KeyInfo{Key: bytes.Repeat([]byte{byte(keyId)}, 16)}, nil
+}
+
+func TestMuxerAsyncKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_async_key")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	kp := &blockingKeyProvider{release: make(chan struct{})}
+	config := &hls.MuxerConfig{
+		OutPath:              dir,
+		FragmentDurationMs:   1000,
+		FragmentNum:          2,
+		DeleteThreshold:      1,
+		CleanupMode:          hls.CleanupModeAsap,
+		EncryptMethod:        hls.EncryptMethodAes128,
+		KeyRotateFragmentNum: 1,
+	}
+	m := hls.NewMuxer("test110", config, &mockMuxerObserver{})
+	m.SetKeyProvider(kp)
+	m.Start()
+	m.FeedPatPmt(mpegts.FixedFragmentHeader)
+
+	var ts uint64
+	feed := func() {
+		frame := &mpegts.Frame{
+			Pts: ts,
+			Dts: ts,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: true,
+			Raw: []byte{0, 0, 0, 1, 0x65, 0x88},
+		}
+		m.FeedMpegts(frame.Pack(), frame, true)
+		ts += 90000
+	}
+	glob := func(pattern string) []string {
+		matches, _ := filepath.Glob(filepath.Join(dir, "test110", pattern))
+		return matches
+	}
+	liveKeys := func() map[string]struct{} {
+		content, _ := ioutil.ReadFile(filepath.Join(dir, "test110", "playlist.m3u8"))
+		keys := make(map[string]struct{})
+		for _, sm := range keyTagRegexp.FindAllStringSubmatch(string(content), -1) {
+			keys[sm[2]] = struct{}{}
+		}
+		return keys
+	}
+	// 异步获取密钥，输入数据直到满足条件
+	feedUntil := func(cond func() bool) {
+		for i := 0; i < 200 && !cond(); i++ {
+			feed()
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.Equal(t, true, cond())
+	}
+
+	// 第一个密钥还没有获取到，不阻塞，也不生成未加密的分片
+	feed()
+	feed()
+	assert.Equal(t, 0, len(glob("*.ts")))
+
+	// 获取到第一个密钥后开始生成分片，下一个密钥还没有获取到时，继续使用当前的密钥
+	kp.release <- struct{}{}
+	feedUntil(func() bool { return len(glob("*.ts")) > 0 })
+	for i := 0; i < 4; i++ {
+		feed()
+	}
+	assert.Equal(t, 1, len(liveKeys()))
+	assert.Equal(t, 1, len(glob("*.key")))
+
+	// 之后每个分片都更换密钥，不再被引用的key文件被删除
+	close(kp.release)
+	feedUntil(func() bool { return len(liveKeys()) == 2 })
+	for i := 0; i < 10; i++ {
+		feed()
+		time.Sleep(5 * time.Millisecond)
+	}
+	m.Dispose()
+
+	keys := glob("*.key")
+	assert.Equal(t, true, len(keys) > 1)
+	assert.Equal(t, true, len(keys) <= config.FragmentNum+config.DeleteThreshold+2)
+	for k := range liveKeys() {
+		_, err := os.Stat(filepath.Join(dir, "test110", k))
+		assert.Equal(t, nil, err)
+	}
+}
@@ -37,7 +37,13 @@ func ReadFile(filename string) ([]byte, error) {
 	return fslCtx.ReadFile(filename)
 }
 
+// RemoveAll 删除hls文件目录，开启上传时（见 SetUploader ），同时删除该目录下已上传的对象
+//
 func RemoveAll(path string) error {
+	deleteUploadedAll(path)
+	if segmentStore != nil {
+		segmentStore.removeStreamByPath(path)
+	}
 	return fslCtx.RemoveAll(path)
 }
 
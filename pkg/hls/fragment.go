@@ -9,14 +9,29 @@
 package hls
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+
 	"github.com/q191201771/naza/pkg/filesystemlayer"
 )
 
 type Fragment struct {
-	fp filesystemlayer.IFile
+	fp  filesystemlayer.IFile
+	seg *memSegment // 使用内存分片存储时不为nil，见 segment_store.go
+
+	encrypter cipher.BlockMode // 使用AES-128整体加密时不为nil
+	remain    []byte           // 还不足一个加密块的数据，等待后续数据或者关闭文件时填充
+
+	uploadFilename string // 不为空时，关闭时上传写入的内容，见 SetUploader
+	uploadBuf      []byte
 }
 
 func (f *Fragment) OpenFile(filename string) (err error) {
+	f.encrypter = nil
+	f.remain = nil
+	f.uploadFilename = ""
+	f.uploadBuf = nil
+	f.seg = nil
 	f.fp, err = fslCtx.Create(filename)
 	if err != nil {
 		return
@@ -24,11 +39,91 @@ func (f *Fragment) OpenFile(filename string) (err error) {
 	return
 }
 
+// openSegment 写入内存分片存储，而不是文件
+//
+func (f *Fragment) openSegment(seg *memSegment) {
+	f.encrypter = nil
+	f.remain = nil
+	f.uploadFilename = ""
+	f.uploadBuf = nil
+	f.fp = nil
+	f.seg = seg
+}
+
+// SetAes128Key 设置后，后续写入的数据使用AES-128-CBC加密，关闭文件时使用PKCS7填充
+//
+// 注意，需要在 OpenFile 之后，首次 WriteFile 之前调用
+//
+func (f *Fragment) SetAes128Key(key []byte, iv []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	f.encrypter = cipher.NewCBCEncrypter(block, iv)
+	return nil
+}
+
 func (f *Fragment) WriteFile(b []byte) (err error) {
-	_, err = f.fp.Write(b)
-	return
+	if f.encrypter == nil {
+		return f.write(b)
+	}
+
+	f.remain = append(f.remain, b...)
+	n := len(f.remain) / aes.BlockSize * aes.BlockSize
+	if n == 0 {
+		return nil
+	}
+	out := make([]byte, n)
+	f.encrypter.CryptBlocks(out, f.remain[:n])
+	f.remain = append(f.remain[:0], f.remain[n:]...)
+	return f.write(out)
 }
 
 func (f *Fragment) CloseFile() error {
+	if f.encrypter != nil {
+		// PKCS7，数据刚好是块大小的整数倍时，也需要填充一个完整的块
+		padding := aes.BlockSize - len(f.remain)
+		for i := 0; i < padding; i++ {
+			f.remain = append(f.remain, byte(padding))
+		}
+		out := make([]byte, aes.BlockSize)
+		f.encrypter.CryptBlocks(out, f.remain)
+		f.encrypter = nil
+		f.remain = nil
+		if err := f.write(out); err != nil {
+			_ = f.close()
+			return err
+		}
+	}
+	return f.close()
+}
+
+func (f *Fragment) write(b []byte) error {
+	if f.uploadFilename != "" {
+		f.uploadBuf = append(f.uploadBuf, b...)
+	}
+	if f.seg != nil {
+		f.seg.write(b)
+		return nil
+	}
+	_, err := f.fp.Write(b)
+	return err
+}
+
+func (f *Fragment) close() error {
+	if f.uploadFilename != "" {
+		if uploader != nil {
+			if err := uploader.UploadContent(f.uploadFilename, f.uploadBuf); err != nil {
+				Log.Warnf("hls upload failed. filename=%s, err=%+v", f.uploadFilename, err)
+			}
+		}
+		f.uploadFilename = ""
+		f.uploadBuf = nil
+	}
+	if f.seg != nil {
+		f.seg.finish()
+		f.seg = nil
+		return nil
+	}
 	return f.fp.Close()
 }
@@ -0,0 +1,271 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+const (
+	EncryptMethodNone      = ""           // 默认值，不加密
+	EncryptMethodAes128    = "AES-128"    // 整个分片使用AES-128-CBC加密，PKCS7填充
+	EncryptMethodSampleAes = "SAMPLE-AES" // 只加密音视频帧中的部分数据，见 sample_aes.go。目前只支持ts分片，并且视频为H264
+)
+
+const (
+	keySize = 16
+
+	defaultKeyServerTimeoutMs = 3000
+)
+
+// KeyInfo 分片加密使用的密钥
+//
+type KeyInfo struct {
+	Key []byte // 16字节
+	Iv  []byte // 16字节，为nil时由 Muxer 随机生成
+}
+
+// IKeyProvider 分片加密的密钥提供方
+//
+// 流开始时，以及每隔 MuxerConfig.KeyRotateFragmentNum 个分片，Muxer 会使用新的密钥。
+// GetKey 可能比较耗时（比如请求外部的密钥服务），所以 Muxer 在独立的协程中调用，并且在使用第N个密钥时提前获取第N+1个：
+//
+// - 流开始时第一个密钥还没有获取到，不生成分片（数据被丢弃），而不是生成未加密的分片
+// - 需要轮换时下一个密钥还没有获取到，继续使用当前的密钥
+//
+// 实现了 INonBlockingKeyProvider 的除外
+//
+type IKeyProvider interface {
+	// GetKey
+	//
+	// @param keyId: 同一个 Muxer 内的密钥自增序号，从0开始
+	//
+	// 注意，返回错误时，Muxer 不会生成未加密的分片
+	//
+	GetKey(streamName string, keyId int) (KeyInfo, error)
+}
+
+// INonBlockingKeyProvider GetKey 不会阻塞的密钥提供方（比如只读取本地的密钥文件），Muxer 在需要时直接调用 GetKey
+//
+type INonBlockingKeyProvider interface {
+	IKeyProvider
+	NonBlocking() bool
+}
+
+// NewKeyProvider 根据配置创建内置的密钥提供方，优先使用 MuxerConfig.KeyFile ，都没有配置时返回nil
+//
+func NewKeyProvider(config *MuxerConfig) IKeyProvider {
+	if config.KeyFile != "" {
+		return NewStaticFileKeyProvider(config.KeyFile)
+	}
+	if config.KeyServerUrl != "" {
+		return NewHttpKeyProvider(config.KeyServerUrl)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// StaticFileKeyProvider 从文件中读取固定的密钥，文件内容为16字节的二进制数据，或者32个字符的十六进制字符串
+//
+// 轮换时密钥不变，只更换IV
+//
+type StaticFileKeyProvider struct {
+	filename string
+
+	mutex sync.Mutex
+	key   []byte
+}
+
+func NewStaticFileKeyProvider(filename string) *StaticFileKeyProvider {
+	return &StaticFileKeyProvider{
+		filename: filename,
+	}
+}
+
+// NonBlocking 实现 INonBlockingKeyProvider ，只在第一次调用 GetKey 时读取本地的密钥文件
+//
+func (p *StaticFileKeyProvider) NonBlocking() bool {
+	return true
+}
+
+func (p *StaticFileKeyProvider) GetKey(streamName string, keyId int) (KeyInfo, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.key == nil {
+		content, err := ioutil.ReadFile(p.filename)
+		if err != nil {
+			return KeyInfo{}, err
+		}
+		key, err := parseKey(content)
+		if err != nil {
+			return KeyInfo{}, err
+		}
+		p.key = key
+	}
+	return KeyInfo{Key: p.key}, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// HttpKeyProvider 从外部的密钥服务获取密钥
+//
+// 请求格式为 GET {keyServerUrl}?stream_name={streamName}&key_id={keyId}
+// 响应body为16字节的二进制数据，或者32个字符的十六进制字符串
+// 响应头中如果包含`X-Key-Iv`（32个字符的十六进制字符串），则作为IV使用
+//
+type HttpKeyProvider struct {
+	keyServerUrl string
+	client       *http.Client
+}
+
+func NewHttpKeyProvider(keyServerUrl string) *HttpKeyProvider {
+	return &HttpKeyProvider{
+		keyServerUrl: keyServerUrl,
+		client: &http.Client{
+			Timeout: time.Duration(defaultKeyServerTimeoutMs) * time.Millisecond,
+		},
+	}
+}
+
+func (p *HttpKeyProvider) GetKey(streamName string, keyId int) (ki KeyInfo, err error) {
+	u, err := url.Parse(p.keyServerUrl)
+	if err != nil {
+		return
+	}
+	q := u.Query()
+	q.Set("stream_name", streamName)
+	q.Set("key_id", strconv.Itoa(keyId))
+	u.RawQuery = q.Encode()
+
+	resp, err := p.client.Get(u.String())
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ki, fmt.Errorf("%w. key server status code %d", base.ErrHls, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	if ki.Key, err = parseKey(body); err != nil {
+		return
+	}
+	if ivHex := resp.Header.Get("X-Key-Iv"); ivHex != "" {
+		if ki.Iv, err = parseKey([]byte(ivHex)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// keyFetcher 在独立的协程中获取密钥，见 IKeyProvider
+//
+type keyFetcher struct {
+	provider   IKeyProvider
+	streamName string
+
+	mutex    sync.Mutex
+	keyId    int // 正在获取，或者已经获取到的密钥的序号，为-1表示没有
+	fetching bool
+	done     bool
+	ki       KeyInfo
+	err      error
+}
+
+func newKeyFetcher(provider IKeyProvider, streamName string) *keyFetcher {
+	return &keyFetcher{
+		provider:   provider,
+		streamName: streamName,
+		keyId:      -1,
+	}
+}
+
+// fetch 开始获取序号为`keyId`的密钥，已经在获取或者已经获取到时直接返回
+//
+func (f *keyFetcher) fetch(keyId int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.keyId == keyId && (f.fetching || f.done) {
+		return
+	}
+	f.keyId = keyId
+	f.fetching = true
+	f.done = false
+	go func() {
+		ki, err := f.provider.GetKey(f.streamName, keyId)
+
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		if f.keyId != keyId {
+			return
+		}
+		f.fetching = false
+		f.done = true
+		f.ki, f.err = ki, err
+	}()
+}
+
+// take 取出序号为`keyId`的密钥
+//
+// @return ok: 为false表示还没有获取到。获取失败时返回错误，并在下次调用 take 时重新获取
+//
+func (f *keyFetcher) take(keyId int) (ki KeyInfo, ok bool, err error) {
+	f.mutex.Lock()
+	if f.keyId == keyId && f.done {
+		ki, err = f.ki, f.err
+		f.keyId = -1
+		f.done = false
+		f.mutex.Unlock()
+		return ki, err == nil, err
+	}
+	fetching := f.keyId == keyId && f.fetching
+	f.mutex.Unlock()
+
+	if !fetching {
+		f.fetch(keyId)
+	}
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func parseKey(b []byte) ([]byte, error) {
+	if len(b) == keySize {
+		return b, nil
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) == keySize*2 {
+		return hex.DecodeString(string(b))
+	}
+	return nil, fmt.Errorf("%w. invalid key length %d", base.ErrHls, len(b))
+}
+
+func randomIv() ([]byte, error) {
+	iv := make([]byte, keySize)
+	_, err := rand.Read(iv)
+	return iv, err
+}
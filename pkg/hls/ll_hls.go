@@ -0,0 +1,408 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ll_hls.go
+//
+// LL-HLS(Low-Latency HLS)，参考 draft-pantos-hls-rfc8216bis
+//
+// - hls.Muxer 开启 MuxerConfig.LowLatency 后，每个fmp4媒体分片（moof+mdat）作为一个partial segment（EXT-X-PART）
+// - partial segment以及playlist保存在内存中（ llHlsStream ），由 ServerHandler 直接从内存中读取，轮询不会访问磁盘
+// - ServerHandler 支持blocking playlist reload（_HLS_msn/_HLS_part），以及delta update（_HLS_skip）
+//
+
+const (
+	defaultPartDurationMs = 1000
+
+	// 最近多少个完整的segment在playlist中保留EXT-X-PART信息，以及在内存中保留partial segment数据
+	llHlsPartSegmentNum = 3
+)
+
+type llHlsPart struct {
+	uri         string
+	duration    float64
+	independent bool
+}
+
+type llHlsSegment struct {
+	msn          int
+	duration     float64
+	uri          string
+	discont      bool
+	initFilename string
+	dateTime     time.Time
+	parts        []llHlsPart
+	complete     bool // 为false表示是正在生成中的segment，此时只有parts
+}
+
+// llHlsPlaylist playlist快照，生成后只读
+//
+type llHlsPlaylist struct {
+	targetDuration int
+	partTarget     float64
+	segments       []llHlsSegment
+	preloadHint    string
+	ended          bool
+}
+
+// render
+//
+// @param skip: 是否是delta update，也即使用`EXT-X-SKIP`替换掉较早的segment
+//
+func (p *llHlsPlaylist) render(skip bool) []byte {
+	skipUntil := float64(p.targetDuration * 6)
+
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString("#EXT-X-VERSION:9\n")
+	buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", p.targetDuration))
+	buf.WriteString(fmt.Sprintf("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f,CAN-SKIP-UNTIL=%.1f\n", p.partTarget*3, skipUntil))
+	buf.WriteString(fmt.Sprintf("#EXT-X-PART-INF:PART-TARGET=%.3f\n", p.partTarget))
+	buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", p.firstMsn()))
+
+	// 计算需要跳过的segment数量，距离playlist末尾超过CAN-SKIP-UNTIL的完整segment可以跳过
+	skipped := 0
+	if skip {
+		var tail float64
+		for i := len(p.segments) - 1; i >= 0; i-- {
+			if tail > skipUntil && p.segments[i].complete {
+				skipped = i + 1
+				break
+			}
+			tail += p.segments[i].duration
+		}
+		buf.WriteString(fmt.Sprintf("#EXT-X-SKIP:SKIPPED-SEGMENTS=%d\n", skipped))
+	}
+	buf.WriteString("\n")
+
+	// 最近的llHlsPartSegmentNum个完整segment，以及正在生成中的segment，写入EXT-X-PART
+	completeNum := 0
+	for _, seg := range p.segments {
+		if seg.complete {
+			completeNum++
+		}
+	}
+
+	var initFilename string
+	index := 0
+	for i, seg := range p.segments {
+		if seg.complete {
+			index++
+		}
+		if i < skipped {
+			initFilename = seg.initFilename
+			continue
+		}
+
+		if seg.discont && (seg.complete || len(seg.parts) > 0) {
+			buf.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if seg.initFilename != initFilename || i == skipped {
+			buf.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"\n", seg.initFilename))
+			initFilename = seg.initFilename
+		}
+		if seg.complete || len(seg.parts) > 0 {
+			buf.WriteString(buildProgramDateTimeTag(seg.dateTime))
+		}
+		if !seg.complete || index > completeNum-llHlsPartSegmentNum {
+			for _, part := range seg.parts {
+				buf.WriteString(fmt.Sprintf("#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", part.duration, part.uri))
+				if part.independent {
+					buf.WriteString(",INDEPENDENT=YES")
+				}
+				buf.WriteString("\n")
+			}
+		}
+		if seg.complete {
+			buf.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", seg.duration, seg.uri))
+		}
+	}
+
+	if p.ended {
+		buf.WriteString("#EXT-X-ENDLIST\n")
+	} else if p.preloadHint != "" {
+		buf.WriteString(fmt.Sprintf("#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", p.preloadHint))
+	}
+	return buf.Bytes()
+}
+
+func (p *llHlsPlaylist) firstMsn() int {
+	if len(p.segments) == 0 {
+		return 0
+	}
+	return p.segments[0].msn
+}
+
+// has playlist中是否已经包含了序号为msn的segment的第part个partial segment
+//
+// @param part: -1表示要求msn这个segment已经完整
+//
+func (p *llHlsPlaylist) has(msn int, part int) bool {
+	if p.ended {
+		return true
+	}
+	if len(p.segments) == 0 {
+		return false
+	}
+
+	// 注意，只有最后一个segment可能是未完整的
+	last := &p.segments[len(p.segments)-1]
+	if last.msn != msn {
+		return last.msn > msn
+	}
+	if last.complete {
+		return true
+	}
+	return part >= 0 && len(last.parts) > part
+}
+
+// lastMsn playlist中最后一个segment（可能还在生成中）的序号
+//
+func (p *llHlsPlaylist) lastMsn() int {
+	if len(p.segments) == 0 {
+		return 0
+	}
+	return p.segments[len(p.segments)-1].msn
+}
+
+// llHlsPartFileName partial segment的文件名，在所属segment的文件名基础上增加序号
+//
+// 比如 test110-1620540712084-0.m4s 的第1个partial segment为 test110-1620540712084-0-part1.m4s
+//
+// 注意，文件名中不能再出现`.`，否则解析url时无法得到正确的文件类型
+//
+func llHlsPartFileName(segmentFilename string, index int) string {
+	ext := ".m4s"
+	return fmt.Sprintf("%s-part%d%s", strings.TrimSuffix(segmentFilename, ext), index, ext)
+}
+
+// blockTimeout 阻塞请求的最长等待时间，为target duration的3倍
+//
+func (p *llHlsPlaylist) blockTimeout() time.Duration {
+	return time.Duration(p.targetDuration*3) * time.Second
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// addLlHlsPart 将fmp4媒体分片作为当前segment的一个partial segment
+//
+func (m *Muxer) addLlHlsPart(b []byte, endTs uint64, boundary bool) {
+	f := m.getCurrFrag()
+	name := llHlsPartFileName(f.filename, len(f.parts))
+
+	var duration float64
+	if endTs > m.llPartEndTs {
+		duration = float64(endTs-m.llPartEndTs) / 90000
+	}
+	m.llPartEndTs = endTs
+
+	f.parts = append(f.parts, llHlsPart{
+		uri:         name,
+		duration:    duration,
+		independent: boundary,
+	})
+	m.llStream.update(m.buildLlHlsPlaylist(false), name, b, nil)
+}
+
+// closeLlHlsSegment segment结束，更新playlist，并淘汰内存中过期的partial segment
+//
+func (m *Muxer) closeLlHlsSegment(frag *fragmentInfo) {
+	names := make([]string, len(frag.parts))
+	for i := range frag.parts {
+		names[i] = frag.parts[i].uri
+	}
+	m.llPartNames = append(m.llPartNames, names)
+
+	var removeNames []string
+	if len(m.llPartNames) > llHlsPartSegmentNum {
+		removeNames = m.llPartNames[0]
+		m.llPartNames = m.llPartNames[1:]
+	}
+	m.llStream.update(m.buildLlHlsPlaylist(false), "", nil, removeNames)
+}
+
+// buildLlHlsPlaylist 生成playlist快照，包含直播列表中已完整的segment，以及正在生成中的segment
+//
+func (m *Muxer) buildLlHlsPlaylist(ended bool) *llHlsPlaylist {
+	maxFrag := float64(m.config.FragmentDurationMs) / 1000
+	segments := make([]llHlsSegment, 0, m.nfrags+1)
+	m.iterateFragsInPlaylist(func(frag *fragmentInfo) {
+		if frag.duration > maxFrag {
+			maxFrag = frag.duration + 0.5
+		}
+		segments = append(segments, llHlsSegment{
+			msn:          frag.id,
+			duration:     frag.duration,
+			uri:          frag.filename,
+			discont:      frag.discont,
+			initFilename: frag.initFilename,
+			dateTime:     frag.programDateTime,
+			parts:        frag.parts,
+			complete:     true,
+		})
+	})
+
+	p := &llHlsPlaylist{
+		partTarget: float64(m.config.GetPartDurationMs()) / 1000,
+		ended:      ended,
+	}
+	if m.opened && !ended {
+		f := m.getCurrFrag()
+		segments = append(segments, llHlsSegment{
+			msn:          f.id,
+			discont:      f.discont,
+			initFilename: f.initFilename,
+			dateTime:     f.programDateTime,
+			parts:        f.parts,
+		})
+		p.preloadHint = llHlsPartFileName(f.filename, len(f.parts))
+	}
+	p.targetDuration = int(maxFrag)
+	if p.targetDuration < 1 {
+		p.targetDuration = 1
+	}
+	p.segments = segments
+	return p
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// llHlsStream 单个流的LL-HLS内存数据，由 Muxer 写入， ServerHandler 读取
+//
+type llHlsStream struct {
+	playlistFilename string // const after init
+
+	mutex    sync.Mutex
+	playlist *llHlsPlaylist
+	parts    map[string][]byte // key为partial segment的文件名
+	updateCh chan struct{}     // 每次更新时close，用于唤醒等待的请求
+}
+
+func newLlHlsStream(playlistFilename string) *llHlsStream {
+	return &llHlsStream{
+		playlistFilename: playlistFilename,
+		parts:            make(map[string][]byte),
+		updateCh:         make(chan struct{}),
+	}
+}
+
+// update
+//
+// @param name, data: 新增的partial segment，name为空表示没有新增
+//
+// @param removeNames: 需要从内存中删除的partial segment
+//
+func (s *llHlsStream) update(playlist *llHlsPlaylist, name string, data []byte, removeNames []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.playlist = playlist
+	if name != "" {
+		s.parts[name] = data
+	}
+	for _, n := range removeNames {
+		delete(s.parts, n)
+	}
+	close(s.updateCh)
+	s.updateCh = make(chan struct{})
+}
+
+// waitPlaylist 等待playlist中包含指定的segment和partial segment
+//
+// @return 超时返回nil
+//
+func (s *llHlsStream) waitPlaylist(msn int, part int, timeout time.Duration) *llHlsPlaylist {
+	return s.wait(timeout, func() bool {
+		return s.playlist != nil && s.playlist.has(msn, part)
+	})
+}
+
+// getPart 获取partial segment，如果是preload hint指定的partial segment，则等待其生成
+//
+func (s *llHlsStream) getPart(name string, timeout time.Duration) ([]byte, bool) {
+	s.mutex.Lock()
+	data, ok := s.parts[name]
+	isHint := s.playlist != nil && s.playlist.preloadHint == name
+	s.mutex.Unlock()
+	if ok || !isHint {
+		return data, ok
+	}
+
+	s.wait(timeout, func() bool {
+		data, ok = s.parts[name]
+		// 开启了新的segment时，preload hint指定的partial segment不会再生成了
+		return ok || s.playlist.ended || s.playlist.preloadHint != name
+	})
+	return data, ok
+}
+
+func (s *llHlsStream) getPlaylist() *llHlsPlaylist {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.playlist
+}
+
+// wait 等待cond满足，cond在持有锁的情况下调用
+//
+// @return cond满足时的playlist，超时返回nil
+//
+func (s *llHlsStream) wait(timeout time.Duration, cond func() bool) *llHlsPlaylist {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mutex.Lock()
+		if cond() {
+			p := s.playlist
+			s.mutex.Unlock()
+			return p
+		}
+		ch := s.updateCh
+		s.mutex.Unlock()
+
+		select {
+		case <-ch:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+var (
+	llHlsStreamsMutex sync.Mutex
+	llHlsStreams      = make(map[string]*llHlsStream) // key为流的hls文件目录，也即 IPathWriteStrategy.GetMuxerOutPath 的结果
+)
+
+func registerLlHlsStream(outPath string, s *llHlsStream) {
+	llHlsStreamsMutex.Lock()
+	defer llHlsStreamsMutex.Unlock()
+	llHlsStreams[outPath] = s
+}
+
+func unregisterLlHlsStream(outPath string, s *llHlsStream) {
+	llHlsStreamsMutex.Lock()
+	defer llHlsStreamsMutex.Unlock()
+	if llHlsStreams[outPath] == s {
+		delete(llHlsStreams, outPath)
+	}
+}
+
+func getLlHlsStream(outPath string) *llHlsStream {
+	llHlsStreamsMutex.Lock()
+	defer llHlsStreamsMutex.Unlock()
+	return llHlsStreams[outPath]
+}
@@ -0,0 +1,101 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestLlHls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_ll_hls")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	config := &hls.MuxerConfig{
+		OutPath:            dir,
+		FragmentDurationMs: 1000,
+		FragmentNum:        3,
+		DeleteThreshold:    3,
+		CleanupMode:        hls.CleanupModeNever,
+		LowLatency:         true,
+		PartDurationMs:     500,
+	}
+	m := hls.NewMuxer("test110", config, &mockMuxerObserver{})
+	m.Start()
+
+	handler := hls.NewServerHandler(dir)
+	get := func(uri string) (int, string) {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest("GET", uri, nil))
+		return resp.Code, resp.Body.String()
+	}
+
+	m.FeedFmp4InitSegment([]byte("init"))
+	// 第0个segment包含2个part，第3个part开启第1个segment
+	m.FeedFmp4Fragment([]byte("part0"), 0, 45000, true)
+	m.FeedFmp4Fragment([]byte("part1"), 45000, 90000, false)
+	m.FeedFmp4Fragment([]byte("part2"), 90000, 135000, true)
+
+	code, body := get("/hls/test110/playlist.m3u8")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, true, strings.Contains(body, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES"))
+	assert.Equal(t, true, strings.Contains(body, "#EXT-X-PART-INF:PART-TARGET=0.500\n"))
+	assert.Equal(t, 3, strings.Count(body, "#EXT-X-PART:"))
+	assert.Equal(t, 2, strings.Count(body, "INDEPENDENT=YES"))
+	assert.Equal(t, 1, strings.Count(body, "#EXTINF:1.000,"))
+	assert.Equal(t, true, strings.Contains(body, "#EXT-X-PRELOAD-HINT:TYPE=PART"))
+
+	// partial segment从内存中读取
+	var partUri string
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "#EXT-X-PART:") && strings.Contains(line, "-part1.m4s") {
+			partUri = line[strings.Index(line, `URI="`)+5 : strings.LastIndex(line, `"`)]
+		}
+	}
+	code, body = get("/hls/test110/" + partUri)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "part1", body)
+
+	// 参数错误
+	code, _ = get("/hls/test110/playlist.m3u8?_HLS_part=1")
+	assert.Equal(t, 400, code)
+	code, _ = get("/hls/test110/playlist.m3u8?_HLS_msn=10")
+	assert.Equal(t, 400, code)
+
+	// 已经存在的part，立即返回
+	code, _ = get("/hls/test110/playlist.m3u8?_HLS_msn=1&_HLS_part=0")
+	assert.Equal(t, 200, code)
+
+	// blocking playlist reload，等待第1个segment的第1个part
+	done := make(chan string)
+	go func() {
+		_, b := get("/hls/test110/playlist.m3u8?_HLS_msn=1&_HLS_part=1")
+		done <- b
+	}()
+	time.Sleep(50 * time.Millisecond)
+	m.FeedFmp4Fragment([]byte("part3"), 135000, 180000, false)
+	body = <-done
+	assert.Equal(t, 4, strings.Count(body, "#EXT-X-PART:"))
+
+	m.Dispose()
+
+	// 结束后从文件中读取
+	code, body = get("/hls/test110/playlist.m3u8")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, true, strings.HasSuffix(body, "#EXT-X-ENDLIST\n"))
+	assert.Equal(t, false, strings.Contains(body, "#EXT-X-PART:"))
+}
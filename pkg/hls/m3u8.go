@@ -28,7 +28,11 @@ func writeM3u8File(content []byte, filename string, filenameBak string) error {
 		return err
 	}
 
-	return fslCtx.Rename(filenameBak, filename)
+	if err := fslCtx.Rename(filenameBak, filename); err != nil {
+		return err
+	}
+	uploadContent(filename, content)
+	return nil
 }
 
 // updateTargetDurationInM3u8 如果当前duration比原m3u8文件的`EXT-X-TARGETDURATION`大，则更新`EXT-X-TARGETDURATION`的值
@@ -0,0 +1,73 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// VariantStreamInfo master playlist中单个码率档位（rendition）的信息
+//
+type VariantStreamInfo struct {
+	StreamName string
+	Bandwidth  int    // 单位bit/s，写入BANDWIDTH字段
+	Width      int    // 宽高都大于0时写入RESOLUTION字段
+	Height     int    //
+	Codecs     string // RFC 6381格式，比如`avc1.64001f,mp4a.40.2`，不为空时写入CODECS字段
+}
+
+// IVariantProvider 多码率分组的信息提供方，由业务层实现
+//
+type IVariantProvider interface {
+	// GetHlsVariantStreams
+	//
+	// @param name: 分组名称
+	//
+	// @return streams: 分组内当前正在直播的流，没有正在直播的流时返回空
+	// @return exist:   分组是否存在，不存在时按普通流的请求处理
+	//
+	GetHlsVariantStreams(name string) (streams []VariantStreamInfo, exist bool)
+}
+
+// BuildVariantMasterPlaylist 生成多码率的master playlist，按码率从高到低排列
+//
+// @param uriPrefix: 每个档位playlist的uri前缀，档位uri为 uriPrefix + 流名称 + "/playlist.m3u8"
+// @param rawQuery:  不为空时，追加到每个档位uri的后面，用于透传鉴权等参数
+//
+func BuildVariantMasterPlaylist(streams []VariantStreamInfo, uriPrefix string, rawQuery string) []byte {
+	sorted := make([]VariantStreamInfo, len(streams))
+	copy(sorted, streams)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Bandwidth > sorted[j].Bandwidth
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString("#EXT-X-VERSION:3\n")
+	for _, s := range sorted {
+		attrs := []string{fmt.Sprintf("BANDWIDTH=%d", s.Bandwidth)}
+		if s.Width > 0 && s.Height > 0 {
+			attrs = append(attrs, fmt.Sprintf("RESOLUTION=%dx%d", s.Width, s.Height))
+		}
+		if s.Codecs != "" {
+			attrs = append(attrs, fmt.Sprintf("CODECS=\"%s\"", s.Codecs))
+		}
+		buf.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:%s\n", strings.Join(attrs, ",")))
+
+		uri := uriPrefix + s.StreamName + "/" + playlistM3u8FileName
+		if rawQuery != "" {
+			uri += "?" + rawQuery
+		}
+		buf.WriteString(uri + "\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,82 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type variantProvider map[string][]hls.VariantStreamInfo
+
+func (p variantProvider) GetHlsVariantStreams(name string) ([]hls.VariantStreamInfo, bool) {
+	streams, exist := p[name]
+	return streams, exist
+}
+
+func TestBuildVariantMasterPlaylist(t *testing.T) {
+	streams := []hls.VariantStreamInfo{
+		{StreamName: "cam1_360", Bandwidth: 800000, Width: 640, Height: 360, Codecs: "avc1.64001e,mp4a.40.2"},
+		{StreamName: "cam1_1080", Bandwidth: 5000000, Width: 1920, Height: 1080, Codecs: "avc1.640028,mp4a.40.2"},
+		{StreamName: "cam1_audio", Bandwidth: 128000, Codecs: "mp4a.40.2"},
+	}
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080,CODECS=\"avc1.640028,mp4a.40.2\"\n" +
+		"/hls/cam1_1080/playlist.m3u8?token=abc\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS=\"avc1.64001e,mp4a.40.2\"\n" +
+		"/hls/cam1_360/playlist.m3u8?token=abc\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=128000,CODECS=\"mp4a.40.2\"\n" +
+		"/hls/cam1_audio/playlist.m3u8?token=abc\n"
+	assert.Equal(t, expected, string(hls.BuildVariantMasterPlaylist(streams, "/hls/", "token=abc")))
+}
+
+func TestServerHandler_VariantGroup(t *testing.T) {
+	provider := variantProvider{
+		"cam1": {
+			{StreamName: "cam1_720", Bandwidth: 2000000, Width: 1280, Height: 720, Codecs: "avc1.64001f"},
+			{StreamName: "cam1_1080", Bandwidth: 5000000, Width: 1920, Height: 1080, Codecs: "avc1.640028"},
+		},
+		"cam2": nil,
+	}
+	dir, err := ioutil.TempDir("", "lal_hls_variant")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	handler := hls.NewServerHandler(dir).WithVariantProvider(provider)
+
+	expected := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080,CODECS=\"avc1.640028\"\n" +
+		"/live/hls/cam1_1080/playlist.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720,CODECS=\"avc1.64001f\"\n" +
+		"/live/hls/cam1_720/playlist.m3u8\n"
+
+	for _, uri := range []string{"/live/hls/cam1/master.m3u8", "/live/hls/cam1.m3u8"} {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest("GET", uri, nil))
+		assert.Equal(t, 200, resp.Code)
+		assert.Equal(t, "application/x-mpegurl", resp.Header().Get("Content-Type"))
+		assert.Equal(t, expected, resp.Body.String())
+	}
+
+	// 分组内没有正在直播的流
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/hls/cam2/master.m3u8", nil))
+	assert.Equal(t, 404, resp.Code)
+
+	// 不是分组，按普通流处理，文件不存在
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/hls/cam3/master.m3u8", nil))
+	assert.Equal(t, 404, resp.Code)
+}
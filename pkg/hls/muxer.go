@@ -11,6 +11,8 @@ package hls
 import (
 	"bytes"
 	"fmt"
+	"path/filepath"
+	"time"
 
 	"github.com/q191201771/naza/pkg/nazaerrors"
 
@@ -41,8 +43,55 @@ type MuxerConfig struct {
 	FragmentNum        int    `json:"fragment_num"`
 	DeleteThreshold    int    `json:"delete_threshold"`
 	CleanupMode        int    `json:"cleanup_mode"` // TODO chef: lalserver的模式1的逻辑是在上层做的，应该重构到hls模块中
+	SegmentFormat      string `json:"segment_format"`
+	LowLatency         bool   `json:"low_latency"`      // 开启LL-HLS，开启后分片格式固定为fmp4，见 ll_hls.go
+	PartDurationMs     int    `json:"part_duration_ms"` // LL-HLS中partial segment的目标时长，为0时使用默认值
+
+	EncryptMethod        string `json:"encrypt_method"`          // 分片加密方式，见 EncryptMethodAes128 等，为空时不加密
+	KeyRotateFragmentNum int    `json:"key_rotate_fragment_num"` // 每隔多少个分片更换一次密钥，为0时不更换
+	KeyFile              string `json:"key_file"`                // 内置密钥提供方，见 StaticFileKeyProvider
+	KeyServerUrl         string `json:"key_server_url"`          // 内置密钥提供方，见 HttpKeyProvider
+
+	ProgramDateTimeSource string `json:"program_date_time_source"` // `#EXT-X-PROGRAM-DATE-TIME`的时间来源，见 ProgramDateTimeSourceReceive
+
+	DvrWindowMs int `json:"dvr_window_ms"` // 时移窗口时长，大于0时生成dvr m3u8，见 dvr.go
+
+	ReconnectGraceMs int `json:"reconnect_grace_ms"` // 断线重连的宽限期，大于0时，在宽限期内重新开始的流延续之前的序号，见 resume.go
+
+	RecordMaxDurationMs int `json:"record_max_duration_ms"` // record m3u8保留的时长，大于0时淘汰更早的fragment并删除文件，见 record_retention.go
+}
+
+// IsFmp4 分片格式是否为fmp4
+//
+// 注意，开启LL-HLS时，无论 SegmentFormat 配置为什么，都使用fmp4
+//
+func (c *MuxerConfig) IsFmp4() bool {
+	return c.SegmentFormat == SegmentFormatFmp4 || c.LowLatency
+}
+
+// GetPartDurationMs LL-HLS中partial segment的目标时长，单位毫秒
+//
+func (c *MuxerConfig) GetPartDurationMs() int {
+	if c.PartDurationMs <= 0 {
+		return defaultPartDurationMs
+	}
+	return c.PartDurationMs
 }
 
+const programDateTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+const (
+	ProgramDateTimeSourceReceive = "receive" // 默认值，空字符串也表示receive。使用服务端生成分片时的本地时间
+	ProgramDateTimeSourceCapture = "capture" // 使用分片首帧的采集时间（见 mpegts.Frame.CaptureTimeMs ，fmp4分片见 Muxer.OnFmp4FragmentCaptureTime ），没有采集时间时使用本地时间
+)
+
+const (
+	SegmentFormatTs   = "ts"   // 默认值，空字符串也表示ts
+	SegmentFormatFmp4 = "fmp4" // fmp4（CMAF），输入数据见 Muxer.FeedFmp4InitSegment 和 Muxer.FeedFmp4Fragment
+)
+
+const closedCaptionsGroupId = "cc"
+
 const (
 	CleanupModeNever    = 0
 	CleanupModeInTheEnd = 1
@@ -53,6 +102,10 @@ const (
 //
 // 输入mpegts流，输出hls(m3u8+ts)至文件中
 //
+// 或者，分片格式配置为fmp4时，输入fmp4流，输出hls(m3u8+init.mp4+m4s)至文件中
+//
+// 开启LL-HLS时，额外在内存中维护partial segment和playlist，见 ll_hls.go
+//
 type Muxer struct {
 	UniqueKey string
 
@@ -62,6 +115,10 @@ type Muxer struct {
 	playlistFilenameBak       string // const after init
 	recordPlayListFilename    string // const after init
 	recordPlayListFilenameBak string // const after init
+	masterPlaylistFilename    string // const after init
+	masterPlaylistFilenameBak string // const after init
+	dvrPlaylistFilename       string // const after init
+	dvrPlaylistFilenameBak    string // const after init
 
 	config   *MuxerConfig
 	observer IMuxerObserver
@@ -83,6 +140,37 @@ type Muxer struct {
 	frags  []fragmentInfo // frags TS文件的固定大小环形队列，记录TS的信息
 
 	patpmt []byte
+
+	initFilename string // 当前的fmp4初始化分片文件名，为空表示还没有收到初始化分片
+	initId       int    // fmp4初始化分片的自增序号
+
+	recordInitFilename  string   // record m3u8中最后一次写入`#EXT-X-MAP`的初始化分片文件名
+	recordPendingRemove []string // 已从record m3u8中移除，但还在使用中，等待删除的fragment文件名
+
+	hasClosedCaptions bool // 流中是否携带CEA-608/708字幕，为true时生成master playlist并声明CLOSED-CAPTIONS
+	fragBytes         int  // 当前fragment已写入的字节数，用于估算码率
+	maxBandwidth      int  // 所有fragment中最大的码率，单位bit/s，写入master playlist的BANDWIDTH字段
+
+	llStream    *llHlsStream // 开启LL-HLS时不为nil
+	llPartEndTs uint64       // 上一个partial segment结束的时间戳，单位（毫秒*90）
+	llPartNames [][]string   // 最近几个segment的partial segment文件名，用于淘汰内存中过期的partial segment
+
+	keyProvider    IKeyProvider        // 开启分片加密时使用
+	keyFetcher     *keyFetcher         // 密钥提供方可能阻塞时，在独立的协程中提前获取密钥
+	currKey        *fragmentKey        // 当前使用的密钥，为nil表示还没有获取过密钥
+	keyId          int                 // 密钥的自增序号
+	keyFragCount   int                 // 当前密钥已经用于多少个分片
+	recordKey      *fragmentKey        // record m3u8中最后一次写入`#EXT-X-KEY`的密钥
+	keyFiles       map[string]struct{} // 写入过的，还没有删除的key文件
+	recordKeyFiles map[string]struct{} // record m3u8中引用的key文件
+	sampleAes      *sampleAesEncrypter // 当前分片使用SAMPLE-AES加密时不为nil
+	asc            []byte              // AAC的AudioSpecificConfig，SAMPLE-AES的PMT中需要使用
+
+	frameCaptureTimeMs int64 // 最近一次输入帧（fmp4时为媒体分片的首帧）的采集时间，使用采集时间作为`#EXT-X-PROGRAM-DATE-TIME`时使用
+
+	dvrFrags []fragmentInfo // 时移窗口内的fragment，按时间顺序排列
+
+	pendingCue *pendingCue // 收到SCTE-35信令后，等待在下一个边界处开启新分片，见 cue.go
 }
 
 // 记录fragment的一些信息，注意，写m3u8文件时可能还需要用到历史fragment的信息
@@ -91,6 +179,17 @@ type fragmentInfo struct {
 	duration float64 // 当前fragment中数据的时长，单位秒
 	discont  bool    // #EXT-X-DISCONTINUITY
 	filename string
+
+	initFilename string       // 分片格式为fmp4时，该分片对应的初始化分片，`#EXT-X-MAP`
+	key          *fragmentKey // 开启分片加密时，该分片使用的密钥，`#EXT-X-KEY`
+
+	programDateTime time.Time // 分片开始时的绝对时间，`#EXT-X-PROGRAM-DATE-TIME`
+
+	parts []llHlsPart // 开启LL-HLS时，该分片包含的partial segment
+
+	cueOut         bool    // 广告开始，`#EXT-X-CUE-OUT`
+	cueOutDuration float64 // 广告时长，单位秒，为0表示未知
+	cueIn          bool    // 广告结束，`#EXT-X-CUE-IN`
 }
 
 // NewMuxer
@@ -104,6 +203,10 @@ func NewMuxer(streamName string, config *MuxerConfig, observer IMuxerObserver) *
 	recordPlaylistFilename := PathStrategy.GetRecordM3u8FileName(op, streamName)
 	playlistFilenameBak := fmt.Sprintf("%s.bak", playlistFilename)
 	recordPlaylistFilenameBak := fmt.Sprintf("%s.bak", recordPlaylistFilename)
+	masterPlaylistFilename := PathStrategy.GetMasterM3u8FileName(op, streamName)
+	masterPlaylistFilenameBak := fmt.Sprintf("%s.bak", masterPlaylistFilename)
+	dvrPlaylistFilename := PathStrategy.GetDvrM3u8FileName(op, streamName)
+	dvrPlaylistFilenameBak := fmt.Sprintf("%s.bak", dvrPlaylistFilename)
 	m := &Muxer{
 		UniqueKey:                 uk,
 		streamName:                streamName,
@@ -112,10 +215,22 @@ func NewMuxer(streamName string, config *MuxerConfig, observer IMuxerObserver) *
 		playlistFilenameBak:       playlistFilenameBak,
 		recordPlayListFilename:    recordPlaylistFilename,
 		recordPlayListFilenameBak: recordPlaylistFilenameBak,
+		masterPlaylistFilename:    masterPlaylistFilename,
+		masterPlaylistFilenameBak: masterPlaylistFilenameBak,
+		dvrPlaylistFilename:       dvrPlaylistFilename,
+		dvrPlaylistFilenameBak:    dvrPlaylistFilenameBak,
 		config:                    config,
 		observer:                  observer,
+		keyProvider:               NewKeyProvider(config),
+		keyFiles:                  make(map[string]struct{}),
 	}
 	m.makeFrags()
+	if config.LowLatency {
+		m.llStream = newLlHlsStream(playlistFilename)
+	}
+	if config.EncryptMethod != EncryptMethodNone && config.IsFmp4() {
+		Log.Warnf("[%s] hls encryption only supports ts segment format, ignored. method=%s", uk, config.EncryptMethod)
+	}
 	Log.Infof("[%s] lifecycle new hls muxer. muxer=%p, streamName=%s", uk, m, streamName)
 	return m
 }
@@ -123,13 +238,36 @@ func NewMuxer(streamName string, config *MuxerConfig, observer IMuxerObserver) *
 func (m *Muxer) Start() {
 	Log.Infof("[%s] start hls muxer.", m.UniqueKey)
 	m.ensureDir()
+	m.resumeIfNeeded()
+	m.startKeyFetcherIfNeeded()
+	if m.llStream != nil {
+		registerLlHlsStream(m.outPath, m.llStream)
+	}
 }
 
 func (m *Muxer) Dispose() {
 	Log.Infof("[%s] lifecycle dispose hls muxer.", m.UniqueKey)
-	if err := m.closeFragment(true); err != nil {
+	// 开启断线重连宽限期时，live m3u8不写入`#EXT-X-ENDLIST`，使得播放器在宽限期内继续拉取
+	isLast := m.config.ReconnectGraceMs <= 0
+	if err := m.closeFragment(isLast); err != nil {
 		Log.Errorf("[%s] close fragment error. err=%+v", m.UniqueKey, err)
 	}
+	if !isLast {
+		m.saveResumeState()
+	}
+	if m.llStream != nil {
+		// 唤醒所有阻塞等待的请求，并且不再从内存中提供数据
+		m.llStream.update(m.buildLlHlsPlaylist(true), "", nil, nil)
+		unregisterLlHlsStream(m.outPath, m.llStream)
+	}
+}
+
+// SetHasClosedCaptions 设置流中是否携带CEA-608/708字幕
+//
+// 设置为true后，每次生成新的TS分片时，会同时更新master playlist，在其中声明`#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS`
+//
+func (m *Muxer) SetHasClosedCaptions(v bool) {
+	m.hasClosedCaptions = v
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -146,6 +284,24 @@ func (m *Muxer) OnTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary bool
 	m.FeedMpegts(tsPackets, frame, boundary)
 }
 
+// OnFmp4InitSegment OnFmp4Fragment
+//
+// 实现 remux.IRtmp2Fmp4RemuxerObserver，方便直接将 remux.Rtmp2Fmp4Remuxer 的数据喂入 hls.Muxer
+//
+func (m *Muxer) OnFmp4InitSegment(b []byte) {
+	m.FeedFmp4InitSegment(b)
+}
+
+func (m *Muxer) OnFmp4Fragment(b []byte, startTs uint64, endTs uint64, boundary bool) {
+	m.FeedFmp4Fragment(b, startTs, endTs, boundary)
+}
+
+// OnFmp4FragmentCaptureTime 实现 remux.IRtmp2Fmp4RemuxerCaptureTimeObserver ，在 FeedFmp4Fragment 之前设置媒体分片首帧的采集时间
+//
+func (m *Muxer) OnFmp4FragmentCaptureTime(captureTimeMs int64) {
+	m.frameCaptureTimeMs = captureTimeMs
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (m *Muxer) FeedPatPmt(b []byte) {
@@ -153,6 +309,14 @@ func (m *Muxer) FeedPatPmt(b []byte) {
 }
 
 func (m *Muxer) FeedMpegts(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	if frame.Pid == mpegts.PidScte35 {
+		m.feedScte35(tsPackets, frame)
+		return
+	}
+
+	m.updateAscIfNeeded(frame)
+	m.frameCaptureTimeMs = frame.CaptureTimeMs
+
 	if frame.Sid == mpegts.StreamIdAudio {
 		// TODO(chef): 为什么音频用pts，视频用dts
 		if err := m.updateFragment(frame.Pts, boundary); err != nil {
@@ -178,10 +342,81 @@ func (m *Muxer) FeedMpegts(tsPackets []byte, frame *mpegts.Frame, boundary bool)
 		//Log.Debugf("[%s] WriteFrame V. dts=%d, len=%d", m.UniqueKey, frame.Dts, len(frame.Raw))
 	}
 
+	// 只加密音视频，定时元数据不加密
+	if m.sampleAes != nil && (frame.Pid == mpegts.PidVideo || frame.Pid == mpegts.PidAudio) {
+		tsPackets = m.sampleAesRepack(frame)
+	}
+
 	if err := m.fragment.WriteFile(tsPackets); err != nil {
 		Log.Errorf("[%s] fragment write error. err=%+v", m.UniqueKey, err)
 		return
 	}
+	m.fragBytes += len(tsPackets)
+}
+
+// FeedFmp4InitSegment 输入fmp4初始化分片（ftyp+moov），分片格式为fmp4时使用
+//
+// 流的编码参数发生变化时会再次输入，此时会立即结束当前分片，后续的分片使用新的初始化分片
+//
+func (m *Muxer) FeedFmp4InitSegment(b []byte) {
+	id := m.initId
+	m.initId++
+	filename := PathStrategy.GetFmp4InitFileName(m.streamName, id, int(Clock.Now().UnixNano()/1e6))
+	filenameWithPath := PathStrategy.GetTsFileNameWithPath(m.outPath, filename)
+	if err := fslCtx.WriteFile(filenameWithPath, b, 0666); err != nil {
+		Log.Errorf("[%s] write init segment file error. err=%+v", m.UniqueKey, err)
+		return
+	}
+	uploadContent(filenameWithPath, b)
+
+	if m.initFilename != "" {
+		if err := m.closeFragment(false); err != nil {
+			Log.Errorf("[%s] close fragment error. err=%+v", m.UniqueKey, err)
+		}
+	}
+	m.initFilename = filename
+}
+
+// FeedFmp4Fragment 输入fmp4媒体分片（moof+mdat），分片格式为fmp4时使用
+//
+// @param startTs: 分片中第一个sample的dts，单位（毫秒*90）
+// @param endTs:   分片中最后一个sample结束的时间，单位（毫秒*90）
+//
+// @param boundary: 是否允许在该分片处开启新的hls分片
+//
+func (m *Muxer) FeedFmp4Fragment(b []byte, startTs uint64, endTs uint64, boundary bool) {
+	if m.initFilename == "" {
+		Log.Warnf("[%s] FeedFmp4Fragment but init segment not exist.", m.UniqueKey)
+		return
+	}
+
+	if err := m.updateFragment(startTs, boundary); err != nil {
+		Log.Errorf("[%s] update fragment error. err=%+v", m.UniqueKey, err)
+		return
+	}
+	if !m.opened {
+		Log.Warnf("[%s] FeedFmp4Fragment not opened. boundary=%t", m.UniqueKey, boundary)
+		return
+	}
+
+	if err := m.fragment.WriteFile(b); err != nil {
+		Log.Errorf("[%s] fragment write error. err=%+v", m.UniqueKey, err)
+		return
+	}
+	m.fragBytes += len(b)
+
+	if m.llStream != nil {
+		m.addLlHlsPart(b, endTs, boundary)
+	}
+
+	// 与ts不同，一个fmp4媒体分片包含多帧数据，所以使用分片结束的时间更新hls分片的时长
+	if endTs > m.fragTs {
+		f := m.getCurrFrag()
+		duration := float64(endTs-m.fragTs) / 90000
+		if duration > f.duration {
+			f.duration = duration
+		}
+	}
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -190,6 +425,12 @@ func (m *Muxer) OutPath() string {
 	return m.outPath
 }
 
+// MaxBandwidth 所有已生成的fragment中最大的码率，单位bit/s，还没有fragment时为0
+//
+func (m *Muxer) MaxBandwidth() int {
+	return m.maxBandwidth
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 // updateFragment 决定是否开启新的TS切片文件（注意，可能已经有TS切片，也可能没有，这是第一个切片）
@@ -241,7 +482,8 @@ func (m *Muxer) updateFragment(ts uint64, boundary bool) error {
 		discont = false
 
 		// 已经有TS切片，切片时长没有达到设置的阈值，则不开启新的切片
-		if f.duration < float64(m.config.FragmentDurationMs)/1000 {
+		// 有等待中的SCTE-35信令时除外，保证广告的切入切出点位于分片的开始处
+		if f.duration < float64(m.config.FragmentDurationMs)/1000 && m.pendingCue == nil {
 			return nil
 		}
 	}
@@ -262,6 +504,17 @@ func (m *Muxer) updateFragment(ts uint64, boundary bool) error {
 	return nil
 }
 
+// removeFragment 删除分片文件，或者内存分片存储中的分片，开启上传时同时删除对应的对象
+//
+func (m *Muxer) removeFragment(filename string) error {
+	deleteUploaded(PathStrategy.GetTsFileNameWithPath(m.outPath, filename))
+	if segmentStore != nil {
+		segmentStore.remove(m.streamName, filename)
+		return nil
+	}
+	return fslCtx.Remove(PathStrategy.GetTsFileNameWithPath(m.outPath, filename))
+}
+
 // openFragment
 //
 // @param discont: 不连续标志，会在m3u8文件的fragment前增加`#EXT-X-DISCONTINUITY`
@@ -275,15 +528,73 @@ func (m *Muxer) openFragment(ts uint64, discont bool) error {
 
 	id := m.getFragmentId()
 
-	filename := PathStrategy.GetTsFileName(m.streamName, id, int(Clock.Now().UnixNano()/1e6))
+	// 先获取密钥，获取失败时不生成分片，避免写入未加密的数据
+	var key *fragmentKey
+	method := m.encryptMethod()
+	if method != EncryptMethodNone {
+		if err := m.updateKeyIfNeeded(method); err != nil {
+			return err
+		}
+		key = m.currKey
+	}
+
+	var filename string
+	if m.isFmp4() {
+		filename = PathStrategy.GetFmp4SegmentFileName(m.streamName, id, int(Clock.Now().UnixNano()/1e6))
+	} else {
+		filename = PathStrategy.GetTsFileName(m.streamName, id, int(Clock.Now().UnixNano()/1e6))
+	}
 	filenameWithPath := PathStrategy.GetTsFileNameWithPath(m.outPath, filename)
 
-	if err := m.fragment.OpenFile(filenameWithPath); err != nil {
+	if segmentStore != nil {
+		m.fragment.openSegment(segmentStore.create(m.streamName, m.outPath, id, filename))
+	} else if err := m.fragment.OpenFile(filenameWithPath); err != nil {
 		return err
 	}
+	if uploader != nil {
+		m.fragment.uploadFilename = filenameWithPath
+		// 分片不会被删除时不需要记录，避免一直增长
+		if m.config.CleanupMode != CleanupModeNever {
+			trackUploaded(filenameWithPath)
+		}
+	}
 
-	if err := m.fragment.WriteFile(m.patpmt); err != nil {
-		return err
+	m.sampleAes = nil
+	patpmt := m.patpmt
+	if key != nil {
+		switch key.method {
+		case EncryptMethodAes128:
+			if err := m.fragment.SetAes128Key(key.key, key.iv); err != nil {
+				_ = m.fragment.CloseFile()
+				return err
+			}
+		case EncryptMethodSampleAes:
+			sampleAes, err := newSampleAesEncrypter(key.key, key.iv)
+			if err != nil {
+				_ = m.fragment.CloseFile()
+				return err
+			}
+			m.sampleAes = sampleAes
+			patpmt = buildSampleAesPatPmt(m.asc)
+			if mpegts.HasMetadataStream(m.patpmt) {
+				if patpmt, err = mpegts.AppendMetadataStreamToPatPmt(patpmt); err != nil {
+					_ = m.fragment.CloseFile()
+					return err
+				}
+			}
+			if mpegts.HasScte35Stream(m.patpmt) {
+				if patpmt, err = mpegts.AppendScte35StreamToPatPmt(patpmt); err != nil {
+					_ = m.fragment.CloseFile()
+					return err
+				}
+			}
+		}
+	}
+
+	if !m.isFmp4() {
+		if err := m.fragment.WriteFile(patpmt); err != nil {
+			return err
+		}
 	}
 
 	m.opened = true
@@ -293,8 +604,20 @@ func (m *Muxer) openFragment(ts uint64, discont bool) error {
 	frag.id = id
 	frag.filename = filename
 	frag.duration = 0
+	frag.initFilename = m.initFilename
+	frag.key = key
+	frag.programDateTime = m.fragmentWallClock()
+	frag.parts = nil
 
 	m.fragTs = ts
+	m.llPartEndTs = ts
+	m.fragBytes = 0
+	if !m.isFmp4() {
+		m.fragBytes = len(patpmt)
+	}
+	if err := m.applyPendingCue(frag); err != nil {
+		return err
+	}
 
 	// nrm said: start fragment with audio to make iPhone happy
 	m.observer.OnFragmentOpen()
@@ -335,19 +658,36 @@ func (m *Muxer) closeFragment(isLast bool) error {
 
 	m.writePlaylist(isLast)
 
+	currFrag := m.getClosedFrag()
+	if m.llStream != nil {
+		m.closeLlHlsSegment(currFrag)
+	}
+	if currFrag.duration > 0 {
+		bandwidth := int(float64(m.fragBytes*8) / currFrag.duration)
+		if bandwidth > m.maxBandwidth {
+			m.maxBandwidth = bandwidth
+		}
+	}
+	if m.hasClosedCaptions {
+		m.writeMasterPlaylist()
+	}
+
 	if m.config.CleanupMode == CleanupModeNever || m.config.CleanupMode == CleanupModeInTheEnd {
 		m.writeRecordPlaylist()
 	}
+	if m.config.DvrWindowMs > 0 {
+		m.updateDvr(currFrag, isLast)
+	}
 	if m.config.CleanupMode == CleanupModeAsap {
 		frag := m.getDeleteFrag()
-		if frag.filename != "" {
-			filenameWithPath := PathStrategy.GetTsFileNameWithPath(m.outPath, frag.filename)
-			if err := fslCtx.Remove(filenameWithPath); err != nil {
-				Log.Warnf("[%s] remove stale fragment file failed. filename=%s, err=%+v", m.UniqueKey, filenameWithPath, err)
+		// 还在时移窗口内的fragment，等离开时移窗口时再删除
+		if frag.filename != "" && !m.isInDvrWindow(frag.id) {
+			if err := m.removeFragment(frag.filename); err != nil {
+				Log.Warnf("[%s] remove stale fragment file failed. filename=%s, err=%+v", m.UniqueKey, frag.filename, err)
 			}
 		}
 	}
-	currFrag := m.getClosedFrag()
+	m.removeStaleKeyFiles()
 	m.observer.OnHlsMakeTs(base.HlsMakeTsInfo{
 		Event:          "close",
 		StreamName:     m.streamName,
@@ -368,7 +708,16 @@ func (m *Muxer) writeRecordPlaylist() {
 		m.recordMaxFragDuration = currFrag.duration + 0.5
 	}
 
-	fragLines := fmt.Sprintf("#EXTINF:%.3f,\n%s\n", currFrag.duration, currFrag.filename)
+	fragLines := buildCueTag(currFrag) + buildProgramDateTimeTag(currFrag.programDateTime) +
+		fmt.Sprintf("#EXTINF:%.3f,\n%s\n", currFrag.duration, currFrag.filename)
+	if currFrag.initFilename != m.recordInitFilename {
+		fragLines = fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"\n", currFrag.initFilename) + fragLines
+		m.recordInitFilename = currFrag.initFilename
+	}
+	if currFrag.key != m.recordKey {
+		fragLines = buildKeyTag(currFrag.key) + fragLines
+		m.recordKey = currFrag.key
+	}
 
 	content, err := fslCtx.ReadFile(m.recordPlayListFilename)
 	if err == nil {
@@ -386,12 +735,22 @@ func (m *Muxer) writeRecordPlaylist() {
 		}
 
 		content = append(content, []byte(fragLines)...)
+
+		if m.config.RecordMaxDurationMs > 0 {
+			var removed []string
+			content, removed, err = trimRecordM3u8(content, float64(m.config.RecordMaxDurationMs)/1000)
+			if err != nil {
+				Log.Errorf("[%s] trim record m3u8 failed. err=%+v", m.UniqueKey, err)
+			}
+			m.removeRecordFragments(removed)
+		}
+
 		content = append(content, []byte("#EXT-X-ENDLIST\n")...)
 	} else {
 		// m3u8文件不存在
 		var buf bytes.Buffer
 		buf.WriteString("#EXTM3U\n")
-		buf.WriteString("#EXT-X-VERSION:3\n")
+		buf.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", m.playlistVersion()))
 		buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(m.recordMaxFragDuration)))
 		buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n\n", 0))
 
@@ -405,6 +764,7 @@ func (m *Muxer) writeRecordPlaylist() {
 		content = buf.Bytes()
 	}
 
+	m.updateRecordKeyFiles(content)
 	if err := writeM3u8File(content, m.recordPlayListFilename, m.recordPlayListFilenameBak); err != nil {
 		Log.Errorf("[%s] write record m3u8 file error. err=%+v", m.UniqueKey, err)
 	}
@@ -422,17 +782,14 @@ func (m *Muxer) writePlaylist(isLast bool) {
 	// TODO chef 优化这块buffer的构造
 	var buf bytes.Buffer
 	buf.WriteString("#EXTM3U\n")
-	buf.WriteString("#EXT-X-VERSION:3\n")
+	buf.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", m.playlistVersion()))
 	buf.WriteString("#EXT-X-ALLOW-CACHE:NO\n")
 	buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(maxFrag)))
 	buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n\n", m.extXMediaSeq()))
 
+	var fw playlistFragWriter
 	m.iterateFragsInPlaylist(func(frag *fragmentInfo) {
-		if frag.discont {
-			buf.WriteString("#EXT-X-DISCONTINUITY\n")
-		}
-
-		buf.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", frag.duration, frag.filename))
+		fw.write(&buf, frag)
 	})
 
 	if isLast {
@@ -444,6 +801,84 @@ func (m *Muxer) writePlaylist(isLast bool) {
 	}
 }
 
+// playlistFragWriter 写m3u8中单个fragment相关的行，只在与上一个fragment不同时写`#EXT-X-KEY`和`#EXT-X-MAP`
+//
+type playlistFragWriter struct {
+	initFilename string
+	key          *fragmentKey
+}
+
+func (fw *playlistFragWriter) write(buf *bytes.Buffer, frag *fragmentInfo) {
+	if frag.discont {
+		buf.WriteString("#EXT-X-DISCONTINUITY\n")
+	}
+	if frag.key != fw.key {
+		buf.WriteString(buildKeyTag(frag.key))
+		fw.key = frag.key
+	}
+	if frag.initFilename != fw.initFilename {
+		buf.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=\"%s\"\n", frag.initFilename))
+		fw.initFilename = frag.initFilename
+	}
+
+	buf.WriteString(buildCueTag(frag))
+	buf.WriteString(buildProgramDateTimeTag(frag.programDateTime))
+	buf.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", frag.duration, frag.filename))
+}
+
+// writeMasterPlaylist 写master playlist，目前只在流中携带字幕时使用
+//
+// 注意，CEA-608/708字幕是携带在视频帧的SEI中的，所以master playlist中只需要声明，不需要额外的媒体文件，
+// 播放器通过INSTREAM-ID从视频流中提取对应的字幕通道
+//
+func (m *Muxer) writeMasterPlaylist() {
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	buf.WriteString("#EXT-X-VERSION:3\n")
+	buf.WriteString(fmt.Sprintf("#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID=\"%s\",NAME=\"CC1\",LANGUAGE=\"und\",INSTREAM-ID=\"CC1\",DEFAULT=YES,AUTOSELECT=YES\n", closedCaptionsGroupId))
+	buf.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,CLOSED-CAPTIONS=\"%s\"\n", m.maxBandwidth, closedCaptionsGroupId))
+	buf.WriteString(filepath.Base(m.playlistFilename) + "\n")
+
+	if err := writeM3u8File(buf.Bytes(), m.masterPlaylistFilename, m.masterPlaylistFilenameBak); err != nil {
+		Log.Errorf("[%s] write master m3u8 file error. err=%+v", m.UniqueKey, err)
+	}
+}
+
+// fragmentWallClock 新分片的绝对时间，见 MuxerConfig.ProgramDateTimeSource
+//
+func (m *Muxer) fragmentWallClock() time.Time {
+	if m.config.ProgramDateTimeSource == ProgramDateTimeSourceCapture && m.frameCaptureTimeMs != 0 {
+		return time.Unix(0, m.frameCaptureTimeMs*int64(time.Millisecond))
+	}
+	return Clock.Now()
+}
+
+// buildProgramDateTimeTag 格式为ISO 8601，精确到毫秒，比如`#EXT-X-PROGRAM-DATE-TIME:2022-07-01T14:03:20.123+08:00`
+//
+func buildProgramDateTimeTag(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("#EXT-X-PROGRAM-DATE-TIME:%s\n", t.Format(programDateTimeLayout))
+}
+
+func (m *Muxer) isFmp4() bool {
+	return m.config.IsFmp4()
+}
+
+// playlistVersion fmp4需要使用`#EXT-X-MAP`，要求版本号至少为6
+//
+func (m *Muxer) playlistVersion() int {
+	if m.isFmp4() {
+		return 7
+	}
+	// SAMPLE-AES要求版本号至少为5
+	if m.config.EncryptMethod == EncryptMethodSampleAes {
+		return 5
+	}
+	return 3
+}
+
 func (m *Muxer) ensureDir() {
 	// 注意，如果路径已经存在，则啥也不干
 	err := fslCtx.MkdirAll(m.outPath, 0777)
@@ -0,0 +1,213 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/mock"
+)
+
+type mockMuxerObserver struct {
+	infos []base.HlsMakeTsInfo
+}
+
+func (o *mockMuxerObserver) OnHlsMakeTs(info base.HlsMakeTsInfo) {
+	o.infos = append(o.infos, info)
+}
+
+func (o *mockMuxerObserver) OnFragmentOpen() {
+}
+
+func TestMuxerFmp4(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_fmp4")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	config := &hls.MuxerConfig{
+		OutPath:            dir,
+		FragmentDurationMs: 1000,
+		FragmentNum:        3,
+		DeleteThreshold:    3,
+		CleanupMode:        hls.CleanupModeNever,
+		SegmentFormat:      hls.SegmentFormatFmp4,
+	}
+	observer := &mockMuxerObserver{}
+	m := hls.NewMuxer("test110", config, observer)
+	m.Start()
+
+	// 没有初始化分片，忽略
+	m.FeedFmp4Fragment([]byte("frag"), 0, 90000, true)
+	assert.Equal(t, 0, len(observer.infos))
+
+	m.FeedFmp4InitSegment([]byte("init0"))
+	for i := uint64(0); i < 4; i++ {
+		m.FeedFmp4Fragment([]byte("frag"), i*90000, (i+1)*90000, true)
+	}
+	// 编码参数变化
+	m.FeedFmp4InitSegment([]byte("init1"))
+	m.FeedFmp4Fragment([]byte("frag"), 4*90000, 5*90000, true)
+	m.Dispose()
+
+	// 每个分片一个open和一个close事件
+	assert.Equal(t, 10, len(observer.infos))
+	assert.Equal(t, true, strings.HasSuffix(observer.infos[0].TsFile, ".m4s"))
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "test110", "record.m3u8"))
+	assert.Equal(t, nil, err)
+	lines := strings.Split(string(content), "\n")
+	assert.Equal(t, "#EXT-X-VERSION:7", lines[1])
+	var maps []string
+	var segments int
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#EXT-X-MAP:") {
+			maps = append(maps, line)
+		}
+		if strings.HasSuffix(line, ".m4s") {
+			segments++
+			b, err := ioutil.ReadFile(filepath.Join(dir, "test110", line))
+			assert.Equal(t, nil, err)
+			assert.Equal(t, "frag", string(b))
+		}
+	}
+	assert.Equal(t, 5, segments)
+	assert.Equal(t, 2, len(maps))
+	for i, line := range maps {
+		filename := strings.TrimSuffix(strings.TrimPrefix(line, `#EXT-X-MAP:URI="`), `"`)
+		assert.Equal(t, true, strings.HasSuffix(filename, "-init.mp4"))
+		b, err := ioutil.ReadFile(filepath.Join(dir, "test110", filename))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "init"+string(rune('0'+i)), string(b))
+	}
+	assert.Equal(t, true, strings.Contains(string(content), "#EXT-X-DISCONTINUITY\n#EXT-X-MAP:"))
+
+	content, err = ioutil.ReadFile(filepath.Join(dir, "test110", "playlist.m3u8"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(content), "#EXT-X-MAP:"))
+	assert.Equal(t, true, strings.HasSuffix(string(content), "#EXT-X-ENDLIST\n"))
+}
+
+func TestMuxerProgramDateTime(t *testing.T) {
+	hls.Clock = mock.NewFakeClock()
+	defer func() {
+		hls.Clock = mock.NewStdClock()
+	}()
+	start := time.Date(2022, 7, 1, 14, 3, 20, 0, time.UTC)
+	hls.Clock.Set(start)
+
+	for _, source := range []string{hls.ProgramDateTimeSourceReceive, hls.ProgramDateTimeSourceCapture} {
+		dir, err := ioutil.TempDir("", "lal_hls_pdt")
+		assert.Equal(t, nil, err)
+
+		config := &hls.MuxerConfig{
+			OutPath:               dir,
+			FragmentDurationMs:    1000,
+			FragmentNum:           3,
+			DeleteThreshold:       3,
+			CleanupMode:           hls.CleanupModeNever,
+			ProgramDateTimeSource: source,
+		}
+		m := hls.NewMuxer("test110", config, &mockMuxerObserver{})
+		m.Start()
+		m.FeedPatPmt(mpegts.FixedFragmentHeader)
+		for i := 0; i < 3; i++ {
+			// 采集时间比服务端接收时间早一个小时
+			frame := &mpegts.Frame{
+				Pts:           uint64(i) * 90000,
+				Dts:           uint64(i) * 90000,
+				Pid:           mpegts.PidVideo,
+				Sid:           mpegts.StreamIdVideo,
+				Key:           true,
+				Raw:           []byte{0, 0, 0, 1, 0x65, 0x88},
+				CaptureTimeMs: start.Add(-time.Hour).UnixNano()/1e6 + int64(i)*1000,
+			}
+			m.FeedMpegts(frame.Pack(), frame, true)
+			hls.Clock.Add(time.Second)
+		}
+		m.Dispose()
+
+		expected := []string{"14:03:20.000", "14:03:21.000", "14:03:22.000"}
+		if source == hls.ProgramDateTimeSourceCapture {
+			expected = []string{"13:03:20.000", "13:03:21.000", "13:03:22.000"}
+		}
+		for _, filename := range []string{"playlist.m3u8", "record.m3u8"} {
+			content, err := ioutil.ReadFile(filepath.Join(dir, "test110", filename))
+			assert.Equal(t, nil, err)
+			lines := strings.Split(string(content), "\n")
+			var tags []string
+			for i, line := range lines {
+				if strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:") {
+					// 紧跟着分片的EXTINF
+					assert.Equal(t, true, strings.HasPrefix(lines[i+1], "#EXTINF:"))
+					tm, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
+					assert.Equal(t, nil, err)
+					tags = append(tags, tm.UTC().Format("15:04:05.000"))
+				}
+			}
+			assert.Equal(t, expected, tags)
+		}
+
+		hls.Clock.Set(start)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestMuxerProgramDateTimeFmp4(t *testing.T) {
+	hls.Clock = mock.NewFakeClock()
+	defer func() {
+		hls.Clock = mock.NewStdClock()
+	}()
+	start := time.Date(2022, 7, 1, 14, 3, 20, 0, time.UTC)
+	hls.Clock.Set(start)
+
+	dir, err := ioutil.TempDir("", "lal_hls_pdt_fmp4")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	config := &hls.MuxerConfig{
+		OutPath:               dir,
+		FragmentDurationMs:    1000,
+		FragmentNum:           3,
+		DeleteThreshold:       3,
+		CleanupMode:           hls.CleanupModeNever,
+		SegmentFormat:         hls.SegmentFormatFmp4,
+		ProgramDateTimeSource: hls.ProgramDateTimeSourceCapture,
+	}
+	m := hls.NewMuxer("test110", config, &mockMuxerObserver{})
+	m.Start()
+	m.OnFmp4InitSegment([]byte("init"))
+	for i := uint64(0); i < 3; i++ {
+		// fmp4的采集时间由 remux.Rtmp2Fmp4Remuxer 在媒体分片之前回调
+		m.OnFmp4FragmentCaptureTime(start.Add(-time.Hour).UnixNano()/1e6 + int64(i)*1000)
+		m.OnFmp4Fragment([]byte("frag"), i*90000, (i+1)*90000, true)
+		hls.Clock.Add(time.Second)
+	}
+	m.Dispose()
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "test110", "record.m3u8"))
+	assert.Equal(t, nil, err)
+	var tags []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:") {
+			tm, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
+			assert.Equal(t, nil, err)
+			tags = append(tags, tm.UTC().Format("15:04:05.000"))
+		}
+	}
+	assert.Equal(t, []string{"13:03:20.000", "13:03:21.000", "13:03:22.000"}, tags)
+}
@@ -61,6 +61,20 @@ type IPathWriteStrategy interface {
 	// @param outPath: func GetMuxerOutPath的结果
 	GetRecordM3u8FileName(outPath string, streamName string) string
 
+	// GetMasterM3u8FileName 获取单个流对应的master playlist文件路径
+	//
+	// master playlist用于声明live m3u8以外的附加信息，比如CLOSED-CAPTIONS字幕
+	//
+	// @param outPath: func GetMuxerOutPath的结果
+	GetMasterM3u8FileName(outPath string, streamName string) string
+
+	// GetDvrM3u8FileName 获取单个流对应的DVR（时移）m3u8文件路径
+	//
+	// dvr m3u8记录的是最近 MuxerConfig.DvrWindowMs 时长的可播放内容
+	//
+	// @param outPath: func GetMuxerOutPath的结果
+	GetDvrM3u8FileName(outPath string, streamName string) string
+
 	// GetTsFileNameWithPath 获取单个流对应的ts文件路径
 	//
 	// @param outPath: func GetMuxerOutPath的结果
@@ -68,6 +82,14 @@ type IPathWriteStrategy interface {
 
 	// GetTsFileName ts文件名的生成策略
 	GetTsFileName(streamName string, index int, timestamp int) string
+
+	// GetFmp4InitFileName fmp4初始化分片文件名的生成策略，分片格式为fmp4时使用
+	//
+	// 注意，文件路径同样使用 GetTsFileNameWithPath 生成
+	GetFmp4InitFileName(streamName string, index int, timestamp int) string
+
+	// GetFmp4SegmentFileName fmp4媒体分片文件名的生成策略，分片格式为fmp4时使用
+	GetFmp4SegmentFileName(streamName string, index int, timestamp int) string
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -75,6 +97,8 @@ type IPathWriteStrategy interface {
 const (
 	playlistM3u8FileName = "playlist.m3u8"
 	recordM3u8FileName   = "record.m3u8"
+	masterM3u8FileName   = "master.m3u8"
+	dvrM3u8FileName      = "dvr.m3u8"
 )
 
 // DefaultPathStrategy 默认的路由，落盘策略
@@ -83,10 +107,18 @@ const (
 //
 // - playlist.m3u8              实时的HLS文件，定期刷新，写入当前最新的TS文件列表，淘汰过期的TS文件列表
 // - record.m3u8                录制回放的HLS文件，包含了从流开始至今的所有TS文件
+// - master.m3u8                master playlist，指向playlist.m3u8，流中携带字幕时生成
+// - dvr.m3u8                   时移的HLS文件，包含最近一段时间的TS文件列表，配置了DVR窗口时生成
 // - test110-1620540712084-0.ts TS分片文件，命名格式为{liveid}-{timestamp}-{index}.ts
 // - test110-1620540716095-1.ts
 // - ...                        一系列的TS文件
 //
+// 分片格式为fmp4时，TS分片文件替换为：
+//
+// - test110-1620540712084-0-init.mp4 fmp4初始化分片，命名格式为{liveid}-{timestamp}-{index}-init.mp4
+// - test110-1620540712084-0.m4s      fmp4媒体分片，命名格式为{liveid}-{timestamp}-{index}.m4s
+// - ...
+//
 //
 // 假设
 // 流名称="test110"
@@ -95,6 +127,8 @@ const (
 // 则
 // http://127.0.0.1:8080/hls/test110/playlist.m3u8              -> /tmp/lal/hls/test110/playlist.m3u8
 // http://127.0.0.1:8080/hls/test110/record.m3u8                -> /tmp/lal/hls/test110/record.m3u8
+// http://127.0.0.1:8080/hls/test110/master.m3u8                -> /tmp/lal/hls/test110/master.m3u8
+// http://127.0.0.1:8080/hls/test110/dvr.m3u8                   -> /tmp/lal/hls/test110/dvr.m3u8
 // http://127.0.0.1:8080/hls/test110/test110-1620540712084-0.ts -> /tmp/lal/hls/test110/test110-1620540712084-0.ts
 //
 // http://127.0.0.1:8080/hls/test110.m3u8                       -> /tmp/lal/hls/test110/playlist.m3u8
@@ -111,8 +145,12 @@ type DefaultPathStrategy struct {
 // /hls/test110.m3u8                      -> test110.m3u8              test110    m3u8     {rootOutPath}/test110/playlist.m3u8
 // /hls/test110/playlist.m3u8             -> playlist.m3u8             test110    m3u8     {rootOutPath}/test110/playlist.m3u8
 // /hls/test110/record.m3u8               -> record.m3u8               test110    m3u8     {rootOutPath}/test110/record.m3u8
+// /hls/test110/master.m3u8               -> master.m3u8               test110    m3u8     {rootOutPath}/test110/master.m3u8
+// /hls/test110/dvr.m3u8                  -> dvr.m3u8                  test110    m3u8     {rootOutPath}/test110/dvr.m3u8
 // /hls/test110/test110-1620540712084-.ts -> test110-1620540712084-.ts test110    ts       {rootOutPath/test110/test110-1620540712084-.ts
 // /hls/test110-1620540712084-.ts         -> test110-1620540712084-.ts test110    ts       {rootOutPath/test110/test110-1620540712084-.ts
+// /hls/test110/test110-1620540712084-.m4s -> test110-1620540712084-.m4s test110   m4s      {rootOutPath/test110/test110-1620540712084-.m4s
+// /hls/test110/test110-1620540712084-0.key -> test110-1620540712084-0.key test110  key      {rootOutPath/test110/test110-1620540712084-0.key
 //
 func (dps *DefaultPathStrategy) GetRequestInfo(urlCtx base.UrlContext, rootOutPath string) (ri RequestInfo) {
 	filename := urlCtx.LastItemOfPath
@@ -120,7 +158,7 @@ func (dps *DefaultPathStrategy) GetRequestInfo(urlCtx base.UrlContext, rootOutPa
 	fileNameWithoutType := urlCtx.GetFilenameWithoutType()
 
 	if filetype == "m3u8" {
-		if filename == playlistM3u8FileName || filename == recordM3u8FileName {
+		if filename == playlistM3u8FileName || filename == recordM3u8FileName || filename == masterM3u8FileName || filename == dvrM3u8FileName {
 			uriItems := strings.Split(urlCtx.Path, "/")
 			ri.StreamName = uriItems[len(uriItems)-2]
 			ri.FileNameWithPath = filepath.Join(rootOutPath, ri.StreamName, filename)
@@ -128,7 +166,7 @@ func (dps *DefaultPathStrategy) GetRequestInfo(urlCtx base.UrlContext, rootOutPa
 			ri.StreamName = fileNameWithoutType
 			ri.FileNameWithPath = filepath.Join(rootOutPath, ri.StreamName, playlistM3u8FileName)
 		}
-	} else if filetype == "ts" {
+	} else if filetype == "ts" || filetype == "m4s" || filetype == "mp4" || filetype == "key" {
 		ri.StreamName = dps.getStreamNameFromTsFileName(filename)
 		ri.FileNameWithPath = filepath.Join(rootOutPath, ri.StreamName, filename)
 	}
@@ -149,6 +187,14 @@ func (*DefaultPathStrategy) GetRecordM3u8FileName(outPath string, streamName str
 	return filepath.Join(outPath, recordM3u8FileName)
 }
 
+func (*DefaultPathStrategy) GetMasterM3u8FileName(outPath string, streamName string) string {
+	return filepath.Join(outPath, masterM3u8FileName)
+}
+
+func (*DefaultPathStrategy) GetDvrM3u8FileName(outPath string, streamName string) string {
+	return filepath.Join(outPath, dvrM3u8FileName)
+}
+
 func (*DefaultPathStrategy) GetTsFileNameWithPath(outPath string, fileName string) string {
 	return filepath.Join(outPath, fileName)
 }
@@ -157,6 +203,14 @@ func (*DefaultPathStrategy) GetTsFileName(streamName string, index int, timestam
 	return fmt.Sprintf("%s-%d-%d.ts", streamName, timestamp, index)
 }
 
+func (*DefaultPathStrategy) GetFmp4InitFileName(streamName string, index int, timestamp int) string {
+	return fmt.Sprintf("%s-%d-%d-init.mp4", streamName, timestamp, index)
+}
+
+func (*DefaultPathStrategy) GetFmp4SegmentFileName(streamName string, index int, timestamp int) string {
+	return fmt.Sprintf("%s-%d-%d.m4s", streamName, timestamp, index)
+}
+
 func (*DefaultPathStrategy) getStreamNameFromTsFileName(fileName string) string {
 	return strings.Split(fileName, "-")[0]
 }
@@ -0,0 +1,160 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// record m3u8的保留策略：
+//
+// - 配置 MuxerConfig.RecordMaxDurationMs 后，record m3u8只保留最近一段时间内的fragment，更早的fragment从record m3u8中移除，并删除文件
+// - 还在live m3u8删除阈值内，或者还在时移窗口内的fragment，先从record m3u8中移除，等离开后再删除文件
+// - 被移除的fragment携带的`#EXT-X-KEY`、`#EXT-X-MAP`，转移到剩余的第一个fragment上
+//
+
+type recordEntry struct {
+	lines    []string // 包含fragment前面的tag，以及fragment文件名
+	filename string
+	duration float64
+	key      string // fragment前面的`#EXT-X-KEY`，没有时为空
+	initMap  string // fragment前面的`#EXT-X-MAP`，没有时为空
+}
+
+// trimRecordM3u8 淘汰record m3u8中超出时长的fragment
+//
+// @param content:     record m3u8文件的内容，不包含`#EXT-X-ENDLIST`
+// @param maxDuration: 保留的时长，单位秒
+//
+// @return removed: 被移除的fragment文件名
+//
+func trimRecordM3u8(content []byte, maxDuration float64) (out []byte, removed []string, err error) {
+	lines := bytes.Split(bytes.TrimSuffix(content, []byte{'\n'}), []byte{'\n'})
+
+	// 头部，直到第一个空行
+	var header []string
+	i := 0
+	for ; i < len(lines); i++ {
+		header = append(header, string(lines[i]))
+		if len(lines[i]) == 0 {
+			i++
+			break
+		}
+	}
+
+	var (
+		entries []recordEntry
+		curr    recordEntry
+		total   float64
+	)
+	for ; i < len(lines); i++ {
+		line := string(lines[i])
+		if line == "" {
+			continue
+		}
+		curr.lines = append(curr.lines, line)
+		switch {
+		case bytes.HasPrefix(lines[i], []byte("#EXTINF:")):
+			v := bytes.TrimPrefix(lines[i], []byte("#EXTINF:"))
+			if idx := bytes.IndexByte(v, ','); idx != -1 {
+				v = v[:idx]
+			}
+			if curr.duration, err = strconv.ParseFloat(string(v), 64); err != nil {
+				return content, nil, err
+			}
+		case bytes.HasPrefix(lines[i], []byte("#EXT-X-KEY:")):
+			curr.key = line
+		case bytes.HasPrefix(lines[i], []byte("#EXT-X-MAP:")):
+			curr.initMap = line
+		case !bytes.HasPrefix(lines[i], []byte("#")):
+			curr.filename = line
+			total += curr.duration
+			entries = append(entries, curr)
+			curr = recordEntry{}
+		}
+	}
+
+	var lastKey, lastMap string
+	n := 0
+	for len(entries)-n > 1 && total-entries[n].duration >= maxDuration {
+		if entries[n].key != "" {
+			lastKey = entries[n].key
+		}
+		if entries[n].initMap != "" {
+			lastMap = entries[n].initMap
+		}
+		total -= entries[n].duration
+		removed = append(removed, entries[n].filename)
+		n++
+	}
+	if n == 0 {
+		return content, nil, nil
+	}
+	entries = entries[n:]
+
+	first := &entries[0]
+	if first.initMap == "" && lastMap != "" {
+		first.lines = append([]string{lastMap}, first.lines...)
+	}
+	if first.key == "" && lastKey != "" {
+		first.lines = append([]string{lastKey}, first.lines...)
+	}
+
+	var buf bytes.Buffer
+	for _, line := range header {
+		if bytes.HasPrefix([]byte(line), []byte("#EXT-X-MEDIA-SEQUENCE:")) {
+			seq, err := strconv.Atoi(line[len("#EXT-X-MEDIA-SEQUENCE:"):])
+			if err != nil {
+				return content, nil, err
+			}
+			line = fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d", seq+n)
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	for _, e := range entries {
+		for _, line := range e.lines {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	// 未完整的尾部
+	for _, line := range curr.lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), removed, nil
+}
+
+// removeRecordFragments 删除从record m3u8中移除的fragment文件
+//
+func (m *Muxer) removeRecordFragments(filenames []string) {
+	inUse := make(map[string]struct{})
+	for i := range m.frags {
+		inUse[m.frags[i].filename] = struct{}{}
+	}
+	for i := range m.dvrFrags {
+		inUse[m.dvrFrags[i].filename] = struct{}{}
+	}
+
+	// 之前还在使用的fragment，在这次重新检查
+	filenames = append(m.recordPendingRemove, filenames...)
+	m.recordPendingRemove = nil
+	for _, filename := range filenames {
+		if _, ok := inUse[filename]; ok {
+			m.recordPendingRemove = append(m.recordPendingRemove, filename)
+			continue
+		}
+		if err := m.removeFragment(filename); err != nil {
+			Log.Warnf("[%s] remove record fragment file failed. filename=%s, err=%+v", m.UniqueKey, filename, err)
+		}
+	}
+}
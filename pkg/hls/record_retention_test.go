@@ -0,0 +1,76 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestMuxerRecordRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_record")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	config := &hls.MuxerConfig{
+		OutPath:             dir,
+		FragmentDurationMs:  1000,
+		FragmentNum:         2,
+		DeleteThreshold:     1,
+		CleanupMode:         hls.CleanupModeNever,
+		RecordMaxDurationMs: 3000,
+	}
+	m := hls.NewMuxer("test110", config, &mockMuxerObserver{})
+	m.Start()
+	m.FeedPatPmt(mpegts.FixedFragmentHeader)
+
+	// 每个分片1秒，共生成10个完整的分片
+	var all []string
+	for i := uint64(0); i < 11; i++ {
+		frame := &mpegts.Frame{
+			Pts: i * 90000,
+			Dts: i * 90000,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: true,
+			Raw: []byte{0, 0, 0, 1, 0x65, 0x88, byte(i)},
+		}
+		m.FeedMpegts(frame.Pack(), frame, true)
+		if i == 1 {
+			content, err := ioutil.ReadFile(filepath.Join(dir, "test110", "record.m3u8"))
+			assert.Equal(t, nil, err)
+			all = listFragments(string(content))
+		}
+	}
+	m.Dispose()
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "test110", "record.m3u8"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(content), "#EXT-X-MEDIA-SEQUENCE:7\n"))
+	assert.Equal(t, true, strings.HasSuffix(string(content), "#EXT-X-ENDLIST\n"))
+	// 3秒内的分片，以及结束时关闭的分片
+	frags := listFragments(string(content))
+	assert.Equal(t, 4, len(frags))
+	for _, frag := range frags {
+		_, err := os.Stat(filepath.Join(dir, "test110", frag))
+		assert.Equal(t, nil, err)
+	}
+
+	// 最早的分片被删除
+	assert.Equal(t, 1, len(all))
+	_, err = os.Stat(filepath.Join(dir, "test110", all[0]))
+	assert.Equal(t, true, os.IsNotExist(err))
+}
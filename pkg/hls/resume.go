@@ -0,0 +1,190 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/naza/pkg/filesystemlayer"
+)
+
+// 断线重连后延续序号：
+//
+// - 配置 MuxerConfig.ReconnectGraceMs 后，Muxer 结束时记录live m3u8中的fragment信息，并且live m3u8不写入`#EXT-X-ENDLIST`
+// - 在宽限期内，相同输出目录的 Muxer 重新开始时，延续`#EXT-X-MEDIA-SEQUENCE`，保留已经在live m3u8中的fragment，
+//   并在新的fragment前插入`#EXT-X-DISCONTINUITY`
+// - 优先使用内存中记录的信息（推流端断线重连），没有时读取磁盘上已有的live m3u8（lalserver重启），
+//   此时使用m3u8文件的修改时间判断是否超过宽限期
+//
+
+// muxerResumeState Muxer 结束时记录的信息
+type muxerResumeState struct {
+	updateTime time.Time
+	nextId     int            // 下一个fragment的序号
+	frags      []fragmentInfo // live m3u8中的fragment
+	dvrFrags   []fragmentInfo
+	keyId      int
+}
+
+var (
+	resumeStatesMutex sync.Mutex
+	resumeStates      = make(map[string]*muxerResumeState) // key为 Muxer 的输出目录
+)
+
+// saveResumeState Dispose 时调用
+//
+func (m *Muxer) saveResumeState() {
+	state := &muxerResumeState{
+		updateTime: Clock.Now(),
+		nextId:     m.getFragmentId(),
+		dvrFrags:   m.dvrFrags,
+		keyId:      m.keyId,
+	}
+	m.iterateFragsInPlaylist(func(frag *fragmentInfo) {
+		f := *frag
+		f.parts = nil
+		state.frags = append(state.frags, f)
+	})
+
+	grace := time.Duration(m.config.ReconnectGraceMs) * time.Millisecond
+
+	resumeStatesMutex.Lock()
+	defer resumeStatesMutex.Unlock()
+	// 顺便清理已经超过宽限期的记录
+	for k, v := range resumeStates {
+		if state.updateTime.Sub(v.updateTime) > grace {
+			delete(resumeStates, k)
+		}
+	}
+	resumeStates[m.outPath] = state
+}
+
+// resumeIfNeeded Start 时调用
+//
+func (m *Muxer) resumeIfNeeded() {
+	if m.config.ReconnectGraceMs <= 0 {
+		return
+	}
+
+	resumeStatesMutex.Lock()
+	state := resumeStates[m.outPath]
+	delete(resumeStates, m.outPath)
+	resumeStatesMutex.Unlock()
+
+	if state == nil {
+		state = m.loadResumeState()
+	}
+	if state == nil || len(state.frags) == 0 {
+		return
+	}
+	if Clock.Now().Sub(state.updateTime) > time.Duration(m.config.ReconnectGraceMs)*time.Millisecond {
+		Log.Infof("[%s] hls reconnect grace period exceeded, start from scratch. updateTime=%s", m.UniqueKey, state.updateTime.String())
+		return
+	}
+
+	frags := state.frags
+	if len(frags) > m.config.FragmentNum {
+		frags = frags[len(frags)-m.config.FragmentNum:]
+	}
+	m.frag = state.nextId - len(frags)
+	m.nfrags = len(frags)
+	for i := range frags {
+		*m.getFrag(i) = frags[i]
+	}
+	m.dvrFrags = state.dvrFrags
+	m.keyId = state.keyId
+	for _, fs := range [][]fragmentInfo{frags, m.dvrFrags} {
+		for i := range fs {
+			if fs[i].key != nil {
+				m.keyFiles[fs[i].key.filename] = struct{}{}
+			}
+		}
+	}
+
+	Log.Infof("[%s] hls resume. nextId=%d, frags=%d, dvrFrags=%d", m.UniqueKey, state.nextId, len(frags), len(m.dvrFrags))
+}
+
+// loadResumeState 从磁盘上已有的m3u8中恢复
+//
+func (m *Muxer) loadResumeState() *muxerResumeState {
+	if fslCtx.Type() != filesystemlayer.FslTypeDisk {
+		return nil
+	}
+	fi, err := os.Stat(m.playlistFilename)
+	if err != nil {
+		return nil
+	}
+	content, err := fslCtx.ReadFile(m.playlistFilename)
+	if err != nil {
+		return nil
+	}
+	_, mediaSeq, frags := parseM3u8Fragments(content)
+	state := &muxerResumeState{
+		updateTime: fi.ModTime(),
+		nextId:     mediaSeq + len(frags),
+		frags:      toFragmentInfos(mediaSeq, frags),
+	}
+
+	if m.config.DvrWindowMs > 0 {
+		if content, err = fslCtx.ReadFile(m.dvrPlaylistFilename); err == nil {
+			_, mediaSeq, frags = parseM3u8Fragments(content)
+			state.dvrFrags = toFragmentInfos(mediaSeq, frags)
+		}
+	}
+	return state
+}
+
+func toFragmentInfos(mediaSeq int, frags []m3u8Fragment) []fragmentInfo {
+	out := make([]fragmentInfo, len(frags))
+	keys := make(map[string]*fragmentKey)
+	for i := range frags {
+		out[i] = fragmentInfo{
+			id:              mediaSeq + i,
+			duration:        frags[i].duration,
+			discont:         frags[i].discont,
+			filename:        frags[i].uri,
+			initFilename:    parseTagAttr(frags[i].initLine, "URI"),
+			programDateTime: frags[i].dateTime,
+		}
+		if frags[i].key != "" {
+			// 使用相同密钥的fragment共用同一个对象，写m3u8时才不会重复写`#EXT-X-KEY`
+			key, ok := keys[frags[i].key]
+			if !ok {
+				key = &fragmentKey{
+					method:   parseTagAttr(frags[i].key, "METHOD"),
+					filename: parseTagAttr(frags[i].key, "URI"),
+				}
+				key.iv, _ = hex.DecodeString(strings.TrimPrefix(parseTagAttr(frags[i].key, "IV"), "0x"))
+				keys[frags[i].key] = key
+			}
+			out[i].key = key
+		}
+	}
+	return out
+}
+
+// parseTagAttr 获取m3u8标签中的属性值，比如从`#EXT-X-MAP:URI="a.mp4"`中获取URI的值
+//
+func parseTagAttr(line string, name string) string {
+	i := strings.IndexByte(line, ':')
+	if i == -1 {
+		return ""
+	}
+	for _, attr := range strings.Split(line[i+1:], ",") {
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) == 2 && kv[0] == name {
+			return strings.Trim(kv[1], "\"")
+		}
+	}
+	return ""
+}
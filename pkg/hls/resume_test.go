@@ -0,0 +1,112 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func feedKeyFrames(m *hls.Muxer, n int) {
+	for i := 0; i < n; i++ {
+		frame := &mpegts.Frame{
+			Pts: uint64(i) * 90000,
+			Dts: uint64(i) * 90000,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: true,
+			Raw: []byte{0, 0, 0, 1, 0x65, 0x88, byte(i)},
+		}
+		m.FeedMpegts(frame.Pack(), frame, true)
+	}
+}
+
+func TestMuxerResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_resume")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	config := &hls.MuxerConfig{
+		OutPath:            dir,
+		FragmentDurationMs: 1000,
+		FragmentNum:        3,
+		DeleteThreshold:    3,
+		CleanupMode:        hls.CleanupModeNever,
+		ReconnectGraceMs:   60000,
+	}
+	playlistFilename := filepath.Join(dir, "test110", "playlist.m3u8")
+
+	// 第一次推流，生成0~4号分片
+	m := hls.NewMuxer("test110", config, &mockMuxerObserver{})
+	m.Start()
+	m.FeedPatPmt(mpegts.FixedFragmentHeader)
+	feedKeyFrames(m, 5)
+	m.Dispose()
+
+	content, err := ioutil.ReadFile(playlistFilename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, strings.Contains(string(content), "#EXT-X-ENDLIST"))
+	assert.Equal(t, true, strings.Contains(string(content), "#EXT-X-MEDIA-SEQUENCE:2\n"))
+	before := listFragments(string(content))
+	assert.Equal(t, 3, len(before))
+
+	// 宽限期内重新推流，从内存中恢复
+	m = hls.NewMuxer("test110", config, &mockMuxerObserver{})
+	m.Start()
+	m.FeedPatPmt(mpegts.FixedFragmentHeader)
+	feedKeyFrames(m, 2)
+
+	content, err = ioutil.ReadFile(playlistFilename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(content), "#EXT-X-MEDIA-SEQUENCE:3\n"))
+	after := listFragments(string(content))
+	assert.Equal(t, before[1:], after[:2])
+	assert.Equal(t, true, strings.HasSuffix(after[2], "-5.ts"))
+	assert.Equal(t, true, strings.Contains(string(content), "#EXT-X-DISCONTINUITY\n"))
+	m.Dispose()
+
+	// 从磁盘上的m3u8恢复
+	config.OutPath, err = ioutil.TempDir("", "lal_hls_resume")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(config.OutPath)
+	assert.Equal(t, nil, os.MkdirAll(filepath.Join(config.OutPath, "test110"), 0777))
+	assert.Equal(t, nil, ioutil.WriteFile(filepath.Join(config.OutPath, "test110", "playlist.m3u8"), content, 0666))
+
+	m = hls.NewMuxer("test110", config, &mockMuxerObserver{})
+	m.Start()
+	m.FeedPatPmt(mpegts.FixedFragmentHeader)
+	feedKeyFrames(m, 2)
+	m.Dispose()
+
+	content, err = ioutil.ReadFile(filepath.Join(config.OutPath, "test110", "playlist.m3u8"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(content), "#EXT-X-MEDIA-SEQUENCE:5\n"))
+	frags := listFragments(string(content))
+	assert.Equal(t, true, strings.HasSuffix(frags[2], "-7.ts"))
+
+	// 没有开启宽限期时，从0开始
+	config.ReconnectGraceMs = 0
+	m = hls.NewMuxer("test110", config, &mockMuxerObserver{})
+	m.Start()
+	m.FeedPatPmt(mpegts.FixedFragmentHeader)
+	feedKeyFrames(m, 2)
+	m.Dispose()
+
+	content, err = ioutil.ReadFile(filepath.Join(config.OutPath, "test110", "playlist.m3u8"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.Contains(string(content), "#EXT-X-MEDIA-SEQUENCE:0\n"))
+	assert.Equal(t, true, strings.HasSuffix(string(content), "#EXT-X-ENDLIST\n"))
+}
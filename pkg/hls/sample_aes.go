@@ -0,0 +1,165 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+
+	"github.com/q191201771/lal/pkg/aac"
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/mpegts"
+)
+
+// 参考文档：
+// Apple MPEG-2 Stream Encryption Format for HTTP Live Streaming
+//
+// SAMPLE-AES只加密音视频帧中的部分数据，ts的封装层（PES头等）依然是明文：
+//
+// - H264: 只加密类型为1和5、长度大于48字节的nal。nal的前32字节为明文，之后每160字节中，第一个16字节的块加密，剩余9个块为明文，
+//         末尾不足16字节的部分为明文。加密前去除防竞争字节，加密后重新插入
+// - AAC:  每个ADTS帧，ADTS头以及之后的16字节为明文，之后所有完整的16字节块加密，末尾不足16字节的部分为明文
+//
+// 每个nal和每个ADTS帧都重新使用密钥对应的IV开始CBC加密。
+// 另外，PMT中的stream_type需要替换为加密类型，并增加对应的描述子
+//
+
+const (
+	streamTypeSampleAesAvc uint8 = 0xdb
+	streamTypeSampleAesAac uint8 = 0xcf
+
+	sampleAesAvcClearLeader  = 32
+	sampleAesAvcMinNalLength = 48
+	sampleAesAvcClearBlocks  = 9
+	sampleAesAacClearLeader  = 16
+)
+
+type sampleAesEncrypter struct {
+	block cipher.Block
+	iv    []byte
+}
+
+func newSampleAesEncrypter(key []byte, iv []byte) (*sampleAesEncrypter, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &sampleAesEncrypter{
+		block: block,
+		iv:    iv,
+	}, nil
+}
+
+// encryptAvc
+//
+// @param annexb: 函数调用结束后，内部不持有该内存块
+//
+// @return 返回的内存块为内部独立新申请，格式为Annexb，start code统一为4字节
+//
+func (e *sampleAesEncrypter) encryptAvc(annexb []byte) []byte {
+	out := make([]byte, 0, len(annexb)+64)
+	_ = avc.IterateNaluAnnexb(annexb, func(nal []byte) {
+		out = append(out, avc.NaluStartCode4...)
+
+		t := avc.ParseNaluType(nal[0])
+		if (t != avc.NaluTypeSlice && t != avc.NaluTypeIdrSlice) || len(nal) <= sampleAesAvcMinNalLength {
+			out = append(out, nal...)
+			return
+		}
+
+		rbsp := avc.Nal2Rbsp(nal)
+		mode := cipher.NewCBCEncrypter(e.block, e.iv)
+		for pos := sampleAesAvcClearLeader; pos+aes.BlockSize <= len(rbsp); pos += aes.BlockSize * (1 + sampleAesAvcClearBlocks) {
+			mode.CryptBlocks(rbsp[pos:pos+aes.BlockSize], rbsp[pos:pos+aes.BlockSize])
+		}
+		encrypted := avc.Rbsp2Nal(rbsp)
+		// nal的最后一个字节不能为0
+		if encrypted[len(encrypted)-1] == 0x00 {
+			encrypted = append(encrypted, 0x03)
+		}
+		out = append(out, encrypted...)
+	})
+	return out
+}
+
+// encryptAdts
+//
+// @param adts: 一个或多个ADTS帧。函数调用结束后，内部不持有该内存块
+//
+// @return 返回的内存块为内部独立新申请
+//
+func (e *sampleAesEncrypter) encryptAdts(adts []byte) []byte {
+	out := make([]byte, len(adts))
+	copy(out, adts)
+
+	for pos := 0; pos+aac.AdtsHeaderLength <= len(out); {
+		ctx, err := aac.NewAdtsHeaderContext(out[pos:])
+		if err != nil || ctx.AdtsLength < aac.AdtsHeaderLength || pos+int(ctx.AdtsLength) > len(out) {
+			break
+		}
+		frame := out[pos : pos+int(ctx.AdtsLength)]
+		pos += int(ctx.AdtsLength)
+
+		headerLength := aac.AdtsHeaderLength
+		if frame[1]&0x01 == 0 {
+			// protection_absent为0时，ADTS头后面跟着2字节的crc
+			headerLength += 2
+		}
+		payload := frame[headerLength:]
+		if len(payload) < sampleAesAacClearLeader+aes.BlockSize {
+			continue
+		}
+		payload = payload[sampleAesAacClearLeader:]
+		n := len(payload) / aes.BlockSize * aes.BlockSize
+		cipher.NewCBCEncrypter(e.block, e.iv).CryptBlocks(payload[:n], payload[:n])
+	}
+	return out
+}
+
+// buildSampleAesPatPmt 生成SAMPLE-AES使用的PAT和PMT，pid与 mpegts.FixedFragmentHeader 相同
+//
+// @param asc: AAC的AudioSpecificConfig，写入PMT中音频的audio_setup_information。为nil时不写入setup_data
+//
+func buildSampleAesPatPmt(asc []byte) []byte {
+	const tsPacketSize = 188
+
+	out := make([]byte, tsPacketSize*2)
+	copy(out, mpegts.FixedFragmentHeader[:tsPacketSize])
+
+	// 视频: private_data_indicator_descriptor('zavc')
+	videoDesc := []byte{0x0f, 4, 'z', 'a', 'v', 'c'}
+
+	// 音频: private_data_indicator_descriptor('aacd') + registration_descriptor('apad', audio_setup_information)
+	setupInfo := []byte{'z', 'a', 'a', 'c', 0x00, 0x00, 0x01, uint8(len(asc))}
+	setupInfo = append(setupInfo, asc...)
+	audioDesc := []byte{0x0f, 4, 'a', 'a', 'c', 'd', 0x05, uint8(4 + len(setupInfo)), 'a', 'p', 'a', 'd'}
+	audioDesc = append(audioDesc, setupInfo...)
+
+	var section []byte
+	section = append(section, 0x02, 0, 0, 0x00, 0x01, 0xc1, 0x00, 0x00)
+	section = append(section, 0xe1, 0x00) // PCR_PID 256
+	section = append(section, 0xf0, 0x00) // program_info_length
+	section = append(section, streamTypeSampleAesAvc, 0xe1, 0x00, 0xf0, uint8(len(videoDesc)))
+	section = append(section, videoDesc...)
+	section = append(section, streamTypeSampleAesAac, 0xe1, 0x01, 0xf0, uint8(len(audioDesc)))
+	section = append(section, audioDesc...)
+	sectionLength := len(section) - 3 + 4
+	section[1] = 0xb0 | uint8(sectionLength>>8)
+	section[2] = uint8(sectionLength)
+	crc := mpegts.Crc32Mpeg2(section)
+	section = append(section, uint8(crc>>24), uint8(crc>>16), uint8(crc>>8), uint8(crc))
+
+	pmt := out[tsPacketSize:]
+	copy(pmt, []byte{0x47, 0x50, 0x01, 0x10, 0x00})
+	copy(pmt[5:], section)
+	for i := 5 + len(section); i < tsPacketSize; i++ {
+		pmt[i] = 0xff
+	}
+	return out
+}
@@ -0,0 +1,335 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 内存分片存储
+//
+// 开启后（见 SetSegmentStore ），ts/m4s分片不再写入文件，而是写入内存，m3u8等其他文件不受影响：
+// - 分片按流名称和序号组织，所有流共享一个内存上限，超过上限时淘汰最早完成的分片
+// - 分片在写入过程中就可以被请求，请求方会持续收到新写入的数据直到分片结束（chunked）
+// - 已完成的分片支持Range请求，并携带ETag、Last-Modified以及较长的Cache-Control，方便CDN缓存
+// - 分片数据只追加不修改，所以读取时直接使用底层内存块，不拷贝
+//
+// 注意，内存中的分片在进程重启后丢失，并且record m3u8中被淘汰的分片将无法访问
+//
+
+// segmentStoreCacheControl 分片完成后内容不再变化
+const segmentStoreCacheControl = "public, max-age=86400, immutable"
+
+// memSegmentWaitTimeout 请求正在写入的分片时，等待新数据的超时时间
+var memSegmentWaitTimeout = 10 * time.Second
+
+var segmentStore *SegmentStore
+
+// SetSegmentStore 设置内存分片存储，nil表示分片写入文件，默认为nil
+//
+// 注意，需要在创建 Muxer 之前调用
+//
+func SetSegmentStore(s *SegmentStore) {
+	segmentStore = s
+}
+
+type SegmentStore struct {
+	maxBytes int64
+
+	mutex     sync.Mutex
+	usedBytes int64
+	streams   map[string]*segmentStoreStream // key: 流名称
+	finished  *list.List                     // 已完成的分片，按完成时间排列，用于淘汰
+}
+
+type segmentStoreStream struct {
+	outPath string
+	bySeq   map[int]*memSegment
+	byName  map[string]*memSegment
+}
+
+// NewSegmentStore
+//
+// @param maxBytes: 所有分片占用内存的上限
+//
+func NewSegmentStore(maxBytes int64) *SegmentStore {
+	return &SegmentStore{
+		maxBytes: maxBytes,
+		streams:  make(map[string]*segmentStoreStream),
+		finished: list.New(),
+	}
+}
+
+// UsedBytes 所有分片占用的内存
+//
+func (s *SegmentStore) UsedBytes() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.usedBytes
+}
+
+// GetSegment 获取分片数据
+//
+// @param seq: 分片序号，与m3u8中的media sequence对应
+//
+// @return b:    const只读内存块，分片还在写入时为已写入的部分
+// @return done: 分片是否已经写入完成
+//
+func (s *SegmentStore) GetSegment(streamName string, seq int) (b []byte, done bool, exist bool) {
+	s.mutex.Lock()
+	stream, ok := s.streams[streamName]
+	var seg *memSegment
+	if ok {
+		seg = stream.bySeq[seq]
+	}
+	s.mutex.Unlock()
+	if seg == nil {
+		return nil, false, false
+	}
+	b, done, _ = seg.snapshot()
+	return b, done, true
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (s *SegmentStore) create(streamName string, outPath string, seq int, filename string) *memSegment {
+	seg := &memSegment{
+		store:      s,
+		streamName: streamName,
+		seq:        seq,
+		filename:   filename,
+		notify:     make(chan struct{}),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stream, ok := s.streams[streamName]
+	if !ok {
+		stream = &segmentStoreStream{
+			bySeq:  make(map[int]*memSegment),
+			byName: make(map[string]*memSegment),
+		}
+		s.streams[streamName] = stream
+	}
+	stream.outPath = outPath
+	if old, ok := stream.bySeq[seq]; ok {
+		s.removeLocked(stream, old)
+	}
+	stream.bySeq[seq] = seg
+	stream.byName[filename] = seg
+	return seg
+}
+
+func (s *SegmentStore) get(streamName string, filename string) *memSegment {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stream, ok := s.streams[streamName]
+	if !ok {
+		return nil
+	}
+	return stream.byName[filename]
+}
+
+func (s *SegmentStore) remove(streamName string, filename string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stream, ok := s.streams[streamName]
+	if !ok {
+		return
+	}
+	if seg, ok := stream.byName[filename]; ok {
+		s.removeLocked(stream, seg)
+	}
+}
+
+// removeStreamByPath 删除hls文件目录为outPath的流的所有分片
+//
+func (s *SegmentStore) removeStreamByPath(outPath string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, stream := range s.streams {
+		if stream.outPath != outPath {
+			continue
+		}
+		for _, seg := range stream.byName {
+			s.removeLocked(stream, seg)
+		}
+	}
+}
+
+func (s *SegmentStore) onWrite(seg *memSegment, n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if seg.removed {
+		return
+	}
+	s.usedBytes += int64(n)
+	s.evictLocked()
+}
+
+func (s *SegmentStore) onFinish(seg *memSegment) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if seg.removed {
+		return
+	}
+	seg.elem = s.finished.PushBack(seg)
+	s.evictLocked()
+}
+
+// evictLocked 超过内存上限时，淘汰最早完成的分片，正在写入的分片不淘汰
+//
+func (s *SegmentStore) evictLocked() {
+	for s.usedBytes > s.maxBytes && s.finished.Len() > 0 {
+		seg := s.finished.Front().Value.(*memSegment)
+		Log.Debugf("evict hls segment from memory. stream=%s, filename=%s", seg.streamName, seg.filename)
+		if stream, ok := s.streams[seg.streamName]; ok {
+			s.removeLocked(stream, seg)
+		} else {
+			s.finished.Remove(seg.elem)
+			seg.elem = nil
+		}
+	}
+}
+
+func (s *SegmentStore) removeLocked(stream *segmentStoreStream, seg *memSegment) {
+	if stream.bySeq[seg.seq] == seg {
+		delete(stream.bySeq, seg.seq)
+	}
+	if stream.byName[seg.filename] == seg {
+		delete(stream.byName, seg.filename)
+	}
+	if len(stream.byName) == 0 {
+		delete(s.streams, seg.streamName)
+	}
+	if seg.elem != nil {
+		s.finished.Remove(seg.elem)
+		seg.elem = nil
+	}
+	if !seg.removed {
+		seg.removed = true
+		s.usedBytes -= int64(seg.size())
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type memSegment struct {
+	store      *SegmentStore
+	streamName string
+	seq        int
+	filename   string
+
+	// 以下两个字段由 SegmentStore.mutex 保护
+	elem    *list.Element
+	removed bool
+
+	mutex   sync.Mutex
+	data    []byte
+	done    bool
+	notify  chan struct{} // 有新数据写入或者分片完成时关闭，并替换为新的chan
+	modTime time.Time
+	etag    string
+}
+
+func (seg *memSegment) write(b []byte) {
+	seg.mutex.Lock()
+	seg.data = append(seg.data, b...)
+	close(seg.notify)
+	seg.notify = make(chan struct{})
+	seg.mutex.Unlock()
+
+	seg.store.onWrite(seg, len(b))
+}
+
+func (seg *memSegment) finish() {
+	seg.mutex.Lock()
+	seg.done = true
+	seg.modTime = Clock.Now()
+	seg.etag = fmt.Sprintf(`"%x-%x-%x"`, seg.seq, seg.modTime.UnixNano(), len(seg.data))
+	close(seg.notify)
+	seg.notify = make(chan struct{})
+	seg.mutex.Unlock()
+
+	seg.store.onFinish(seg)
+}
+
+// snapshot
+//
+// @return b:      已写入的数据，后续写入不会修改这部分内存
+// @return notify: 有新数据写入或分片完成时关闭
+//
+func (seg *memSegment) snapshot() (b []byte, done bool, notify chan struct{}) {
+	seg.mutex.Lock()
+	defer seg.mutex.Unlock()
+	return seg.data, seg.done, seg.notify
+}
+
+func (seg *memSegment) size() int {
+	seg.mutex.Lock()
+	defer seg.mutex.Unlock()
+	return len(seg.data)
+}
+
+// serveMemSegment
+//
+// @param req: 为nil时不支持Range以及条件请求
+//
+func serveMemSegment(resp http.ResponseWriter, req *http.Request, filetype string, seg *memSegment) {
+	b, done, notify := seg.snapshot()
+	if done {
+		seg.mutex.Lock()
+		etag, modTime := seg.etag, seg.modTime
+		seg.mutex.Unlock()
+
+		setHlsContentHeader(resp, filetype)
+		resp.Header().Set("Cache-Control", segmentStoreCacheControl)
+		resp.Header().Set("ETag", etag)
+		if req == nil {
+			resp.Header().Set("Content-Length", strconv.Itoa(len(b)))
+			_, _ = resp.Write(b)
+			return
+		}
+		http.ServeContent(resp, req, seg.filename, modTime, bytes.NewReader(b))
+		return
+	}
+
+	// 分片正在写入，边写边发送
+	setHlsContentHeader(resp, filetype)
+	resp.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := resp.(http.Flusher)
+	var pos int
+	for {
+		if len(b) > pos {
+			if _, err := resp.Write(b[pos:]); err != nil {
+				return
+			}
+			pos = len(b)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if done {
+			return
+		}
+		select {
+		case <-notify:
+		case <-time.After(memSegmentWaitTimeout):
+			Log.Warnf("wait hls segment in memory timeout. stream=%s, filename=%s", seg.streamName, seg.filename)
+			return
+		}
+		b, done, notify = seg.snapshot()
+	}
+}
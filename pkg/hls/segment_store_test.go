@@ -0,0 +1,144 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestSegmentStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_store")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	// 每个分片为PAT+PMT+一个视频帧，共3个ts packet
+	store := hls.NewSegmentStore(188 * 3 * 4)
+	hls.SetSegmentStore(store)
+	defer hls.SetSegmentStore(nil)
+
+	config := &hls.MuxerConfig{
+		OutPath:            dir,
+		FragmentDurationMs: 1000,
+		FragmentNum:        3,
+		DeleteThreshold:    3,
+		CleanupMode:        hls.CleanupModeNever,
+	}
+	observer := &mockMuxerObserver{}
+	m := hls.NewMuxer("test110", config, observer)
+	m.Start()
+	m.FeedPatPmt(mpegts.FixedFragmentHeader)
+	feed := func(i uint64) {
+		frame := &mpegts.Frame{
+			Pts: i * 90000,
+			Dts: i * 90000,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: true,
+			Raw: []byte{0, 0, 0, 1, 0x65, 0x88, byte(i)},
+		}
+		m.FeedMpegts(frame.Pack(), frame, true)
+	}
+	for i := uint64(0); i < 6; i++ {
+		feed(i)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "test110", "record.m3u8"))
+	assert.Equal(t, nil, err)
+	frags := listFragments(string(content))
+	assert.Equal(t, 5, len(frags))
+
+	// 分片不写入文件
+	_, err = os.Stat(filepath.Join(dir, "test110", frags[4]))
+	assert.Equal(t, true, os.IsNotExist(err))
+
+	// 超过内存上限，最早的分片被淘汰，正在写入的分片不淘汰
+	assert.Equal(t, int64(188*3*4), store.UsedBytes())
+	_, _, exist := store.GetSegment("test110", 1)
+	assert.Equal(t, false, exist)
+	b, done, exist := store.GetSegment("test110", 4)
+	assert.Equal(t, true, exist)
+	assert.Equal(t, true, done)
+	assert.Equal(t, 188*3, len(b))
+	_, done, exist = store.GetSegment("test110", 5)
+	assert.Equal(t, true, exist)
+	assert.Equal(t, false, done)
+
+	handler := hls.NewServerHandler(dir)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/hls/test110/"+frags[0], nil))
+	assert.Equal(t, 404, resp.Code)
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/hls/test110/"+frags[4], nil))
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, b, resp.Body.Bytes())
+	assert.Equal(t, "video/mp2t", resp.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=86400, immutable", resp.Header().Get("Cache-Control"))
+	etag := resp.Header().Get("ETag")
+	assert.Equal(t, true, etag != "")
+
+	// Range
+	req := httptest.NewRequest("GET", "/hls/test110/"+frags[4], nil)
+	req.Header.Set("Range", "bytes=188-375")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, 206, resp.Code)
+	assert.Equal(t, b[188:376], resp.Body.Bytes())
+
+	// 条件请求
+	req = httptest.NewRequest("GET", "/hls/test110/"+frags[4], nil)
+	req.Header.Set("If-None-Match", etag)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, 304, resp.Code)
+
+	// 正在写入的分片，边写边发送，直到分片结束
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	filename := filepath.Base(observer.infos[len(observer.infos)-1].TsFile)
+	httpResp, err := http.Get(server.URL + "/hls/test110/" + filename)
+	assert.Equal(t, nil, err)
+	defer httpResp.Body.Close()
+	assert.Equal(t, "no-cache", httpResp.Header.Get("Cache-Control"))
+	b, _, _ = store.GetSegment("test110", 5)
+	buf := make([]byte, len(b))
+	_, err = io.ReadFull(httpResp.Body, buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, b, buf)
+
+	frame := &mpegts.Frame{
+		Pts: 5*90000 + 3600,
+		Dts: 5*90000 + 3600,
+		Pid: mpegts.PidVideo,
+		Sid: mpegts.StreamIdVideo,
+		Raw: []byte{0, 0, 0, 1, 0x41, 0x88},
+	}
+	m.FeedMpegts(frame.Pack(), frame, false)
+	m.Dispose()
+	rest, err := ioutil.ReadAll(httpResp.Body)
+	assert.Equal(t, nil, err)
+	b, done, _ = store.GetSegment("test110", 5)
+	assert.Equal(t, true, done)
+	assert.Equal(t, b, append(buf, rest...))
+	assert.Equal(t, 188*4, len(b))
+
+	// 清理
+	assert.Equal(t, nil, hls.RemoveAll(filepath.Join(dir, "test110")))
+	assert.Equal(t, int64(0), store.UsedBytes())
+}
@@ -9,13 +9,20 @@
 package hls
 
 import (
+	"bytes"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/q191201771/lal/pkg/base"
 )
 
 type ServerHandler struct {
-	outPath string
+	outPath         string
+	variantProvider IVariantProvider
 }
 
 func NewServerHandler(outPath string) *ServerHandler {
@@ -24,16 +31,31 @@ func NewServerHandler(outPath string) *ServerHandler {
 	}
 }
 
+// WithVariantProvider 设置多码率分组的信息提供方，设置后，分组名称对应的m3u8请求返回多码率的master playlist
+//
+func (s *ServerHandler) WithVariantProvider(p IVariantProvider) *ServerHandler {
+	s.variantProvider = p
+	return s
+}
+
 func (s *ServerHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	urlCtx, err := base.ParseUrl(base.ParseHttpRequest(req), 80)
 	if err != nil {
 		Log.Errorf("parse url. err=%+v", err)
 		return
 	}
-	s.ServeHTTPWithUrlCtx(resp, urlCtx)
+	s.serve(resp, req, urlCtx)
 }
 
 func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, urlCtx base.UrlContext) {
+	s.serve(resp, nil, urlCtx)
+}
+
+// serve
+//
+// @param req: 为nil时，内存分片存储中的分片不支持Range以及条件请求
+//
+func (s *ServerHandler) serve(resp http.ResponseWriter, req *http.Request, urlCtx base.UrlContext) {
 	//Log.Debugf("%+v", req)
 
 	// TODO chef:
@@ -45,12 +67,43 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, urlCtx bas
 	ri := PathStrategy.GetRequestInfo(urlCtx, s.outPath)
 	//Log.Debugf("%+v", ri)
 
-	if filename == "" || (filetype != "m3u8" && filetype != "ts") || ri.StreamName == "" || ri.FileNameWithPath == "" {
+	if filename == "" || (filetype != "m3u8" && filetype != "ts" && filetype != "m4s" && filetype != "mp4" && filetype != "key") || ri.StreamName == "" || ri.FileNameWithPath == "" {
 		Log.Warnf("invalid hls request. url=%+v, request=%+v", urlCtx, ri)
 		resp.WriteHeader(404)
 		return
 	}
 
+	if filetype == "m3u8" && s.serveVariantMasterPlaylistIfNeeded(resp, urlCtx) {
+		return
+	}
+
+	// 开启了LL-HLS的流，live m3u8和partial segment从内存中读取
+	if ll := getLlHlsStream(filepath.Dir(ri.FileNameWithPath)); ll != nil {
+		if filetype == "m3u8" && ri.FileNameWithPath == ll.playlistFilename {
+			s.serveLlHlsPlaylist(resp, urlCtx, ll)
+			return
+		}
+		if filetype == "m4s" {
+			var timeout time.Duration
+			if p := ll.getPlaylist(); p != nil {
+				timeout = p.blockTimeout()
+			}
+			if content, ok := ll.getPart(filepath.Base(ri.FileNameWithPath), timeout); ok {
+				writeHlsResponse(resp, filetype, content)
+				return
+			}
+			// 内存中没有，可能是完整的segment，继续从文件中读取
+		}
+	}
+
+	// 开启了内存分片存储时，分片从内存中读取
+	if segmentStore != nil && (filetype == "ts" || filetype == "m4s") {
+		if seg := segmentStore.get(ri.StreamName, filepath.Base(ri.FileNameWithPath)); seg != nil {
+			serveMemSegment(resp, req, filetype, seg)
+			return
+		}
+	}
+
 	content, err := ReadFile(ri.FileNameWithPath)
 	if err != nil {
 		Log.Warnf("read hls file failed. request=%+v, err=%+v", ri, err)
@@ -58,6 +111,157 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, urlCtx bas
 		return
 	}
 
+	if filetype == "m3u8" {
+		if content, err = clipPlaylistIfNeeded(content, urlCtx.RawQuery); err != nil {
+			Log.Warnf("clip hls playlist failed. request=%+v, err=%+v", ri, err)
+			resp.WriteHeader(httpStatusOfClipError(err))
+			return
+		}
+		content = appendQueryToKeyUri(content, urlCtx.RawQuery)
+		content = appendSubSessionKeyToUri(content, urlCtx.RawQuery)
+	}
+	writeHlsResponse(resp, filetype, content)
+}
+
+// appendQueryToKeyUri 将m3u8请求的参数追加到`#EXT-X-KEY`的URI后面
+//
+// 播放器请求key时不会自动携带m3u8的参数，追加后，key的请求可以使用与m3u8相同的鉴权参数
+//
+func appendQueryToKeyUri(content []byte, rawQuery string) []byte {
+	if rawQuery == "" || !bytes.Contains(content, []byte("#EXT-X-KEY:")) {
+		return content
+	}
+	return bytes.ReplaceAll(content, []byte(".key\""), []byte(".key?"+rawQuery+"\""))
+}
+
+// clipPlaylistIfNeeded 请求携带`start`或`end`参数时，按时间范围截取m3u8，见 BuildVodClipPlaylist
+//
+func clipPlaylistIfNeeded(content []byte, rawQuery string) ([]byte, error) {
+	if rawQuery == "" {
+		return content, nil
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, errVodClipBadParam
+	}
+	start := query.Get(VodClipParamStart)
+	end := query.Get(VodClipParamEnd)
+	if start == "" && end == "" {
+		return content, nil
+	}
+	return BuildVodClipPlaylist(content, start, end)
+}
+
+// serveVariantMasterPlaylistIfNeeded
+//
+// 请求的名称为多码率分组时，返回master playlist，支持以下两种uri：
+//
+// /hls/{group}/master.m3u8
+// /hls/{group}.m3u8
+//
+// @return 是否已经处理了该请求
+//
+func (s *ServerHandler) serveVariantMasterPlaylistIfNeeded(resp http.ResponseWriter, urlCtx base.UrlContext) bool {
+	if s.variantProvider == nil {
+		return false
+	}
+
+	var name, uriPrefix string
+	if urlCtx.LastItemOfPath == masterM3u8FileName {
+		uriItems := strings.Split(urlCtx.Path, "/")
+		if len(uriItems) < 3 {
+			return false
+		}
+		name = uriItems[len(uriItems)-2]
+		uriPrefix = strings.TrimSuffix(urlCtx.Path, name+"/"+masterM3u8FileName)
+	} else {
+		name = urlCtx.GetFilenameWithoutType()
+		uriPrefix = strings.TrimSuffix(urlCtx.Path, urlCtx.LastItemOfPath)
+	}
+	if name == "" {
+		return false
+	}
+
+	streams, exist := s.variantProvider.GetHlsVariantStreams(name)
+	if !exist {
+		return false
+	}
+	if len(streams) == 0 {
+		Log.Warnf("no live stream in hls variant group. name=%s", name)
+		resp.WriteHeader(404)
+		return true
+	}
+
+	writeHlsResponse(resp, "m3u8", BuildVariantMasterPlaylist(streams, uriPrefix, urlCtx.RawQuery))
+	return true
+}
+
+// serveLlHlsPlaylist
+//
+// 支持以下url参数：
+//
+// - _HLS_msn, _HLS_part: blocking playlist reload，等待playlist中包含指定的segment和partial segment后再返回
+// - _HLS_skip:           delta update，值为YES或v2时，使用`EXT-X-SKIP`替换较早的segment
+//
+func (s *ServerHandler) serveLlHlsPlaylist(resp http.ResponseWriter, urlCtx base.UrlContext, ll *llHlsStream) {
+	query, err := url.ParseQuery(urlCtx.RawQuery)
+	if err != nil {
+		resp.WriteHeader(400)
+		return
+	}
+	skip := query.Get("_HLS_skip") == "YES" || query.Get("_HLS_skip") == "v2"
+
+	playlist := ll.getPlaylist()
+	if playlist == nil {
+		resp.WriteHeader(404)
+		return
+	}
+
+	msnStr := query.Get("_HLS_msn")
+	partStr := query.Get("_HLS_part")
+	if msnStr == "" {
+		if partStr != "" {
+			resp.WriteHeader(400)
+			return
+		}
+		writeHlsResponse(resp, "m3u8", playlist.render(skip))
+		return
+	}
+
+	msn, err := strconv.Atoi(msnStr)
+	if err != nil || msn < 0 {
+		resp.WriteHeader(400)
+		return
+	}
+	part := -1
+	if partStr != "" {
+		if part, err = strconv.Atoi(partStr); err != nil || part < 0 {
+			resp.WriteHeader(400)
+			return
+		}
+	}
+	// 请求的segment超出当前最新的segment太多
+	if msn > playlist.lastMsn()+2 {
+		resp.WriteHeader(400)
+		return
+	}
+
+	playlist = ll.waitPlaylist(msn, part, playlist.blockTimeout())
+	if playlist == nil {
+		resp.WriteHeader(503)
+		return
+	}
+	writeHlsResponse(resp, "m3u8", playlist.render(skip))
+}
+
+func writeHlsResponse(resp http.ResponseWriter, filetype string, content []byte) {
+	setHlsContentHeader(resp, filetype)
+	resp.Header().Add("Cache-Control", "no-cache")
+
+	_, _ = resp.Write(content)
+}
+
+func setHlsContentHeader(resp http.ResponseWriter, filetype string) {
 	switch filetype {
 	case "m3u8":
 		resp.Header().Add("Content-Type", "application/x-mpegurl")
@@ -65,12 +269,17 @@ func (s *ServerHandler) ServeHTTPWithUrlCtx(resp http.ResponseWriter, urlCtx bas
 	case "ts":
 		resp.Header().Add("Content-Type", "video/mp2t")
 		resp.Header().Add("Server", base.LalHlsTsServer)
+	case "m4s":
+		resp.Header().Add("Content-Type", "video/iso.segment")
+		resp.Header().Add("Server", base.LalHlsTsServer)
+	case "mp4":
+		resp.Header().Add("Content-Type", "video/mp4")
+		resp.Header().Add("Server", base.LalHlsTsServer)
+	case "key":
+		resp.Header().Add("Content-Type", "application/octet-stream")
+		resp.Header().Add("Server", base.LalHlsTsServer)
 	}
-	resp.Header().Add("Cache-Control", "no-cache")
 	resp.Header().Add("Access-Control-Allow-Origin", "*")
-
-	_, _ = resp.Write(content)
-	return
 }
 
 // m3u8文件用这个也行
@@ -0,0 +1,234 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// hls播放者：
+//
+// hls是基于短连接的，每个播放者的多次http请求通过以下方式关联到同一个 SubSession ：
+//
+// 1. 请求参数中的`lal_hls_session`
+// 2. 名称为`lal_hls_session`的cookie
+// 3. 以上都没有时，使用客户端ip、User-Agent以及流名称生成
+//
+// 返回m3u8时，会将`lal_hls_session`参数追加到m3u8中各分片的URI后面，并设置cookie，使得后续的分片请求可以关联到同一个播放者。
+// 超过一定时间没有请求的播放者被视为已经离开
+//
+
+const SubSessionKeyName = "lal_hls_session"
+
+type SubSession struct {
+	key        string
+	urlCtx     base.UrlContext
+	streamName string
+	timeout    time.Duration
+
+	sessionStat base.BasicSessionStat
+
+	mutex          sync.Mutex
+	lastActiveTime time.Time
+	disposed       bool
+}
+
+// NewSubSession
+//
+// @param key:       播放者的标识，见 GetSubSessionKey
+// @param urlCtx:    该播放者的第一个m3u8请求
+// @param timeoutMs: 超过该时间没有请求时，IsAlive 返回false
+//
+func NewSubSession(key string, urlCtx base.UrlContext, streamName string, remoteAddr string, timeoutMs int) *SubSession {
+	s := &SubSession{
+		key:            key,
+		urlCtx:         urlCtx,
+		streamName:     streamName,
+		timeout:        time.Duration(timeoutMs) * time.Millisecond,
+		sessionStat:    base.NewBasicSessionStat(base.SessionTypeHlsSub, remoteAddr),
+		lastActiveTime: Clock.Now(),
+	}
+	Log.Infof("[%s] lifecycle new hls SubSession. session=%p, key=%s, remote addr=%s", s.UniqueKey(), s, key, remoteAddr)
+	return s
+}
+
+// Key 播放者的标识
+//
+func (session *SubSession) Key() string {
+	return session.key
+}
+
+// KeepAlive 收到该播放者的请求时调用
+//
+func (session *SubSession) KeepAlive() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.lastActiveTime = Clock.Now()
+}
+
+// Dispose 没有对应的连接需要关闭，只做标记，由上层从group中删除
+//
+func (session *SubSession) Dispose() error {
+	Log.Infof("[%s] lifecycle dispose hls SubSession.", session.UniqueKey())
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.disposed = true
+	return nil
+}
+
+func (session *SubSession) IsDisposed() bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.disposed
+}
+
+// WrapResponseWriter 统计写给该播放者的字节数
+//
+func (session *SubSession) WrapResponseWriter(w http.ResponseWriter) http.ResponseWriter {
+	return &subSessionResponseWriter{
+		ResponseWriter: w,
+		session:        session,
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+// IObject interface
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (session *SubSession) UniqueKey() string {
+	return session.sessionStat.UniqueKey()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+// ISessionUrlContext interface
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (session *SubSession) Url() string {
+	return session.urlCtx.Url
+}
+
+func (session *SubSession) AppName() string {
+	return ""
+}
+
+func (session *SubSession) StreamName() string {
+	return session.streamName
+}
+
+func (session *SubSession) RawQuery() string {
+	return session.urlCtx.RawQuery
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+// ISessionStat interface
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (session *SubSession) UpdateStat(intervalSec uint32) {
+	session.sessionStat.UpdateStat(intervalSec)
+}
+
+func (session *SubSession) GetStat() base.StatSession {
+	return session.sessionStat.GetStat()
+}
+
+// IsAlive 超过超时时间没有请求，或者已经被踢掉时，返回false
+//
+func (session *SubSession) IsAlive() (readAlive, writeAlive bool) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	alive := !session.disposed && Clock.Now().Sub(session.lastActiveTime) < session.timeout
+	return alive, alive
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// GetSubSessionKey 获取请求对应的播放者标识，见文件头部的说明
+//
+func GetSubSessionKey(req *http.Request, streamName string) string {
+	if v := req.URL.Query().Get(SubSessionKeyName); v != "" {
+		return v
+	}
+	if c, err := req.Cookie(SubSessionKeyName); err == nil && c.Value != "" {
+		return c.Value
+	}
+
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	sum := md5.Sum([]byte(ip + "|" + req.UserAgent() + "|" + streamName))
+	return hex.EncodeToString(sum[:8])
+}
+
+type subSessionResponseWriter struct {
+	http.ResponseWriter
+	session *SubSession
+}
+
+func (w *subSessionResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.session.sessionStat.AddWriteBytes(n)
+	return n, err
+}
+
+// appendSubSessionKeyToUri 将播放者标识追加到m3u8中分片以及`#EXT-X-MAP`的URI后面
+//
+// 注意，`#EXT-X-KEY`的URI已经追加了m3u8请求的所有参数，见 appendQueryToKeyUri
+//
+func appendSubSessionKeyToUri(content []byte, rawQuery string) []byte {
+	if rawQuery == "" {
+		return content
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return content
+	}
+	key := query.Get(SubSessionKeyName)
+	if key == "" {
+		return content
+	}
+	param := SubSessionKeyName + "=" + url.QueryEscape(key)
+
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		switch {
+		case line == "":
+		case line[0] != '#':
+			lines[i] = appendParamToUri(line, param)
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			l := strings.Index(line, "URI=\"")
+			if l == -1 {
+				continue
+			}
+			l += len("URI=\"")
+			r := strings.IndexByte(line[l:], '"')
+			if r == -1 {
+				continue
+			}
+			lines[i] = line[:l] + appendParamToUri(line[l:l+r], param) + line[l+r:]
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func appendParamToUri(uri string, param string) string {
+	if strings.Contains(uri, "?") {
+		return uri + "&" + param
+	}
+	return uri + "?" + param
+}
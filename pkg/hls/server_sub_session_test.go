@@ -0,0 +1,101 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/mock"
+)
+
+func TestSubSession(t *testing.T) {
+	hls.Clock = mock.NewFakeClock()
+	defer func() {
+		hls.Clock = mock.NewStdClock()
+	}()
+	hls.Clock.Set(time.Date(2022, 7, 1, 14, 3, 20, 0, time.UTC))
+
+	// 播放者标识
+	req := httptest.NewRequest("GET", "/hls/test110/playlist.m3u8", nil)
+	req.RemoteAddr = "127.0.0.1:12345"
+	req.Header.Set("User-Agent", "lal")
+	key := hls.GetSubSessionKey(req, "test110")
+	assert.Equal(t, 16, len(key))
+	req.RemoteAddr = "127.0.0.1:23456"
+	assert.Equal(t, key, hls.GetSubSessionKey(req, "test110"))
+	assert.Equal(t, false, key == hls.GetSubSessionKey(req, "test111"))
+
+	req.AddCookie(&http.Cookie{Name: hls.SubSessionKeyName, Value: "fromcookie"})
+	assert.Equal(t, "fromcookie", hls.GetSubSessionKey(req, "test110"))
+	req.URL.RawQuery = hls.SubSessionKeyName + "=fromquery"
+	assert.Equal(t, "fromquery", hls.GetSubSessionKey(req, "test110"))
+
+	// 超时
+	session := hls.NewSubSession("abc", base.UrlContext{}, "test110", "127.0.0.1:12345", 30000)
+	readAlive, writeAlive := session.IsAlive()
+	assert.Equal(t, true, readAlive)
+	assert.Equal(t, true, writeAlive)
+	hls.Clock.Add(20 * time.Second)
+	session.KeepAlive()
+	hls.Clock.Add(20 * time.Second)
+	_, writeAlive = session.IsAlive()
+	assert.Equal(t, true, writeAlive)
+	hls.Clock.Add(10 * time.Second)
+	_, writeAlive = session.IsAlive()
+	assert.Equal(t, false, writeAlive)
+
+	session = hls.NewSubSession("abc", base.UrlContext{}, "test110", "127.0.0.1:12345", 30000)
+	_ = session.Dispose()
+	_, writeAlive = session.IsAlive()
+	assert.Equal(t, false, writeAlive)
+
+	// 字节统计
+	resp := httptest.NewRecorder()
+	_, _ = session.WrapResponseWriter(resp).Write([]byte("hello"))
+	assert.Equal(t, uint64(5), session.GetStat().WroteBytesSum)
+	assert.Equal(t, base.SessionProtocolHlsStr, session.GetStat().Protocol)
+
+	// m3u8中的分片URI追加播放者标识
+	golden := `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:4.000,
+a-0.m4s
+#EXTINF:4.000,
+a-1.m4s?foo=bar
+`
+	dir, err := ioutil.TempDir("", "lal_hls_sub")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	assert.Equal(t, nil, os.MkdirAll(filepath.Join(dir, "test110"), 0777))
+	assert.Equal(t, nil, ioutil.WriteFile(filepath.Join(dir, "test110", "playlist.m3u8"), []byte(golden), 0666))
+
+	handler := hls.NewServerHandler(dir)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/hls/test110/playlist.m3u8?"+hls.SubSessionKeyName+"=abc", nil))
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, []string{"a-0.m4s?lal_hls_session=abc", "a-1.m4s?foo=bar&lal_hls_session=abc"}, listFragments(resp.Body.String()))
+	assert.Equal(t, true, strings.Contains(resp.Body.String(), `#EXT-X-MAP:URI="init.mp4?lal_hls_session=abc"`))
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/hls/test110/playlist.m3u8", nil))
+	assert.Equal(t, golden, resp.Body.String())
+}
@@ -0,0 +1,131 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 开启后（见 SetUploader ），分片、初始化分片以及m3u8在写入本地后，再异步上传一份，比如上传到对象存储：
+//
+// - 上传的是写入完成后的完整内容，ts/m4s分片在关闭时上传，m3u8每次更新时上传
+// - 本地删除过期分片时（ CleanupModeAsap ），同时删除对应的对象；流结束后清理整个目录时（ CleanupModeInTheEnd 、
+//   CleanupModeAsap ，见 RemoveAll ），同时删除该目录下所有已上传的对象。 CleanupModeNever 时都不删除
+// - 上传不减少本地的占用：本地（或者内存分片存储）依然按照原来的策略保留分片，比如 CleanupModeAsap 时保留直播窗口内的分片，
+//   另外，上传队列中还没有上传完成的内容占用内存，上限见 storage.UploaderConfig.QueueMaxMb
+// - 加密使用的key文件不上传
+//
+
+// IUploader 见 storage.Uploader
+//
+type IUploader interface {
+	// UploadContent
+	//
+	// @param filename: 本地的文件名，包含目录
+	// @param content:  调用后由 IUploader 持有
+	//
+	UploadContent(filename string, content []byte) error
+
+	// Delete 删除本地文件名对应的对象
+	//
+	Delete(filename string) error
+}
+
+var (
+	uploader IUploader
+
+	uploadedMutex sync.Mutex
+	uploaded      = make(map[string]map[string]struct{}) // 已上传，并且之后可能需要删除的文件，key为所在的目录
+)
+
+// SetUploader 需要在创建 Muxer 之前调用，nil表示不上传，默认为nil
+//
+func SetUploader(u IUploader) {
+	uploader = u
+}
+
+func uploadContent(filename string, content []byte) {
+	if uploader == nil {
+		return
+	}
+	if err := uploader.UploadContent(filename, append([]byte(nil), content...)); err != nil {
+		Log.Warnf("hls upload failed. filename=%s, err=%+v", filename, err)
+		return
+	}
+	trackUploaded(filename)
+}
+
+// trackUploaded 记录已上传的文件，清理目录时删除对应的对象
+//
+func trackUploaded(filename string) {
+	filename = filepath.Clean(filename)
+	dir := filepath.Dir(filename)
+
+	uploadedMutex.Lock()
+	defer uploadedMutex.Unlock()
+	files, ok := uploaded[dir]
+	if !ok {
+		files = make(map[string]struct{})
+		uploaded[dir] = files
+	}
+	files[filename] = struct{}{}
+}
+
+// deleteUploaded 删除本地文件时调用，删除对应的对象
+//
+func deleteUploaded(filename string) {
+	if uploader == nil {
+		return
+	}
+	filename = filepath.Clean(filename)
+	dir := filepath.Dir(filename)
+
+	uploadedMutex.Lock()
+	if files, ok := uploaded[dir]; ok {
+		delete(files, filename)
+		if len(files) == 0 {
+			delete(uploaded, dir)
+		}
+	}
+	uploadedMutex.Unlock()
+
+	if err := uploader.Delete(filename); err != nil {
+		Log.Warnf("hls delete uploaded failed. filename=%s, err=%+v", filename, err)
+	}
+}
+
+// deleteUploadedAll 删除目录path（包含子目录）下所有已上传的文件对应的对象
+//
+func deleteUploadedAll(path string) {
+	if uploader == nil {
+		return
+	}
+	path = filepath.Clean(path)
+
+	var filenames []string
+	uploadedMutex.Lock()
+	for dir, files := range uploaded {
+		if dir != path && !strings.HasPrefix(dir, path+string(filepath.Separator)) {
+			continue
+		}
+		for filename := range files {
+			filenames = append(filenames, filename)
+		}
+		delete(uploaded, dir)
+	}
+	uploadedMutex.Unlock()
+
+	for _, filename := range filenames {
+		if err := uploader.Delete(filename); err != nil {
+			Log.Warnf("hls delete uploaded failed. filename=%s, err=%+v", filename, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type mockUploader struct {
+	contents map[string][]byte
+	deleted  []string
+}
+
+func (m *mockUploader) UploadContent(filename string, content []byte) error {
+	m.contents[filename] = content
+	return nil
+}
+
+func (m *mockUploader) Delete(filename string) error {
+	delete(m.contents, filename)
+	m.deleted = append(m.deleted, filename)
+	return nil
+}
+
+func TestMuxerUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_upload")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	u := &mockUploader{contents: make(map[string][]byte)}
+	hls.SetUploader(u)
+	defer hls.SetUploader(nil)
+
+	config := &hls.MuxerConfig{
+		OutPath:            dir,
+		FragmentDurationMs: 1000,
+		FragmentNum:        3,
+		DeleteThreshold:    3,
+		CleanupMode:        hls.CleanupModeNever,
+	}
+	m := hls.NewMuxer("test111", config, &mockMuxerObserver{})
+	m.Start()
+	m.FeedPatPmt(mpegts.FixedFragmentHeader)
+	for i := uint64(0); i < 4; i++ {
+		frame := &mpegts.Frame{
+			Pts: i * 90000,
+			Dts: i * 90000,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: true,
+			Raw: []byte{0, 0, 0, 1, 0x65, 0x88, byte(i)},
+		}
+		m.FeedMpegts(frame.Pack(), frame, true)
+	}
+	m.Dispose()
+
+	// 上传的内容与本地文件一致
+	outPath := filepath.Join(dir, "test111")
+	for _, name := range []string{"playlist.m3u8", "record.m3u8"} {
+		content, err := ioutil.ReadFile(filepath.Join(outPath, name))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, content, u.contents[filepath.Join(outPath, name)])
+	}
+	content, err := ioutil.ReadFile(filepath.Join(outPath, "record.m3u8"))
+	assert.Equal(t, nil, err)
+	frags := listFragments(string(content))
+	assert.Equal(t, 4, len(frags))
+	for _, frag := range frags {
+		b, err := ioutil.ReadFile(filepath.Join(outPath, frag))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, b, u.contents[filepath.Join(outPath, frag)])
+	}
+	assert.Equal(t, 6, len(u.contents))
+}
+
+func TestMuxerUploadDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_hls_upload_delete")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	u := &mockUploader{contents: make(map[string][]byte)}
+	hls.SetUploader(u)
+	defer hls.SetUploader(nil)
+
+	config := &hls.MuxerConfig{
+		OutPath:            dir,
+		FragmentDurationMs: 1000,
+		FragmentNum:        2,
+		DeleteThreshold:    1,
+		CleanupMode:        hls.CleanupModeAsap,
+	}
+	m := hls.NewMuxer("test112", config, &mockMuxerObserver{})
+	m.Start()
+	m.FeedPatPmt(mpegts.FixedFragmentHeader)
+	for i := uint64(0); i < 8; i++ {
+		frame := &mpegts.Frame{
+			Pts: i * 90000,
+			Dts: i * 90000,
+			Pid: mpegts.PidVideo,
+			Sid: mpegts.StreamIdVideo,
+			Key: true,
+			Raw: []byte{0, 0, 0, 1, 0x65, 0x88, byte(i)},
+		}
+		m.FeedMpegts(frame.Pack(), frame, true)
+	}
+	m.Dispose()
+
+	// 本地删除的过期分片，对应的对象也被删除
+	outPath := filepath.Join(dir, "test112")
+	assert.Equal(t, true, len(u.deleted) > 0)
+	for _, filename := range u.deleted {
+		_, err := os.Stat(filename)
+		assert.Equal(t, true, os.IsNotExist(err))
+	}
+	for filename := range u.contents {
+		_, err := os.Stat(filename)
+		assert.Equal(t, nil, err)
+	}
+
+	// 清理目录时，删除剩余的对象
+	assert.Equal(t, nil, hls.RemoveAll(outPath))
+	assert.Equal(t, 0, len(u.contents))
+}
@@ -0,0 +1,256 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hls
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// 按时间范围截取m3u8：
+//
+// 请求m3u8文件时（一般为record.m3u8或dvr.m3u8），携带`start`和/或`end`参数，返回只包含该时间范围内fragment的点播m3u8，
+// fragment文件本身不做任何处理，所以截取的精度为fragment时长。
+//
+// 参数支持两种格式：
+//
+// - 数字: 相对m3u8中第一个fragment的偏移，单位秒，比如 start=60&end=90.5
+// - RFC3339格式的时间: 比如 start=2022-01-02T15:04:05Z ，依赖m3u8中的`#EXT-X-PROGRAM-DATE-TIME`
+//
+// 与时间范围有交集的fragment都会被包含
+//
+
+const (
+	VodClipParamStart = "start"
+	VodClipParamEnd   = "end"
+)
+
+var (
+	errVodClipBadParam = fmt.Errorf("%w. invalid clip param", base.ErrHls)
+	errVodClipEmpty    = fmt.Errorf("%w. no fragment in clip range", base.ErrHls)
+)
+
+type m3u8Fragment struct {
+	discont  bool
+	key      string // `#EXT-X-KEY`所在行，为空表示未加密
+	initLine string // `#EXT-X-MAP`所在行
+	dateTime time.Time
+	duration float64
+	uri      string
+}
+
+// httpStatusOfClipError 参数错误返回400，时间范围内没有fragment返回404
+//
+func httpStatusOfClipError(err error) int {
+	if errors.Is(err, errVodClipBadParam) {
+		return 400
+	}
+	return 404
+}
+
+// clipPoint 时间范围的一端，offset和wallClock只有一个有效
+type clipPoint struct {
+	valid     bool
+	offset    float64
+	wallClock time.Time
+}
+
+func parseClipPoint(v string) (p clipPoint, err error) {
+	if v == "" {
+		return
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		if f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+			return p, fmt.Errorf("%w. v=%s", errVodClipBadParam, v)
+		}
+		return clipPoint{valid: true, offset: f}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return p, fmt.Errorf("%w. v=%s", errVodClipBadParam, v)
+	}
+	return clipPoint{valid: true, wallClock: t}, nil
+}
+
+// BuildVodClipPlaylist 根据时间范围截取m3u8，生成点播m3u8
+//
+// @param content: 原m3u8文件的内容
+// @param start:   见文件头部的说明，为空表示从第一个fragment开始
+// @param end:     见文件头部的说明，为空表示到最后一个fragment结束
+//
+// @return 截取后的m3u8文件内容。参数格式错误，或者时间范围内没有fragment时，返回错误
+//
+func BuildVodClipPlaylist(content []byte, start string, end string) ([]byte, error) {
+	sp, err := parseClipPoint(start)
+	if err != nil {
+		return nil, err
+	}
+	ep, err := parseClipPoint(end)
+	if err != nil {
+		return nil, err
+	}
+
+	version, _, frags := parseM3u8Fragments(content)
+
+	var (
+		out       []m3u8Fragment
+		offset    float64
+		maxFrag   float64
+		pdtBase   time.Time // 最近一个带`#EXT-X-PROGRAM-DATE-TIME`的fragment的时间
+		pdtOffset float64   // 与pdtBase的偏移
+	)
+	for i := range frags {
+		frag := &frags[i]
+		if !frag.dateTime.IsZero() {
+			pdtBase, pdtOffset = frag.dateTime, 0
+		}
+
+		fragStart, fragEnd := offset, offset+frag.duration
+		offset = fragEnd
+
+		var wallStart time.Time
+		if !pdtBase.IsZero() {
+			wallStart = pdtBase.Add(time.Duration(pdtOffset * float64(time.Second)))
+		}
+		pdtOffset += frag.duration
+
+		if !clipFragmentIn(sp, ep, fragStart, fragEnd, wallStart, frag.duration) {
+			continue
+		}
+		if frag.dateTime.IsZero() {
+			frag.dateTime = wallStart
+		}
+		out = append(out, *frag)
+		if frag.duration > maxFrag {
+			maxFrag = frag.duration
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w. start=%s, end=%s", errVodClipEmpty, start, end)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	if version > 0 {
+		buf.WriteString(fmt.Sprintf("#EXT-X-VERSION:%d\n", version))
+	}
+	buf.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(maxFrag))))
+	buf.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	buf.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n\n")
+
+	var key, initLine string
+	for i := range out {
+		frag := &out[i]
+		// 第一个fragment不需要`#EXT-X-DISCONTINUITY`
+		if frag.discont && i != 0 {
+			buf.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if frag.key != key {
+			if frag.key == "" {
+				buf.WriteString("#EXT-X-KEY:METHOD=NONE\n")
+			} else {
+				buf.WriteString(frag.key + "\n")
+			}
+			key = frag.key
+		}
+		if frag.initLine != initLine {
+			buf.WriteString(frag.initLine + "\n")
+			initLine = frag.initLine
+		}
+		buf.WriteString(buildProgramDateTimeTag(frag.dateTime))
+		buf.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", frag.duration, frag.uri))
+	}
+	buf.WriteString("#EXT-X-ENDLIST\n")
+	return buf.Bytes(), nil
+}
+
+func clipFragmentIn(sp, ep clipPoint, fragStart, fragEnd float64, wallStart time.Time, duration float64) bool {
+	if sp.valid {
+		if sp.wallClock.IsZero() {
+			if fragEnd <= sp.offset {
+				return false
+			}
+		} else {
+			if wallStart.IsZero() || !wallStart.Add(time.Duration(duration*float64(time.Second))).After(sp.wallClock) {
+				return false
+			}
+		}
+	}
+	if ep.valid {
+		if ep.wallClock.IsZero() {
+			if fragStart >= ep.offset {
+				return false
+			}
+		} else {
+			if wallStart.IsZero() || !wallStart.Before(ep.wallClock) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseM3u8Fragments 解析m3u8中的fragment，以及每个fragment生效的`#EXT-X-KEY`和`#EXT-X-MAP`
+//
+func parseM3u8Fragments(content []byte) (version int, mediaSeq int, frags []m3u8Fragment) {
+	var (
+		curr     m3u8Fragment
+		key      string
+		initLine string
+		inf      bool
+	)
+	for _, l := range strings.Split(string(content), "\n") {
+		line := strings.TrimSpace(l)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-VERSION:"):
+			version, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-VERSION:"))
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			mediaSeq, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			if strings.HasPrefix(line, "#EXT-X-KEY:METHOD=NONE") {
+				key = ""
+			} else {
+				key = line
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			initLine = line
+		case line == "#EXT-X-DISCONTINUITY":
+			curr.discont = true
+		case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+			curr.dateTime, _ = time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			v := strings.TrimPrefix(line, "#EXTINF:")
+			if i := strings.IndexByte(v, ','); i != -1 {
+				v = v[:i]
+			}
+			curr.duration, _ = strconv.ParseFloat(strings.TrimSpace(v), 64)
+			inf = true
+		case strings.HasPrefix(line, "#"):
+		default:
+			if !inf {
+				continue
+			}
+			curr.uri = line
+			curr.key = key
+			curr.initLine = initLine
+			frags = append(frags, curr)
+			curr = m3u8Fragment{}
+			inf = false
+		}
+	}
+	return
+}
@@ -17,11 +17,40 @@ import (
 // TODO chef: 结构体重命名为FileWriter，文件名重命名为file_writer.go。所有写流文件的（flv,hls,ts）统一重构
 
 type FlvFileWriter struct {
-	fp *os.File
+	fp     *os.File
+	offset int64 // 已写入文件的字节数
+
+	keyframesNum int // 大于0时，在onMetaData中写入keyframes，见 WithKeyframesMetadata
+	keyframes    keyframesCtx
+}
+
+type keyframesCtx struct {
+	metadataPos int64 // 带占位的onMetaData tag在文件中的位置，为0表示还没有写入
+	entries     keyframesEntries
+	hasTs       bool  // 是否已写入音视频数据tag
+	firstAvPos  int64 // 第一个音视频数据tag在文件中的位置
+	firstTs     uint32
+	lastTs      uint32
+	times       []float64
+	positions   []int64
+}
+
+// WithKeyframesMetadata 在onMetaData中写入duration、filesize以及keyframes（times/filepositions），使得播放器可以seek
+//
+// 写入第一个音视频tag（包含seq header）之前，如果有onMetaData，则在其中追加这些字段，否则先写入一个新的onMetaData。
+// 由于文件大小不能改变，keyframes按`num`预留空间，关闭文件时回填，关键帧数量超过`num`时均匀抽取。
+//
+// 注意，需要在 Open 之前调用，开启后，每次 WriteRaw 只能写入一个完整的tag
+//
+func (ffw *FlvFileWriter) WithKeyframesMetadata(num int) *FlvFileWriter {
+	ffw.keyframesNum = num
+	return ffw
 }
 
 func (ffw *FlvFileWriter) Open(filename string) (err error) {
 	ffw.fp, err = os.Create(filename)
+	ffw.offset = 0
+	ffw.keyframes = keyframesCtx{}
 	return
 }
 
@@ -29,30 +58,38 @@ func (ffw *FlvFileWriter) WriteRaw(b []byte) (err error) {
 	if ffw.fp == nil {
 		return base.ErrFileNotExist
 	}
-	_, err = ffw.fp.Write(b)
-	return
+	if ffw.keyframesNum > 0 && len(b) >= TagHeaderSize+PrevTagSizeFieldSize {
+		return ffw.writeTagWithKeyframes(b)
+	}
+	return ffw.write(b)
 }
 
 func (ffw *FlvFileWriter) WriteFlvHeader() (err error) {
 	if ffw.fp == nil {
 		return base.ErrFileNotExist
 	}
-	_, err = ffw.fp.Write(FlvHeader)
-	return
+	return ffw.write(FlvHeader)
 }
 
 func (ffw *FlvFileWriter) WriteTag(tag Tag) (err error) {
-	if ffw.fp == nil {
-		return base.ErrFileNotExist
-	}
-	_, err = ffw.fp.Write(tag.Raw)
-	return
+	return ffw.WriteRaw(tag.Raw)
+}
+
+// Size 已写入文件的字节数
+//
+func (ffw *FlvFileWriter) Size() int64 {
+	return ffw.offset
 }
 
 func (ffw *FlvFileWriter) Dispose() error {
 	if ffw.fp == nil {
 		return base.ErrFileNotExist
 	}
+	if ffw.keyframes.metadataPos > 0 {
+		if err := ffw.fillKeyframes(); err != nil {
+			Log.Warnf("fill flv keyframes metadata failed. filename=%s, err=%+v", ffw.fp.Name(), err)
+		}
+	}
 	return ffw.fp.Close()
 }
 
@@ -62,3 +99,99 @@ func (ffw *FlvFileWriter) Name() string {
 	}
 	return ffw.fp.Name()
 }
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (ffw *FlvFileWriter) write(b []byte) error {
+	n, err := ffw.fp.Write(b)
+	ffw.offset += int64(n)
+	return err
+}
+
+func (ffw *FlvFileWriter) writeTagWithKeyframes(b []byte) error {
+	ctx := &ffw.keyframes
+	t := b[0]
+	payload := b[TagHeaderSize : len(b)-PrevTagSizeFieldSize]
+
+	if t == TagTypeMetadata {
+		if ctx.metadataPos == 0 && !ctx.hasTs {
+			entries := packKeyframesEntries(ffw.keyframesNum)
+			if spliced := spliceOnMetadata(payload, entries.raw); spliced != nil {
+				ts := parseTagHeader(b).Timestamp
+				return ffw.writeMetadata(PackHttpflvTag(TagTypeMetadata, ts, spliced), len(spliced), entries)
+			}
+		}
+		return ffw.write(b)
+	}
+	if t != TagTypeAudio && t != TagTypeVideo {
+		return ffw.write(b)
+	}
+
+	// 第一个音视频tag之前没有onMetaData，先写入一个
+	if ctx.metadataPos == 0 && !ctx.hasTs {
+		entries := packKeyframesEntries(ffw.keyframesNum)
+		metadata := packOnMetadata(entries.raw)
+		if err := ffw.writeMetadata(PackHttpflvTag(TagTypeMetadata, 0, metadata), len(metadata), entries); err != nil {
+			return err
+		}
+	}
+
+	isSeqHeader, isKeyframe := classifyAvTag(t, payload)
+	if isSeqHeader {
+		return ffw.write(b)
+	}
+
+	ts := parseTagHeader(b).Timestamp
+	if !ctx.hasTs {
+		ctx.hasTs = true
+		ctx.firstTs = ts
+		ctx.firstAvPos = ffw.offset
+	}
+	if ts > ctx.lastTs {
+		ctx.lastTs = ts
+	}
+	if isKeyframe && ts >= ctx.firstTs {
+		ctx.times = append(ctx.times, float64(ts-ctx.firstTs)/1000)
+		ctx.positions = append(ctx.positions, ffw.offset)
+	}
+	return ffw.write(b)
+}
+
+// writeMetadata
+//
+// @param payloadLen: onMetaData的payload的大小，entries位于payload末尾的object end之前
+//
+func (ffw *FlvFileWriter) writeMetadata(tag []byte, payloadLen int, entries keyframesEntries) error {
+	ctx := &ffw.keyframes
+	ctx.metadataPos = ffw.offset
+
+	// 转换为相对于tag开始的位置
+	shift := TagHeaderSize + payloadLen - len(amf0ObjectEnd) - len(entries.raw)
+	entries.durationPos += shift
+	entries.filesizePos += shift
+	entries.timesPos += shift
+	entries.positionsPos += shift
+	ctx.entries = entries
+	return ffw.write(tag)
+}
+
+// fillKeyframes 回填onMetaData中的duration、filesize以及keyframes
+//
+func (ffw *FlvFileWriter) fillKeyframes() error {
+	ctx := &ffw.keyframes
+	e := ctx.entries
+	b := make([]byte, e.positionsPos+ffw.keyframesNum*amf0NumberSize)
+	if _, err := ffw.fp.ReadAt(b, ctx.metadataPos); err != nil {
+		return err
+	}
+
+	putAmf0Number(b[e.durationPos:], float64(ctx.lastTs-ctx.firstTs)/1000)
+	putAmf0Number(b[e.filesizePos:], float64(ffw.offset))
+	times, positions := sampleKeyframes(ctx.times, ctx.positions, ffw.keyframesNum, ctx.firstAvPos)
+	for i := 0; i < ffw.keyframesNum; i++ {
+		putAmf0Number(b[e.timesPos+i*amf0NumberSize+1:], times[i])
+		putAmf0Number(b[e.positionsPos+i*amf0NumberSize+1:], float64(positions[i]))
+	}
+	_, err := ffw.fp.WriteAt(b, ctx.metadataPos)
+	return err
+}
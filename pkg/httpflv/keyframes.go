@@ -0,0 +1,208 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package httpflv
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// onMetaData中的keyframes字段，格式与yamdi、flvmeta等工具生成的一致：
+//
+// keyframes: {
+//   times:         [ number, ... ], // 关键帧的时间，单位秒
+//   filepositions: [ number, ... ], // 关键帧tag在文件中的位置
+// }
+//
+
+const (
+	amf0MarkerNumber      = uint8(0x00)
+	amf0MarkerString      = uint8(0x02)
+	amf0MarkerObject      = uint8(0x03)
+	amf0MarkerEcmaArray   = uint8(0x08)
+	amf0MarkerStrictArray = uint8(0x0a)
+
+	amf0NumberSize = 9 // 1字节的marker + 8字节的double
+)
+
+var amf0ObjectEnd = []byte{0, 0, 9}
+
+// keyframesEntries packKeyframesEntries 的结果，各个值的位置均相对于 raw 的开始
+//
+type keyframesEntries struct {
+	raw          []byte
+	durationPos  int // duration数值的8字节的位置
+	filesizePos  int // filesize数值的8字节的位置
+	timesPos     int // times数组第一个元素的位置（包含marker）
+	positionsPos int // filepositions数组第一个元素的位置（包含marker）
+}
+
+// packKeyframesEntries 生成追加到onMetaData中的duration、filesize以及容量为`num`的keyframes字段，值都为0，关闭文件时回填
+//
+func packKeyframesEntries(num int) (e keyframesEntries) {
+	var buf bytes.Buffer
+	writeKey := func(key string) {
+		_ = bele.WriteBe(&buf, uint16(len(key)))
+		buf.WriteString(key)
+	}
+	writeNumberPlaceholder := func() int {
+		buf.WriteByte(amf0MarkerNumber)
+		pos := buf.Len()
+		buf.Write(make([]byte, 8))
+		return pos
+	}
+	writeStrictArrayPlaceholder := func() int {
+		buf.WriteByte(amf0MarkerStrictArray)
+		_ = bele.WriteBe(&buf, uint32(num))
+		pos := buf.Len()
+		for i := 0; i < num; i++ {
+			writeNumberPlaceholder()
+		}
+		return pos
+	}
+
+	writeKey("duration")
+	e.durationPos = writeNumberPlaceholder()
+	writeKey("filesize")
+	e.filesizePos = writeNumberPlaceholder()
+	writeKey("keyframes")
+	buf.WriteByte(amf0MarkerObject)
+	writeKey("times")
+	e.timesPos = writeStrictArrayPlaceholder()
+	writeKey("filepositions")
+	e.positionsPos = writeStrictArrayPlaceholder()
+	buf.Write(amf0ObjectEnd)
+
+	e.raw = buf.Bytes()
+	return
+}
+
+// spliceOnMetadata 将`entries`追加到onMetaData的payload的末尾，同名的字段后出现的生效
+//
+// @return 不是onMetaData，或者格式不符合预期时，返回nil
+//
+func spliceOnMetadata(payload []byte, entries []byte) []byte {
+	pos := 0
+	name, ok := readAmf0String(payload, &pos)
+	if ok && name == "@setDataFrame" {
+		name, ok = readAmf0String(payload, &pos)
+	}
+	if !ok || name != "onMetaData" || pos >= len(payload) || !bytes.HasSuffix(payload, amf0ObjectEnd) {
+		return nil
+	}
+	marker := payload[pos]
+	if marker != amf0MarkerEcmaArray && marker != amf0MarkerObject {
+		return nil
+	}
+
+	out := make([]byte, 0, len(payload)+len(entries))
+	out = append(out, payload[:len(payload)-len(amf0ObjectEnd)]...)
+	out = append(out, entries...)
+	out = append(out, amf0ObjectEnd...)
+	if marker == amf0MarkerEcmaArray && pos+5 <= len(out) {
+		count := bele.BeUint32(out[pos+1:])
+		bele.BePutUint32(out[pos+1:], count+3)
+	}
+	return out
+}
+
+// packOnMetadata 生成只包含`entries`的onMetaData的payload
+//
+func packOnMetadata(entries []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(amf0MarkerString)
+	_ = bele.WriteBe(&buf, uint16(len("onMetaData")))
+	buf.WriteString("onMetaData")
+	buf.WriteByte(amf0MarkerEcmaArray)
+	_ = bele.WriteBe(&buf, uint32(3))
+	buf.Write(entries)
+	buf.Write(amf0ObjectEnd)
+	return buf.Bytes()
+}
+
+func readAmf0String(b []byte, pos *int) (string, bool) {
+	if *pos+3 > len(b) || b[*pos] != amf0MarkerString {
+		return "", false
+	}
+	l := int(bele.BeUint16(b[*pos+1:]))
+	if *pos+3+l > len(b) {
+		return "", false
+	}
+	s := string(b[*pos+3 : *pos+3+l])
+	*pos += 3 + l
+	return s, true
+}
+
+func putAmf0Number(b []byte, v float64) {
+	bele.BePutUint64(b, math.Float64bits(v))
+}
+
+// sampleKeyframes 将关键帧列表调整为`num`个：数量不足时使用最后一个补齐，超过时均匀抽取
+//
+func sampleKeyframes(times []float64, positions []int64, num int, defaultPosition int64) ([]float64, []int64) {
+	outTimes := make([]float64, num)
+	outPositions := make([]int64, num)
+	n := len(times)
+	for i := 0; i < num; i++ {
+		switch {
+		case n == 0:
+			outPositions[i] = defaultPosition
+		case n <= num:
+			j := i
+			if j >= n {
+				j = n - 1
+			}
+			outTimes[i], outPositions[i] = times[j], positions[j]
+		default:
+			j := 0
+			if num > 1 {
+				j = i * (n - 1) / (num - 1)
+			}
+			outTimes[i], outPositions[i] = times[j], positions[j]
+		}
+	}
+	return outTimes, outPositions
+}
+
+// classifyAvTag 根据tag类型以及payload的开始部分，判断是否为seq header，以及是否为视频关键帧
+//
+// 支持enhanced rtmp的视频格式
+//
+func classifyAvTag(t uint8, payload []byte) (isSeqHeader bool, isKeyframe bool) {
+	if len(payload) == 0 {
+		return
+	}
+	switch t {
+	case TagTypeAudio:
+		isSeqHeader = payload[0]>>4 == SoundFormatAac && len(payload) > 1 && payload[1] == AacPacketTypeSeqHeader
+	case TagTypeVideo:
+		if payload[0]&0x80 != 0 {
+			// enhanced rtmp: 4bit packet type, 0为SequenceStart，1为CodedFrames，3为CodedFramesX
+			frameType := (payload[0] >> 4) & 0x7
+			packetType := payload[0] & 0xF
+			isSeqHeader = packetType == 0
+			isKeyframe = frameType == frameTypeKey && (packetType == 1 || packetType == 3)
+			return
+		}
+		frameType := payload[0] >> 4
+		codecId := payload[0] & 0xF
+		if codecId == codecIdAvc || codecId == codecIdHevc {
+			if len(payload) < 2 {
+				return
+			}
+			isSeqHeader = frameType == frameTypeKey && payload[1] == AvcPacketTypeSeqHeader
+			isKeyframe = frameType == frameTypeKey && payload[1] == AvcPacketTypeNalu
+			return
+		}
+		isKeyframe = frameType == frameTypeKey
+	}
+	return
+}
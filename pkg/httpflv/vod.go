@@ -0,0 +1,267 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package httpflv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// KeyframeIndex flv文件中视频关键帧的索引，用于按时间seek
+//
+type KeyframeIndex struct {
+	HeaderSize int64     // 第一个音视频数据tag之前的部分的大小，包含flv header、metadata以及seq header
+	Times      []float64 // 关键帧相对于第一个音视频数据tag的时间，单位秒
+	Positions  []int64   // 关键帧tag在文件中的位置
+}
+
+// BuildKeyframeIndex 遍历文件中的tag建立索引，只读取tag header以及payload的开始部分
+//
+// 文件末尾不完整的tag（比如正在录制中的文件）被忽略
+//
+func BuildKeyframeIndex(r io.ReaderAt, size int64) (*KeyframeIndex, error) {
+	header := make([]byte, flvHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:3], FlvHeader[:3]) {
+		return nil, fmt.Errorf("%w. invalid flv header. header=%v", base.ErrHttpflv, header[:3])
+	}
+	pos := int64(bele.BeUint32(header[5:])) + int64(PrevTagSizeFieldSize)
+
+	index := &KeyframeIndex{HeaderSize: -1}
+	var firstTs uint32
+	b := make([]byte, TagHeaderSize+2)
+	for pos+int64(TagHeaderSize) <= size {
+		n, err := r.ReadAt(b, pos)
+		if n < TagHeaderSize {
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+		h := parseTagHeader(b)
+		next := pos + int64(TagHeaderSize) + int64(h.DataSize) + int64(PrevTagSizeFieldSize)
+		if next > size {
+			break
+		}
+
+		if h.Type == TagTypeAudio || h.Type == TagTypeVideo {
+			payload := b[TagHeaderSize:n]
+			if int(h.DataSize) < len(payload) {
+				payload = payload[:h.DataSize]
+			}
+			isSeqHeader, isKeyframe := classifyAvTag(h.Type, payload)
+			if !isSeqHeader {
+				if index.HeaderSize == -1 {
+					index.HeaderSize = pos
+					firstTs = h.Timestamp
+				}
+				if isKeyframe && h.Timestamp >= firstTs {
+					index.Times = append(index.Times, float64(h.Timestamp-firstTs)/1000)
+					index.Positions = append(index.Positions, pos)
+				}
+			}
+		}
+		pos = next
+	}
+	if index.HeaderSize == -1 {
+		index.HeaderSize = pos
+	}
+	return index, nil
+}
+
+// Seek 返回时间不大于`sec`的最后一个关键帧的位置，`sec`小于第一个关键帧的时间时返回第一个关键帧的位置，没有关键帧时返回 HeaderSize
+//
+func (ki *KeyframeIndex) Seek(sec float64) int64 {
+	if len(ki.Times) == 0 {
+		return ki.HeaderSize
+	}
+	i := sort.Search(len(ki.Times), func(i int) bool {
+		return ki.Times[i] > sec
+	})
+	if i == 0 {
+		return ki.Positions[0]
+	}
+	return ki.Positions[i-1]
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+const vodIndexCacheMaxNum = 128
+
+// VodServerHandler 点播录制的flv文件
+//
+// - `{urlPattern}{相对于rootPath的文件路径}`，比如 /vod/test110-1666590000.flv
+// - 不带`start`参数时，按普通文件处理，支持Range请求
+// - 带`start=<秒>`参数时，从不大于该时间的关键帧开始返回，并在前面加上flv header、metadata以及seq header，
+//   时间戳保持不变，与flv.js以及nginx的flv模块的方式类似
+//
+type VodServerHandler struct {
+	urlPattern   string
+	rootPath     string
+	authProvider IVodAuthProvider
+
+	mutex   sync.Mutex
+	indexes map[string]*vodIndexCacheItem
+}
+
+// IVodAuthProvider 点播请求的鉴权
+//
+type IVodAuthProvider interface {
+	// OnVod 在打开文件之前调用
+	//
+	// @param rel:      相对于rootPath的文件路径，使用`/`分隔，比如 test110/1666590000.flv
+	// @param urlParam: url中的参数，比如 lal_secret=xxx&start=10
+	//
+	// @return 不为nil时拒绝请求，返回403
+	//
+	OnVod(rel string, urlParam string) error
+}
+
+type vodIndexCacheItem struct {
+	size    int64
+	modTime time.Time
+	index   *KeyframeIndex
+}
+
+func NewVodServerHandler(urlPattern string, rootPath string) *VodServerHandler {
+	return &VodServerHandler{
+		urlPattern: urlPattern,
+		rootPath:   rootPath,
+		indexes:    make(map[string]*vodIndexCacheItem),
+	}
+}
+
+// WithAuthProvider 设置鉴权，不设置时不鉴权
+//
+func (s *VodServerHandler) WithAuthProvider(p IVodAuthProvider) *VodServerHandler {
+	s.authProvider = p
+	return s
+}
+
+func (s *VodServerHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Server", base.LalHttpflvSubSessionServer)
+	resp.Header().Set("Access-Control-Allow-Origin", "*")
+	resp.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges")
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions:
+		resp.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+		resp.Header().Set("Access-Control-Allow-Headers", "Range")
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rel := strings.TrimPrefix(req.URL.Path, s.urlPattern)
+	if !strings.HasSuffix(rel, ".flv") {
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rel = strings.TrimPrefix(path.Clean("/"+rel), "/")
+	if s.authProvider != nil {
+		if err := s.authProvider.OnVod(rel, req.URL.RawQuery); err != nil {
+			Log.Warnf("flv vod auth failed. path=%s, err=%+v", rel, err)
+			resp.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	filename := filepath.Join(s.rootPath, filepath.FromSlash(rel))
+
+	fp, err := os.Open(filename)
+	if err != nil {
+		Log.Warnf("open flv vod file failed. filename=%s, err=%+v", filename, err)
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer fp.Close()
+	fi, err := fp.Stat()
+	if err != nil || fi.IsDir() {
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "video/x-flv")
+
+	start := req.URL.Query().Get("start")
+	if start == "" {
+		http.ServeContent(resp, req, fi.Name(), fi.ModTime(), fp)
+		return
+	}
+	sec, err := strconv.ParseFloat(start, 64)
+	if err != nil || sec < 0 {
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	index, err := s.getIndex(filename, fp, fi)
+	if err != nil {
+		Log.Warnf("build flv keyframe index failed. filename=%s, err=%+v", filename, err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	pos := index.Seek(sec)
+	if pos < index.HeaderSize {
+		pos = index.HeaderSize
+	}
+
+	size := fi.Size()
+	resp.Header().Set("Content-Length", strconv.FormatInt(index.HeaderSize+size-pos, 10))
+	resp.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return
+	}
+	r := io.MultiReader(io.NewSectionReader(fp, 0, index.HeaderSize), io.NewSectionReader(fp, pos, size-pos))
+	_, _ = io.Copy(resp, r)
+}
+
+// getIndex 文件大小或者修改时间变化后（比如正在录制中的文件）重新建立索引
+//
+func (s *VodServerHandler) getIndex(filename string, fp *os.File, fi os.FileInfo) (*KeyframeIndex, error) {
+	s.mutex.Lock()
+	item, ok := s.indexes[filename]
+	s.mutex.Unlock()
+	if ok && item.size == fi.Size() && item.modTime.Equal(fi.ModTime()) {
+		return item.index, nil
+	}
+
+	index, err := BuildKeyframeIndex(fp, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.indexes) >= vodIndexCacheMaxNum {
+		s.indexes = make(map[string]*vodIndexCacheItem)
+	}
+	s.indexes[filename] = &vodIndexCacheItem{
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+		index:   index,
+	}
+	return index, nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package httpflv_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+// writeTestVodFile 10个GOP，每个GOP 1秒，时间戳从10000开始
+//
+func writeTestVodFile(t *testing.T, filename string, keyframesNum int) {
+	w := (&httpflv.FlvFileWriter{}).WithKeyframesMetadata(keyframesNum)
+	assert.Equal(t, nil, w.Open(filename))
+	assert.Equal(t, nil, w.WriteFlvHeader())
+
+	metadata, err := rtmp.BuildMetadata(640, 360, 10, 7)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.WriteRaw(httpflv.PackHttpflvTag(httpflv.TagTypeMetadata, 0, metadata)))
+	assert.Equal(t, nil, w.WriteRaw(httpflv.PackHttpflvTag(httpflv.TagTypeVideo, 0, []byte{httpflv.AvcKeyFrame, 0, 0, 0, 0, 1})))
+	assert.Equal(t, nil, w.WriteRaw(httpflv.PackHttpflvTag(httpflv.TagTypeAudio, 0, []byte{0xAF, 0, 0x12, 0x10})))
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 25; j++ {
+			ts := uint32(10000 + i*1000 + j*40)
+			frameType := uint8(httpflv.AvcInterFrame)
+			if j == 0 {
+				frameType = httpflv.AvcKeyFrame
+			}
+			assert.Equal(t, nil, w.WriteRaw(httpflv.PackHttpflvTag(httpflv.TagTypeVideo, ts, []byte{frameType, 1, 0, 0, 0, byte(j)})))
+			assert.Equal(t, nil, w.WriteRaw(httpflv.PackHttpflvTag(httpflv.TagTypeAudio, ts, []byte{0xAF, 1, byte(j)})))
+		}
+	}
+	size := w.Size()
+	assert.Equal(t, nil, w.Dispose())
+	fi, err := os.Stat(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, size, fi.Size())
+}
+
+func readTags(t *testing.T, b []byte) []httpflv.Tag {
+	assert.Equal(t, true, len(b) >= 13)
+	r := bytes.NewReader(b[13:])
+	var tags []httpflv.Tag
+	for r.Len() > 0 {
+		tag, err := httpflv.ReadTag(r)
+		assert.Equal(t, nil, err)
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+func TestFlvFileWriterKeyframes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_httpflv_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "test.flv")
+	writeTestVodFile(t, filename, 4)
+	content, err := ioutil.ReadFile(filename)
+	assert.Equal(t, nil, err)
+
+	// 原有的onMetaData中追加了keyframes等字段
+	tags := readTags(t, content)
+	assert.Equal(t, true, tags[0].IsMetadata())
+	name, values, err := rtmp.ParseDataMessage(tags[0].Payload())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "onMetaData", name)
+	m := values[0].(map[string]interface{})
+	assert.Equal(t, float64(640), m["width"])
+	assert.Equal(t, 9.96, m["duration"])
+	assert.Equal(t, float64(len(content)), m["filesize"])
+
+	// 10个关键帧均匀抽取4个
+	index, err := httpflv.BuildKeyframeIndex(bytes.NewReader(content), int64(len(content)))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 10, len(index.Times))
+	keyframes := m["keyframes"].(map[string]interface{})
+	times := keyframes["times"].([]interface{})
+	positions := keyframes["filepositions"].([]interface{})
+	assert.Equal(t, []interface{}{float64(0), float64(3), float64(6), float64(9)}, times)
+	for i, j := range []int{0, 3, 6, 9} {
+		pos := int64(positions[i].(float64))
+		assert.Equal(t, index.Positions[j], pos)
+		assert.Equal(t, httpflv.TagTypeVideo, content[pos])
+		assert.Equal(t, httpflv.AvcKeyFrame, content[pos+int64(httpflv.TagHeaderSize)])
+	}
+
+	// 没有onMetaData时，在第一个音视频数据tag之前写入，关键帧数量不足时使用最后一个补齐
+	w := (&httpflv.FlvFileWriter{}).WithKeyframesMetadata(3)
+	assert.Equal(t, nil, w.Open(filename))
+	assert.Equal(t, nil, w.WriteFlvHeader())
+	assert.Equal(t, nil, w.WriteRaw(httpflv.PackHttpflvTag(httpflv.TagTypeVideo, 0, []byte{httpflv.AvcKeyFrame, 0, 0, 0, 0, 1})))
+	assert.Equal(t, nil, w.WriteRaw(httpflv.PackHttpflvTag(httpflv.TagTypeVideo, 0, []byte{httpflv.AvcKeyFrame, 1, 0, 0, 0})))
+	assert.Equal(t, nil, w.WriteRaw(httpflv.PackHttpflvTag(httpflv.TagTypeVideo, 40, []byte{httpflv.AvcInterFrame, 1, 0, 0, 0})))
+	assert.Equal(t, nil, w.Dispose())
+	content, err = ioutil.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	tags = readTags(t, content)
+	assert.Equal(t, 4, len(tags))
+	assert.Equal(t, true, tags[0].IsMetadata())
+	assert.Equal(t, true, tags[1].IsAvcKeySeqHeader())
+	_, values, err = rtmp.ParseDataMessage(tags[0].Payload())
+	assert.Equal(t, nil, err)
+	m = values[0].(map[string]interface{})
+	assert.Equal(t, 0.04, m["duration"])
+	keyframes = m["keyframes"].(map[string]interface{})
+	firstPos := float64(13 + len(tags[0].Raw) + len(tags[1].Raw))
+	assert.Equal(t, []interface{}{firstPos, firstPos, firstPos}, keyframes["filepositions"])
+}
+
+func TestVodServerHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_httpflv_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, nil, os.MkdirAll(filepath.Join(dir, "test110"), 0755))
+	filename := filepath.Join(dir, "test110", "1.flv")
+	writeTestVodFile(t, filename, 16)
+	content, err := ioutil.ReadFile(filename)
+	assert.Equal(t, nil, err)
+
+	srv := httptest.NewServer(httpflv.NewVodServerHandler("/vod/", dir))
+	defer srv.Close()
+
+	get := func(uri string, header http.Header) (*http.Response, []byte) {
+		req, err := http.NewRequest("GET", srv.URL+uri, nil)
+		assert.Equal(t, nil, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.Equal(t, nil, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, nil, err)
+		return resp, body
+	}
+
+	// 整个文件
+	resp, body := get("/vod/test110/1.flv", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "video/x-flv", resp.Header.Get("Content-Type"))
+	assert.Equal(t, content, body)
+
+	// Range请求
+	resp, body = get("/vod/test110/1.flv", http.Header{"Range": {"bytes=100-199"}})
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, content[100:200], body)
+
+	// 从不大于5.5秒的关键帧开始，前面带上metadata以及seq header
+	resp, body = get("/vod/test110/1.flv?start=5.5", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	tags := readTags(t, body)
+	assert.Equal(t, true, tags[0].IsMetadata())
+	assert.Equal(t, true, tags[1].IsAvcKeySeqHeader())
+	assert.Equal(t, true, tags[2].IsAacSeqHeader())
+	assert.Equal(t, true, tags[3].IsAvcKeyNalu())
+	assert.Equal(t, uint32(15000), tags[3].Header.Timestamp)
+	assert.Equal(t, 3+5*50, len(tags))
+
+	// 在第一个关键帧之前，以及超过最后一个关键帧
+	_, body = get("/vod/test110/1.flv?start=0", nil)
+	assert.Equal(t, content, body)
+	_, body = get("/vod/test110/1.flv?start=100", nil)
+	tags = readTags(t, body)
+	assert.Equal(t, uint32(19000), tags[3].Header.Timestamp)
+
+	resp, _ = get("/vod/test110/1.flv?start=abc", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = get("/vod/test110/2.flv", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = get("/vod/test110/../../1.flv", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+type mockVodAuthProvider struct {
+	rels []string
+}
+
+func (m *mockVodAuthProvider) OnVod(rel string, urlParam string) error {
+	m.rels = append(m.rels, rel)
+	if urlParam != "token=ok" {
+		return errors.New("mock auth failed")
+	}
+	return nil
+}
+
+func TestVodServerHandlerAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_httpflv_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, nil, os.MkdirAll(filepath.Join(dir, "test110"), 0755))
+	writeTestVodFile(t, filepath.Join(dir, "test110", "1.flv"), 4)
+
+	auth := &mockVodAuthProvider{}
+	srv := httptest.NewServer(httpflv.NewVodServerHandler("/vod/", dir).WithAuthProvider(auth))
+	defer srv.Close()
+
+	get := func(uri string) int {
+		resp, err := http.Get(srv.URL + uri)
+		assert.Equal(t, nil, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusForbidden, get("/vod/test110/1.flv"))
+	assert.Equal(t, http.StatusOK, get("/vod/test110/1.flv?token=ok"))
+	// 鉴权使用清理后的路径，并且在打开文件之前
+	assert.Equal(t, http.StatusForbidden, get("/vod/test110/./2.flv"))
+	assert.Equal(t, []string{"test110/1.flv", "test110/1.flv", "test110/2.flv"}, auth.rels)
+}
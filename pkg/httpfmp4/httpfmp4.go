@@ -0,0 +1,13 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+// Package httpfmp4 通过http或websocket长连接输出fmp4直播流，供浏览器使用MSE播放
+//
+// 先发送初始化分片（ftyp+moov），之后持续发送媒体分片（moof+mdat）
+//
+package httpfmp4
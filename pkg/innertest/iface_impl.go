@@ -12,6 +12,7 @@ import (
 	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/hls"
 	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/httpfmp4"
 	"github.com/q191201771/lal/pkg/httpts"
 	"github.com/q191201771/lal/pkg/logic"
 	"github.com/q191201771/lal/pkg/remux"
@@ -29,6 +30,7 @@ var (
 	_ base.ISession = &rtsp.SubSession{}
 	_ base.ISession = &httpflv.SubSession{}
 	_ base.ISession = &httpts.SubSession{}
+	_ base.ISession = &httpfmp4.SubSession{}
 
 	_ base.ISession = &rtmp.PushSession{}
 	_ base.ISession = &rtmp.PullSession{}
@@ -53,6 +55,7 @@ var (
 	_ base.IServerSession = &rtsp.SubSession{}
 	_ base.IServerSession = &httpflv.SubSession{}
 	_ base.IServerSession = &httpts.SubSession{}
+	_ base.IServerSession = &httpfmp4.SubSession{}
 )
 
 // IClientSessionLifecycle: 所有Client Session都满足
@@ -77,6 +80,7 @@ var (
 	_ base.IServerSessionLifecycle = &rtsp.SubSession{}
 	_ base.IServerSessionLifecycle = &httpflv.SubSession{}
 	_ base.IServerSessionLifecycle = &httpts.SubSession{}
+	_ base.IServerSessionLifecycle = &httpfmp4.SubSession{}
 
 	// other
 	_ base.IServerSessionLifecycle = &base.BasicHttpSubSession{}
@@ -97,6 +101,7 @@ var (
 	_ base.ISessionStat = &rtsp.SubSession{}
 	_ base.ISessionStat = &httpflv.SubSession{}
 	_ base.ISessionStat = &httpts.SubSession{}
+	_ base.ISessionStat = &httpfmp4.SubSession{}
 	// other
 	_ base.ISessionStat = &base.BasicHttpSubSession{}
 	_ base.ISessionStat = &rtmp.ClientSession{}
@@ -119,6 +124,7 @@ var (
 	_ base.ISessionUrlContext = &rtsp.SubSession{}
 	_ base.ISessionUrlContext = &httpflv.SubSession{}
 	_ base.ISessionUrlContext = &httpts.SubSession{}
+	_ base.ISessionUrlContext = &httpfmp4.SubSession{}
 	// other
 	_ base.ISessionUrlContext = &base.BasicHttpSubSession{}
 	_ base.ISessionUrlContext = &rtmp.ClientSession{}
@@ -139,6 +145,7 @@ var (
 	_ base.IObject = &rtsp.SubSession{}
 	_ base.IObject = &httpflv.SubSession{}
 	_ base.IObject = &httpts.SubSession{}
+	_ base.IObject = &httpfmp4.SubSession{}
 	//// other
 	_ base.IObject = &base.BasicHttpSubSession{}
 	_ base.IObject = &rtmp.ClientSession{}
@@ -18,15 +18,19 @@ import (
 	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/hls"
 	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/lal/pkg/storage"
 	"github.com/q191201771/naza/pkg/nazajson"
 	"github.com/q191201771/naza/pkg/nazalog"
 )
 
 const (
-	defaultHlsCleanupMode    = hls.CleanupModeInTheEnd
-	defaultHttpflvUrlPattern = "/live/"
-	defaultHttptsUrlPattern  = "/live/"
-	defaultHlsUrlPattern     = "/hls/"
+	defaultHlsCleanupMode       = hls.CleanupModeInTheEnd
+	defaultHttpflvUrlPattern    = "/live/"
+	defaultHttpflvVodUrlPattern = "/vod/"
+	defaultHttptsUrlPattern     = "/live/"
+	defaultHttpfmp4UrlPattern   = "/live/"
+	defaultHlsUrlPattern        = "/hls/"
+	defaultDashUrlPattern       = "/dash/"
 )
 
 type Config struct {
@@ -34,10 +38,14 @@ type Config struct {
 	RtmpConfig            RtmpConfig            `json:"rtmp"`
 	DefaultHttpConfig     DefaultHttpConfig     `json:"default_http"`
 	HttpflvConfig         HttpflvConfig         `json:"httpflv"`
+	HttpflvVodConfig      HttpflvVodConfig      `json:"httpflv_vod"`
 	HlsConfig             HlsConfig             `json:"hls"`
+	DashConfig            DashConfig            `json:"dash"`
 	HttptsConfig          HttptsConfig          `json:"httpts"`
+	Httpfmp4Config        Httpfmp4Config        `json:"httpfmp4"`
 	RtspConfig            RtspConfig            `json:"rtsp"`
 	RecordConfig          RecordConfig          `json:"record"`
+	StorageConfig         StorageConfig         `json:"storage"`
 	RelayPushConfig       RelayPushConfig       `json:"relay_push"`
 	StaticRelayPullConfig StaticRelayPullConfig `json:"static_relay_pull"`
 
@@ -69,23 +77,71 @@ type HttpflvConfig struct {
 	GopNum int `json:"gop_num"`
 }
 
+// HttpflvVodConfig
+//
+// 点播录制的flv文件，文件目录为 RecordConfig.FlvOutPath ，见 httpflv.VodServerHandler
+//
+type HttpflvVodConfig struct {
+	CommonHttpServerConfig
+}
+
 type HttptsConfig struct {
 	CommonHttpServerConfig
 
 	GopNum int `json:"gop_num"`
 }
 
+// Httpfmp4Config
+//
+// 通过`{url_pattern}{stream}.mp4`拉取fmp4直播流，见 httpfmp4.SubSession
+//
+type Httpfmp4Config struct {
+	CommonHttpServerConfig
+
+	GopNum int `json:"gop_num"`
+
+	// FragmentDurationMs 在视频关键帧处，以及时长达到该值时生成一个媒体分片（moof+mdat）。
+	// 为0时每帧一个媒体分片，延迟最低；大于GOP时长时每个GOP一个媒体分片，开销最小
+	FragmentDurationMs int `json:"fragment_duration_ms"`
+}
+
 type HlsConfig struct {
 	CommonHttpServerConfig
 
-	UseMemoryAsDiskFlag bool `json:"use_memory_as_disk_flag"`
+	UseMemoryAsDiskFlag bool                    `json:"use_memory_as_disk_flag"`
+	SegmentStoreMaxMb   int                     `json:"segment_store_max_mb"` // 大于0时分片保存在内存中，所有流共享该内存上限，单位MB，见 hls.SegmentStore
+	VariantGroups       []HlsVariantGroupConfig `json:"variant_groups"`
+	SubSessionTimeoutMs int                     `json:"sub_session_timeout_ms"` // 大于0时统计hls播放者，超过该时间没有请求的播放者视为离开，见 hls.SubSession
+	TimedMetadataEnable bool                    `json:"timed_metadata_enable"`  // 将rtmp数据消息转换为mpegts中的ID3定时元数据，见 remux.Rtmp2MpegtsRemuxer.WithTimedMetadata
+	Scte35Enable        bool                    `json:"scte35_enable"`          // 支持通过http api插入SCTE-35广告信令，见 remux.Rtmp2MpegtsRemuxer.WithScte35
 	hls.MuxerConfig
 }
 
+// HlsVariantGroupConfig
+//
+// HLS多码率分组，将同一路节目的多个不同码率的流组合起来，通过`/hls/{name}/master.m3u8`或`/hls/{name}.m3u8`访问master playlist
+//
+type HlsVariantGroupConfig struct {
+	Name        string   `json:"name"`
+	StreamNames []string `json:"stream_names"`
+}
+
+// DashConfig
+//
+// 文件目录、分片时长、分片数量以及清理策略等复用hls的配置，见 HlsConfig
+//
+type DashConfig struct {
+	CommonHttpServerConfig
+}
+
 type RtspConfig struct {
 	Enable              bool   `json:"enable"`
 	Addr                string `json:"addr"`
 	OutWaitKeyFrameFlag bool   `json:"out_wait_key_frame_flag"`
+
+	// PullOnvifMetadataEnable rtsp pull时，如果对端有onvif metadata数据轨道，是否拉取该轨道，并通知给业务方以及透传给rtsp sub
+	PullOnvifMetadataEnable bool `json:"pull_onvif_metadata_enable"`
+
 	rtsp.ServerAuthConfig
 }
 
@@ -94,8 +150,65 @@ type RecordConfig struct {
 	FlvOutPath    string `json:"flv_out_path"`
 	EnableMpegts  bool   `json:"enable_mpegts"`
 	MpegtsOutPath string `json:"mpegts_out_path"`
+	EnableMp4     bool   `json:"enable_mp4"`
+	Mp4OutPath    string `json:"mp4_out_path"`
+
+	// Mp4FinalizeFlag 录制过程中写入的是fmp4，录制正常结束时，是否转换为moov在头部的普通mp4
+	Mp4FinalizeFlag bool `json:"mp4_finalize_flag"`
+
+	// FlvKeyframesMetadataNum 大于0时，flv录制文件的onMetaData中写入keyframes（times/filepositions），使得播放器可以seek，
+	// 该值为预留的关键帧数量，见 httpflv.FlvFileWriter.WithKeyframesMetadata
+	FlvKeyframesMetadataNum int `json:"flv_keyframes_metadata_num"`
+
+	// FilenameTemplate 录制文件名模板，可以包含目录，比如`{app}/{stream}/{yyyy}/{mm}/{dd}/{HHMMSS}.{ext}`，
+	// 支持的变量见 Group.genRecordFilename ，为空时为`{stream}-{unix}.{ext}`
+	FilenameTemplate string `json:"filename_template"`
+
+	// SegmentDurationSec SegmentMaxSizeMb 文件时长或大小达到阈值后，在视频关键帧处切换到新的文件，为0表示不按该条件切换
+	SegmentDurationSec int `json:"segment_duration_sec"`
+	SegmentMaxSizeMb   int `json:"segment_max_size_mb"`
+
+	// RetentionMaxAgeSec RetentionMaxSizeMb 每个录制目录中录制文件的保留策略，超过后从最旧的文件开始删除，为0表示不按该条件删除。
+	// 只作用于flv、ts、mp4录制，hls录制见 hls.MuxerConfig.RecordMaxDurationMs
+	RetentionMaxAgeSec int `json:"retention_max_age_sec"`
+	RetentionMaxSizeMb int `json:"retention_max_size_mb"`
+
+	// DiskLowWatermarkMb 录制目录所在磁盘的剩余空间低于该值时，暂停正在写入的录制文件，并拒绝新的录制，空间恢复后继续录制，为0表示不检查
+	DiskLowWatermarkMb int `json:"disk_low_watermark_mb"`
+
+	// CatalogFile 录制文件索引的持久化文件，为空表示不记录索引，见 recordCatalog
+	CatalogFile string `json:"catalog_file"`
+
+	// ExportOutPath 通过http api导出的文件的存放目录
+	//
+	// 注意，导出只支持以flv录制文件为源，需要开启 EnableFlv ，只开启了ts、mp4录制的流无法导出
+	//
+	ExportOutPath string `json:"export_out_path"`
 }
 
+// StorageConfig hls的分片、m3u8，以及录制文件写入本地后，异步上传到存储后端，见 storage.Uploader
+//
+// 开启 RemoveLocalRecord 时，本地文件删除后同时从录制文件索引中去掉（见 RecordConfig.CatalogFile ），
+// 本地的flv点播（见 HttpflvVodConfig ）也无法再访问该文件
+//
+type StorageConfig struct {
+	Enable    bool             `json:"enable"`
+	Type      string           `json:"type"`       // StorageTypeLocal 或 StorageTypeS3
+	LocalPath string           `json:"local_path"` // Type为StorageTypeLocal时，上传到的目录，比如挂载的网络存储
+	S3        storage.S3Config `json:"s3"`         // Type为StorageTypeS3时使用
+
+	storage.UploaderConfig
+
+	UploadHls         bool `json:"upload_hls"`
+	UploadRecord      bool `json:"upload_record"`       // flv、ts、mp4录制文件，在每个文件写入完成后上传
+	RemoveLocalRecord bool `json:"remove_local_record"` // 录制文件上传成功后删除本地文件
+}
+
+const (
+	StorageTypeLocal = "local"
+	StorageTypeS3    = "s3"
+)
+
 type RelayPushConfig struct {
 	Enable   bool     `json:"enable"`
 	AddrList []string `json:"addr_list"`
@@ -124,6 +237,9 @@ type HttpNotifyConfig struct {
 	OnRelayPullStop   string `json:"on_relay_pull_stop"`
 	OnRtmpConnect     string `json:"on_rtmp_connect"`
 	OnHlsMakeTs       string `json:"on_hls_make_ts"`
+	OnOnvifMetadata   string `json:"on_onvif_metadata"`
+	OnRecordFileDone  string `json:"on_record_file_done"`
+	OnRecordDiskGuard string `json:"on_record_disk_guard"`
 }
 
 type SimpleAuthConfig struct {
@@ -133,9 +249,11 @@ type SimpleAuthConfig struct {
 	SubRtmpEnable      bool   `json:"sub_rtmp_enable"`
 	SubHttpflvEnable   bool   `json:"sub_httpflv_enable"`
 	SubHttptsEnable    bool   `json:"sub_httpts_enable"`
+	SubHttpfmp4Enable  bool   `json:"sub_httpfmp4_enable"`
 	PubRtspEnable      bool   `json:"pub_rtsp_enable"`
 	SubRtspEnable      bool   `json:"sub_rtsp_enable"`
 	HlsM3u8Enable      bool   `json:"hls_m3u8_enable"`
+	VodEnable          bool   `json:"vod_enable"` // 点播录制文件，见 SimpleAuthCtx.OnVod
 }
 
 type PprofConfig struct {
@@ -147,6 +265,9 @@ type DebugConfig struct {
 	LogGroupIntervalSec       int `json:"log_group_interval_sec"`
 	LogGroupMaxGroupNum       int `json:"log_group_max_group_num"`
 	LogGroupMaxSubNumPerGroup int `json:"log_group_max_sub_num_per_group"`
+
+	// LatencySeiEnable 是否在视频帧输入时注入记录了本地时间的SEI，用于测量各协议输出的延迟，见 remux.LatencySeiInfo
+	LatencySeiEnable bool `json:"latency_sei_enable"`
 }
 
 type CommonHttpServerConfig struct {
@@ -255,8 +376,11 @@ func LoadConfAndInitLog(confFile string) *Config {
 		"log.",
 		"default_http.http_listen_addr", "default_http.https_listen_addr", "default_http.https_cert_file", "default_http.https_key_file",
 		"httpflv.http_listen_addr", "httpflv.https_listen_addr", "httpflv.https_cert_file", "httpflv.https_key_file",
+		"httpflv_vod.http_listen_addr", "httpflv_vod.https_listen_addr", "httpflv_vod.https_cert_file", "httpflv_vod.https_key_file",
 		"hls.http_listen_addr", "hls.https_listen_addr", "hls.https_cert_file", "hls.https_key_file",
+		"dash.http_listen_addr", "dash.https_listen_addr", "dash.https_cert_file", "dash.https_key_file",
 		"httpts.http_listen_addr", "httpts.https_listen_addr", "httpts.https_cert_file", "httpts.https_key_file",
+		"httpfmp4.http_listen_addr", "httpfmp4.https_listen_addr", "httpfmp4.https_cert_file", "httpfmp4.https_key_file",
 	)
 	if err != nil {
 		Log.Warnf("config nazajson collect not exist fields failed. err=%+v", err)
@@ -272,8 +396,11 @@ func LoadConfAndInitLog(confFile string) *Config {
 
 	// 如果具体的HTTP应用没有设置HTTP监听相关的配置，则尝试使用全局配置
 	mergeCommonHttpAddrConfig(&config.HttpflvConfig.CommonHttpAddrConfig, &config.DefaultHttpConfig.CommonHttpAddrConfig)
+	mergeCommonHttpAddrConfig(&config.HttpflvVodConfig.CommonHttpAddrConfig, &config.DefaultHttpConfig.CommonHttpAddrConfig)
 	mergeCommonHttpAddrConfig(&config.HttptsConfig.CommonHttpAddrConfig, &config.DefaultHttpConfig.CommonHttpAddrConfig)
+	mergeCommonHttpAddrConfig(&config.Httpfmp4Config.CommonHttpAddrConfig, &config.DefaultHttpConfig.CommonHttpAddrConfig)
 	mergeCommonHttpAddrConfig(&config.HlsConfig.CommonHttpAddrConfig, &config.DefaultHttpConfig.CommonHttpAddrConfig)
+	mergeCommonHttpAddrConfig(&config.DashConfig.CommonHttpAddrConfig, &config.DefaultHttpConfig.CommonHttpAddrConfig)
 
 	// 为缺失的字段中的一些特定字段，设置特定默认值
 	if config.HlsConfig.Enable && !j.Exist("hls.cleanup_mode") {
@@ -285,18 +412,34 @@ func LoadConfAndInitLog(confFile string) *Config {
 			config.HlsConfig.FragmentNum)
 		config.HlsConfig.DeleteThreshold = config.HlsConfig.FragmentNum
 	}
+	if config.HlsConfig.EncryptMethod != hls.EncryptMethodNone && config.HlsConfig.KeyFile == "" && config.HlsConfig.KeyServerUrl == "" {
+		Log.Warnf("config hls.encrypt_method is %s but neither hls.key_file nor hls.key_server_url exist. hls fragments will not be generated",
+			config.HlsConfig.EncryptMethod)
+	}
 	if (config.HttpflvConfig.Enable || config.HttpflvConfig.EnableHttps) && !j.Exist("httpflv.url_pattern") {
 		Log.Warnf("config httpflv.url_pattern not exist. set to default wchich is %s", defaultHttpflvUrlPattern)
 		config.HttpflvConfig.UrlPattern = defaultHttpflvUrlPattern
 	}
+	if (config.HttpflvVodConfig.Enable || config.HttpflvVodConfig.EnableHttps) && !j.Exist("httpflv_vod.url_pattern") {
+		Log.Warnf("config httpflv_vod.url_pattern not exist. set to default wchich is %s", defaultHttpflvVodUrlPattern)
+		config.HttpflvVodConfig.UrlPattern = defaultHttpflvVodUrlPattern
+	}
 	if (config.HttptsConfig.Enable || config.HttptsConfig.EnableHttps) && !j.Exist("httpts.url_pattern") {
 		Log.Warnf("config httpts.url_pattern not exist. set to default wchich is %s", defaultHttptsUrlPattern)
 		config.HttptsConfig.UrlPattern = defaultHttptsUrlPattern
 	}
+	if (config.Httpfmp4Config.Enable || config.Httpfmp4Config.EnableHttps) && !j.Exist("httpfmp4.url_pattern") {
+		Log.Warnf("config httpfmp4.url_pattern not exist. set to default wchich is %s", defaultHttpfmp4UrlPattern)
+		config.Httpfmp4Config.UrlPattern = defaultHttpfmp4UrlPattern
+	}
 	if (config.HlsConfig.Enable || config.HlsConfig.EnableHttps) && !j.Exist("hls.url_pattern") {
 		Log.Warnf("config hls.url_pattern not exist. set to default wchich is %s", defaultHlsUrlPattern)
 		config.HttpflvConfig.UrlPattern = defaultHlsUrlPattern
 	}
+	if (config.DashConfig.Enable || config.DashConfig.EnableHttps) && !j.Exist("dash.url_pattern") {
+		Log.Warnf("config dash.url_pattern not exist. set to default wchich is %s", defaultDashUrlPattern)
+		config.DashConfig.UrlPattern = defaultDashUrlPattern
+	}
 
 	// 对一些常见的格式错误做修复
 	// 确保url pattern以`/`开始，并以`/`结束
@@ -304,14 +447,26 @@ func LoadConfAndInitLog(confFile string) *Config {
 		Log.Warnf("fix config. httpflv.url_pattern %s -> %s", config.HttpflvConfig.UrlPattern, urlPattern)
 		config.HttpflvConfig.UrlPattern = urlPattern
 	}
+	if urlPattern, changed := ensureStartAndEndWithSlash(config.HttpflvVodConfig.UrlPattern); changed {
+		Log.Warnf("fix config. httpflv_vod.url_pattern %s -> %s", config.HttpflvVodConfig.UrlPattern, urlPattern)
+		config.HttpflvVodConfig.UrlPattern = urlPattern
+	}
 	if urlPattern, changed := ensureStartAndEndWithSlash(config.HttptsConfig.UrlPattern); changed {
 		Log.Warnf("fix config. httpts.url_pattern %s -> %s", config.HttptsConfig.UrlPattern, urlPattern)
 		config.HttpflvConfig.UrlPattern = urlPattern
 	}
+	if urlPattern, changed := ensureStartAndEndWithSlash(config.Httpfmp4Config.UrlPattern); changed {
+		Log.Warnf("fix config. httpfmp4.url_pattern %s -> %s", config.Httpfmp4Config.UrlPattern, urlPattern)
+		config.Httpfmp4Config.UrlPattern = urlPattern
+	}
 	if urlPattern, changed := ensureStartAndEndWithSlash(config.HlsConfig.UrlPattern); changed {
 		Log.Warnf("fix config. hls.url_pattern %s -> %s", config.HlsConfig.UrlPattern, urlPattern)
 		config.HttpflvConfig.UrlPattern = urlPattern
 	}
+	if urlPattern, changed := ensureStartAndEndWithSlash(config.DashConfig.UrlPattern); changed {
+		Log.Warnf("fix config. dash.url_pattern %s -> %s", config.DashConfig.UrlPattern, urlPattern)
+		config.DashConfig.UrlPattern = urlPattern
+	}
 
 	// 打印配置文件中的元素内容，以及解析后的最终值
 	// 把配置文件原始内容中的换行去掉，使得打印日志时紧凑一些
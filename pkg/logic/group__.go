@@ -13,10 +13,13 @@ import (
 	"github.com/q191201771/lal/pkg/gb28181"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/dash"
 	"github.com/q191201771/lal/pkg/hls"
 	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/httpfmp4"
 	"github.com/q191201771/lal/pkg/httpts"
 	"github.com/q191201771/lal/pkg/mpegts"
 	"github.com/q191201771/lal/pkg/remux"
@@ -56,6 +59,7 @@ import (
 // rtspPubSession -> OnRtpPacket -> rtsp
 //                -> OnAvPacket -> rtsp2RtmpRemuxer -> onRtmpMsgFromRemux -> broadcastByRtmpMsg -> rtmp
 //                                                                                              -> http-flv, ts, hls
+//                                                                                              -> [seiRtpRepacker] -> rtsp（视频轨道）
 //
 // ---------------------------------------------------------------------------------------------------------------------
 // psPubSession -> OnAvPacketFromPsPubSession -> rtsp2RtmpRemuxer -> onRtmpMsgFromRemux -> broadcastByRtmpMsg -> rtmp2RtspRemuxer -> rtsp
@@ -64,9 +68,13 @@ import (
 
 type IGroupObserver interface {
 	CleanupHlsIfNeeded(appName string, streamName string, path string)
+	CleanupDashIfNeeded(appName string, streamName string, path string)
 	OnHlsMakeTs(info base.HlsMakeTsInfo)
+	OnOnvifMetadata(info base.OnvifMetadataInfo)
 	OnRelayPullStart(info base.PullStartInfo) // TODO(chef): refactor me
 	OnRelayPullStop(info base.PullStopInfo)
+	OnDelHlsSubSession(info base.SubStopInfo) // hls播放者超时或者被踢掉
+	OnRecordFileDone(info base.RecordFileDoneInfo)
 }
 
 type Group struct {
@@ -75,6 +83,7 @@ type Group struct {
 	streamName string // const after init TODO chef: 和stat里的字段重复，可以删除掉
 	config     *Config
 	observer   IGroupObserver
+	seiHandler ISeiHandler // const after init
 
 	exitChan chan struct{}
 
@@ -86,11 +95,14 @@ type Group struct {
 	psPubSession        *gb28181.PubSession
 	rtsp2RtmpRemuxer    *remux.AvPacket2RtmpRemuxer // TODO(chef): [refactor] 重命名为avPacket2RtmpRemuxer，因为除了rtsp，customize pub和gb28181 pub都是 202208
 	rtmp2RtspRemuxer    *remux.Rtmp2RtspRemuxer
+	seiRtpRepacker      *seiRtpRepacker // 输入为rtsp并且需要注入SEI时使用，见 group__sei.go
 	rtmp2MpegtsRemuxer  *remux.Rtmp2MpegtsRemuxer
 	// pull
 	pullProxy *pullProxy
 	// rtmp pub使用
-	dummyAudioFilter *remux.DummyAudioFilter
+	dummyAudioFilter      *remux.DummyAudioFilter
+	rtmpCaptureClock      base.CaptureClock
+	rtmpCaptureClockByPub bool // rtmpCaptureClock的锚点是否来自推流端的`onFI`
 	// ps pub使用
 	psPubTimeoutSec            uint32 // 超时时间
 	psPubPrevInactiveCheckTick int64  // 上次检查时间
@@ -101,24 +113,44 @@ type Group struct {
 	httpflvGopCache *remux.GopCache
 	// httpts sub使用
 	httptsGopCache *remux.GopCacheMpegts
+	// httpfmp4 sub使用
+	httpfmp4Streamer *httpfmp4Streamer
 	// rtsp使用
 	sdpCtx *sdp.LogicContext
 	// mpegts使用
-	patpmt []byte
+	patpmt       []byte
+	cuePointMsgs []base.RtmpMsg // 由SCTE-35信令转换得到的onCuePoint消息，等待广播，见 group__scte35.go
 	// sub
 	rtmpSubSessionSet     map[*rtmp.ServerSession]struct{}
 	httpflvSubSessionSet  map[*httpflv.SubSession]struct{}
 	httptsSubSessionSet   map[*httpts.SubSession]struct{}
+	httpfmp4SubSessionSet map[*httpfmp4.SubSession]struct{}
 	rtspSubSessionSet     map[*rtsp.SubSession]struct{}
 	waitRtspSubSessionSet map[*rtsp.SubSession]struct{}
+	hlsSubSessionSet      map[string]*hls.SubSession // key: 播放者标识，见 hls.GetSubSessionKey
+	hlsKickedSubSessions  map[string]int64           // 被踢掉的hls播放者，在超时时间内不允许再次播放。key: 播放者标识, value: 过期时间，单位毫秒
 	// push
 	pushEnable    bool
 	url2PushProxy map[string]*pushProxy
 	// hls
-	hlsMuxer *hls.Muxer
+	hlsMuxer         *hls.Muxer
+	hlsStartTime     time.Time
+	rtmp2Fmp4Remuxer *remux.Rtmp2Fmp4Remuxer // hls分片格式为fmp4时使用
+	videoCodecString string                  // RFC 6381格式，hls多码率分组的master playlist使用
+	audioCodecString string                  //
+	// dash
+	dashMuxer *dash.Muxer
 	// record
-	recordFlv    *httpflv.FlvFileWriter
-	recordMpegts *mpegts.FileWriter
+	recordHeaders            rtmpHeaderCache
+	recordFlv                *httpflv.FlvFileWriter
+	recordFlvSegment         *recordSegment
+	recordFlvWaitKeyFrame    bool // 中途开启录制时，从视频关键帧开始写入
+	recordMpegts             *mpegts.FileWriter
+	recordMpegtsSegment      *recordSegment
+	recordMpegtsWaitBoundary bool
+	recordMp4                *recordMp4
+	recordPaused             bool                   // 磁盘空间不足时暂停录制，见 PauseRecord
+	recordCtrls              map[string]*recordCtrl // 通过http api开启的录制，key为录制格式
 	// rtmp sub使用
 	rtmpMergeWriter *base.MergeWriter // TODO(chef): 后面可以在业务层加一个定时Flush
 	//
@@ -141,8 +173,11 @@ func NewGroup(appName string, streamName string, config *Config, observer IGroup
 		rtmpSubSessionSet:          make(map[*rtmp.ServerSession]struct{}),
 		httpflvSubSessionSet:       make(map[*httpflv.SubSession]struct{}),
 		httptsSubSessionSet:        make(map[*httpts.SubSession]struct{}),
+		httpfmp4SubSessionSet:      make(map[*httpfmp4.SubSession]struct{}),
 		rtspSubSessionSet:          make(map[*rtsp.SubSession]struct{}),
 		waitRtspSubSessionSet:      make(map[*rtsp.SubSession]struct{}),
+		hlsSubSessionSet:           make(map[string]*hls.SubSession),
+		hlsKickedSubSessions:       make(map[string]int64),
 		rtmpGopCache:               remux.NewGopCache("rtmp", uk, config.RtmpConfig.GopNum),
 		httpflvGopCache:            remux.NewGopCache("httpflv", uk, config.HttpflvConfig.GopNum),
 		httptsGopCache:             remux.NewGopCacheMpegts(uk, config.HttptsConfig.GopNum),
@@ -182,6 +217,8 @@ func (group *Group) Tick(tickCount uint32) {
 	if tickCount%calcSessionStatIntervalSec == 0 {
 		group.updateAllSessionStat()
 	}
+
+	group.tickRecordCtrls()
 }
 
 // Dispose ...
@@ -226,6 +263,18 @@ func (group *Group) Dispose() {
 	}
 	group.httptsSubSessionSet = nil
 
+	for session := range group.httpfmp4SubSessionSet {
+		session.Dispose()
+	}
+	group.httpfmp4SubSessionSet = nil
+
+	// hls播放者由group通知上层结束
+	for _, session := range group.hlsSubSessionSet {
+		_ = session.Dispose()
+		group.delHlsSubSession(session)
+	}
+	group.hlsSubSessionSet = nil
+
 	group.delIn()
 }
 
@@ -277,6 +326,13 @@ func (group *Group) GetStat(maxsub int) base.StatGroup {
 		}
 		group.stat.StatSubs = append(group.stat.StatSubs, base.Session2StatSub(s))
 	}
+	for s := range group.httpfmp4SubSessionSet {
+		statSubCount++
+		if statSubCount > maxsub {
+			break
+		}
+		group.stat.StatSubs = append(group.stat.StatSubs, base.Session2StatSub(s))
+	}
 	for s := range group.rtspSubSessionSet {
 		statSubCount++
 		if statSubCount > maxsub {
@@ -291,6 +347,15 @@ func (group *Group) GetStat(maxsub int) base.StatGroup {
 		}
 		group.stat.StatSubs = append(group.stat.StatSubs, base.Session2StatSub(s))
 	}
+	for _, s := range group.hlsSubSessionSet {
+		statSubCount++
+		if statSubCount > maxsub {
+			break
+		}
+		group.stat.StatSubs = append(group.stat.StatSubs, base.Session2StatSub(s))
+	}
+
+	group.stat.StatRecords = group.getStatRecords()
 
 	return group.stat
 }
@@ -339,6 +404,13 @@ func (group *Group) KickSession(sessionId string) bool {
 				return true
 			}
 		}
+	} else if strings.HasPrefix(sessionId, base.UkPreFmp4SubSession) {
+		for s := range group.httpfmp4SubSessionSet {
+			if s.UniqueKey() == sessionId {
+				s.Dispose()
+				return true
+			}
+		}
 	} else if strings.HasPrefix(sessionId, base.UkPreRtspSubSession) {
 		for s := range group.rtspSubSessionSet {
 			if s.UniqueKey() == sessionId {
@@ -352,6 +424,15 @@ func (group *Group) KickSession(sessionId string) bool {
 				return true
 			}
 		}
+	} else if strings.HasPrefix(sessionId, base.UkPreHlsSubSession) {
+		for _, s := range group.hlsSubSessionSet {
+			if s.UniqueKey() == sessionId {
+				_ = s.Dispose()
+				group.hlsKickedSubSessions[s.Key()] = time.Now().UnixNano()/1e6 + int64(group.config.HlsConfig.SubSessionTimeoutMs)
+				group.delHlsSubSession(s)
+				return true
+			}
+		}
 	} else {
 		Log.Errorf("[%s] kick session while session id format invalid. %s", group.UniqueKey, sessionId)
 	}
@@ -378,8 +459,6 @@ func (group *Group) HasOutSession() bool {
 }
 
 func (group *Group) OutSessionNum() int {
-	// TODO(chef): 没有包含hls的播放者
-
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
 
@@ -391,7 +470,8 @@ func (group *Group) OutSessionNum() int {
 		}
 	}
 	return len(group.rtmpSubSessionSet) + len(group.rtspSubSessionSet) + len(group.waitRtspSubSessionSet) +
-		len(group.httpflvSubSessionSet) + len(group.httptsSubSessionSet) + pushNum
+		len(group.httpflvSubSessionSet) + len(group.httptsSubSessionSet) + len(group.httpfmp4SubSessionSet) +
+		len(group.hlsSubSessionSet) + pushNum
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -470,6 +550,26 @@ func (group *Group) disposeInactiveSessions(tickCount uint32) {
 			session.Dispose()
 		}
 	}
+	for session := range group.httpfmp4SubSessionSet {
+		if _, writeAlive := session.IsAlive(); !writeAlive {
+			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, session.UniqueKey())
+			session.Dispose()
+		}
+	}
+	// hls没有长连接，超时后直接从group中删除
+	for _, session := range group.hlsSubSessionSet {
+		if _, writeAlive := session.IsAlive(); !writeAlive {
+			Log.Warnf("[%s] session timeout. session=%s", group.UniqueKey, session.UniqueKey())
+			_ = session.Dispose()
+			group.delHlsSubSession(session)
+		}
+	}
+	nowMs := time.Now().UnixNano() / 1e6
+	for key, expireMs := range group.hlsKickedSubSessions {
+		if nowMs >= expireMs {
+			delete(group.hlsKickedSubSessions, key)
+		}
+	}
 	for _, item := range group.url2PushProxy {
 		session := item.pushSession
 		if item.isPushing && session != nil {
@@ -505,12 +605,18 @@ func (group *Group) updateAllSessionStat() {
 	for session := range group.httptsSubSessionSet {
 		session.UpdateStat(calcSessionStatIntervalSec)
 	}
+	for session := range group.httpfmp4SubSessionSet {
+		session.UpdateStat(calcSessionStatIntervalSec)
+	}
 	for session := range group.rtspSubSessionSet {
 		session.UpdateStat(calcSessionStatIntervalSec)
 	}
 	for session := range group.waitRtspSubSessionSet {
 		session.UpdateStat(calcSessionStatIntervalSec)
 	}
+	for _, session := range group.hlsSubSessionSet {
+		session.UpdateStat(calcSessionStatIntervalSec)
+	}
 	for _, item := range group.url2PushProxy {
 		session := item.pushSession
 		if item.isPushing && session != nil {
@@ -528,8 +634,10 @@ func (group *Group) hasSubSession() bool {
 	return len(group.rtmpSubSessionSet) != 0 ||
 		len(group.httpflvSubSessionSet) != 0 ||
 		len(group.httptsSubSessionSet) != 0 ||
+		len(group.httpfmp4SubSessionSet) != 0 ||
 		len(group.rtspSubSessionSet) != 0 ||
-		len(group.waitRtspSubSessionSet) != 0
+		len(group.waitRtspSubSessionSet) != 0 ||
+		len(group.hlsSubSessionSet) != 0
 }
 
 func (group *Group) hasPushSession() bool {
@@ -575,7 +683,7 @@ func (group *Group) shouldStartRtspRemuxer() bool {
 }
 
 func (group *Group) shouldStartMpegtsRemuxer() bool {
-	return (group.config.HlsConfig.Enable || group.config.HlsConfig.EnableHttps) ||
+	return ((group.config.HlsConfig.Enable || group.config.HlsConfig.EnableHttps) && !group.isHlsFmp4()) ||
 		(group.config.HttptsConfig.Enable || group.config.HttptsConfig.EnableHttps) ||
 		group.config.RecordConfig.EnableMpegts
 }
@@ -17,6 +17,7 @@ import (
 
 	"github.com/q191201771/lal/pkg/avc"
 	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
 	"github.com/q191201771/lal/pkg/hevc"
 	"github.com/q191201771/lal/pkg/remux"
 	"github.com/q191201771/lal/pkg/rtprtcp"
@@ -38,6 +39,17 @@ import (
 func (group *Group) OnReadRtmpAvMsg(msg base.RtmpMsg) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
+	// 上游没有携带采集时间的（比如rtmp pub），优先使用推流端在`onFI`中携带的系统时间作为锚点，
+	// 收到`onFI`之前，或者推流端不发送`onFI`时，使用首个消息到达时的本地时间作为锚点推算
+	if msg.Header.MsgTypeId == base.RtmpTypeIdMetadata && !group.rtmpCaptureClockByPub {
+		if unixMs, ok := rtmp.ParseOnFiWallTime(msg.Payload); ok {
+			group.rtmpCaptureClock.Anchor(int64(msg.Header.TimestampAbs), unixMs)
+			group.rtmpCaptureClockByPub = true
+		}
+	}
+	if msg.CaptureTimeMs == 0 && !msg.IsVideoKeySeqHeader() && !msg.IsAacSeqHeader() {
+		msg.CaptureTimeMs = group.rtmpCaptureClock.Calc(int64(msg.Header.TimestampAbs))
+	}
 	group.broadcastByRtmpMsg(msg)
 }
 
@@ -62,6 +74,11 @@ func (group *Group) OnSdp(sdpCtx sdp.LogicContext) {
 func (group *Group) OnRtpPacket(pkt rtprtcp.RtpPacket) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
+	// 需要注入SEI时，输入的视频rtp被丢弃，由 seiRtpRepacker 使用注入后的视频帧重新打包
+	if group.seiRtpRepacker != nil && group.sdpCtx != nil && group.sdpCtx.IsVideoPayloadTypeOrigin(int(pkt.Header.PacketType)) {
+		group.seiRtpRepacker.onInputVideoRtp(group.sdpCtx, pkt)
+		return
+	}
 	group.feedRtpPacket(pkt)
 }
 
@@ -78,6 +95,26 @@ func (group *Group) OnAvPacket(pkt base.AvPacket) {
 	}
 }
 
+// OnOnvifMetadata
+//
+// rtsp输入流中onvif metadata数据轨道合包后的xml文档。
+// 来自 rtsp.PullSession 或 rtsp.PubSession 的回调，见 rtsp.IOnvifMetadataObserver 。
+//
+func (group *Group) OnOnvifMetadata(timestamp int64, b []byte) {
+	group.mutex.Lock()
+	info := base.OnvifMetadataInfo{
+		SessionId:  group.inSessionUniqueKey(),
+		AppName:    group.appName,
+		StreamName: group.streamName,
+		Timestamp:  timestamp,
+		Metadata:   string(b),
+	}
+	group.mutex.Unlock()
+
+	// 注意，在锁外回调，避免业务方在回调中调用 ILalServer 的接口导致死锁
+	group.observer.OnOnvifMetadata(info)
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 // OnAvPacketFromPsPubSession
@@ -105,15 +142,11 @@ func (group *Group) OnAvPacketFromPsPubSession(pkt *base.AvPacket) {
 func (group *Group) OnPatPmt(b []byte) {
 	group.patpmt = b
 
-	if group.hlsMuxer != nil {
+	if group.hlsMuxer != nil && !group.isHlsFmp4() {
 		group.hlsMuxer.FeedPatPmt(b)
 	}
 
-	if group.recordMpegts != nil {
-		if err := group.recordMpegts.Write(b); err != nil {
-			Log.Errorf("[%s] record mpegts write fragment header error. err=%+v", group.UniqueKey, err)
-		}
-	}
+	group.writeRecordMpegts(b, nil)
 }
 
 // OnTsPackets ...
@@ -121,6 +154,17 @@ func (group *Group) OnTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary
 	group.feedTsPackets(tsPackets, frame, boundary)
 }
 
+// InjectTimedMetadata 注入定时元数据，见 remux.Rtmp2MpegtsRemuxer.FeedTimedMetadata
+//
+func (group *Group) InjectTimedMetadata(description string, value string) bool {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	if group.rtmp2MpegtsRemuxer == nil {
+		return false
+	}
+	return group.rtmp2MpegtsRemuxer.FeedTimedMetadata(description, value)
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 // onRtmpMsgFromRemux
@@ -156,7 +200,9 @@ func (group *Group) onRtpPacketFromRemux(pkt rtprtcp.RtpPacket) {
 // 来自 hls.Muxer 的回调
 //
 func (group *Group) OnFragmentOpen() {
-	group.rtmp2MpegtsRemuxer.FlushAudio()
+	if group.rtmp2MpegtsRemuxer != nil {
+		group.rtmp2MpegtsRemuxer.FlushAudio()
+	}
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -177,6 +223,11 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 		nazalog.Debugf("[%s] metadata. err=%+v, len=%d, value=%s", group.UniqueKey, err, len(m), m.DebugString())
 	}
 
+	// 在所有输出之前读取以及注入SEI，保证各协议的输出一致
+	if group.shouldHandleSei() && msg.Header.MsgTypeId == base.RtmpTypeIdVideo && len(msg.Payload) > 0 {
+		msg = group.handleSei(msg)
+	}
+
 	var (
 		lazyRtmpChunkDivider remux.LazyRtmpChunkDivider
 		lazyRtmpMsg2FlvTag   remux.LazyRtmpMsg2FlvTag
@@ -217,15 +268,40 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 	//	}
 	//}
 
+	// # 缓存录制文件的头部信息
+	group.recordHeaders.feed(msg)
+
 	// # mpegts remuxer
 	if group.rtmp2MpegtsRemuxer != nil {
 		group.rtmp2MpegtsRemuxer.FeedRtmpMessage(msg)
+		// 在视频关键帧之前，广播由SCTE-35信令转换得到的onCuePoint
+		group.broadcastCuePoints()
+	}
+
+	// # fmp4 remuxer
+	if group.rtmp2Fmp4Remuxer != nil {
+		group.rtmp2Fmp4Remuxer.FeedRtmpMessage(msg)
+	}
+
+	// # httpfmp4
+	if group.httpfmp4Streamer != nil {
+		group.httpfmp4Streamer.FeedRtmpMessage(msg)
+	}
+
+	// # dash
+	if group.dashMuxer != nil {
+		group.dashMuxer.FeedRtmpMessage(msg)
 	}
 
 	// # rtsp
 	if group.rtmp2RtspRemuxer != nil {
 		group.rtmp2RtspRemuxer.FeedRtmpMsg(msg)
 	}
+	if group.seiRtpRepacker != nil {
+		for _, pkt := range group.seiRtpRepacker.pack(msg) {
+			group.feedRtpPacket(pkt)
+		}
+	}
 
 	// # 广播。遍历所有 rtmp sub session，转发数据
 	// ## 如果是新的 sub session，发送已缓存的信息
@@ -357,21 +433,25 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 
 	// # 录制flv文件
 	if group.recordFlv != nil {
-		if err := group.recordFlv.WriteRaw(lazyRtmpMsg2FlvTag.GetEnsureWithoutSdf()); err != nil {
-			Log.Errorf("[%s] record flv write error. err=%+v", group.UniqueKey, err)
-		}
+		group.feedRecordFlv(msg, lazyRtmpMsg2FlvTag.GetEnsureWithoutSdf())
+	}
+
+	// # 录制mp4文件
+	if group.recordMp4 != nil {
+		group.recordMp4.FeedRtmpMessage(msg)
 	}
 
 	// # 缓存关键信息，以及gop
 	if group.config.RtmpConfig.Enable {
 		group.rtmpGopCache.Feed(msg, lazyRtmpChunkDivider.GetEnsureWithoutSdf())
-		if msg.Header.MsgTypeId == base.RtmpTypeIdMetadata {
+		if msg.Header.MsgTypeId == base.RtmpTypeIdMetadata && rtmp.IsStreamMetadata(msg.Payload) {
 			group.rtmpGopCache.SetMetadata(lazyRtmpChunkDivider.GetEnsureWithSdf(), lazyRtmpChunkDivider.GetEnsureWithoutSdf())
 		}
 	}
 	if group.config.HttpflvConfig.Enable {
 		group.httpflvGopCache.Feed(msg, lazyRtmpMsg2FlvTag.GetEnsureWithoutSdf())
-		if msg.Header.MsgTypeId == base.RtmpTypeIdMetadata {
+		// 注意，`onTextData`、`onCuePoint`等数据消息只实时转发，不覆盖缓存的metadata
+		if msg.Header.MsgTypeId == base.RtmpTypeIdMetadata && rtmp.IsStreamMetadata(msg.Payload) {
 			// 注意，因为withSdf实际上用不上，而且我们也没实现，所以全部用without了
 			group.httpflvGopCache.SetMetadata(lazyRtmpMsg2FlvTag.GetEnsureWithoutSdf(), lazyRtmpMsg2FlvTag.GetEnsureWithoutSdf())
 		}
@@ -415,11 +495,44 @@ func (group *Group) broadcastByRtmpMsg(msg base.RtmpMsg) {
 			}
 		}
 	}
+	if msg.IsVideoKeySeqHeader() && len(msg.Payload) > 5 {
+		track := fmp4.VideoTrack{
+			Codec:                      base.VideoCodecAvc,
+			DecoderConfigurationRecord: msg.Payload[5:],
+		}
+		if msg.IsHevcKeySeqHeader() {
+			track.Codec = base.VideoCodecHevc
+		}
+		group.videoCodecString = track.CodecString()
+	}
+	if msg.IsAacSeqHeader() && len(msg.Payload) > 2 {
+		track := fmp4.AudioTrack{
+			Asc: msg.Payload[2:],
+		}
+		group.audioCodecString = track.CodecString()
+	}
+	if !group.stat.HasClosedCaptions && remux.RtmpMsgHasClosedCaptions(msg) {
+		Log.Infof("[%s] closed captions detected.", group.UniqueKey)
+		group.stat.HasClosedCaptions = true
+		if group.hlsMuxer != nil {
+			group.hlsMuxer.SetHasClosedCaptions(true)
+		}
+	}
 }
 
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (group *Group) feedRtpPacket(pkt rtprtcp.RtpPacket) {
+	// onvif metadata数据轨道，不参与关键帧判断，只发送给已经开始发送音视频数据的sub session
+	if group.sdpCtx != nil && group.sdpCtx.IsMetadataPayloadTypeOrigin(int(pkt.Header.PacketType)) {
+		for s := range group.rtspSubSessionSet {
+			if !group.config.RtspConfig.OutWaitKeyFrameFlag || !s.ShouldWaitVideoKeyFrame {
+				s.WriteRtpPacket(pkt)
+			}
+		}
+		return
+	}
+
 	// 如果配置项 OutWaitKeyFrameFlag 为false，则音频和视频都直接发送。（音频和视频都不等待视频关键帧，都不等待任何数据）
 	if !group.config.RtspConfig.OutWaitKeyFrameFlag {
 		for s := range group.rtspSubSessionSet {
@@ -467,8 +580,12 @@ func (group *Group) feedRtpPacket(pkt rtprtcp.RtpPacket) {
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (group *Group) feedTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	if frame.Pid == mpegts.PidScte35 {
+		group.cacheCuePoint(frame)
+	}
+
 	// 注意，hls的处理放在前面，让hls先判断是否打开新的fragment并flush audio
-	if group.hlsMuxer != nil {
+	if group.hlsMuxer != nil && !group.isHlsFmp4() {
 		group.hlsMuxer.FeedMpegts(tsPackets, frame, boundary)
 	}
 
@@ -511,9 +628,7 @@ func (group *Group) feedTsPackets(tsPackets []byte, frame *mpegts.Frame, boundar
 	} // for loop iterate httptsSubSessionSet
 
 	if group.recordMpegts != nil {
-		if err := group.recordMpegts.Write(tsPackets); err != nil {
-			Log.Errorf("[%s] record mpegts write error. err=%+v", group.UniqueKey, err)
-		}
+		group.feedRecordMpegts(tsPackets, frame, boundary)
 	}
 
 	group.httptsGopCache.Feed(tsPackets, boundary)
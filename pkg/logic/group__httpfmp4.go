@@ -0,0 +1,115 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/remux"
+)
+
+// startHttpfmp4IfNeeded 必要时开启httpfmp4
+//
+func (group *Group) startHttpfmp4IfNeeded() {
+	if !group.config.Httpfmp4Config.Enable && !group.config.Httpfmp4Config.EnableHttps {
+		return
+	}
+
+	group.httpfmp4Streamer = newHttpfmp4Streamer(group)
+}
+
+func (group *Group) stopHttpfmp4IfNeeded() {
+	if group.httpfmp4Streamer != nil {
+		group.httpfmp4Streamer.Dispose()
+		group.httpfmp4Streamer = nil
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// httpfmp4Streamer 将rtmp流转换为fmp4，分发给 httpfmp4.SubSession
+//
+// 新加入的播放者先收到初始化分片，然后是GOP缓存中的媒体分片，之后实时转发。
+// 编码参数发生变化时（生成新的初始化分片），清空GOP缓存，所有播放者重新收到初始化分片，并从下一个视频关键帧开始转发
+//
+type httpfmp4Streamer struct {
+	group *Group
+
+	remuxer     *remux.Rtmp2Fmp4Remuxer
+	initSegment []byte
+	gopCache    *remux.GopCacheMpegts // 复用mpegts的GOP缓存，缓存的是fmp4的媒体分片
+}
+
+func newHttpfmp4Streamer(group *Group) *httpfmp4Streamer {
+	s := &httpfmp4Streamer{
+		group:    group,
+		gopCache: remux.NewGopCacheMpegts(group.UniqueKey, group.config.Httpfmp4Config.GopNum),
+	}
+	s.remuxer = remux.NewRtmp2Fmp4Remuxer(s).
+		WithFragmentDurationMs(uint32(group.config.Httpfmp4Config.FragmentDurationMs))
+	return s
+}
+
+func (s *httpfmp4Streamer) FeedRtmpMessage(msg base.RtmpMsg) {
+	s.remuxer.FeedRtmpMessage(msg)
+}
+
+func (s *httpfmp4Streamer) Dispose() {
+	s.remuxer.Dispose()
+	s.gopCache.Clear()
+	s.initSegment = nil
+}
+
+// OnFmp4InitSegment OnFmp4Fragment
+//
+// 实现 remux.IRtmp2Fmp4RemuxerObserver
+//
+func (s *httpfmp4Streamer) OnFmp4InitSegment(b []byte) {
+	s.initSegment = b
+	s.gopCache.Clear()
+
+	// 还未收到过初始化分片的新加入者，在收到媒体分片时再处理
+	for session := range s.group.httpfmp4SubSessionSet {
+		if session.IsFresh {
+			continue
+		}
+		session.Write(b)
+		session.ShouldWaitBoundary = true
+	}
+}
+
+func (s *httpfmp4Streamer) OnFmp4Fragment(b []byte, startTs uint64, endTs uint64, boundary bool) {
+	for session := range s.group.httpfmp4SubSessionSet {
+		if session.IsFresh {
+			// 发送初始化分片，以及GOP缓存
+			session.Write(s.initSegment)
+
+			gopCount := s.gopCache.GetGopCount()
+			for i := 0; i < gopCount; i++ {
+				for _, item := range s.gopCache.GetGopDataAt(i) {
+					session.Write(item)
+				}
+			}
+			if gopCount > 0 {
+				session.ShouldWaitBoundary = false
+			}
+
+			session.IsFresh = false
+		}
+
+		if session.ShouldWaitBoundary {
+			if !boundary {
+				continue
+			}
+			session.ShouldWaitBoundary = false
+		}
+		session.Write(b)
+	}
+
+	s.gopCache.Feed(b, boundary)
+}
@@ -0,0 +1,132 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
+	"github.com/q191201771/lal/pkg/httpfmp4"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+// bufConn 只记录写入的数据
+//
+type bufConn struct {
+	net.Conn
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (c *bufConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.buf.Write(b)
+}
+
+func (c *bufConn) Close() error {
+	return nil
+}
+
+func (c *bufConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *bufConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// body 跳过http header，返回fmp4的各个box的类型
+//
+func (c *bufConn) body(t *testing.T) (header string, boxes []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	b := c.buf.Bytes()
+	i := bytes.Index(b, []byte("\r\n\r\n"))
+	assert.Equal(t, true, i > 0)
+	err := fmp4.IterateBox(b[i+4:], func(typ string, payload []byte) {
+		boxes = append(boxes, typ)
+	})
+	assert.Equal(t, nil, err)
+	return string(b[:i]), boxes
+}
+
+func TestGroupHttpfmp4(t *testing.T) {
+	origin := httpfmp4.SubSessionWriteChanSize
+	httpfmp4.SubSessionWriteChanSize = 0
+	defer func() {
+		httpfmp4.SubSessionWriteChanSize = origin
+	}()
+
+	config := &Config{}
+	config.Httpfmp4Config.Enable = true
+	config.Httpfmp4Config.GopNum = 1
+	group := NewGroup("live", "test", config, nil)
+
+	newSub := func() (*httpfmp4.SubSession, *bufConn) {
+		conn := &bufConn{}
+		urlCtx, err := base.ParseUrl("http://127.0.0.1:8080/live/test.mp4", 80)
+		assert.Equal(t, nil, err)
+		session := httpfmp4.NewSubSession(conn, urlCtx, false, "")
+		assert.Equal(t, "test", session.StreamName())
+		group.AddHttpfmp4SubSession(session)
+		return session, conn
+	}
+
+	// 先于推流加入
+	_, conn1 := newSub()
+
+	pub, err := group.AddCustomizePubSession("test")
+	assert.Equal(t, nil, err)
+	pub.WithOption(func(option *base.AvPacketStreamOption) {
+		option.AudioFormat = base.AvPacketStreamAudioFormatRawAac
+	})
+	pub.FeedAudioSpecificConfig([]byte{0x12, 0x10})
+	feed := func(from, to int) {
+		for i := from; i < to; i++ {
+			pub.FeedAvPacket(base.AvPacket{
+				PayloadType: base.AvPacketPtAac,
+				Timestamp:   int64(i * 23),
+				Payload:     []byte{0x21, 0x10, 0x04, byte(i)},
+			})
+		}
+	}
+	feed(0, 100)
+
+	header, boxes := conn1.body(t)
+	assert.Equal(t, true, strings.Contains(header, "Content-Type: video/mp4"))
+	assert.Equal(t, true, len(boxes) > 4)
+	assert.Equal(t, []string{"ftyp", "moov", "moof", "mdat"}, boxes[:4])
+	for i := 2; i < len(boxes); i += 2 {
+		assert.Equal(t, "moof", boxes[i])
+		assert.Equal(t, "mdat", boxes[i+1])
+	}
+
+	// 中途加入，先收到初始化分片以及GOP缓存
+	session2, conn2 := newSub()
+	feed(100, 101)
+	_, boxes = conn2.body(t)
+	assert.Equal(t, []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat"}, boxes)
+
+	stat := group.GetStat(10)
+	assert.Equal(t, 2, len(stat.StatSubs))
+	assert.Equal(t, base.SessionProtocolFmp4Str, stat.StatSubs[0].Protocol)
+	assert.Equal(t, true, group.KickSession(session2.UniqueKey()))
+
+	group.DelHttpfmp4SubSession(session2)
+	assert.Equal(t, 1, group.OutSessionNum())
+	group.DelCustomizePubSession(pub)
+	assert.Equal(t, (*httpfmp4Streamer)(nil), group.httpfmp4Streamer)
+}
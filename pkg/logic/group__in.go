@@ -103,6 +103,10 @@ func (group *Group) AddRtspPubSession(session *rtsp.PubSession) error {
 	group.addIn()
 
 	group.rtsp2RtmpRemuxer = remux.NewAvPacket2RtmpRemuxer().WithOnRtmpMsg(group.onRtmpMsgFromRemux)
+	// 需要注入SEI时，rtsp输出的视频轨道使用注入后的数据重新打包，其他轨道依然透传
+	if group.shouldHandleSei() && group.shouldStartRtspRemuxer() {
+		group.seiRtpRepacker = newSeiRtpRepacker()
+	}
 	session.SetObserver(group)
 
 	return nil
@@ -225,6 +229,10 @@ func (group *Group) AddRtspPullSession(session *rtsp.PullSession) error {
 	group.addIn()
 
 	group.rtsp2RtmpRemuxer = remux.NewAvPacket2RtmpRemuxer().WithOnRtmpMsg(group.onRtmpMsgFromRemux)
+	// 需要注入SEI时，rtsp输出的视频轨道使用注入后的数据重新打包，其他轨道依然透传
+	if group.shouldHandleSei() && group.shouldStartRtspRemuxer() {
+		group.seiRtpRepacker = newSeiRtpRepacker()
+	}
 
 	var info base.PullStartInfo
 	info.SessionId = session.UniqueKey()
@@ -368,14 +376,22 @@ func (group *Group) delPullSession(session base.IObject) {
 func (group *Group) addIn() {
 	now := time.Now().Unix()
 
+	group.rtmpCaptureClock.Reset()
+	group.rtmpCaptureClockByPub = false
+
 	if group.shouldStartMpegtsRemuxer() {
-		group.rtmp2MpegtsRemuxer = remux.NewRtmp2MpegtsRemuxer(group)
+		group.rtmp2MpegtsRemuxer = remux.NewRtmp2MpegtsRemuxer(group).
+			WithTimedMetadata(group.config.HlsConfig.TimedMetadataEnable).
+			WithScte35(group.config.HlsConfig.Scte35Enable)
 	}
 
 	group.startPushIfNeeded()
 	group.startHlsIfNeeded()
+	group.startHttpfmp4IfNeeded()
+	group.startDashIfNeeded()
 	group.startRecordFlvIfNeeded(now)
 	group.startRecordMpegtsIfNeeded(now)
+	group.startRecordMp4IfNeeded(now)
 }
 
 // delIn 有pub或pull的输入型session离开时，需要调用该函数
@@ -389,8 +405,12 @@ func (group *Group) delIn() {
 
 	group.stopPushIfNeeded()
 	group.stopHlsIfNeeded()
+	group.stopHttpfmp4IfNeeded()
+	group.stopDashIfNeeded()
 	group.stopRecordFlvIfNeeded()
 	group.stopRecordMpegtsIfNeeded()
+	group.stopRecordMp4IfNeeded()
+	group.stopAllRecordCtrls()
 
 	group.rtmpPubSession = nil
 	group.rtspPubSession = nil
@@ -398,6 +418,7 @@ func (group *Group) delIn() {
 	group.psPubSession = nil
 	group.rtsp2RtmpRemuxer = nil
 	group.rtmp2RtspRemuxer = nil
+	group.seiRtpRepacker = nil
 	group.dummyAudioFilter = nil
 
 	if group.psPubDumpFile != nil {
@@ -409,4 +430,7 @@ func (group *Group) delIn() {
 	group.httptsGopCache.Clear()
 	group.sdpCtx = nil
 	group.patpmt = nil
+	group.recordHeaders.reset()
+	// 下一路输入流重新检测，见 startHls
+	group.stat.HasClosedCaptions = false
 }
@@ -0,0 +1,62 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestGroupDelInResetClosedCaptions(t *testing.T) {
+	group := NewGroup("live", "test", &Config{}, &mockRecordObserver{})
+	_, err := group.AddCustomizePubSession("test")
+	assert.Equal(t, nil, err)
+	group.stat.HasClosedCaptions = true
+
+	// 输入流断开后，不再声明字幕
+	group.delIn()
+	assert.Equal(t, false, group.stat.HasClosedCaptions)
+}
+
+func TestGroupRtmpCaptureClockAnchorByOnFi(t *testing.T) {
+	group := NewGroup("live", "test", &Config{}, &mockRecordObserver{})
+	_, err := group.AddCustomizePubSession("test")
+	assert.Equal(t, nil, err)
+
+	var buf bytes.Buffer
+	_ = rtmp.Amf0.WriteString(&buf, "onFI")
+	_ = rtmp.Amf0.WriteObject(&buf, rtmp.ObjectPairArray{
+		{Key: "sd", Value: "15-07-2022"},
+		{Key: "st", Value: "10:20:30.000"},
+	})
+	pubUnixMs := time.Date(2022, 7, 15, 10, 20, 30, 0, time.Local).UnixNano() / 1e6
+
+	// 推流端携带了系统时间，以推流端的时间为准，而不是本地到达时间
+	group.OnReadRtmpAvMsg(base.RtmpMsg{
+		Header: base.RtmpHeader{
+			MsgLen:       uint32(buf.Len()),
+			MsgTypeId:    base.RtmpTypeIdMetadata,
+			TimestampAbs: 1000,
+		},
+		Payload: buf.Bytes(),
+	})
+	assert.Equal(t, true, group.rtmpCaptureClockByPub)
+	assert.Equal(t, pubUnixMs+2000, group.rtmpCaptureClock.Calc(3000))
+
+	// 输入流重新加入后，重新建立锚点
+	group.delIn()
+	group.addIn()
+	assert.Equal(t, false, group.rtmpCaptureClockByPub)
+	assert.Equal(t, false, group.rtmpCaptureClock.IsAnchored())
+}
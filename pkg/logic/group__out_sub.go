@@ -9,7 +9,10 @@
 package logic
 
 import (
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hls"
 	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/httpfmp4"
 	"github.com/q191201771/lal/pkg/httpts"
 	"github.com/q191201771/lal/pkg/rtmp"
 	"github.com/q191201771/lal/pkg/rtsp"
@@ -60,6 +63,18 @@ func (group *Group) AddHttptsSubSession(session *httpts.SubSession) {
 	group.addSub()
 }
 
+// AddHttpfmp4SubSession ...
+func (group *Group) AddHttpfmp4SubSession(session *httpfmp4.SubSession) {
+	Log.Debugf("[%s] [%s] add httpfmp4 SubSession into group.", group.UniqueKey, session.UniqueKey())
+	session.WriteHttpResponseHeader()
+
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.httpfmp4SubSessionSet[session] = struct{}{}
+
+	group.addSub()
+}
+
 func (group *Group) HandleNewRtspSubSessionDescribe(session *rtsp.SubSession) (ok bool, sdp []byte) {
 	Log.Debugf("[%s] [%s] rtsp sub describe.", group.UniqueKey, session.UniqueKey())
 
@@ -89,6 +104,30 @@ func (group *Group) HandleNewRtspSubSessionPlay(session *rtsp.SubSession) {
 	group.addSub()
 }
 
+// GetHlsSubSession
+//
+// @param key: 播放者标识，见 hls.GetSubSessionKey
+//
+// @return kicked: 该播放者已经被踢掉，并且还在禁止再次播放的时间内
+//
+func (group *Group) GetHlsSubSession(key string) (session *hls.SubSession, kicked bool) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	if _, ok := group.hlsKickedSubSessions[key]; ok {
+		return nil, true
+	}
+	return group.hlsSubSessionSet[key], false
+}
+
+func (group *Group) AddHlsSubSession(session *hls.SubSession) {
+	Log.Debugf("[%s] [%s] add hls SubSession into group.", group.UniqueKey, session.UniqueKey())
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.hlsSubSessionSet[session.Key()] = session
+
+	group.addSub()
+}
+
 func (group *Group) DelRtmpSubSession(session *rtmp.ServerSession) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
@@ -107,6 +146,12 @@ func (group *Group) DelHttptsSubSession(session *httpts.SubSession) {
 	group.delHttptsSubSession(session)
 }
 
+func (group *Group) DelHttpfmp4SubSession(session *httpfmp4.SubSession) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.delHttpfmp4SubSession(session)
+}
+
 func (group *Group) DelRtspSubSession(session *rtsp.SubSession) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
@@ -130,11 +175,28 @@ func (group *Group) delHttptsSubSession(session *httpts.SubSession) {
 	delete(group.httptsSubSessionSet, session)
 }
 
+func (group *Group) delHttpfmp4SubSession(session *httpfmp4.SubSession) {
+	Log.Debugf("[%s] [%s] del httpfmp4 SubSession from group.", group.UniqueKey, session.UniqueKey())
+	delete(group.httpfmp4SubSessionSet, session)
+}
+
 func (group *Group) delRtspSubSession(session *rtsp.SubSession) {
 	Log.Debugf("[%s] [%s] del rtsp SubSession from group.", group.UniqueKey, session.UniqueKey())
 	delete(group.rtspSubSessionSet, session)
 }
 
+// delHlsSubSession hls播放者没有对应的连接，在group内部超时或者被踢掉时删除，并由group通知上层
+//
+func (group *Group) delHlsSubSession(session *hls.SubSession) {
+	Log.Debugf("[%s] [%s] del hls SubSession from group.", group.UniqueKey, session.UniqueKey())
+	delete(group.hlsSubSessionSet, session.Key())
+
+	info := base.Session2SubStopInfo(session)
+	info.HasInSession = group.hasInSession()
+	info.HasOutSession = group.hasOutSession()
+	group.observer.OnDelHlsSubSession(info)
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (group *Group) addSub() {
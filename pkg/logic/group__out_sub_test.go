@@ -0,0 +1,46 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type mockHlsSubObserver struct {
+	IGroupObserver
+	stopped []base.SubStopInfo
+}
+
+func (m *mockHlsSubObserver) OnDelHlsSubSession(info base.SubStopInfo) {
+	m.stopped = append(m.stopped, info)
+}
+
+func TestGroupDisposeHlsSubSession(t *testing.T) {
+	config := &Config{}
+	observer := &mockHlsSubObserver{}
+	group := NewGroup("live", "test", config, observer)
+
+	for _, key := range []string{"a", "b"} {
+		urlCtx, err := base.ParseUrl("http://127.0.0.1:8080/hls/test/playlist.m3u8", 80)
+		assert.Equal(t, nil, err)
+		group.AddHlsSubSession(hls.NewSubSession(key, urlCtx, "test", "127.0.0.1:10000", 30000))
+	}
+
+	// group销毁时，每个hls播放者都通知上层结束
+	group.Dispose()
+	assert.Equal(t, 2, len(observer.stopped))
+	for _, info := range observer.stopped {
+		assert.Equal(t, "test", info.StreamName)
+		assert.Equal(t, base.SessionProtocolHlsStr, info.Protocol)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtmp"
+)
+
+// 录制文件的切片以及命名，flv、ts、mp4录制共用
+//
+// - 文件名由 RecordConfig.FilenameTemplate 生成，可以包含目录，目录不存在时自动创建
+// - 开启 RecordConfig.SegmentDurationSec 或 RecordConfig.SegmentMaxSizeMb 后，在视频关键帧处（纯音频流为任意音频帧处）切换到新的文件
+// - 每个文件写入完成后，回调 IGroupObserver.OnRecordFileDone
+//
+
+const defaultRecordFilenameTemplate = "{stream}-{unix}.{ext}"
+
+// recordSegment 一个正在写入的录制文件
+//
+type recordSegment struct {
+	format    string
+	filename  string // 包含录制目录
+	startTime time.Time
+
+	hasTs   bool
+	startTs uint64 // 单位毫秒
+	endTs   uint64
+	size    int64
+}
+
+func newRecordSegment(format string, filename string) *recordSegment {
+	return &recordSegment{
+		format:    format,
+		filename:  filename,
+		startTime: time.Now(),
+	}
+}
+
+// update 写入数据后调用
+//
+// @param ts: 写入数据的时间戳，单位毫秒
+//
+func (s *recordSegment) update(ts uint64, n int) {
+	s.size += int64(n)
+	if !s.hasTs {
+		s.startTs = ts
+		s.endTs = ts
+		s.hasTs = true
+		return
+	}
+	if ts > s.endTs {
+		s.endTs = ts
+	}
+}
+
+func (s *recordSegment) duration() float64 {
+	return float64(s.endTs-s.startTs) / 1000
+}
+
+// genRecordFilename 根据文件名模板生成录制文件名，并创建所在的目录
+//
+// 模板中支持的变量：
+// {app} {stream} {ext}
+// {yyyy} {mm} {dd} 年月日
+// {HH} {MM} {SS} {HHMMSS} 时分秒
+// {unix} unix时间戳，单位秒
+//
+// 模板中没有{ext}时，在末尾添加扩展名。
+// 生成的文件已存在时（比如同一秒内切换了多个文件），在扩展名前添加序号
+//
+// @return 包含录制目录的文件名
+//
+func (group *Group) genRecordFilename(outPath string, ext string, t time.Time) string {
+	template := group.config.RecordConfig.FilenameTemplate
+	if template == "" {
+		template = defaultRecordFilenameTemplate
+	}
+	if !strings.Contains(template, "{ext}") && !strings.HasSuffix(template, "."+ext) {
+		template += ".{ext}"
+	}
+
+	r := strings.NewReplacer(
+		"{app}", group.appName,
+		"{stream}", group.streamName,
+		"{ext}", ext,
+		"{yyyy}", t.Format("2006"),
+		"{mm}", t.Format("01"),
+		"{dd}", t.Format("02"),
+		"{HHMMSS}", t.Format("150405"),
+		"{HH}", t.Format("15"),
+		"{MM}", t.Format("04"),
+		"{SS}", t.Format("05"),
+		"{unix}", strconv.FormatInt(t.Unix(), 10),
+	)
+	filename := filepath.Join(outPath, r.Replace(template))
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		Log.Errorf("[%s] record mkdir error. filename=%s, err=%+v", group.UniqueKey, filename, err)
+	}
+
+	stem := strings.TrimSuffix(filename, filepath.Ext(filename))
+	for i := 1; ; i++ {
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			return filename
+		}
+		filename = fmt.Sprintf("%s-%d%s", stem, i, filepath.Ext(filename))
+	}
+}
+
+// shouldRotateRecord 是否应该切换到新的录制文件，只在关键帧等可以开始新文件的位置调用
+//
+// @param ts: 即将写入的数据的时间戳，单位毫秒
+//
+func (group *Group) shouldRotateRecord(s *recordSegment, ts uint64) bool {
+	if s == nil || !s.hasTs {
+		return false
+	}
+	c := &group.config.RecordConfig
+	if c.SegmentDurationSec > 0 && ts > s.startTs && ts-s.startTs >= uint64(c.SegmentDurationSec)*1000 {
+		return true
+	}
+	if c.SegmentMaxSizeMb > 0 && s.size >= int64(c.SegmentMaxSizeMb)*1024*1024 {
+		return true
+	}
+	return false
+}
+
+// onRecordFileDone 录制文件写入完成
+//
+// 注意，mp4开启了 RecordConfig.Mp4FinalizeFlag 时，在转换完成后调用，此时不在group的协程中
+//
+// @param endTime: 停止写入文件的时间
+//
+func (group *Group) onRecordFileDone(s *recordSegment, endTime time.Time) {
+	if s == nil {
+		return
+	}
+	if fi, err := os.Stat(s.filename); err == nil {
+		s.size = fi.Size()
+	}
+	Log.Infof("[%s] record file done. format=%s, filename=%s, size=%d, duration=%.3f",
+		group.UniqueKey, s.format, s.filename, s.size, s.duration())
+	group.observer.OnRecordFileDone(base.RecordFileDoneInfo{
+		AppName:    group.appName,
+		StreamName: group.streamName,
+		Format:     s.format,
+		Path:       s.filename,
+		Size:       s.size,
+		Duration:   s.duration(),
+		StartTime:  s.startTime.Format(base.RecordTimeLayout),
+		EndTime:    endTime.Format(base.RecordTimeLayout),
+	})
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// rtmpHeaderCache 缓存最新的metadata以及seq header
+//
+// 中途开启录制，或者录制切换到新的文件时，用于写入文件的头部
+//
+type rtmpHeaderCache struct {
+	metadata       *base.RtmpMsg
+	videoSeqHeader *base.RtmpMsg
+	aacSeqHeader   *base.RtmpMsg
+}
+
+func (c *rtmpHeaderCache) feed(msg base.RtmpMsg) {
+	switch {
+	case msg.Header.MsgTypeId == base.RtmpTypeIdMetadata && rtmp.IsStreamMetadata(msg.Payload):
+		m := msg.Clone()
+		c.metadata = &m
+	case msg.IsVideoKeySeqHeader():
+		m := msg.Clone()
+		c.videoSeqHeader = &m
+	case msg.IsAacSeqHeader():
+		m := msg.Clone()
+		c.aacSeqHeader = &m
+	}
+}
+
+// msgs 按照metadata、video seq header、audio seq header的顺序返回已缓存的消息
+//
+func (c *rtmpHeaderCache) msgs() []base.RtmpMsg {
+	var out []base.RtmpMsg
+	for _, m := range []*base.RtmpMsg{c.metadata, c.videoSeqHeader, c.aacSeqHeader} {
+		if m != nil {
+			out = append(out, *m)
+		}
+	}
+	return out
+}
+
+func (c *rtmpHeaderCache) hasVideo() bool {
+	return c.videoSeqHeader != nil
+}
+
+func (c *rtmpHeaderCache) reset() {
+	*c = rtmpHeaderCache{}
+}
@@ -0,0 +1,373 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/remux"
+)
+
+// 通过http api按需开启、关闭录制
+//
+// - 只能对有输入流的group开启，输入流断开时自动关闭
+// - 同一种格式同时只能有一个录制，包括由配置文件开启的录制
+// - 可以指定输出目录，不指定时使用配置文件中对应格式的目录
+// - 可以指定最长录制时长，到期后自动关闭
+//
+
+// recordCtrl 一个通过http api开启的录制
+//
+type recordCtrl struct {
+	outPath  string
+	deadline time.Time // 为零值时不自动关闭
+}
+
+// StartRecord 开启录制
+//
+// @param format:         base.RecordFormatFlv 等
+// @param outPath:        输出目录，为空时使用配置文件中的目录
+// @param maxDurationSec: 最长录制时长，单位秒，为0时不限制
+//
+func (group *Group) StartRecord(format string, outPath string, maxDurationSec int) error {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if !group.hasInSession() {
+		return base.ErrRecordNoInStream
+	}
+	if group.recordPaused {
+		return base.ErrRecordDiskLow
+	}
+	if !isValidRecordFormat(format) {
+		return fmt.Errorf("%w. format=%s", base.ErrRecordInvalidFormat, format)
+	}
+	if group.isRecording(format) {
+		return fmt.Errorf("%w. format=%s", base.ErrRecordAlreadyStarted, format)
+	}
+
+	now := time.Now()
+	ctrl := &recordCtrl{
+		outPath: outPath,
+	}
+	if maxDurationSec > 0 {
+		ctrl.deadline = now.Add(time.Duration(maxDurationSec) * time.Second)
+	}
+	if group.recordCtrls == nil {
+		group.recordCtrls = make(map[string]*recordCtrl)
+	}
+	// 先记录下来，打开文件时需要使用指定的输出目录
+	group.recordCtrls[format] = ctrl
+
+	switch format {
+	case base.RecordFormatFlv, base.RecordFormatMpegts, base.RecordFormatMp4:
+		if !group.openRecordMidStream(format, now) {
+			delete(group.recordCtrls, format)
+			return base.ErrRecordOpenFileFailed
+		}
+	case base.RecordFormatHls:
+		muxerConfig := group.config.HlsConfig.MuxerConfig
+		if outPath != "" {
+			muxerConfig.OutPath = outPath
+		}
+		group.startHls(&muxerConfig)
+		if group.isHlsFmp4() {
+			for _, msg := range group.recordHeaders.msgs() {
+				group.rtmp2Fmp4Remuxer.FeedRtmpMessage(msg)
+			}
+		} else {
+			group.ensureMpegtsRemuxer()
+			if group.patpmt != nil {
+				group.hlsMuxer.FeedPatPmt(group.patpmt)
+			}
+		}
+	}
+
+	Log.Infof("[%s] start record. format=%s, out path=%s, max duration=%d",
+		group.UniqueKey, format, outPath, maxDurationSec)
+	return nil
+}
+
+// StopRecord 关闭通过 StartRecord 开启的录制
+//
+func (group *Group) StopRecord(format string) error {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if !isValidRecordFormat(format) {
+		return fmt.Errorf("%w. format=%s", base.ErrRecordInvalidFormat, format)
+	}
+	if _, ok := group.recordCtrls[format]; !ok {
+		return fmt.Errorf("%w. format=%s", base.ErrRecordNotStarted, format)
+	}
+
+	group.stopRecordCtrl(format)
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (group *Group) stopRecordCtrl(format string) {
+	Log.Infof("[%s] stop record. format=%s", group.UniqueKey, format)
+
+	// 注意，不再需要的remuxer放前面，使得有机会将内部缓存的数据吐出来
+	if format == base.RecordFormatMpegts || (format == base.RecordFormatHls && !group.isHlsFmp4()) {
+		group.releaseMpegtsRemuxerIfNeeded(format)
+	}
+
+	switch format {
+	case base.RecordFormatFlv:
+		group.closeRecordFlv()
+		group.recordFlvWaitKeyFrame = false
+	case base.RecordFormatMpegts:
+		group.closeRecordMpegts()
+		group.recordMpegtsWaitBoundary = false
+	case base.RecordFormatMp4:
+		if group.recordMp4 != nil {
+			group.recordMp4.Dispose()
+			group.recordMp4 = nil
+		}
+	case base.RecordFormatHls:
+		group.stopHls(false)
+	}
+	delete(group.recordCtrls, format)
+}
+
+// openRecordMidStream 在流的中途开启flv、ts、mp4录制，先写入缓存的metadata以及seq header，再从关键帧开始写入
+//
+func (group *Group) openRecordMidStream(format string, t time.Time) bool {
+	switch format {
+	case base.RecordFormatFlv:
+		if !group.openRecordFlv(t) {
+			return false
+		}
+		group.writeRecordFlvHeaders()
+		group.recordFlvWaitKeyFrame = group.recordHeaders.hasVideo()
+	case base.RecordFormatMpegts:
+		if !group.openRecordMpegts(t) {
+			return false
+		}
+		group.recordMpegtsWaitBoundary = true
+		group.ensureMpegtsRemuxer()
+	case base.RecordFormatMp4:
+		group.recordMp4 = newRecordMp4(group, t)
+		for _, msg := range group.recordHeaders.msgs() {
+			group.recordMp4.FeedRtmpMessage(msg)
+		}
+	}
+	return true
+}
+
+// PauseRecord 磁盘空间不足时调用，关闭正在写入的flv、ts、mp4录制文件，并拒绝新的录制
+//
+// 注意，hls同时用于直播，所以不暂停
+//
+func (group *Group) PauseRecord() {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.recordPaused {
+		return
+	}
+	group.recordPaused = true
+	Log.Warnf("[%s] pause record.", group.UniqueKey)
+
+	group.closeRecordFlv()
+	group.recordFlvWaitKeyFrame = false
+	group.closeRecordMpegts()
+	group.recordMpegtsWaitBoundary = false
+	if group.recordMp4 != nil {
+		// 磁盘空间不足，不再转换为普通mp4，避免再写一份完整的文件
+		group.recordMp4.DisposeWithoutFinalize()
+		group.recordMp4 = nil
+	}
+}
+
+// ResumeRecord 磁盘空间恢复时调用，重新开启暂停前的录制
+//
+func (group *Group) ResumeRecord() {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if !group.recordPaused {
+		return
+	}
+	group.recordPaused = false
+	Log.Infof("[%s] resume record.", group.UniqueKey)
+
+	if !group.hasInSession() {
+		return
+	}
+	now := time.Now()
+	for _, format := range []string{base.RecordFormatFlv, base.RecordFormatMpegts, base.RecordFormatMp4} {
+		if group.isRecordEnabled(format) && !group.isRecording(format) {
+			group.openRecordMidStream(format, now)
+		}
+	}
+}
+
+// stopAllRecordCtrls 输入流断开时调用
+//
+func (group *Group) stopAllRecordCtrls() {
+	for format := range group.recordCtrls {
+		group.stopRecordCtrl(format)
+	}
+	group.recordCtrls = nil
+}
+
+// tickRecordCtrls 关闭达到最长录制时长的录制
+//
+func (group *Group) tickRecordCtrls() {
+	now := time.Now()
+	for format, ctrl := range group.recordCtrls {
+		if ctrl.deadline != (time.Time{}) && !now.Before(ctrl.deadline) {
+			Log.Infof("[%s] record reach max duration. format=%s", group.UniqueKey, format)
+			group.stopRecordCtrl(format)
+		}
+	}
+}
+
+// recordOutPath 录制的输出目录，通过http api开启并且指定了目录时使用指定的目录
+//
+func (group *Group) recordOutPath(format string) string {
+	if ctrl, ok := group.recordCtrls[format]; ok && ctrl.outPath != "" {
+		return ctrl.outPath
+	}
+	return defaultRecordOutPath(group.config, format)
+}
+
+// isRecordEnabled 配置文件中开启了录制，或者通过http api开启了录制
+//
+func (group *Group) isRecordEnabled(format string) bool {
+	if _, ok := group.recordCtrls[format]; ok {
+		return true
+	}
+	switch format {
+	case base.RecordFormatFlv:
+		return group.config.RecordConfig.EnableFlv
+	case base.RecordFormatMpegts:
+		return group.config.RecordConfig.EnableMpegts
+	case base.RecordFormatMp4:
+		return group.config.RecordConfig.EnableMp4
+	}
+	return false
+}
+
+func (group *Group) isRecording(format string) bool {
+	switch format {
+	case base.RecordFormatFlv:
+		return group.recordFlv != nil
+	case base.RecordFormatMpegts:
+		return group.recordMpegts != nil
+	case base.RecordFormatMp4:
+		return group.recordMp4 != nil
+	case base.RecordFormatHls:
+		return group.hlsMuxer != nil
+	}
+	return false
+}
+
+// ensureMpegtsRemuxer 配置中没有开启需要mpegts的功能时，按需创建mpegts remuxer，并输入缓存的metadata以及seq header
+//
+func (group *Group) ensureMpegtsRemuxer() {
+	if group.rtmp2MpegtsRemuxer != nil {
+		return
+	}
+	group.rtmp2MpegtsRemuxer = remux.NewRtmp2MpegtsRemuxer(group).
+		WithTimedMetadata(group.config.HlsConfig.TimedMetadataEnable).
+		WithScte35(group.config.HlsConfig.Scte35Enable)
+	for _, msg := range group.recordHeaders.msgs() {
+		group.rtmp2MpegtsRemuxer.FeedRtmpMessage(msg)
+	}
+}
+
+// releaseMpegtsRemuxerIfNeeded 关闭录制format后，mpegts remuxer没有其他使用者时释放
+//
+func (group *Group) releaseMpegtsRemuxerIfNeeded(format string) {
+	if group.rtmp2MpegtsRemuxer == nil || group.shouldStartMpegtsRemuxer() {
+		return
+	}
+	if format != base.RecordFormatMpegts && group.recordMpegts != nil {
+		return
+	}
+	if format != base.RecordFormatHls && group.hlsMuxer != nil && !group.isHlsFmp4() {
+		return
+	}
+	group.rtmp2MpegtsRemuxer.Dispose()
+	group.rtmp2MpegtsRemuxer = nil
+}
+
+// GetRecordingFiles 正在写入的flv、ts、mp4录制文件
+//
+func (group *Group) GetRecordingFiles() (files []string) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	for _, s := range []*recordSegment{group.recordFlvSegment, group.recordMpegtsSegment} {
+		if s != nil {
+			files = append(files, s.filename)
+		}
+	}
+	if group.recordMp4 != nil && group.recordMp4.segment != nil {
+		files = append(files, group.recordMp4.segment.filename)
+	}
+	return
+}
+
+// getStatRecords 当前正在进行的录制
+//
+func (group *Group) getStatRecords() []base.StatRecord {
+	var out []base.StatRecord
+	add := func(format string, path string, startTime time.Time) {
+		_, onDemand := group.recordCtrls[format]
+		out = append(out, base.StatRecord{
+			Format:    format,
+			Path:      path,
+			StartTime: startTime.Format(base.RecordTimeLayout),
+			OnDemand:  onDemand,
+		})
+	}
+	if group.recordFlvSegment != nil {
+		add(base.RecordFormatFlv, group.recordFlvSegment.filename, group.recordFlvSegment.startTime)
+	}
+	if group.recordMpegtsSegment != nil {
+		add(base.RecordFormatMpegts, group.recordMpegtsSegment.filename, group.recordMpegtsSegment.startTime)
+	}
+	if group.recordMp4 != nil && group.recordMp4.segment != nil {
+		add(base.RecordFormatMp4, group.recordMp4.segment.filename, group.recordMp4.segment.startTime)
+	}
+	if group.hlsMuxer != nil {
+		add(base.RecordFormatHls, group.hlsMuxer.OutPath(), group.hlsStartTime)
+	}
+	return out
+}
+
+func isValidRecordFormat(format string) bool {
+	switch format {
+	case base.RecordFormatFlv, base.RecordFormatMpegts, base.RecordFormatMp4, base.RecordFormatHls:
+		return true
+	}
+	return false
+}
+
+// defaultRecordOutPath 配置文件中对应格式的输出目录
+//
+func defaultRecordOutPath(config *Config, format string) string {
+	switch format {
+	case base.RecordFormatFlv:
+		return config.RecordConfig.FlvOutPath
+	case base.RecordFormatMpegts:
+		return config.RecordConfig.MpegtsOutPath
+	case base.RecordFormatMp4:
+		return config.RecordConfig.Mp4OutPath
+	}
+	return config.HlsConfig.OutPath
+}
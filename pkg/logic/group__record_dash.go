@@ -0,0 +1,51 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"github.com/q191201771/lal/pkg/dash"
+)
+
+func (group *Group) IsDashMuxerAlive() bool {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	return group.dashMuxer != nil
+}
+
+// startDashIfNeeded 必要时启动dash
+//
+// 注意，文件目录、分片时长、分片数量以及清理策略复用hls的配置
+//
+func (group *Group) startDashIfNeeded() {
+	if !group.config.DashConfig.Enable && !group.config.DashConfig.EnableHttps {
+		return
+	}
+
+	hlsConfig := &group.config.HlsConfig.MuxerConfig
+	group.dashMuxer = dash.NewMuxer(group.streamName, &dash.MuxerConfig{
+		OutPath:            hlsConfig.OutPath,
+		FragmentDurationMs: hlsConfig.FragmentDurationMs,
+		FragmentNum:        hlsConfig.FragmentNum,
+		DeleteThreshold:    hlsConfig.DeleteThreshold,
+		CleanupMode:        hlsConfig.CleanupMode,
+	})
+	group.dashMuxer.Start()
+}
+
+func (group *Group) stopDashIfNeeded() {
+	if !group.config.DashConfig.Enable && !group.config.DashConfig.EnableHttps {
+		return
+	}
+
+	if group.dashMuxer != nil {
+		group.dashMuxer.Dispose()
+		group.observer.CleanupDashIfNeeded(group.appName, group.streamName, group.dashMuxer.OutPath())
+		group.dashMuxer = nil
+	}
+}
@@ -9,44 +9,120 @@
 package logic
 
 import (
-	"fmt"
-	"path/filepath"
+	"time"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/remux"
 )
 
 // startRecordFlvIfNeeded 必要时开启flv录制
 //
 func (group *Group) startRecordFlvIfNeeded(nowUnix int64) {
+	if !group.config.RecordConfig.EnableFlv || group.recordPaused {
+		return
+	}
+
+	group.openRecordFlv(time.Unix(nowUnix, 0))
+}
+
+func (group *Group) stopRecordFlvIfNeeded() {
 	if !group.config.RecordConfig.EnableFlv {
 		return
 	}
 
-	// 构造文件名
-	filename := fmt.Sprintf("%s-%d.flv", group.streamName, nowUnix)
-	filenameWithPath := filepath.Join(group.config.RecordConfig.FlvOutPath, filename)
+	group.closeRecordFlv()
+}
+
+// feedRecordFlv
+//
+// @param tag: msg对应的flv tag
+//
+// 注意，调用前需要先将msg输入 Group.recordHeaders
+//
+func (group *Group) feedRecordFlv(msg base.RtmpMsg, tag []byte) {
+	ts := uint64(msg.Header.TimestampAbs)
+	boundary := msg.IsVideoKeyNalu() ||
+		(msg.Header.MsgTypeId == base.RtmpTypeIdAudio && !group.recordHeaders.hasVideo())
+
+	// 中途开启的录制，已经写入了缓存的metadata以及seq header，从关键帧开始写入音视频数据
+	if group.recordFlvWaitKeyFrame {
+		if !boundary {
+			return
+		}
+		group.recordFlvWaitKeyFrame = false
+	}
+
+	if boundary && group.shouldRotateRecord(group.recordFlvSegment, ts) {
+		group.closeRecordFlv()
+		if group.openRecordFlv(time.Now()) {
+			group.writeRecordFlvHeaders()
+		}
+	}
+
+	group.writeRecordFlv(tag, ts)
+}
+
+// writeRecordFlvHeaders 将缓存的metadata以及seq header写入当前文件，不参与文件时长的计算
+//
+func (group *Group) writeRecordFlvHeaders() {
+	if group.recordFlv == nil {
+		return
+	}
+	for _, msg := range group.recordHeaders.msgs() {
+		tag := remux.RtmpMsg2FlvTag(msg).Raw
+		if err := group.recordFlv.WriteRaw(tag); err != nil {
+			Log.Errorf("[%s] record flv write error. err=%+v", group.UniqueKey, err)
+			return
+		}
+		group.recordFlvSegment.size += int64(len(tag))
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
 
-	// 初始化录制
-	group.recordFlv = &httpflv.FlvFileWriter{}
-	if err := group.recordFlv.Open(filenameWithPath); err != nil {
+func (group *Group) openRecordFlv(t time.Time) bool {
+	filenameWithPath := group.genRecordFilename(group.recordOutPath(base.RecordFormatFlv), base.RecordFormatFlv, t)
+
+	w := &httpflv.FlvFileWriter{}
+	if group.config.RecordConfig.FlvKeyframesMetadataNum > 0 {
+		w.WithKeyframesMetadata(group.config.RecordConfig.FlvKeyframesMetadataNum)
+	}
+	if err := w.Open(filenameWithPath); err != nil {
 		Log.Errorf("[%s] record flv open file failed. filename=%s, err=%+v",
 			group.UniqueKey, filenameWithPath, err)
-		group.recordFlv = nil
+		return false
 	}
-	if err := group.recordFlv.WriteFlvHeader(); err != nil {
+	if err := w.WriteFlvHeader(); err != nil {
 		Log.Errorf("[%s] record flv write flv header failed. filename=%s, err=%+v",
 			group.UniqueKey, filenameWithPath, err)
-		group.recordFlv = nil
+		_ = w.Dispose()
+		return false
 	}
+	group.recordFlv = w
+	group.recordFlvSegment = newRecordSegment(base.RecordFormatFlv, filenameWithPath)
+	return true
 }
 
-func (group *Group) stopRecordFlvIfNeeded() {
-	if !group.config.RecordConfig.EnableFlv {
+func (group *Group) writeRecordFlv(tag []byte, ts uint64) {
+	if group.recordFlv == nil || tag == nil {
 		return
 	}
+	if err := group.recordFlv.WriteRaw(tag); err != nil {
+		Log.Errorf("[%s] record flv write error. err=%+v", group.UniqueKey, err)
+		return
+	}
+	group.recordFlvSegment.update(ts, len(tag))
+}
 
-	if group.recordFlv != nil {
-		_ = group.recordFlv.Dispose()
-		group.recordFlv = nil
+func (group *Group) closeRecordFlv() {
+	if group.recordFlv == nil {
+		return
 	}
+	// 文件中还包含onMetaData中追加的keyframes等字段
+	group.recordFlvSegment.size = group.recordFlv.Size()
+	_ = group.recordFlv.Dispose()
+	group.recordFlv = nil
+	group.onRecordFileDone(group.recordFlvSegment, time.Now())
+	group.recordFlvSegment = nil
 }
@@ -8,7 +8,13 @@
 
 package logic
 
-import "github.com/q191201771/lal/pkg/hls"
+import (
+	"strings"
+	"time"
+
+	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/lal/pkg/remux"
+)
 
 func (group *Group) IsHlsMuxerAlive() bool {
 	group.mutex.Lock()
@@ -16,6 +22,45 @@ func (group *Group) IsHlsMuxerAlive() bool {
 	return group.hlsMuxer != nil
 }
 
+// GetHlsVariantStreamInfo 获取流作为hls多码率分组中一个档位的信息
+//
+// @return ok: 流没有输入时返回false
+//
+func (group *Group) GetHlsVariantStreamInfo() (info hls.VariantStreamInfo, ok bool) {
+	stat := group.GetStat(0)
+
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if !group.hasInSession() {
+		return
+	}
+
+	info.StreamName = group.streamName
+	info.Width = stat.VideoWidth
+	info.Height = stat.VideoHeight
+
+	// 优先使用输入会话的实时码率，还没有统计结果时，使用hls分片的码率
+	bitrate := stat.StatPub.Bitrate
+	if bitrate == 0 {
+		bitrate = stat.StatPull.Bitrate
+	}
+	info.Bandwidth = bitrate * 1024
+	if info.Bandwidth == 0 && group.hlsMuxer != nil {
+		info.Bandwidth = group.hlsMuxer.MaxBandwidth()
+	}
+
+	var codecs []string
+	if group.videoCodecString != "" {
+		codecs = append(codecs, group.videoCodecString)
+	}
+	if group.audioCodecString != "" {
+		codecs = append(codecs, group.audioCodecString)
+	}
+	info.Codecs = strings.Join(codecs, ",")
+	return info, true
+}
+
 // startHlsIfNeeded 必要时启动hls
 //
 func (group *Group) startHlsIfNeeded() {
@@ -23,8 +68,7 @@ func (group *Group) startHlsIfNeeded() {
 		return
 	}
 
-	group.hlsMuxer = hls.NewMuxer(group.streamName, &group.config.HlsConfig.MuxerConfig, group)
-	group.hlsMuxer.Start()
+	group.startHls(&group.config.HlsConfig.MuxerConfig)
 }
 
 func (group *Group) stopHlsIfNeeded() {
@@ -32,9 +76,48 @@ func (group *Group) stopHlsIfNeeded() {
 		return
 	}
 
+	group.stopHls(true)
+}
+
+// startHls
+//
+// @param muxerConfig: 通过http api开启hls录制时，使用指定的输出目录
+//
+func (group *Group) startHls(muxerConfig *hls.MuxerConfig) {
+	group.hlsMuxer = hls.NewMuxer(group.streamName, muxerConfig, group)
+	group.hlsStartTime = time.Now()
+	group.hlsMuxer.SetHasClosedCaptions(group.stat.HasClosedCaptions)
+	group.hlsMuxer.Start()
+
+	if group.isHlsFmp4() {
+		group.rtmp2Fmp4Remuxer = remux.NewRtmp2Fmp4Remuxer(group.hlsMuxer)
+		if group.config.HlsConfig.LowLatency {
+			// LL-HLS中，每个fmp4媒体分片即为一个partial segment
+			group.rtmp2Fmp4Remuxer.WithFragmentDurationMs(uint32(group.config.HlsConfig.GetPartDurationMs()))
+		}
+	}
+}
+
+// stopHls
+//
+// @param cleanup: 是否按照配置清理hls文件，通过http api开启的hls录制不清理
+//
+func (group *Group) stopHls(cleanup bool) {
+	// 注意，remuxer放前面，使得有机会将内部缓存的数据吐出来
+	if group.rtmp2Fmp4Remuxer != nil {
+		group.rtmp2Fmp4Remuxer.Dispose()
+		group.rtmp2Fmp4Remuxer = nil
+	}
+
 	if group.hlsMuxer != nil {
 		group.hlsMuxer.Dispose()
-		group.observer.CleanupHlsIfNeeded(group.appName, group.streamName, group.hlsMuxer.OutPath())
+		if cleanup {
+			group.observer.CleanupHlsIfNeeded(group.appName, group.streamName, group.hlsMuxer.OutPath())
+		}
 		group.hlsMuxer = nil
 	}
 }
+
+func (group *Group) isHlsFmp4() bool {
+	return group.config.HlsConfig.IsFmp4()
+}
@@ -0,0 +1,225 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
+	"github.com/q191201771/lal/pkg/remux"
+)
+
+// startRecordMp4IfNeeded 必要时开启mp4录制
+//
+func (group *Group) startRecordMp4IfNeeded(nowUnix int64) {
+	if !group.config.RecordConfig.EnableMp4 || group.recordPaused {
+		return
+	}
+
+	group.recordMp4 = newRecordMp4(group, time.Unix(nowUnix, 0))
+}
+
+func (group *Group) stopRecordMp4IfNeeded() {
+	if !group.config.RecordConfig.EnableMp4 {
+		return
+	}
+
+	if group.recordMp4 != nil {
+		group.recordMp4.Dispose()
+		group.recordMp4 = nil
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// recordMp4 mp4录制
+//
+// 录制过程中写入fmp4，进程崩溃时已写入的部分依然可以播放。
+// 编码参数发生变化时（生成新的初始化分片）切换到新的文件，新文件的时间戳与前一个文件衔接，而不是从0开始。
+// 文件正常写完时，如果开启了 RecordConfig.Mp4FinalizeFlag ，将fmp4转换为moov在头部的普通mp4。
+// 因为磁盘空间不足而关闭时不转换，保留fmp4文件
+//
+type recordMp4 struct {
+	group     *Group
+	startTime time.Time
+
+	remuxer     *remux.Rtmp2Fmp4Remuxer
+	initSegment []byte
+	fw          *fmp4.FileWriter
+	segment     *recordSegment
+}
+
+func newRecordMp4(group *Group, startTime time.Time) *recordMp4 {
+	r := &recordMp4{
+		group:     group,
+		startTime: startTime,
+	}
+	r.remuxer = remux.NewRtmp2Fmp4Remuxer(r)
+	return r
+}
+
+func (r *recordMp4) FeedRtmpMessage(msg base.RtmpMsg) {
+	r.remuxer.FeedRtmpMessage(msg)
+}
+
+func (r *recordMp4) Dispose() {
+	// 注意，remuxer放前面，使得有机会将内部缓存的数据吐出来
+	r.remuxer.Dispose()
+	r.closeFile(true)
+}
+
+// DisposeWithoutFinalize 磁盘空间不足时调用，关闭文件但不转换为普通mp4
+//
+func (r *recordMp4) DisposeWithoutFinalize() {
+	r.remuxer.Dispose()
+	r.closeFile(false)
+}
+
+// OnFmp4InitSegment OnFmp4Fragment
+//
+// 实现 remux.IRtmp2Fmp4RemuxerObserver
+//
+func (r *recordMp4) OnFmp4InitSegment(b []byte) {
+	r.initSegment = b
+	r.closeFile(true)
+
+	// 第一个文件使用录制开始的时间命名
+	t := time.Now()
+	if r.startTime != (time.Time{}) {
+		t = r.startTime
+		r.startTime = time.Time{}
+	}
+	r.openFile(t)
+}
+
+func (r *recordMp4) OnFmp4Fragment(b []byte, startTs uint64, endTs uint64, boundary bool) {
+	// 文件从关键帧开始
+	if r.segment != nil && !r.segment.hasTs && !boundary {
+		return
+	}
+	if boundary && r.group.shouldRotateRecord(r.segment, startTs/90) {
+		r.closeFile(true)
+		r.openFile(time.Now())
+	}
+
+	if r.fw == nil {
+		return
+	}
+	if err := r.fw.Write(b); err != nil {
+		Log.Errorf("[%s] record mp4 write error. err=%+v", r.group.UniqueKey, err)
+		return
+	}
+	r.segment.update(startTs/90, len(b))
+	r.segment.update(endTs/90, 0)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (r *recordMp4) openFile(t time.Time) {
+	filenameWithPath := r.group.genRecordFilename(r.group.recordOutPath(base.RecordFormatMp4), base.RecordFormatMp4, t)
+
+	fw := &fmp4.FileWriter{}
+	if err := fw.Create(filenameWithPath); err != nil {
+		Log.Errorf("[%s] record mp4 open file failed. filename=%s, err=%+v", r.group.UniqueKey, filenameWithPath, err)
+		return
+	}
+	if err := fw.Write(r.initSegment); err != nil {
+		Log.Errorf("[%s] record mp4 write init segment failed. filename=%s, err=%+v", r.group.UniqueKey, filenameWithPath, err)
+		_ = fw.Dispose()
+		return
+	}
+	r.fw = fw
+	r.segment = newRecordSegment(base.RecordFormatMp4, filenameWithPath)
+	r.segment.size = int64(len(r.initSegment))
+}
+
+// closeFile
+//
+// @param finalize: 是否允许转换为普通mp4，最终还取决于 RecordConfig.Mp4FinalizeFlag
+//
+func (r *recordMp4) closeFile(finalize bool) {
+	if r.fw == nil {
+		return
+	}
+	_ = r.fw.Dispose()
+	r.fw = nil
+
+	segment := r.segment
+	r.segment = nil
+	// 结束时间以停止写入的时间为准，不包含转换的耗时
+	endTime := time.Now()
+	if !finalize || !r.group.config.RecordConfig.Mp4FinalizeFlag {
+		r.group.onRecordFileDone(segment, endTime)
+		return
+	}
+
+	// 文件较大时转换比较耗时，所以在单独的协程中执行，转换完成之前依然视为正在写入的文件
+	group := r.group
+	finalizingRecordMp4.add(segment.filename)
+	go func() {
+		defer finalizingRecordMp4.remove(segment.filename)
+		finalizeRecordMp4(group.UniqueKey, segment.filename)
+		group.onRecordFileDone(segment, endTime)
+	}()
+}
+
+// finalizingRecordMp4 正在转换为普通mp4的录制文件
+//
+// 转换在单独的协程中执行，此时录制对象甚至group可能已经销毁，所以单独记录，
+// 使得录制文件保留策略不会删除转换中的文件，见 ServerManager.GetRecordingFiles
+//
+var finalizingRecordMp4 = &recordFileSet{
+	files: make(map[string]struct{}),
+}
+
+type recordFileSet struct {
+	mutex sync.Mutex
+	files map[string]struct{}
+}
+
+func (s *recordFileSet) add(filename string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.files[filename] = struct{}{}
+}
+
+func (s *recordFileSet) remove(filename string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.files, filename)
+}
+
+func (s *recordFileSet) list() (files []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for filename := range s.files {
+		files = append(files, filename)
+	}
+	return
+}
+
+// finalizeRecordMp4 将录制的fmp4文件转换为普通mp4，文件名不变，转换失败时保留fmp4文件
+//
+func finalizeRecordMp4(uniqueKey string, filename string) {
+	tmp := filename + ".tmp"
+	if err := fmp4.DefragmentFile(filename, tmp); err != nil {
+		Log.Errorf("[%s] record mp4 finalize failed, keep fmp4 file. filename=%s, err=%+v", uniqueKey, filename, err)
+		_ = os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		Log.Errorf("[%s] record mp4 rename failed. filename=%s, err=%+v", uniqueKey, filename, err)
+		_ = os.Remove(tmp)
+		return
+	}
+	Log.Infof("[%s] record mp4 finalized. filename=%s", uniqueKey, filename)
+}
@@ -9,38 +9,97 @@
 package logic
 
 import (
-	"fmt"
-	"path/filepath"
+	"time"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/mpegts"
 )
 
 // startRecordMpegtsIfNeeded 必要时开启ts录制
 //
 func (group *Group) startRecordMpegtsIfNeeded(nowUnix int64) {
+	if !group.config.RecordConfig.EnableMpegts || group.recordPaused {
+		return
+	}
+
+	group.openRecordMpegts(time.Unix(nowUnix, 0))
+}
+
+func (group *Group) stopRecordMpegtsIfNeeded() {
 	if !group.config.RecordConfig.EnableMpegts {
 		return
 	}
 
-	// 构造文件名
-	filename := fmt.Sprintf("%s-%d.ts", group.streamName, nowUnix)
-	filenameWithPath := filepath.Join(group.config.RecordConfig.MpegtsOutPath, filename)
+	group.closeRecordMpegts()
+}
+
+// feedRecordMpegts
+//
+// @param boundary: 是否可以从这里开始新的文件，与 IRtmp2MpegtsRemuxerObserver.OnTsPackets 的boundary相同
+//
+func (group *Group) feedRecordMpegts(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	// 中途开启的录制，从boundary开始写入，并且先写入PAT、PMT
+	if group.recordMpegtsWaitBoundary {
+		if !boundary {
+			return
+		}
+		group.recordMpegtsWaitBoundary = false
+		if group.patpmt != nil {
+			group.writeRecordMpegts(group.patpmt, nil)
+		}
+	}
+
+	if boundary && group.shouldRotateRecord(group.recordMpegtsSegment, frame.Dts/90) {
+		group.closeRecordMpegts()
+		if group.openRecordMpegts(time.Now()) && group.patpmt != nil {
+			group.writeRecordMpegts(group.patpmt, nil)
+		}
+	}
+
+	group.writeRecordMpegts(tsPackets, frame)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (group *Group) openRecordMpegts(t time.Time) bool {
+	filenameWithPath := group.genRecordFilename(group.recordOutPath(base.RecordFormatMpegts), base.RecordFormatMpegts, t)
 
-	group.recordMpegts = &mpegts.FileWriter{}
-	if err := group.recordMpegts.Create(filenameWithPath); err != nil {
+	w := &mpegts.FileWriter{}
+	if err := w.Create(filenameWithPath); err != nil {
 		Log.Errorf("[%s] record mpegts open file failed. filename=%s, err=%+v",
 			group.UniqueKey, filenameWithPath, err)
-		group.recordMpegts = nil
+		return false
 	}
+	group.recordMpegts = w
+	group.recordMpegtsSegment = newRecordSegment(base.RecordFormatMpegts, filenameWithPath)
+	return true
 }
 
-func (group *Group) stopRecordMpegtsIfNeeded() {
-	if !group.config.RecordConfig.EnableMpegts {
+// writeRecordMpegts
+//
+// @param frame: 写入PAT、PMT时为nil
+//
+func (group *Group) writeRecordMpegts(b []byte, frame *mpegts.Frame) {
+	if group.recordMpegts == nil {
+		return
+	}
+	if err := group.recordMpegts.Write(b); err != nil {
+		Log.Errorf("[%s] record mpegts write error. err=%+v", group.UniqueKey, err)
 		return
 	}
+	if frame == nil {
+		group.recordMpegtsSegment.size += int64(len(b))
+	} else {
+		group.recordMpegtsSegment.update(frame.Dts/90, len(b))
+	}
+}
 
-	if group.recordMpegts != nil {
-		_ = group.recordMpegts.Dispose()
-		group.recordMpegts = nil
+func (group *Group) closeRecordMpegts() {
+	if group.recordMpegts == nil {
+		return
 	}
+	_ = group.recordMpegts.Dispose()
+	group.recordMpegts = nil
+	group.onRecordFileDone(group.recordMpegtsSegment, time.Now())
+	group.recordMpegtsSegment = nil
 }
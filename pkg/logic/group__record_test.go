@@ -0,0 +1,249 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type mockRecordObserver struct {
+	IGroupObserver
+	done []base.RecordFileDoneInfo
+}
+
+func (m *mockRecordObserver) OnRecordFileDone(info base.RecordFileDoneInfo) {
+	m.done = append(m.done, info)
+}
+
+func TestGroupRecordFlvSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_record_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{}
+	config.RecordConfig.EnableFlv = true
+	config.RecordConfig.FlvOutPath = dir
+	config.RecordConfig.FilenameTemplate = "{app}/{stream}/{yyyy}{mm}{dd}/{HHMMSS}.flv"
+	config.RecordConfig.SegmentDurationSec = 2
+	observer := &mockRecordObserver{}
+	group := NewGroup("live", "test", config, observer)
+
+	// 文件名
+	now := time.Date(2022, 9, 1, 8, 30, 5, 0, time.Local)
+	filename := group.genRecordFilename(dir, base.RecordFormatFlv, now)
+	assert.Equal(t, filepath.Join(dir, "live/test/20220901/083005.flv"), filename)
+	assert.Equal(t, nil, ioutil.WriteFile(filename, nil, 0666))
+	assert.Equal(t, filepath.Join(dir, "live/test/20220901/083005-1.flv"), group.genRecordFilename(dir, base.RecordFormatFlv, now))
+	assert.Equal(t, nil, os.Remove(filename))
+	config.RecordConfig.FilenameTemplate = ""
+	assert.Equal(t, filepath.Join(dir, fmt.Sprintf("test-%d.ts", now.Unix())), group.genRecordFilename(dir, base.RecordFormatMpegts, now))
+	config.RecordConfig.FilenameTemplate = "{app}/{stream}/{yyyy}{mm}{dd}/{HHMMSS}.flv"
+
+	feed := func(payload []byte, ts uint32) []byte {
+		var msg base.RtmpMsg
+		msg.Header.MsgTypeId = base.RtmpTypeIdVideo
+		msg.Header.TimestampAbs = ts
+		msg.Header.MsgLen = uint32(len(payload))
+		msg.Payload = payload
+		var lazy remux.LazyRtmpMsg2FlvTag
+		lazy.Init(msg)
+		tag := lazy.GetEnsureWithoutSdf()
+		group.recordHeaders.feed(msg)
+		group.feedRecordFlv(msg, tag)
+		return tag
+	}
+	seqHeader := []byte{0x17, base.RtmpAvcPacketTypeSeqHeader, 0, 0, 0, 1, 2, 3}
+	key := []byte{0x17, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 1, 0x65}
+	inter := []byte{0x27, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 1, 0x41}
+
+	group.startRecordFlvIfNeeded(now.Unix())
+	seqHeaderTag := feed(seqHeader, 0)
+	feed(key, 0)
+	feed(inter, 1000)
+	// 非关键帧不切换文件
+	feed(inter, 2100)
+	assert.Equal(t, 0, len(observer.done))
+	keyTag := feed(key, 2500)
+	assert.Equal(t, 1, len(observer.done))
+	feed(inter, 3000)
+	group.stopRecordFlvIfNeeded()
+	assert.Equal(t, 2, len(observer.done))
+
+	first := observer.done[0]
+	assert.Equal(t, "live", first.AppName)
+	assert.Equal(t, base.RecordFormatFlv, first.Format)
+	assert.Equal(t, filepath.Join(dir, "live/test/20220901/083005.flv"), first.Path)
+	assert.Equal(t, 2.1, first.Duration)
+	fi, err := os.Stat(first.Path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, fi.Size(), first.Size)
+
+	// 新的文件以flv header、seq header、关键帧开始
+	second := observer.done[1]
+	assert.Equal(t, 0.5, second.Duration)
+	b, err := ioutil.ReadFile(second.Path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(len(b)), second.Size)
+	assert.Equal(t, httpflv.FlvHeader, b[:len(httpflv.FlvHeader)])
+	b = b[len(httpflv.FlvHeader):]
+	assert.Equal(t, seqHeaderTag[:4], b[:4])
+	b = b[len(seqHeaderTag):]
+	assert.Equal(t, keyTag, b[:len(keyTag)])
+}
+
+func TestGroupStartStopRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_record_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{}
+	config.RecordConfig.FlvOutPath = filepath.Join(dir, "default")
+	observer := &mockRecordObserver{}
+	group := NewGroup("live", "test", config, observer)
+
+	// 没有输入流
+	err = group.StartRecord(base.RecordFormatFlv, dir, 0)
+	assert.Equal(t, true, errors.Is(err, base.ErrRecordNoInStream))
+
+	_, err = group.AddCustomizePubSession("test")
+	assert.Equal(t, nil, err)
+
+	video := func(payload []byte, ts uint32) {
+		var msg base.RtmpMsg
+		msg.Header.MsgTypeId = base.RtmpTypeIdVideo
+		msg.Header.TimestampAbs = ts
+		msg.Header.MsgLen = uint32(len(payload))
+		msg.Payload = payload
+		group.broadcastByRtmpMsg(msg)
+	}
+	seqHeader := []byte{0x17, base.RtmpAvcPacketTypeSeqHeader, 0, 0, 0, 1, 2, 3}
+	key := []byte{0x17, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 1, 0x65}
+	inter := []byte{0x27, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 1, 0x41}
+
+	// 录制开启前的seq header被缓存下来
+	video(seqHeader, 0)
+	video(key, 0)
+	video(inter, 40)
+
+	err = group.StartRecord("avi", dir, 0)
+	assert.Equal(t, true, errors.Is(err, base.ErrRecordInvalidFormat))
+	err = group.StopRecord(base.RecordFormatFlv)
+	assert.Equal(t, true, errors.Is(err, base.ErrRecordNotStarted))
+
+	assert.Equal(t, nil, group.StartRecord(base.RecordFormatFlv, dir, 0))
+	err = group.StartRecord(base.RecordFormatFlv, dir, 0)
+	assert.Equal(t, true, errors.Is(err, base.ErrRecordAlreadyStarted))
+
+	stat := group.GetStat(0)
+	assert.Equal(t, 1, len(stat.StatRecords))
+	assert.Equal(t, base.RecordFormatFlv, stat.StatRecords[0].Format)
+	assert.Equal(t, true, stat.StatRecords[0].OnDemand)
+	assert.Equal(t, dir, filepath.Dir(stat.StatRecords[0].Path))
+
+	// 等待关键帧
+	video(inter, 80)
+	video(key, 120)
+	video(inter, 160)
+	assert.Equal(t, nil, group.StopRecord(base.RecordFormatFlv))
+	assert.Equal(t, 0, len(group.GetStat(0).StatRecords))
+
+	assert.Equal(t, 1, len(observer.done))
+	assert.Equal(t, 0.04, observer.done[0].Duration)
+	b, err := ioutil.ReadFile(observer.done[0].Path)
+	assert.Equal(t, nil, err)
+	b = b[len(httpflv.FlvHeader):]
+	assert.Equal(t, uint8(base.RtmpTypeIdVideo), b[0])
+	assert.Equal(t, seqHeader, b[httpflv.TagHeaderSize:httpflv.TagHeaderSize+len(seqHeader)])
+	b = b[httpflv.TagHeaderSize+len(seqHeader)+httpflv.PrevTagSizeFieldSize:]
+	assert.Equal(t, key, b[httpflv.TagHeaderSize:httpflv.TagHeaderSize+len(key)])
+
+	// 达到最长录制时长后自动停止
+	assert.Equal(t, nil, group.StartRecord(base.RecordFormatFlv, "", 10))
+	assert.Equal(t, filepath.Join(dir, "default"), filepath.Dir(group.recordFlvSegment.filename))
+	group.recordCtrls[base.RecordFormatFlv].deadline = time.Now()
+	group.tickRecordCtrls()
+	assert.Equal(t, true, group.recordFlv == nil)
+	assert.Equal(t, 2, len(observer.done))
+
+	// 输入流断开时停止
+	assert.Equal(t, nil, group.StartRecord(base.RecordFormatMpegts, dir, 0))
+	assert.Equal(t, true, group.rtmp2MpegtsRemuxer != nil)
+	group.delIn()
+	assert.Equal(t, true, group.recordMpegts == nil)
+	assert.Equal(t, 0, len(group.recordCtrls))
+	assert.Equal(t, 3, len(observer.done))
+}
+
+type blockingRecordObserver struct {
+	IGroupObserver
+	done    chan base.RecordFileDoneInfo
+	release chan struct{}
+}
+
+func (m *blockingRecordObserver) OnRecordFileDone(info base.RecordFileDoneInfo) {
+	m.done <- info
+	<-m.release
+}
+
+func TestRecordMp4FinalizeInBackground(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_record_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{}
+	config.RecordConfig.EnableMp4 = true
+	config.RecordConfig.Mp4OutPath = dir
+	config.RecordConfig.Mp4FinalizeFlag = true
+	observer := &blockingRecordObserver{
+		done:    make(chan base.RecordFileDoneInfo),
+		release: make(chan struct{}),
+	}
+	group := NewGroup("live", "test", config, observer)
+
+	r := newRecordMp4(group, time.Now())
+	r.initSegment = []byte("not a valid fmp4")
+	r.openFile(time.Now())
+	filename := r.segment.filename
+	r.segment.update(0, 0)
+	r.segment.update(1000, 0)
+	r.closeFile(true)
+	closedAt := time.Now()
+
+	// 转换完成之前，依然视为正在写入的文件
+	info := <-observer.done
+	assert.Equal(t, filename, info.Path)
+	assert.Equal(t, []string{filename}, finalizingRecordMp4.list())
+	sm := &ServerManager{
+		groupManager: NewSimpleGroupManager(nil),
+	}
+	_, ok := sm.GetRecordingFiles()[filepath.Clean(filename)]
+	assert.Equal(t, true, ok)
+
+	// 结束时间为停止写入的时间，不包含转换的耗时
+	endTime, err := parseRecordTime(info.EndTime)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, endTime.After(closedAt))
+
+	close(observer.release)
+	for i := 0; i < 100 && len(finalizingRecordMp4.list()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, len(finalizingRecordMp4.list()))
+}
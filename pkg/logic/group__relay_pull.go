@@ -234,6 +234,7 @@ func (group *Group) pullIfNeeded() (string, error) {
 		rtspSession = rtsp.NewPullSession(group, func(option *rtsp.PullSessionOption) {
 			option.PullTimeoutMs = group.pullProxy.pullTimeoutMs
 			option.OverTcp = group.pullProxy.rtspMode == 0
+			option.EnableOnvifMetadata = group.config.RtspConfig.PullOnvifMetadataEnable
 		}).WithOnDescribeResponse(func() {
 			err := group.AddRtspPullSession(rtspSession)
 			if err != nil {
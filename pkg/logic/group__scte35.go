@@ -0,0 +1,109 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"encoding/base64"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/lal/pkg/rtmp"
+)
+
+// group__scte35.go
+//
+// SCTE-35广告信令，见 ILalServer.CtrlSplice
+//
+// 信令由 remux.Rtmp2MpegtsRemuxer 在视频关键帧处输出到mpegts（hls、http-ts、ts录制），
+// 同时转换为`onCuePoint`数据消息，广播给rtmp、http-flv等输出
+//
+
+// ScheduleSplice 预约插入SCTE-35广告信令，见 remux.Rtmp2MpegtsRemuxer.ScheduleSplice
+//
+func (group *Group) ScheduleSplice(info base.ApiCtrlSpliceReq) bool {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	if group.rtmp2MpegtsRemuxer == nil {
+		return false
+	}
+
+	splice := mpegts.Scte35SpliceInfo{
+		CommandType:  mpegts.Scte35CommandSpliceInsert,
+		EventId:      info.EventId,
+		OutOfNetwork: info.OutOfNetwork,
+		Immediate:    info.Pts < 0,
+		Duration:     uint64(info.DurationMs) * 90,
+		AutoReturn:   info.AutoReturn,
+	}
+	if info.Command == base.SpliceCommandTimeSignal {
+		splice.CommandType = mpegts.Scte35CommandTimeSignal
+	}
+	if info.Pts >= 0 {
+		splice.PtsTime = uint64(info.Pts)
+	}
+	return group.rtmp2MpegtsRemuxer.ScheduleSplice(splice)
+}
+
+// cacheCuePoint 将SCTE-35信令转换为onCuePoint消息并缓存
+//
+// 注意，该函数在 remux.Rtmp2MpegtsRemuxer 的回调中执行，所以不能直接广播，见 broadcastCuePoints
+//
+func (group *Group) cacheCuePoint(frame *mpegts.Frame) {
+	info, err := mpegts.ParseScte35Section(frame.Raw)
+	if err != nil {
+		Log.Warnf("[%s] parse scte35 section failed. err=%+v", group.UniqueKey, err)
+		return
+	}
+
+	command := base.SpliceCommandInsert
+	if info.CommandType == mpegts.Scte35CommandTimeSignal {
+		command = base.SpliceCommandTimeSignal
+	}
+	timestamp := uint32(frame.Dts / 90)
+
+	// Amf0.WriteObject 不支持嵌套，所以SCTE-35的字段直接放在parameters所在的层级
+	opa := rtmp.ObjectPairArray{
+		{Key: "name", Value: "scte35"},
+		{Key: "time", Value: float64(timestamp) / 1000},
+		{Key: "type", Value: "event"},
+		{Key: "command", Value: command},
+		{Key: "event_id", Value: int(info.EventId)},
+		{Key: "out_of_network", Value: info.OutOfNetwork},
+		{Key: "cancel", Value: info.Cancel},
+		{Key: "duration", Value: float64(info.Duration) / 90000},
+		{Key: "scte35", Value: base64.StdEncoding.EncodeToString(frame.Raw)},
+	}
+	payload, err := rtmp.BuildCuePoint(opa)
+	if err != nil {
+		Log.Errorf("[%s] build cue point failed. err=%+v", group.UniqueKey, err)
+		return
+	}
+
+	group.cuePointMsgs = append(group.cuePointMsgs, base.RtmpMsg{
+		Header: base.RtmpHeader{
+			Csid:         rtmp.CsidAmf,
+			MsgLen:       uint32(len(payload)),
+			MsgTypeId:    base.RtmpTypeIdMetadata,
+			MsgStreamId:  rtmp.Msid1,
+			TimestampAbs: timestamp,
+		},
+		Payload: payload,
+	})
+}
+
+func (group *Group) broadcastCuePoints() {
+	if len(group.cuePointMsgs) == 0 {
+		return
+	}
+	msgs := group.cuePointMsgs
+	group.cuePointMsgs = nil
+	for _, msg := range msgs {
+		group.broadcastByRtmpMsg(msg)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"time"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hevc"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/lal/pkg/sdp"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// group__sei.go
+//
+// 视频帧SEI的读取和注入，见 ISeiHandler ，以及 debug.latency_sei_enable 开启后的延迟测量SEI的注入
+//
+
+// WithSeiHandler 设置SEI处理接口，nil表示不处理SEI
+//
+func (group *Group) WithSeiHandler(handler ISeiHandler) *Group {
+	group.seiHandler = handler
+	return group
+}
+
+func (group *Group) shouldHandleSei() bool {
+	return group.seiHandler != nil || group.config.DebugConfig.LatencySeiEnable
+}
+
+// handleSei
+//
+// @param msg: rtmp视频消息（非seq header）
+//
+// @return 如果注入了SEI，返回的消息的Payload为内部独立新申请的内存块；否则原样返回
+//
+func (group *Group) handleSei(msg base.RtmpMsg) base.RtmpMsg {
+	var isHevc bool
+	switch msg.VideoCodecId() {
+	case base.RtmpCodecIdAvc:
+	case base.RtmpCodecIdHevc:
+		isHevc = true
+	default:
+		return msg
+	}
+	if len(msg.Payload) < 5 || msg.Payload[1] != base.RtmpAvcPacketTypeNalu {
+		return msg
+	}
+
+	info := SeiFrameInfo{
+		AppName:       group.appName,
+		StreamName:    group.streamName,
+		VideoCodec:    base.VideoCodecAvc,
+		Dts:           msg.Header.TimestampAbs,
+		Pts:           msg.Header.TimestampAbs + bele.BeUint24(msg.Payload[2:]),
+		IsKeyFrame:    msg.IsVideoKeyNalu(),
+		CaptureTimeMs: msg.CaptureTimeMs,
+	}
+	if isHevc {
+		info.VideoCodec = base.VideoCodecHevc
+	}
+
+	// # 读取
+	var readMsgs []avc.SeiMessage
+	firstVclPos := -1
+	pos := 5
+	err := avc.IterateNaluAvcc(msg.Payload[5:], func(nal []byte) {
+		// 加上4字节长度
+		naluPos := pos
+		pos += 4 + len(nal)
+
+		var (
+			seiMsgs []avc.SeiMessage
+			err     error
+		)
+		if isHevc {
+			t := hevc.ParseNaluType(nal[0])
+			if t == hevc.NaluTypeSei || t == hevc.NaluTypeSeiSuffix {
+				seiMsgs, err = hevc.ParseSei(nal)
+			} else if t < hevc.NaluTypeVps && firstVclPos == -1 {
+				firstVclPos = naluPos
+			}
+		} else {
+			t := avc.ParseNaluType(nal[0])
+			if t == avc.NaluTypeSei {
+				seiMsgs, err = avc.ParseSei(nal)
+			} else if t >= avc.NaluTypeSlice && t <= avc.NaluTypeIdrSlice && firstVclPos == -1 {
+				firstVclPos = naluPos
+			}
+		}
+		if err != nil {
+			Log.Warnf("[%s] parse sei failed. err=%+v", group.UniqueKey, err)
+			return
+		}
+		readMsgs = append(readMsgs, seiMsgs...)
+	})
+	if err != nil {
+		Log.Warnf("[%s] iterate nalu failed. err=%+v", group.UniqueKey, err)
+		return msg
+	}
+	if len(readMsgs) > 0 && group.seiHandler != nil {
+		group.seiHandler.OnReadSei(info, readMsgs)
+	}
+
+	// # 注入
+	var writeMsgs []avc.SeiMessage
+	if group.seiHandler != nil {
+		writeMsgs = group.seiHandler.OnWriteSei(info)
+	}
+	if group.config.DebugConfig.LatencySeiEnable {
+		// 已经携带了延迟测量SEI的（比如上游也是开启了该功能的lalserver），保留上游的，不再重复注入
+		hasLatencySei := false
+		for _, m := range readMsgs {
+			if _, ok := remux.ParseLatencySei(m); ok {
+				hasLatencySei = true
+				break
+			}
+		}
+		if !hasLatencySei {
+			writeMsgs = append(writeMsgs, remux.BuildLatencySei(remux.LatencySeiInfo{
+				IngestUnixMs:  time.Now().UnixNano() / 1e6,
+				CaptureUnixMs: msg.CaptureTimeMs,
+			}))
+		}
+	}
+	if len(writeMsgs) == 0 {
+		return msg
+	}
+	var seiNalu []byte
+	if isHevc {
+		seiNalu = hevc.BuildSei(writeMsgs, false)
+	} else {
+		seiNalu = avc.BuildSei(writeMsgs)
+	}
+	// 插入在第一个视频数据nalu之前，保证位于aud、参数集之后
+	if firstVclPos == -1 {
+		firstVclPos = len(msg.Payload)
+	}
+	payload := make([]byte, len(msg.Payload)+4+len(seiNalu))
+	copy(payload, msg.Payload[:firstVclPos])
+	bele.BePutUint32(payload[firstVclPos:], uint32(len(seiNalu)))
+	copy(payload[firstVclPos+4:], seiNalu)
+	copy(payload[firstVclPos+4+len(seiNalu):], msg.Payload[firstVclPos:])
+
+	msg.Payload = payload
+	msg.Header.MsgLen = uint32(len(payload))
+	return msg
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// seiRtpRepacker 输入为rtsp并且需要注入SEI时，rtsp输出的视频轨道使用注入后的视频帧重新打包
+//
+// 输入的sdp，以及音频、onvif metadata等其他轨道的rtp依然透传，所以不受 remux.Rtmp2RtspRemuxer 支持的音频格式的限制。
+// 重新打包的rtp沿用输入视频rtp的payload type和ssrc。
+//
+// 注意，rtmp消息的时间戳已经被 rtsp.AvPacketQueue 调整为从0开始，而透传的音频等轨道依然是输入的原始时间戳，
+// 所以重新打包时不能直接使用rtmp消息的时间戳，而是缓存输入视频rtp中每一帧的原始时间戳，打包对应帧时沿用，
+// 保证输出的各轨道的时间戳对应关系与输入一致。
+//
+type seiRtpRepacker struct {
+	videoPt   base.AvPacketPt // lal内部定义的类型，用于选择分包方式
+	originPt  int             // 输入视频rtp的payload type，收到输入视频rtp之前为-1
+	ssrc      uint32
+	clockRate int
+	packer    *rtprtcp.RtpPacker
+
+	auTimestamps []uint32 // 输入视频rtp中还没有重新打包的各帧的原始时间戳，按到达顺序
+	hasOffset    bool
+	offsetMs     int64 // 原始时间戳换算成毫秒后，与rtmp消息中pts的差值
+	prevPts      int64
+}
+
+func newSeiRtpRepacker() *seiRtpRepacker {
+	return &seiRtpRepacker{
+		originPt: -1,
+	}
+}
+
+// onInputVideoRtp 记录输入视频rtp的payload type、ssrc以及每一帧的原始时间戳，输入的视频rtp本身被丢弃
+//
+func (r *seiRtpRepacker) onInputVideoRtp(sdpCtx *sdp.LogicContext, pkt rtprtcp.RtpPacket) {
+	if r.originPt == -1 {
+		r.videoPt = sdpCtx.GetVideoPayloadTypeBase()
+		r.originPt = int(pkt.Header.PacketType)
+		r.ssrc = pkt.Header.Ssrc
+		r.clockRate = sdpCtx.VideoClockRate
+		if r.clockRate < 1000 {
+			r.clockRate = 90000
+		}
+	}
+
+	// 同一帧的多个rtp包时间戳相同
+	n := len(r.auTimestamps)
+	if n > 0 && r.auTimestamps[n-1] == pkt.Header.Timestamp {
+		return
+	}
+	if n == seiRtpRepackerMaxCachedAu {
+		r.auTimestamps = r.auTimestamps[1:]
+	}
+	r.auTimestamps = append(r.auTimestamps, pkt.Header.Timestamp)
+}
+
+// pack
+//
+// @param msg: 注入SEI后的rtmp消息，非h264/h265视频数据以及seq header返回nil
+//
+func (r *seiRtpRepacker) pack(msg base.RtmpMsg) []rtprtcp.RtpPacket {
+	if r.originPt == -1 || msg.Header.MsgTypeId != base.RtmpTypeIdVideo || len(msg.Payload) <= 5 {
+		return nil
+	}
+	if msg.IsAvcKeySeqHeader() || msg.IsHevcKeySeqHeader() {
+		return nil
+	}
+	switch msg.VideoCodecId() {
+	case base.RtmpCodecIdAvc, base.RtmpCodecIdHevc:
+	default:
+		return nil
+	}
+	if r.videoPt != base.AvPacketPtAvc && r.videoPt != base.AvPacketPtHevc {
+		return nil
+	}
+
+	if r.packer == nil {
+		pp := rtprtcp.NewRtpPackerPayloadAvcHevc(r.videoPt, func(option *rtprtcp.RtpPackerPayloadAvcHevcOption) {
+			option.Typ = rtprtcp.RtpPackerPayloadAvcHevcTypeAvcc
+		})
+		r.packer = rtprtcp.NewRtpPacker(pp, r.clockRate, r.ssrc)
+	}
+	// rtp中的时间戳为pts
+	pts := int64(msg.Header.TimestampAbs + bele.BeUint24(msg.Payload[2:]))
+	return r.packer.PackWithRtpTimestamp(base.AvPacket{
+		Timestamp:     pts,
+		PayloadType:   base.AvPacketPt(r.originPt),
+		CaptureTimeMs: msg.CaptureTimeMs,
+		Payload:       msg.Payload[5:],
+	}, r.rtpTimestamp(pts))
+}
+
+// rtpTimestamp 找到pts对应帧的输入rtp原始时间戳
+//
+// @param pts: rtmp消息中的pts，单位毫秒
+//
+func (r *seiRtpRepacker) rtpTimestamp(pts int64) uint32 {
+	// rtmp消息的时间戳发生回退，说明 rtsp.AvPacketQueue 重新设置了基准，需要重新计算差值
+	if r.hasOffset && pts < r.prevPts {
+		r.hasOffset = false
+	}
+	r.prevPts = pts
+
+	if !r.hasOffset {
+		if len(r.auTimestamps) == 0 {
+			// 没有可以参考的输入时间戳，只能使用rtmp消息的时间戳
+			return uint32(float64(pts) * float64(r.clockRate) / 1000)
+		}
+		// 还没有重新打包的最早的一帧即为当前帧
+		r.hasOffset = true
+		r.offsetMs = r.rtpTsToMs(r.auTimestamps[0]) - pts
+	}
+
+	target := pts + r.offsetMs
+	for len(r.auTimestamps) > 0 {
+		ts := r.auTimestamps[0]
+		ms := r.rtpTsToMs(ts)
+		if ms > target {
+			break
+		}
+		r.auTimestamps = r.auTimestamps[1:]
+		if ms == target {
+			return ts
+		}
+		// 比当前帧早的，是没有单独输出的帧（比如只包含SEI的帧被合并到了下一帧），丢弃
+	}
+	return uint32(float64(target) * float64(r.clockRate) / 1000)
+}
+
+// rtpTsToMs 与 rtprtcp 中解包时的换算方式保持一致
+//
+func (r *seiRtpRepacker) rtpTsToMs(ts uint32) int64 {
+	return int64(ts / uint32(r.clockRate/1000))
+}
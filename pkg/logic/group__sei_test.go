@@ -0,0 +1,236 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/lal/pkg/sdp"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+type mockSeiHandler struct {
+	readInfo SeiFrameInfo
+	readMsgs []avc.SeiMessage
+	write    []avc.SeiMessage
+}
+
+func (m *mockSeiHandler) OnReadSei(info SeiFrameInfo, msgs []avc.SeiMessage) {
+	m.readInfo = info
+	m.readMsgs = msgs
+}
+
+func (m *mockSeiHandler) OnWriteSei(info SeiFrameInfo) []avc.SeiMessage {
+	return m.write
+}
+
+func TestGroupHandleSei(t *testing.T) {
+	inSei := []avc.SeiMessage{{PayloadType: avc.SeiPayloadTypeUserDataUnregistered, Payload: []byte("0123456789abcdef-in")}}
+	outSei := []avc.SeiMessage{{PayloadType: avc.SeiPayloadTypeUserDataUnregistered, Payload: []byte("0123456789abcdef-out")}}
+
+	appendNalu := func(out []byte, nal []byte) []byte {
+		l := make([]byte, 4)
+		bele.BePutUint32(l, uint32(len(nal)))
+		return append(append(out, l...), nal...)
+	}
+	idr := []byte{0x65, 0x88, 0x84, 0x00}
+	payload := []byte{base.RtmpAvcKeyFrame, base.RtmpAvcPacketTypeNalu, 0x0, 0x0, 0x28}
+	payload = appendNalu(payload, avc.BuildSei(inSei))
+	payload = appendNalu(payload, idr)
+
+	var msg base.RtmpMsg
+	msg.Header.MsgTypeId = base.RtmpTypeIdVideo
+	msg.Header.TimestampAbs = 1000
+	msg.Header.MsgLen = uint32(len(payload))
+	msg.Payload = payload
+
+	handler := &mockSeiHandler{write: outSei}
+	group := NewGroup("live", "test", &Config{}, nil).WithSeiHandler(handler)
+	ret := group.handleSei(msg)
+
+	assert.Equal(t, inSei, handler.readMsgs)
+	assert.Equal(t, uint32(1000), handler.readInfo.Dts)
+	assert.Equal(t, uint32(1040), handler.readInfo.Pts)
+	assert.Equal(t, true, handler.readInfo.IsKeyFrame)
+	assert.Equal(t, base.VideoCodecAvc, handler.readInfo.VideoCodec)
+
+	assert.Equal(t, uint32(len(ret.Payload)), ret.Header.MsgLen)
+	var nals [][]byte
+	err := avc.IterateNaluAvcc(ret.Payload[5:], func(nal []byte) {
+		nals = append(nals, nal)
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(nals))
+	assert.Equal(t, idr, nals[2])
+	seiMsgs, err := avc.ParseSei(nals[1])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, outSei, seiMsgs)
+
+	// 不注入时，原样返回
+	handler.write = nil
+	ret = group.handleSei(msg)
+	assert.Equal(t, payload, ret.Payload)
+}
+
+func TestGroupLatencySei(t *testing.T) {
+	payload := []byte{base.RtmpAvcInterFrame, base.RtmpAvcPacketTypeNalu, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x41, 0x9a, 0x00, 0x01}
+	var msg base.RtmpMsg
+	msg.Header.MsgTypeId = base.RtmpTypeIdVideo
+	msg.Header.MsgLen = uint32(len(payload))
+	msg.Payload = payload
+	msg.CaptureTimeMs = 1660000000000
+
+	var config Config
+	config.DebugConfig.LatencySeiEnable = true
+	group := NewGroup("live", "test", &config, nil)
+	assert.Equal(t, true, group.shouldHandleSei())
+	ret := group.handleSei(msg)
+
+	nals, err := avc.SplitNaluAvcc(ret.Payload[5:])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(nals))
+	info, ok := remux.FindLatencySei(nals, false)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, msg.CaptureTimeMs, info.CaptureUnixMs)
+
+	// 已经携带了延迟测量SEI的，不再重复注入
+	ret2 := group.handleSei(ret)
+	assert.Equal(t, ret.Payload, ret2.Payload)
+}
+
+func TestSeiRtpRepacker(t *testing.T) {
+	golden := `v=0
+o=- 1001 1 IN IP4 192.168.0.221
+s=VCP IPC Realtime stream
+m=video 0 RTP/AVP 105
+a=control:rtsp://192.168.0.221/media/video1/video
+a=rtpmap:105 H264/90000
+a=fmtp:105 profile-level-id=64002a; packetization-mode=1; sprop-parameter-sets=Z2QAKq2EAQwgCGEAQwgCGEAQwgCEO1A8ARPyzcBAQFAAAD6AAAnECEA=,aO4xshs=
+m=audio 0 RTP/AVP 8
+a=control:rtsp://192.168.0.221/media/video1/audio
+a=rtpmap:8 PCMA/8000
+m=application 0 RTP/AVP 107
+a=control:rtsp://192.168.0.221/media/video1/metadata
+a=rtpmap:107 vnd.onvif.metadata/90000`
+	sdpCtx, err := sdp.ParseSdp2LogicContext([]byte(strings.ReplaceAll(golden, "\n", "\r\n")))
+	assert.Equal(t, nil, err)
+
+	var config Config
+	config.RtspConfig.Enable = true
+	config.DebugConfig.LatencySeiEnable = true
+	group := NewGroup("live", "test", &config, nil)
+	group.seiRtpRepacker = newSeiRtpRepacker()
+
+	// 输入的sdp透传
+	group.OnSdp(sdpCtx)
+	assert.Equal(t, &sdpCtx, group.sdpCtx)
+
+	msg := base.RtmpMsg{
+		Payload: []byte{base.RtmpAvcInterFrame, base.RtmpAvcPacketTypeNalu, 0x0, 0x0, 0x28, 0x0, 0x0, 0x0, 0x4, 0x41, 0x9a, 0x00, 0x01},
+	}
+	msg.Header.MsgTypeId = base.RtmpTypeIdVideo
+	msg.Header.MsgLen = uint32(len(msg.Payload))
+	msg.Header.TimestampAbs = 1000
+	msg.CaptureTimeMs = 1660000000000
+
+	// 收到输入视频rtp之前，不知道payload type和ssrc，不打包
+	assert.Equal(t, 0, len(group.seiRtpRepacker.pack(msg)))
+
+	h := rtprtcp.MakeDefaultRtpHeader()
+	h.PacketType = 105
+	h.Ssrc = 0x12345678
+	h.Timestamp = 3000000
+	group.OnRtpPacket(rtprtcp.MakeRtpPacket(h, []byte{0x41, 0x9a}))
+	assert.Equal(t, 105, group.seiRtpRepacker.originPt)
+
+	// 沿用输入视频rtp的payload type、ssrc以及时间戳
+	pkts := group.seiRtpRepacker.pack(group.handleSei(msg))
+	assert.Equal(t, 2, len(pkts))
+	for i := range pkts {
+		assert.Equal(t, uint8(105), pkts[i].Header.PacketType)
+		assert.Equal(t, uint32(0x12345678), pkts[i].Header.Ssrc)
+		assert.Equal(t, uint32(3000000), pkts[i].Header.Timestamp)
+		assert.Equal(t, msg.CaptureTimeMs, pkts[i].CaptureTimeMs)
+	}
+	// 注入的延迟测量SEI在前，原始的slice在后
+	assert.Equal(t, avc.NaluTypeSei, avc.ParseNaluType(pkts[0].Body()[0]))
+	assert.Equal(t, []byte{0x41, 0x9a, 0x00, 0x01}, pkts[1].Body())
+
+	// seq header以及音频不打包
+	seqHeader := msg
+	seqHeader.Payload = []byte{base.RtmpAvcKeyFrame, base.RtmpAvcPacketTypeSeqHeader, 0, 0, 0, 1, 2, 3}
+	assert.Equal(t, 0, len(group.seiRtpRepacker.pack(seqHeader)))
+	audio := msg
+	audio.Header.MsgTypeId = base.RtmpTypeIdAudio
+	assert.Equal(t, 0, len(group.seiRtpRepacker.pack(audio)))
+}
+
+func TestSeiRtpRepackerKeepInputTimestamp(t *testing.T) {
+	golden := `v=0
+o=- 1001 1 IN IP4 192.168.0.221
+s=VCP IPC Realtime stream
+m=video 0 RTP/AVP 96
+a=rtpmap:96 H264/90000
+m=audio 0 RTP/AVP 8
+a=rtpmap:8 PCMA/8000`
+	sdpCtx, err := sdp.ParseSdp2LogicContext([]byte(strings.ReplaceAll(golden, "\n", "\r\n")))
+	assert.Equal(t, nil, err)
+	r := newSeiRtpRepacker()
+
+	rtpPacket := func(pt uint8, ts uint32) rtprtcp.RtpPacket {
+		h := rtprtcp.MakeDefaultRtpHeader()
+		h.PacketType = pt
+		h.Timestamp = ts
+		return rtprtcp.MakeRtpPacket(h, []byte{0x41, 0x9a})
+	}
+	videoMsg := func(pts uint32) base.RtmpMsg {
+		msg := base.RtmpMsg{
+			Payload: []byte{base.RtmpAvcInterFrame, base.RtmpAvcPacketTypeNalu, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x41, 0x9a},
+		}
+		msg.Header.MsgTypeId = base.RtmpTypeIdVideo
+		msg.Header.MsgLen = uint32(len(msg.Payload))
+		msg.Header.TimestampAbs = pts
+		return msg
+	}
+
+	// 输入的视频和音频rtp时间戳的基准都不为0，透传的音频rtp时间戳不变
+	const (
+		videoBase uint32 = 4000000000
+		audioBase uint32 = 123456
+	)
+	audio := rtpPacket(8, audioBase)
+	r.onInputVideoRtp(&sdpCtx, rtpPacket(96, videoBase))
+	r.onInputVideoRtp(&sdpCtx, rtpPacket(96, videoBase))
+	r.onInputVideoRtp(&sdpCtx, rtpPacket(96, videoBase+3600))
+	// 只包含SEI的帧，合并到下一帧输出，没有单独对应的rtmp消息
+	r.onInputVideoRtp(&sdpCtx, rtpPacket(96, videoBase+5400))
+	r.onInputVideoRtp(&sdpCtx, rtpPacket(96, videoBase+7200))
+
+	// rtmp消息的时间戳被 rtsp.AvPacketQueue 调整为从0开始
+	for i, pts := range []uint32{0, 40, 80} {
+		pkts := r.pack(videoMsg(pts))
+		assert.Equal(t, 1, len(pkts))
+		assert.Equal(t, videoBase+uint32(i)*3600, pkts[0].Header.Timestamp)
+		// 视频和音频之间的时间戳对应关系与输入一致
+		assert.Equal(t, videoBase-audioBase*90/8+uint32(i)*3600, pkts[0].Header.Timestamp-audio.Header.Timestamp*90/8)
+	}
+	assert.Equal(t, 0, len(r.auTimestamps))
+
+	// rtsp.AvPacketQueue 重新设置了基准后，重新对应
+	r.onInputVideoRtp(&sdpCtx, rtpPacket(96, 900000))
+	pkts := r.pack(videoMsg(0))
+	assert.Equal(t, 1, len(pkts))
+	assert.Equal(t, uint32(900000), pkts[0].Header.Timestamp)
+}
@@ -53,6 +53,13 @@ func (h *HttpApiServer) RunLoop() error {
 	mux.HandleFunc("/api/ctrl/stop_relay_pull", h.ctrlStopRelayPullHandler)
 	mux.HandleFunc("/api/ctrl/kick_session", h.ctrlKickSessionHandler)
 	mux.HandleFunc("/api/ctrl/start_rtp_pub", h.ctrlStartRtpPubHandler)
+	mux.HandleFunc("/api/ctrl/set_hls_variant_group", h.ctrlSetHlsVariantGroupHandler)
+	mux.HandleFunc("/api/ctrl/inject_timed_metadata", h.ctrlInjectTimedMetadataHandler)
+	mux.HandleFunc("/api/ctrl/splice", h.ctrlSpliceHandler)
+	mux.HandleFunc("/api/ctrl/start_record", h.ctrlStartRecordHandler)
+	mux.HandleFunc("/api/ctrl/stop_record", h.ctrlStopRecordHandler)
+	mux.HandleFunc("/api/record/list", h.recordListHandler)
+	mux.HandleFunc("/api/record/export", h.recordExportHandler)
 	mux.HandleFunc("/", h.notFoundHandler)
 
 	var srv http.Server
@@ -207,6 +214,164 @@ func (h *HttpApiServer) ctrlStartRtpPubHandler(w http.ResponseWriter, req *http.
 	return
 }
 
+func (h *HttpApiServer) ctrlSetHlsVariantGroupHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlSetHlsVariantGroupReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "name")
+	if err != nil || info.Name == "" {
+		Log.Warnf("http api set hls variant group error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api set hls variant group. req info=%+v", info)
+
+	resp := h.sm.CtrlSetHlsVariantGroup(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlInjectTimedMetadataHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlInjectTimedMetadataReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "stream_name", "value")
+	if err != nil {
+		Log.Warnf("http api inject timed metadata error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api inject timed metadata. req info=%+v", info)
+
+	resp := h.sm.CtrlInjectTimedMetadata(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlSpliceHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlSpliceReq
+
+	j, err := unmarshalRequestJsonBody(req, &info, "stream_name")
+	if err == nil && info.Command != "" && info.Command != base.SpliceCommandInsert && info.Command != base.SpliceCommandTimeSignal {
+		err = nazahttp.ErrParamMissing
+	}
+	if err != nil {
+		Log.Warnf("http api splice error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	if info.Command == "" {
+		info.Command = base.SpliceCommandInsert
+	}
+	if !j.Exist("pts") {
+		info.Pts = -1
+	}
+	if !j.Exist("out_of_network") {
+		info.OutOfNetwork = true
+	}
+
+	Log.Infof("http api splice. req info=%+v", info)
+
+	resp := h.sm.CtrlSplice(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlStartRecordHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlStartRecordReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "stream_name", "format")
+	if err == nil && (!isValidRecordFormat(info.Format) || info.MaxDurationSec < 0) {
+		err = nazahttp.ErrParamMissing
+	}
+	if err != nil {
+		Log.Warnf("http api start record error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api start record. req info=%+v", info)
+
+	resp := h.sm.CtrlStartRecord(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) ctrlStopRecordHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiCtrlStopRecordReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "stream_name", "format")
+	if err == nil && !isValidRecordFormat(info.Format) {
+		err = nazahttp.ErrParamMissing
+	}
+	if err != nil {
+		Log.Warnf("http api stop record error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api stop record. req info=%+v", info)
+
+	resp := h.sm.CtrlStopRecord(info)
+	feedback(resp, w)
+	return
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (h *HttpApiServer) recordListHandler(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	info := base.ApiRecordListReq{
+		StreamName: q.Get("stream_name"),
+		Format:     q.Get("format"),
+		StartTime:  q.Get("start_time"),
+		EndTime:    q.Get("end_time"),
+	}
+
+	resp := h.sm.RecordList(info)
+	feedback(resp, w)
+	return
+}
+
+func (h *HttpApiServer) recordExportHandler(w http.ResponseWriter, req *http.Request) {
+	var v base.HttpResponseBasic
+	var info base.ApiRecordExportReq
+
+	_, err := unmarshalRequestJsonBody(req, &info, "stream_name", "format", "start_time", "end_time")
+	if err == nil && info.Format != base.RecordFormatFlv && info.Format != base.RecordFormatMpegts && info.Format != base.RecordFormatMp4 {
+		err = nazahttp.ErrParamMissing
+	}
+	if err != nil {
+		Log.Warnf("http api record export error. err=%+v", err)
+		v.ErrorCode = base.ErrorCodeParamMissing
+		v.Desp = base.DespParamMissing
+		feedback(v, w)
+		return
+	}
+
+	Log.Infof("http api record export. req info=%+v", info)
+
+	resp := h.sm.RecordExport(info)
+	feedback(resp, w)
+	return
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (h *HttpApiServer) notFoundHandler(w http.ResponseWriter, req *http.Request) {
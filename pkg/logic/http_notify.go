@@ -105,6 +105,21 @@ func (h *HttpNotify) NotifyOnHlsMakeTs(info base.HlsMakeTsInfo) {
 	h.asyncPost(h.cfg.OnHlsMakeTs, info)
 }
 
+func (h *HttpNotify) NotifyOnOnvifMetadata(info base.OnvifMetadataInfo) {
+	info.ServerId = h.serverId
+	h.asyncPost(h.cfg.OnOnvifMetadata, info)
+}
+
+func (h *HttpNotify) NotifyOnRecordFileDone(info base.RecordFileDoneInfo) {
+	info.ServerId = h.serverId
+	h.asyncPost(h.cfg.OnRecordFileDone, info)
+}
+
+func (h *HttpNotify) NotifyOnRecordDiskGuard(info base.RecordDiskGuardInfo) {
+	info.ServerId = h.serverId
+	h.asyncPost(h.cfg.OnRecordDiskGuard, info)
+}
+
 // ----- implement INotifyHandler interface ----------------------------------------------------------------------------
 
 func (h *HttpNotify) OnServerStart(info base.LalInfo) {
@@ -147,6 +162,18 @@ func (h *HttpNotify) OnHlsMakeTs(info base.HlsMakeTsInfo) {
 	h.NotifyOnHlsMakeTs(info)
 }
 
+func (h *HttpNotify) OnOnvifMetadata(info base.OnvifMetadataInfo) {
+	h.NotifyOnOnvifMetadata(info)
+}
+
+func (h *HttpNotify) OnRecordFileDone(info base.RecordFileDoneInfo) {
+	h.NotifyOnRecordFileDone(info)
+}
+
+func (h *HttpNotify) OnRecordDiskGuard(info base.RecordDiskGuardInfo) {
+	h.NotifyOnRecordDiskGuard(info)
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (h *HttpNotify) RunLoop() {
@@ -14,6 +14,7 @@ import (
 
 	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/httpfmp4"
 	"github.com/q191201771/lal/pkg/httpts"
 )
 
@@ -29,6 +30,9 @@ type IHttpServerHandlerObserver interface {
 
 	OnNewHttptsSubSession(session *httpts.SubSession) error
 	OnDelHttptsSubSession(session *httpts.SubSession)
+
+	OnNewHttpfmp4SubSession(session *httpfmp4.SubSession) error
+	OnDelHttpfmp4SubSession(session *httpfmp4.SubSession)
 }
 
 type HttpServerHandler struct {
@@ -93,4 +97,18 @@ func (h *HttpServerHandler) ServeSubSession(writer http.ResponseWriter, req *htt
 		h.observer.OnDelHttptsSubSession(session)
 		return
 	}
+
+	if strings.HasSuffix(urlCtx.LastItemOfPath, ".mp4") {
+		session := httpfmp4.NewSubSession(conn, urlCtx, isWebSocket, webSocketKey)
+		Log.Debugf("[%s] < read http request. url=%s", session.UniqueKey(), session.Url())
+		if err = h.observer.OnNewHttpfmp4SubSession(session); err != nil {
+			Log.Infof("[%s] dispose by observer. err=%+v", session.UniqueKey(), err)
+			_ = session.Dispose()
+			return
+		}
+		err = session.RunLoop()
+		Log.Debugf("[%s] httpfmp4 sub session loop done. err=%v", session.UniqueKey(), err)
+		h.observer.OnDelHttpfmp4SubSession(session)
+		return
+	}
 }
@@ -9,8 +9,10 @@
 package logic
 
 import (
-	"github.com/q191201771/lal/pkg/base"
 	"path/filepath"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
 )
 
 // ---------------------------------------------------------------------------------------------------------------------
@@ -42,6 +44,13 @@ type ILalServer interface {
 	CtrlStartRelayPull(info base.ApiCtrlStartRelayPullReq) base.ApiCtrlStartRelayPull
 	CtrlStopRelayPull(streamName string) base.ApiCtrlStopRelayPull
 	CtrlKickSession(info base.ApiCtrlKickSessionReq) base.HttpResponseBasic
+	CtrlSetHlsVariantGroup(info base.ApiCtrlSetHlsVariantGroupReq) base.HttpResponseBasic
+	CtrlInjectTimedMetadata(info base.ApiCtrlInjectTimedMetadataReq) base.HttpResponseBasic
+	CtrlSplice(info base.ApiCtrlSpliceReq) base.HttpResponseBasic
+	CtrlStartRecord(info base.ApiCtrlStartRecordReq) base.HttpResponseBasic
+	CtrlStopRecord(info base.ApiCtrlStopRecordReq) base.HttpResponseBasic
+	RecordList(info base.ApiRecordListReq) base.ApiRecordList
+	RecordExport(info base.ApiRecordExportReq) base.ApiRecordExport
 }
 
 // NewLalServer 创建一个lal server
@@ -78,8 +87,56 @@ type INotifyHandler interface {
 	OnRelayPullStop(info base.PullStopInfo)
 	OnRtmpConnect(info base.RtmpConnectInfo)
 	OnHlsMakeTs(info base.HlsMakeTsInfo)
+
+	// OnOnvifMetadata rtsp输入流的onvif metadata数据轨道收到一个完整的xml文档
+	//
+	// 注意，rtsp pull需要开启配置项 rtsp.pull_onvif_metadata_enable 才会SETUP该数据轨道
+	//
+	OnOnvifMetadata(info base.OnvifMetadataInfo)
+
+	// OnRecordFileDone 一个录制文件写入完成，开启了切片时，每个切片文件回调一次
+	//
+	OnRecordFileDone(info base.RecordFileDoneInfo)
+
+	// OnRecordDiskGuard 录制目录所在磁盘的剩余空间不足，暂停录制，以及恢复录制
+	//
+	OnRecordDiskGuard(info base.RecordDiskGuardInfo)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// ISeiHandler 读取输入流视频帧中的SEI，以及向输出流的视频帧中注入SEI
+//
+// 注意，所有输出流（rtmp、httpflv、httpts、hls、rtsp、录制）都基于注入后的视频帧，所以注入结果在各协议间是一致的。
+// 输入是rtsp时，如果设置了该接口，rtsp输出的视频轨道不再透传rtp，而是由注入后的视频帧重新打包，
+// sdp以及音频、onvif metadata等其他轨道依然透传。
+//
+// 注意，回调发生在group的音视频数据处理流程中（持有group的锁），业务方不应阻塞，也不应在回调中调用 ILalServer 的接口。
+//
+type ISeiHandler interface {
+	// OnReadSei 输入流的视频帧中携带了SEI
+	//
+	// @param msgs: 该帧所有SEI nalu中的sei_message。注意，回调结束后内部不再持有，业务方可自由使用
+	//
+	OnReadSei(info SeiFrameInfo, msgs []avc.SeiMessage)
+
+	// OnWriteSei 返回需要注入到该视频帧中的sei_message，返回nil表示不注入
+	//
+	OnWriteSei(info SeiFrameInfo) []avc.SeiMessage
 }
 
+type SeiFrameInfo struct {
+	AppName       string
+	StreamName    string
+	VideoCodec    string // base.VideoCodecAvc 或 base.VideoCodecHevc
+	Dts           uint32 // 单位毫秒
+	Pts           uint32 // 单位毫秒
+	IsKeyFrame    bool
+	CaptureTimeMs int64 // 见 base.RtmpMsg.CaptureTimeMs
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
 type Option struct {
 	// ConfFilename 配置文件，注意，如果为空，内部会尝试从 DefaultConfFilenameList 读取默认配置文件
 	//
@@ -93,10 +150,17 @@ type Option struct {
 	// 注意，如果业务方实现了自己的事件监听，则lal server内部不再走http notify的逻辑（也即二选一）。
 	//
 	NotifyHandler INotifyHandler
+
+	// SeiHandler
+	//
+	// 视频帧SEI的读取和注入，详见 ISeiHandler 。不填写则不处理SEI。
+	//
+	SeiHandler ISeiHandler
 }
 
 var defaultOption = Option{
 	NotifyHandler: nil, // 注意，为nil时，内部会赋值为 HttpNotify
+	SeiHandler:    nil,
 }
 
 type ModOption func(option *Option)
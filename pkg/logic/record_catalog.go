@@ -0,0 +1,303 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// recordCatalog 录制文件的索引
+//
+// - 每个录制文件写入完成时，以及hls分片写入完成时（只在hls分片不会被自动删除时），增加一条记录
+// - 持久化到 RecordConfig.CatalogFile 中，每行一条json格式的记录，追加写入
+// - 保留策略删除文件时调用 Remove 去掉对应的记录，另外定时调用 Compact 去掉文件已经不存在的记录（比如被手动删除了），
+//   两者都会在必要时重写持久化文件
+// - 启动时加载，并去掉文件已经不存在的记录
+//
+type recordCatalog struct {
+	filename string
+
+	mutex   sync.Mutex
+	items   []base.RecordItem
+	fp      *os.File
+	removed int // 上次重写持久化文件后，内存中去掉了多少条记录，也即持久化文件中无效记录的数量
+}
+
+type recordCatalogFilter struct {
+	streamName string
+	format     string
+	startTime  time.Time // 为零值时不限制
+	endTime    time.Time
+}
+
+func newRecordCatalog(filename string) (*recordCatalog, error) {
+	c := &recordCatalog{
+		filename: filename,
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return nil, err
+	}
+
+	// 加载已有的记录
+	content, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range bytes.Split(content, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		var item base.RecordItem
+		if err := json.Unmarshal(line, &item); err != nil {
+			// 比如进程退出时只写了一半
+			Log.Warnf("record catalog skip invalid line. line=%s, err=%+v", string(line), err)
+			continue
+		}
+		if _, err := os.Stat(item.Path); err != nil {
+			continue
+		}
+		c.items = append(c.items, item)
+	}
+
+	// 重写文件，去掉无效的记录
+	if err := c.rewrite(); err != nil {
+		return nil, err
+	}
+	Log.Infof("record catalog loaded. filename=%s, count=%d", filename, len(c.items))
+	return c, nil
+}
+
+func (c *recordCatalog) Add(item base.RecordItem) {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.fp == nil {
+		// 已经销毁，比如mp4在单独的协程中转换完成
+		return
+	}
+	c.items = append(c.items, item)
+	if _, err := c.fp.Write(b); err != nil {
+		Log.Errorf("record catalog write failed. err=%+v", err)
+	}
+}
+
+// List 按开始时间排序返回符合条件的记录
+//
+// 注意，不检查文件是否存在，被保留策略以外的方式删除的文件，在下一次 Compact 之前依然会返回
+//
+func (c *recordCatalog) List(filter recordCatalogFilter) []base.RecordItem {
+	c.mutex.Lock()
+	var out []base.RecordItem
+	for _, item := range c.items {
+		if filter.match(item) {
+			out = append(out, item)
+		}
+	}
+	c.mutex.Unlock()
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].StartTime < out[j].StartTime
+	})
+	return out
+}
+
+// Remove 录制文件被删除时调用，去掉对应的记录
+//
+// 持久化文件中的无效记录较多时重写持久化文件
+//
+func (c *recordCatalog) Remove(path string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.fp == nil {
+		return
+	}
+	c.removeItems(map[string]struct{}{filepath.Clean(path): {}})
+	if c.removed >= recordCatalogRewriteMinRemoved && c.removed >= len(c.items) {
+		if err := c.rewrite(); err != nil {
+			Log.Errorf("record catalog rewrite failed. err=%+v", err)
+		}
+	}
+}
+
+// Compact 去掉文件已经不存在的记录，并重写持久化文件
+//
+// 注意，检查文件是否存在时不加锁，所以可以在单独的协程中执行
+//
+func (c *recordCatalog) Compact() {
+	c.mutex.Lock()
+	items := append([]base.RecordItem(nil), c.items...)
+	c.mutex.Unlock()
+
+	gone := make(map[string]struct{})
+	for _, item := range items {
+		if _, err := os.Stat(item.Path); err != nil {
+			gone[filepath.Clean(item.Path)] = struct{}{}
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.fp == nil {
+		return
+	}
+	c.removeItems(gone)
+	if c.removed == 0 {
+		return
+	}
+	if err := c.rewrite(); err != nil {
+		Log.Errorf("record catalog rewrite failed. err=%+v", err)
+		return
+	}
+	Log.Infof("record catalog compacted. filename=%s, count=%d", c.filename, len(c.items))
+}
+
+func (c *recordCatalog) Dispose() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.fp != nil {
+		_ = c.fp.Close()
+		c.fp = nil
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// removeItems 去掉path在paths中的记录，由调用方加锁
+//
+func (c *recordCatalog) removeItems(paths map[string]struct{}) {
+	if len(paths) == 0 {
+		return
+	}
+	n := 0
+	for _, item := range c.items {
+		if _, ok := paths[filepath.Clean(item.Path)]; ok {
+			continue
+		}
+		c.items[n] = item
+		n++
+	}
+	// 避免底层数组继续持有被去掉的记录
+	for i := n; i < len(c.items); i++ {
+		c.items[i] = base.RecordItem{}
+	}
+	c.removed += len(c.items) - n
+	c.items = c.items[:n]
+}
+
+// rewrite 使用内存中的记录重写持久化文件，由调用方加锁
+//
+func (c *recordCatalog) rewrite() error {
+	var buf bytes.Buffer
+	for _, item := range c.items {
+		b, err := json.Marshal(item)
+		if err != nil {
+			continue
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	tmp := c.filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0666); err != nil {
+		return err
+	}
+	if c.fp != nil {
+		_ = c.fp.Close()
+		c.fp = nil
+	}
+	// 注意，重命名失败时依然重新打开原来的文件，继续追加写入
+	renameErr := os.Rename(tmp, c.filename)
+	if renameErr != nil {
+		_ = os.Remove(tmp)
+	}
+	fp, err := os.OpenFile(c.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	c.fp = fp
+	if renameErr != nil {
+		return renameErr
+	}
+	c.removed = 0
+	return nil
+}
+
+func (f *recordCatalogFilter) match(item base.RecordItem) bool {
+	if f.streamName != "" && item.StreamName != f.streamName {
+		return false
+	}
+	if f.format != "" && item.Format != f.format {
+		return false
+	}
+	if f.startTime.IsZero() && f.endTime.IsZero() {
+		return true
+	}
+	start, err1 := parseRecordTime(item.StartTime)
+	end, err2 := parseRecordTime(item.EndTime)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	if !f.startTime.IsZero() && end.Before(f.startTime) {
+		return false
+	}
+	if !f.endTime.IsZero() && start.After(f.endTime) {
+		return false
+	}
+	return true
+}
+
+func parseRecordTime(s string) (time.Time, error) {
+	return time.ParseInLocation(base.RecordTimeLayout, s, time.Local)
+}
+
+// recordFileDoneInfo2Item recordHlsMakeTsInfo2Item
+//
+// 录制事件转换为索引记录
+//
+func recordFileDoneInfo2Item(info base.RecordFileDoneInfo) base.RecordItem {
+	return base.RecordItem{
+		AppName:    info.AppName,
+		StreamName: info.StreamName,
+		Format:     info.Format,
+		Path:       info.Path,
+		Size:       info.Size,
+		Duration:   info.Duration,
+		StartTime:  info.StartTime,
+		EndTime:    info.EndTime,
+	}
+}
+
+func recordHlsMakeTsInfo2Item(info base.HlsMakeTsInfo, now time.Time) base.RecordItem {
+	item := base.RecordItem{
+		StreamName: info.StreamName,
+		Format:     base.RecordFormatHls,
+		Path:       info.TsFile,
+		Duration:   info.Duration,
+		StartTime:  now.Add(-time.Duration(info.Duration * float64(time.Second))).Format(base.RecordTimeLayout),
+		EndTime:    now.Format(base.RecordTimeLayout),
+	}
+	if fi, err := os.Stat(info.TsFile); err == nil {
+		item.Size = fi.Size()
+	}
+	return item
+}
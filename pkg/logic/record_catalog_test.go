@@ -0,0 +1,297 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestRecordCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_record_catalog_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	catalogFile := filepath.Join(dir, "catalog", "catalog.jsonl")
+	c, err := newRecordCatalog(catalogFile)
+	assert.Equal(t, nil, err)
+
+	add := func(name string, format string, start string, end string) string {
+		filename := filepath.Join(dir, name)
+		assert.Equal(t, nil, ioutil.WriteFile(filename, make([]byte, 10), 0666))
+		c.Add(base.RecordItem{
+			AppName:    "live",
+			StreamName: "test",
+			Format:     format,
+			Path:       filename,
+			Size:       10,
+			StartTime:  start,
+			EndTime:    end,
+		})
+		return filename
+	}
+	second := add("2.flv", base.RecordFormatFlv, "2022-09-01 10:10:00", "2022-09-01 10:20:00")
+	first := add("1.flv", base.RecordFormatFlv, "2022-09-01 10:00:00", "2022-09-01 10:10:00")
+	ts := add("1.ts", base.RecordFormatMpegts, "2022-09-01 10:00:00", "2022-09-01 10:10:00")
+	removed := add("3.flv", base.RecordFormatFlv, "2022-09-01 10:20:00", "2022-09-01 10:30:00")
+	assert.Equal(t, nil, os.Remove(removed))
+
+	paths := func(items []base.RecordItem) (out []string) {
+		for _, item := range items {
+			out = append(out, item.Path)
+		}
+		return
+	}
+
+	// Compact 去掉文件已经不存在的记录
+	assert.Equal(t, 4, len(c.List(recordCatalogFilter{})))
+	c.Compact()
+
+	// 按开始时间排序
+	assert.Equal(t, []string{first, ts, second}, paths(c.List(recordCatalogFilter{})))
+	assert.Equal(t, []string{first, second}, paths(c.List(recordCatalogFilter{format: base.RecordFormatFlv})))
+	assert.Equal(t, 0, len(c.List(recordCatalogFilter{streamName: "other"})))
+
+	// 时间段过滤
+	start, _ := parseRecordTime("2022-09-01 10:12:00")
+	end, _ := parseRecordTime("2022-09-01 10:40:00")
+	assert.Equal(t, []string{second}, paths(c.List(recordCatalogFilter{startTime: start, endTime: end})))
+	assert.Equal(t, []string{first, ts}, paths(c.List(recordCatalogFilter{endTime: start.Add(-time.Minute * 5)})))
+
+	// Remove 去掉被保留策略删除的文件的记录
+	other := add("4.flv", base.RecordFormatFlv, "2022-09-01 10:30:00", "2022-09-01 10:40:00")
+	assert.Equal(t, nil, os.Remove(other))
+	c.Remove(other)
+	assert.Equal(t, []string{first, ts, second}, paths(c.List(recordCatalogFilter{})))
+	c.Dispose()
+
+	// 重新加载，去掉不存在的文件以及不完整的行
+	fp, err := os.OpenFile(catalogFile, os.O_WRONLY|os.O_APPEND, 0666)
+	assert.Equal(t, nil, err)
+	_, _ = fp.Write([]byte(`{"app_name":"live","stre`))
+	_ = fp.Close()
+	c, err = newRecordCatalog(catalogFile)
+	assert.Equal(t, nil, err)
+	defer c.Dispose()
+	assert.Equal(t, 3, len(c.items))
+	assert.Equal(t, []string{first, ts, second}, paths(c.List(recordCatalogFilter{})))
+	content, err := ioutil.ReadFile(catalogFile)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, bytes.Count(content, []byte{'\n'}))
+}
+
+func TestExportRecordClip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_record_export_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	seqHeader := []byte{0x17, base.RtmpAvcPacketTypeSeqHeader, 0, 0, 0, 1, 2, 3}
+	key := []byte{0x17, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 1, 0x65}
+	inter := []byte{0x27, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 1, 0x41}
+	makeMsg := func(payload []byte, ts uint32) base.RtmpMsg {
+		var msg base.RtmpMsg
+		msg.Header.MsgTypeId = base.RtmpTypeIdVideo
+		msg.Header.TimestampAbs = ts
+		msg.Header.MsgLen = uint32(len(payload))
+		msg.Payload = payload
+		return msg
+	}
+
+	// 每个文件4秒，每250毫秒一帧，每秒一个关键帧
+	writeFile := func(name string, start string, firstTs uint32) base.RecordItem {
+		filename := filepath.Join(dir, name)
+		var w httpflv.FlvFileWriter
+		assert.Equal(t, nil, w.Open(filename))
+		assert.Equal(t, nil, w.WriteFlvHeader())
+		assert.Equal(t, nil, w.WriteRaw(remux.RtmpMsg2FlvTag(makeMsg(seqHeader, firstTs)).Raw))
+		for i := uint32(0); i < 16; i++ {
+			payload := inter
+			if i%4 == 0 {
+				payload = key
+			}
+			assert.Equal(t, nil, w.WriteRaw(remux.RtmpMsg2FlvTag(makeMsg(payload, firstTs+i*250)).Raw))
+		}
+		assert.Equal(t, nil, w.Dispose())
+		return base.RecordItem{
+			AppName:    "live",
+			StreamName: "test",
+			Format:     base.RecordFormatFlv,
+			Path:       filename,
+			StartTime:  start,
+		}
+	}
+	sources := []base.RecordItem{
+		writeFile("1.flv", "2022-09-01 10:00:00", 5000),
+		writeFile("2.flv", "2022-09-01 10:00:05", 0),
+	}
+
+	start, _ := parseRecordTime("2022-09-01 10:00:02.5")
+	end, _ := parseRecordTime("2022-09-01 10:00:06.3")
+	filename := filepath.Join(dir, "export", "clip.flv")
+	item, err := exportRecordClip(sources, base.RecordFormatFlv, start, end, filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, filename, item.Path)
+	assert.Equal(t, "2022-09-01 10:00:02", item.StartTime)
+	assert.Equal(t, "2022-09-01 10:00:06.25", item.EndTime)
+	assert.Equal(t, 4.25, item.Duration)
+
+	// 从起始时间前的关键帧开始，保留文件之间的间隔
+	var r httpflv.FlvFileReader
+	assert.Equal(t, nil, r.Open(filename))
+	defer r.Dispose()
+	var (
+		tss     []uint32
+		headers int
+	)
+	for {
+		tag, err := r.ReadTag()
+		if err == io.EOF {
+			break
+		}
+		assert.Equal(t, nil, err)
+		msg := remux.FlvTag2RtmpMsg(tag)
+		if msg.IsVideoKeySeqHeader() {
+			headers++
+			continue
+		}
+		if len(tss) == 0 {
+			assert.Equal(t, true, msg.IsVideoKeyNalu())
+		}
+		tss = append(tss, msg.Header.TimestampAbs)
+	}
+	assert.Equal(t, 2, headers)
+	assert.Equal(t, []uint32{0, 250, 500, 750, 1000, 1250, 1500, 1750, 3000, 3250, 3500, 3750, 4000, 4250}, tss)
+
+	// 时间段内没有数据
+	start, _ = parseRecordTime("2022-09-01 11:00:00")
+	_, err = exportRecordClip(sources, base.RecordFormatFlv, start, start.Add(time.Minute), filename)
+	assert.Equal(t, base.ErrRecordExportEmpty, err)
+	_, err = os.Stat(filename)
+	assert.Equal(t, true, os.IsNotExist(err))
+}
+
+func TestExportRecordClipAudioBeforeKeyFrame(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_record_export_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	makeMsg := func(typeId uint8, payload []byte, ts uint32) base.RtmpMsg {
+		var msg base.RtmpMsg
+		msg.Header.MsgTypeId = typeId
+		msg.Header.TimestampAbs = ts
+		msg.Header.MsgLen = uint32(len(payload))
+		msg.Payload = payload
+		return msg
+	}
+	videoSeqHeader := []byte{0x17, base.RtmpAvcPacketTypeSeqHeader, 0, 0, 0, 1, 2, 3}
+	audioSeqHeader := []byte{0xAF, base.RtmpAacPacketTypeSeqHeader, 0x12, 0x10}
+	key := []byte{0x17, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 1, 0x65}
+	inter := []byte{0x27, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 1, 0x41}
+	audio := []byte{0xAF, base.RtmpAacPacketTypeRaw, 0x21, 0x10}
+
+	// 第一个视频关键帧之后，紧跟着一个时间戳比它早10毫秒的音频帧
+	filename := filepath.Join(dir, "1.flv")
+	var w httpflv.FlvFileWriter
+	assert.Equal(t, nil, w.Open(filename))
+	assert.Equal(t, nil, w.WriteFlvHeader())
+	for _, msg := range []base.RtmpMsg{
+		makeMsg(base.RtmpTypeIdVideo, videoSeqHeader, 1000),
+		makeMsg(base.RtmpTypeIdAudio, audioSeqHeader, 1000),
+		makeMsg(base.RtmpTypeIdVideo, key, 1000),
+		makeMsg(base.RtmpTypeIdAudio, audio, 990),
+		makeMsg(base.RtmpTypeIdAudio, audio, 1013),
+		makeMsg(base.RtmpTypeIdVideo, inter, 1040),
+		makeMsg(base.RtmpTypeIdAudio, audio, 1036),
+		makeMsg(base.RtmpTypeIdVideo, inter, 1080),
+	} {
+		assert.Equal(t, nil, w.WriteRaw(remux.RtmpMsg2FlvTag(msg).Raw))
+	}
+	assert.Equal(t, nil, w.Dispose())
+	sources := []base.RecordItem{{
+		AppName:    "live",
+		StreamName: "test",
+		Format:     base.RecordFormatFlv,
+		Path:       filename,
+		StartTime:  "2022-09-01 10:00:00",
+	}}
+
+	start, _ := parseRecordTime("2022-09-01 10:00:00")
+	end, _ := parseRecordTime("2022-09-01 10:01:00")
+	out := filepath.Join(dir, "export", "clip.flv")
+	item, err := exportRecordClip(sources, base.RecordFormatFlv, start, end, out)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0.08, item.Duration)
+
+	// 所有帧都导出，并且比第一帧早的音频帧按第一帧的时间处理
+	var r httpflv.FlvFileReader
+	assert.Equal(t, nil, r.Open(out))
+	defer r.Dispose()
+	var tss []uint32
+	for {
+		tag, err := r.ReadTag()
+		if err == io.EOF {
+			break
+		}
+		assert.Equal(t, nil, err)
+		msg := remux.FlvTag2RtmpMsg(tag)
+		if msg.IsVideoKeySeqHeader() || msg.IsAacSeqHeader() {
+			continue
+		}
+		tss = append(tss, msg.Header.TimestampAbs)
+	}
+	assert.Equal(t, []uint32{0, 0, 13, 40, 36, 80}, tss)
+}
+
+func TestRecordExportNoFlv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_record_export_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	c, err := newRecordCatalog(filepath.Join(dir, "catalog.jsonl"))
+	assert.Equal(t, nil, err)
+	sm := &ServerManager{
+		config:        &Config{},
+		recordCatalog: c,
+	}
+	req := base.ApiRecordExportReq{
+		StreamName: "test",
+		Format:     base.RecordFormatMp4,
+		StartTime:  "2022-09-01 10:00:00",
+		EndTime:    "2022-09-01 10:05:00",
+	}
+	ret := sm.RecordExport(req)
+	assert.Equal(t, base.ErrorCodeRecordNotFound, ret.ErrorCode)
+
+	// 只有ts、mp4录制文件时，明确告知只支持以flv录制文件为源
+	for _, format := range []string{base.RecordFormatMpegts, base.RecordFormatMp4} {
+		filename := filepath.Join(dir, "1."+format)
+		assert.Equal(t, nil, ioutil.WriteFile(filename, make([]byte, 10), 0666))
+		c.Add(base.RecordItem{
+			AppName:    "live",
+			StreamName: "test",
+			Format:     format,
+			Path:       filename,
+			StartTime:  "2022-09-01 10:00:00",
+			EndTime:    "2022-09-01 10:10:00",
+		})
+	}
+	ret = sm.RecordExport(req)
+	assert.Equal(t, base.ErrorCodeRecordExportNoFlv, ret.ErrorCode)
+	assert.Equal(t, base.ErrRecordExportNoFlv.Error()+". found=mp4,ts", ret.Desp)
+}
@@ -0,0 +1,369 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
+	"github.com/q191201771/lal/pkg/httpflv"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/lal/pkg/remux"
+)
+
+// 录制片段导出
+//
+// - 以flv录制文件为源，将时间段内的多个录制文件拼接为一个flv、ts或mp4文件，不重新编码
+// - 每个源文件中音视频的墙上时间，由文件的开始时间加上相对于文件第一帧的时间戳得到
+// - 从起始时间前最近的视频关键帧开始（纯音频流从起始时间前最近的一帧开始），到结束时间后的第一帧为止
+// - 输出文件的时间戳从0开始，源文件之间有间隔时保留间隔，有重叠时顺延，保证时间戳递增
+//
+
+// recordClipWriter 导出文件的写入，输入的rtmp消息的时间戳已经修改为输出文件的时间戳
+//
+type recordClipWriter interface {
+	FeedRtmpMessage(msg base.RtmpMsg) error
+	Close() error
+}
+
+// exportRecordClip
+//
+// @param sources:  flv录制文件，按开始时间排序
+// @param filename: 输出文件名，包含目录
+//
+// @return 输出文件对应的记录
+//
+func exportRecordClip(sources []base.RecordItem, format string, start time.Time, end time.Time, filename string) (item base.RecordItem, err error) {
+	if len(sources) == 0 {
+		return item, base.ErrRecordNotFound
+	}
+	if err = os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return
+	}
+
+	var w recordClipWriter
+	switch format {
+	case base.RecordFormatFlv:
+		w, err = newFlvClipWriter(filename)
+	case base.RecordFormatMpegts:
+		w, err = newMpegtsClipWriter(filename)
+	case base.RecordFormatMp4:
+		w, err = newMp4ClipWriter(filename)
+	default:
+		err = fmt.Errorf("%w. format=%s", base.ErrRecordInvalidFormat, format)
+	}
+	if err != nil {
+		return
+	}
+
+	c := &recordClipper{
+		w:       w,
+		startMs: start.UnixNano() / 1e6,
+		endMs:   end.UnixNano() / 1e6,
+	}
+	for _, source := range sources {
+		if err = c.feedFile(source); err != nil || c.done {
+			break
+		}
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !c.started {
+		err = base.ErrRecordExportEmpty
+	}
+	if err != nil {
+		_ = os.Remove(filename)
+		return
+	}
+
+	item = base.RecordItem{
+		AppName:    sources[0].AppName,
+		StreamName: sources[0].StreamName,
+		Format:     format,
+		Path:       filename,
+		Duration:   float64(c.lastMs-c.clipStartMs) / 1000,
+		StartTime:  time.Unix(0, c.clipStartMs*1e6).Format(base.RecordTimeLayout),
+		EndTime:    time.Unix(0, c.lastMs*1e6).Format(base.RecordTimeLayout),
+	}
+	if fi, err := os.Stat(filename); err == nil {
+		item.Size = fi.Size()
+	}
+	return item, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type recordClipper struct {
+	w       recordClipWriter
+	startMs int64 // 以下时间都是墙上时间，单位毫秒
+	endMs   int64
+
+	headers      rtmpHeaderCache
+	headersDirty bool        // 开始写入后，metadata或seq header发生了变化，需要重新写入
+	gop          []clipFrame // 开始写入前，缓存起始时间前最近的关键帧开始的数据
+	started      bool
+	done         bool
+	clipStartMs  int64
+	lastMs       int64 // 已处理的最后一帧的墙上时间
+}
+
+type clipFrame struct {
+	msg    base.RtmpMsg
+	wallMs int64
+}
+
+func (c *recordClipper) feedFile(source base.RecordItem) error {
+	fileStart, err := parseRecordTime(source.StartTime)
+	if err != nil {
+		return err
+	}
+
+	var r httpflv.FlvFileReader
+	if err := r.Open(source.Path); err != nil {
+		return err
+	}
+	defer r.Dispose()
+
+	var (
+		firstTs uint32
+		baseMs  int64
+		hasTs   bool
+	)
+	for {
+		tag, err := r.ReadTag()
+		if err != nil {
+			if err != io.EOF {
+				// 比如文件最后一个tag不完整
+				Log.Warnf("record export read flv tag failed, skip rest of file. filename=%s, err=%+v", source.Path, err)
+			}
+			return nil
+		}
+		msg := remux.FlvTag2RtmpMsg(tag)
+
+		if msg.Header.MsgTypeId == base.RtmpTypeIdMetadata || msg.IsVideoKeySeqHeader() || msg.IsAacSeqHeader() {
+			c.headers.feed(msg)
+			c.headersDirty = c.started
+			continue
+		}
+		if msg.Header.MsgTypeId != base.RtmpTypeIdAudio && msg.Header.MsgTypeId != base.RtmpTypeIdVideo {
+			continue
+		}
+
+		if !hasTs {
+			// 与上一个文件重叠时顺延
+			firstTs = msg.Header.TimestampAbs
+			baseMs = fileStart.UnixNano() / 1e6
+			if baseMs < c.lastMs {
+				baseMs = c.lastMs
+			}
+			hasTs = true
+		}
+		// 音视频交织的时间戳不一定严格递增，比第一帧早的（比如比第一个视频帧早几毫秒的音频帧）按第一帧的时间处理
+		delta := int64(msg.Header.TimestampAbs) - int64(firstTs)
+		if delta < 0 {
+			delta = 0
+		}
+		wallMs := baseMs + delta
+		if wallMs > c.endMs {
+			c.done = true
+			return nil
+		}
+		if err := c.feedFrame(msg, wallMs); err != nil {
+			return err
+		}
+		if wallMs > c.lastMs {
+			c.lastMs = wallMs
+		}
+	}
+}
+
+func (c *recordClipper) feedFrame(msg base.RtmpMsg, wallMs int64) error {
+	if c.started {
+		return c.write(msg, wallMs)
+	}
+
+	boundary := msg.IsVideoKeyNalu() || (msg.Header.MsgTypeId == base.RtmpTypeIdAudio && !c.headers.hasVideo())
+	if boundary {
+		c.gop = c.gop[:0]
+	} else if len(c.gop) == 0 {
+		// 从关键帧开始
+		return nil
+	}
+	c.gop = append(c.gop, clipFrame{msg: msg.Clone(), wallMs: wallMs})
+	if wallMs < c.startMs {
+		return nil
+	}
+
+	c.started = true
+	c.clipStartMs = c.gop[0].wallMs
+	c.headersDirty = true
+	for _, f := range c.gop {
+		if err := c.write(f.msg, f.wallMs); err != nil {
+			return err
+		}
+	}
+	c.gop = nil
+	return nil
+}
+
+func (c *recordClipper) write(msg base.RtmpMsg, wallMs int64) error {
+	// 同理，比起始关键帧早的帧按起始关键帧的时间处理
+	var ts uint32
+	if wallMs > c.clipStartMs {
+		ts = uint32(wallMs - c.clipStartMs)
+	}
+	if c.headersDirty {
+		c.headersDirty = false
+		for _, h := range c.headers.msgs() {
+			h.Header.TimestampAbs = ts
+			if err := c.w.FeedRtmpMessage(h); err != nil {
+				return err
+			}
+		}
+	}
+	msg.Header.TimestampAbs = ts
+	return c.w.FeedRtmpMessage(msg)
+}
+
+// ----- flv -----------------------------------------------------------------------------------------------------------
+
+type flvClipWriter struct {
+	fw httpflv.FlvFileWriter
+}
+
+func newFlvClipWriter(filename string) (*flvClipWriter, error) {
+	w := &flvClipWriter{}
+	if err := w.fw.Open(filename); err != nil {
+		return nil, err
+	}
+	if err := w.fw.WriteFlvHeader(); err != nil {
+		_ = w.fw.Dispose()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *flvClipWriter) FeedRtmpMessage(msg base.RtmpMsg) error {
+	return w.fw.WriteRaw(remux.RtmpMsg2FlvTag(msg).Raw)
+}
+
+func (w *flvClipWriter) Close() error {
+	return w.fw.Dispose()
+}
+
+// ----- ts ------------------------------------------------------------------------------------------------------------
+
+type mpegtsClipWriter struct {
+	fw      mpegts.FileWriter
+	remuxer *remux.Rtmp2MpegtsRemuxer
+	err     error
+}
+
+func newMpegtsClipWriter(filename string) (*mpegtsClipWriter, error) {
+	w := &mpegtsClipWriter{}
+	if err := w.fw.Create(filename); err != nil {
+		return nil, err
+	}
+	w.remuxer = remux.NewRtmp2MpegtsRemuxer(w)
+	return w, nil
+}
+
+func (w *mpegtsClipWriter) FeedRtmpMessage(msg base.RtmpMsg) error {
+	w.remuxer.FeedRtmpMessage(msg)
+	return w.err
+}
+
+func (w *mpegtsClipWriter) Close() error {
+	w.remuxer.Dispose()
+	if err := w.fw.Dispose(); w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+func (w *mpegtsClipWriter) OnPatPmt(b []byte) {
+	w.write(b)
+}
+
+func (w *mpegtsClipWriter) OnTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	w.write(tsPackets)
+}
+
+func (w *mpegtsClipWriter) write(b []byte) {
+	if w.err == nil {
+		w.err = w.fw.Write(b)
+	}
+}
+
+// ----- mp4 -----------------------------------------------------------------------------------------------------------
+
+// mp4ClipWriter 先写入fmp4临时文件，关闭时转换为普通mp4
+//
+type mp4ClipWriter struct {
+	filename    string
+	fw          fmp4.FileWriter
+	remuxer     *remux.Rtmp2Fmp4Remuxer
+	initWritten bool
+	err         error
+}
+
+func newMp4ClipWriter(filename string) (*mp4ClipWriter, error) {
+	w := &mp4ClipWriter{
+		filename: filename,
+	}
+	if err := w.fw.Create(filename + ".tmp"); err != nil {
+		return nil, err
+	}
+	w.remuxer = remux.NewRtmp2Fmp4Remuxer(w)
+	return w, nil
+}
+
+func (w *mp4ClipWriter) FeedRtmpMessage(msg base.RtmpMsg) error {
+	w.remuxer.FeedRtmpMessage(msg)
+	return w.err
+}
+
+func (w *mp4ClipWriter) Close() error {
+	w.remuxer.Dispose()
+	tmp := w.fw.Name()
+	defer os.Remove(tmp)
+	if err := w.fw.Dispose(); w.err == nil {
+		w.err = err
+	}
+	if w.err != nil || !w.initWritten {
+		return w.err
+	}
+	return fmp4.DefragmentFile(tmp, w.filename)
+}
+
+func (w *mp4ClipWriter) OnFmp4InitSegment(b []byte) {
+	// 普通mp4只能有一个moov，编码参数发生变化时，沿用第一个初始化分片
+	if w.initWritten {
+		Log.Warnf("record export mp4 ignore init segment since codec changed. filename=%s", w.filename)
+		return
+	}
+	w.initWritten = true
+	w.write(b)
+}
+
+func (w *mp4ClipWriter) OnFmp4Fragment(b []byte, startTs uint64, endTs uint64, boundary bool) {
+	w.write(b)
+}
+
+func (w *mp4ClipWriter) write(b []byte) {
+	if w.err == nil {
+		w.err = w.fw.Write(b)
+	}
+}
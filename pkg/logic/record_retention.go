@@ -0,0 +1,286 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// 录制文件的保留策略，以及磁盘空间保护
+//
+// - 定时检查配置文件中flv、ts、mp4的录制目录（包含子目录），删除超过 RecordConfig.RetentionMaxAgeSec 的录制文件，
+//   目录中录制文件的总大小超过 RecordConfig.RetentionMaxSizeMb 时，从最旧的文件开始删除
+// - 通过http api按需开启的录制，输出目录（包括配置文件中没有开启的格式的目录）也会被加入检查，见 AddOutPath
+// - 正在写入的文件不会被删除，hls的目录由hls自己管理，不会被删除
+// - 录制目录所在磁盘的剩余空间低于 RecordConfig.DiskLowWatermarkMb 时，暂停录制，恢复到水位线以上时继续录制，两者都会通知上层
+//
+
+// IRecordRetentionObserver
+//
+// 注意，回调在 recordRetention 自己的协程中执行
+//
+type IRecordRetentionObserver interface {
+	// GetRecordingFiles 正在写入的录制文件
+	//
+	GetRecordingFiles() map[string]struct{}
+
+	OnRecordDiskGuard(info base.RecordDiskGuardInfo)
+
+	// OnRecordFileRemoved 录制文件按照保留策略被删除
+	//
+	OnRecordFileRemoved(filename string)
+}
+
+type recordRetention struct {
+	config   *Config
+	observer IRecordRetentionObserver
+
+	mutex         sync.Mutex
+	outPaths      []string // flv、ts、mp4的录制目录，已去重
+	guardPaths    []string // 需要检查剩余空间的目录，包含hls目录
+	guardDisabled bool     // 当前平台不支持获取剩余空间
+	paused        bool
+
+	exitChan chan struct{}
+}
+
+func newRecordRetention(config *Config, observer IRecordRetentionObserver) *recordRetention {
+	r := &recordRetention{
+		config:   config,
+		observer: observer,
+		exitChan: make(chan struct{}, 1),
+	}
+
+	rc := &config.RecordConfig
+	r.outPaths = addRecordPath(r.outPaths, rc.EnableFlv, rc.FlvOutPath)
+	r.outPaths = addRecordPath(r.outPaths, rc.EnableMpegts, rc.MpegtsOutPath)
+	r.outPaths = addRecordPath(r.outPaths, rc.EnableMp4, rc.Mp4OutPath)
+	r.guardPaths = append(r.guardPaths, r.outPaths...)
+	r.guardPaths = addRecordPath(r.guardPaths, config.HlsConfig.Enable || config.HlsConfig.EnableHttps, config.HlsConfig.OutPath)
+	return r
+}
+
+// AddOutPath 通过http api开启flv、ts、mp4录制时调用，使得按需录制的目录也受保留策略以及磁盘空间保护的管理
+//
+func (r *recordRetention) AddOutPath(path string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.outPaths = addRecordPath(r.outPaths, true, path)
+	r.guardPaths = addRecordPath(r.guardPaths, true, path)
+}
+
+// isEnabled 是否配置了保留策略或者磁盘空间保护
+//
+func (r *recordRetention) isEnabled() bool {
+	rc := &r.config.RecordConfig
+	return rc.RetentionMaxAgeSec > 0 || rc.RetentionMaxSizeMb > 0 || rc.DiskLowWatermarkMb > 0
+}
+
+func (r *recordRetention) RunLoop() {
+	t := time.NewTicker(time.Duration(recordRetentionIntervalSec) * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.exitChan:
+			return
+		case <-t.C:
+			r.check(time.Now())
+		}
+	}
+}
+
+func (r *recordRetention) Dispose() {
+	r.exitChan <- struct{}{}
+}
+
+// check 执行一次检查，先删除过期的文件，再检查剩余空间
+//
+func (r *recordRetention) check(now time.Time) {
+	r.mutex.Lock()
+	outPaths := append([]string(nil), r.outPaths...)
+	r.mutex.Unlock()
+
+	rc := &r.config.RecordConfig
+	if rc.RetentionMaxAgeSec > 0 || rc.RetentionMaxSizeMb > 0 {
+		recording := r.observer.GetRecordingFiles()
+		for _, path := range outPaths {
+			r.cleanup(path, now, recording)
+		}
+	}
+
+	if rc.DiskLowWatermarkMb > 0 && !r.guardDisabled {
+		r.guard()
+	}
+}
+
+type recordFileInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// cleanup 按照保留策略删除outPath中的录制文件
+//
+// @return 被删除的文件
+//
+func (r *recordRetention) cleanup(outPath string, now time.Time, recording map[string]struct{}) (deleted []string) {
+	rc := &r.config.RecordConfig
+	hlsOutPath := filepath.Clean(r.config.HlsConfig.OutPath)
+
+	var (
+		files []recordFileInfo
+		total int64
+	)
+	err := filepath.Walk(outPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// 遍历过程中文件被删除等，忽略
+			return nil
+		}
+		if info.IsDir() {
+			if path != outPath && filepath.Clean(path) == hlsOutPath {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isRecordFile(path) {
+			return nil
+		}
+		if _, ok := recording[filepath.Clean(path)]; ok {
+			return nil
+		}
+		files = append(files, recordFileInfo{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		Log.Warnf("record retention walk failed. path=%s, err=%+v", outPath, err)
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	maxAge := time.Duration(rc.RetentionMaxAgeSec) * time.Second
+	maxSize := int64(rc.RetentionMaxSizeMb) * 1024 * 1024
+	for _, f := range files {
+		expired := rc.RetentionMaxAgeSec > 0 && now.Sub(f.modTime) > maxAge
+		oversize := rc.RetentionMaxSizeMb > 0 && total > maxSize
+		if !expired && !oversize {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			Log.Warnf("record retention remove file failed. filename=%s, err=%+v", f.path, err)
+			continue
+		}
+		Log.Infof("record retention remove file. filename=%s, size=%d, expired=%t", f.path, f.size, expired)
+		total -= f.size
+		deleted = append(deleted, f.path)
+		removeEmptyParentDirs(f.path, outPath)
+		r.observer.OnRecordFileRemoved(f.path)
+	}
+	return
+}
+
+// guard 检查剩余空间，并在暂停、恢复时通知上层
+//
+func (r *recordRetention) guard() {
+	watermark := int64(r.config.RecordConfig.DiskLowWatermarkMb)
+
+	r.mutex.Lock()
+	guardPaths := append([]string(nil), r.guardPaths...)
+	r.mutex.Unlock()
+
+	var (
+		minPath string
+		minFree int64 = -1
+	)
+	for _, path := range guardPaths {
+		free, err := base.GetDiskFreeBytes(path)
+		if err != nil {
+			if errors.Is(err, base.ErrDiskFreeNotSupported) {
+				Log.Warnf("record disk guard disabled. err=%+v", err)
+				r.guardDisabled = true
+				return
+			}
+			Log.Warnf("record disk guard get free space failed. path=%s, err=%+v", path, err)
+			continue
+		}
+		freeMb := int64(free / 1024 / 1024)
+		if minFree == -1 || freeMb < minFree {
+			minPath = path
+			minFree = freeMb
+		}
+	}
+	if minFree == -1 {
+		return
+	}
+
+	info := base.RecordDiskGuardInfo{
+		Path:        minPath,
+		FreeMb:      minFree,
+		WatermarkMb: watermark,
+	}
+	if !r.paused && minFree < watermark {
+		r.paused = true
+		info.Event = base.RecordDiskGuardEventPause
+		Log.Warnf("record disk space low, pause record. path=%s, free=%dMB, watermark=%dMB", minPath, minFree, watermark)
+		r.observer.OnRecordDiskGuard(info)
+	} else if r.paused && minFree >= watermark {
+		r.paused = false
+		info.Event = base.RecordDiskGuardEventResume
+		Log.Infof("record disk space recovered, resume record. path=%s, free=%dMB, watermark=%dMB", minPath, minFree, watermark)
+		r.observer.OnRecordDiskGuard(info)
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// addRecordPath 将path加入paths，已去重
+//
+func addRecordPath(paths []string, enable bool, path string) []string {
+	if !enable || path == "" {
+		return paths
+	}
+	path = filepath.Clean(path)
+	for _, p := range paths {
+		if p == path {
+			return paths
+		}
+	}
+	return append(paths, path)
+}
+
+func isRecordFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case "." + base.RecordFormatFlv, "." + base.RecordFormatMpegts, "." + base.RecordFormatMp4:
+		return true
+	}
+	return false
+}
+
+// removeEmptyParentDirs 删除文件后，删除因此变为空的父目录，直到root（不包含root）
+//
+func removeEmptyParentDirs(filename string, root string) {
+	root = filepath.Clean(root)
+	for dir := filepath.Dir(filename); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			// 目录不为空
+			return
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type mockRecordRetentionObserver struct {
+	recording map[string]struct{}
+	events    []base.RecordDiskGuardInfo
+	removed   []string
+}
+
+func (m *mockRecordRetentionObserver) GetRecordingFiles() map[string]struct{} {
+	return m.recording
+}
+
+func (m *mockRecordRetentionObserver) OnRecordDiskGuard(info base.RecordDiskGuardInfo) {
+	m.events = append(m.events, info)
+}
+
+func (m *mockRecordRetentionObserver) OnRecordFileRemoved(filename string) {
+	m.removed = append(m.removed, filename)
+}
+
+func TestRecordRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_record_retention_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{}
+	config.RecordConfig.EnableFlv = true
+	config.RecordConfig.FlvOutPath = dir
+	config.RecordConfig.EnableMpegts = true
+	config.RecordConfig.MpegtsOutPath = dir + "/"
+	config.HlsConfig.Enable = true
+	config.HlsConfig.OutPath = filepath.Join(dir, "hls")
+	config.RecordConfig.RetentionMaxAgeSec = 3600
+	config.RecordConfig.RetentionMaxSizeMb = 1
+
+	now := time.Now()
+	write := func(name string, size int, age time.Duration) string {
+		filename := filepath.Join(dir, name)
+		assert.Equal(t, nil, os.MkdirAll(filepath.Dir(filename), 0777))
+		assert.Equal(t, nil, ioutil.WriteFile(filename, make([]byte, size), 0666))
+		assert.Equal(t, nil, os.Chtimes(filename, now.Add(-age), now.Add(-age)))
+		return filename
+	}
+	exist := func(filename string) bool {
+		_, err := os.Stat(filename)
+		return err == nil
+	}
+
+	expired := write("live/a/1.flv", 10, 2*time.Hour)
+	recording := write("live/b/2.flv", 10, 2*time.Hour)
+	old := write("live/c/3.ts", 600*1024, 30*time.Minute)
+	newer := write("live/c/4.mp4", 600*1024, 10*time.Minute)
+	other := write("live/c/5.txt", 10, 2*time.Hour)
+	hls := write("hls/test/6.ts", 10, 2*time.Hour)
+
+	observer := &mockRecordRetentionObserver{
+		recording: map[string]struct{}{recording: {}},
+	}
+	r := newRecordRetention(config, observer)
+	assert.Equal(t, true, r.isEnabled())
+	assert.Equal(t, []string{filepath.Clean(dir)}, r.outPaths)
+	assert.Equal(t, []string{filepath.Clean(dir), filepath.Join(dir, "hls")}, r.guardPaths)
+
+	// 过期的文件被删除，总大小超过1MB时，删除最旧的文件
+	deleted := r.cleanup(r.outPaths[0], now, observer.recording)
+	assert.Equal(t, []string{expired, old}, deleted)
+	assert.Equal(t, deleted, observer.removed)
+	assert.Equal(t, false, exist(expired))
+	assert.Equal(t, false, exist(filepath.Join(dir, "live/a")))
+	assert.Equal(t, false, exist(old))
+	assert.Equal(t, true, exist(recording))
+	assert.Equal(t, true, exist(newer))
+	assert.Equal(t, true, exist(other))
+	assert.Equal(t, true, exist(hls))
+
+	// 按需录制的目录，重复添加时去重
+	r.AddOutPath(filepath.Join(dir, "ondemand"))
+	r.AddOutPath(filepath.Join(dir, "ondemand") + "/")
+	assert.Equal(t, []string{filepath.Clean(dir), filepath.Join(dir, "ondemand")}, r.outPaths)
+	ondemand := write("ondemand/7.flv", 10, 2*time.Hour)
+	r.check(now)
+	assert.Equal(t, false, exist(ondemand))
+
+	// 剩余空间低于水位线时暂停，恢复时通知
+	config.RecordConfig.DiskLowWatermarkMb = 1 << 40
+	r.guard()
+	r.guard()
+	if r.guardDisabled {
+		return
+	}
+	assert.Equal(t, 1, len(observer.events))
+	assert.Equal(t, base.RecordDiskGuardEventPause, observer.events[0].Event)
+	config.RecordConfig.DiskLowWatermarkMb = 1
+	r.guard()
+	assert.Equal(t, 2, len(observer.events))
+	assert.Equal(t, base.RecordDiskGuardEventResume, observer.events[1].Event)
+}
+
+func TestGroupPauseRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_record_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{}
+	config.RecordConfig.EnableFlv = true
+	config.RecordConfig.FlvOutPath = dir
+	observer := &mockRecordObserver{}
+	group := NewGroup("live", "test", config, observer)
+	_, err = group.AddCustomizePubSession("test")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(group.GetRecordingFiles()))
+
+	// 暂停时关闭文件，并拒绝新的录制
+	group.PauseRecord()
+	assert.Equal(t, 0, len(group.GetRecordingFiles()))
+	assert.Equal(t, 1, len(observer.done))
+	assert.Equal(t, base.ErrRecordDiskLow, group.StartRecord(base.RecordFormatMpegts, dir, 0))
+
+	// 恢复后重新开启配置文件中的录制
+	group.ResumeRecord()
+	assert.Equal(t, 1, len(group.GetRecordingFiles()))
+	assert.Equal(t, nil, group.StartRecord(base.RecordFormatMpegts, dir, 0))
+	assert.Equal(t, 2, len(group.GetRecordingFiles()))
+	group.delIn()
+	assert.Equal(t, 3, len(observer.done))
+}
@@ -12,6 +12,7 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sync"
@@ -21,13 +22,16 @@ import (
 
 	"github.com/q191201771/naza/pkg/defertaskthread"
 
+	"github.com/q191201771/lal/pkg/dash"
 	"github.com/q191201771/lal/pkg/hls"
 
 	"github.com/q191201771/lal/pkg/base"
 
+	"github.com/q191201771/lal/pkg/httpfmp4"
 	"github.com/q191201771/lal/pkg/httpts"
 
 	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/lal/pkg/storage"
 
 	_ "net/http/pprof"
 
@@ -41,9 +45,11 @@ type ServerManager struct {
 	serverStartTime string
 	config          *Config
 
-	httpServerManager *base.HttpServerManager
-	httpServerHandler *HttpServerHandler
-	hlsServerHandler  *hls.ServerHandler
+	httpServerManager       *base.HttpServerManager
+	httpServerHandler       *HttpServerHandler
+	hlsServerHandler        *hls.ServerHandler
+	dashServerHandler       *dash.ServerHandler
+	httpflvVodServerHandler *httpflv.VodServerHandler
 
 	rtmpServer    *rtmp.Server
 	rtspServer    *rtsp.Server
@@ -51,16 +57,23 @@ type ServerManager struct {
 	pprofServer   *http.Server
 	exitChan      chan struct{}
 
-	mutex        sync.Mutex
-	groupManager IGroupManager
+	mutex            sync.Mutex
+	groupManager     IGroupManager
+	hlsVariantGroups map[string][]string // key: 分组名称, value: 分组内的流名称列表
 
 	simpleAuthCtx *SimpleAuthCtx
+
+	recordRetention *recordRetention
+	recordPaused    bool           // 磁盘空间不足，暂停录制
+	recordCatalog   *recordCatalog // 没有配置 RecordConfig.CatalogFile 时为nil
+	uploader        *storage.Uploader
 }
 
 func NewServerManager(modOption ...ModOption) *ServerManager {
 	sm := &ServerManager{
-		serverStartTime: base.ReadableNowTime(),
-		exitChan:        make(chan struct{}, 1),
+		serverStartTime:  base.ReadableNowTime(),
+		exitChan:         make(chan struct{}, 1),
+		hlsVariantGroups: make(map[string][]string),
 	}
 	sm.groupManager = NewSimpleGroupManager(sm)
 
@@ -96,6 +109,27 @@ Doc: %s
 	if sm.config.HlsConfig.Enable && sm.config.HlsConfig.UseMemoryAsDiskFlag {
 		Log.Infof("hls use memory as disk.")
 		hls.SetUseMemoryAsDiskFlag(true)
+		dash.SetUseMemoryAsDiskFlag(true)
+	}
+	if sm.config.HlsConfig.Enable && sm.config.HlsConfig.SegmentStoreMaxMb > 0 {
+		Log.Infof("hls use memory segment store. max=%dMB", sm.config.HlsConfig.SegmentStoreMaxMb)
+		hls.SetSegmentStore(hls.NewSegmentStore(int64(sm.config.HlsConfig.SegmentStoreMaxMb) * 1024 * 1024))
+	}
+
+	if sm.config.StorageConfig.Enable {
+		if s, err := newStorage(&sm.config.StorageConfig); err != nil {
+			Log.Errorf("storage create error. type=%s, err=%+v", sm.config.StorageConfig.Type, err)
+		} else {
+			Log.Infof("storage enable. type=%s", sm.config.StorageConfig.Type)
+			sm.uploader = storage.NewUploader(s, sm.config.StorageConfig.UploaderConfig).WithOnUploadDone(sm.onUploadDone)
+			if sm.config.StorageConfig.UploadHls {
+				hls.SetUploader(sm.uploader)
+			}
+		}
+	}
+
+	for _, vg := range sm.config.HlsConfig.VariantGroups {
+		sm.hlsVariantGroups[vg.Name] = vg.StreamNames
 	}
 
 	if sm.config.RecordConfig.EnableFlv {
@@ -110,16 +144,38 @@ Doc: %s
 		}
 	}
 
+	if sm.config.RecordConfig.EnableMp4 {
+		if err := os.MkdirAll(sm.config.RecordConfig.Mp4OutPath, 0777); err != nil {
+			Log.Errorf("record mp4 mkdir error. path=%s, err=%+v", sm.config.RecordConfig.Mp4OutPath, err)
+		}
+	}
+
+	sm.recordRetention = newRecordRetention(sm.config, sm)
+
+	if sm.config.RecordConfig.CatalogFile != "" {
+		catalog, err := newRecordCatalog(sm.config.RecordConfig.CatalogFile)
+		if err != nil {
+			Log.Errorf("record catalog open error. filename=%s, err=%+v", sm.config.RecordConfig.CatalogFile, err)
+		} else {
+			sm.recordCatalog = catalog
+		}
+	}
+
 	if sm.option.NotifyHandler == nil {
 		sm.option.NotifyHandler = NewHttpNotify(sm.config.HttpNotifyConfig, sm.config.ServerId)
 	}
 
 	if sm.config.HttpflvConfig.Enable || sm.config.HttpflvConfig.EnableHttps ||
+		sm.config.HttpflvVodConfig.Enable || sm.config.HttpflvVodConfig.EnableHttps ||
 		sm.config.HttptsConfig.Enable || sm.config.HttptsConfig.EnableHttps ||
-		sm.config.HlsConfig.Enable || sm.config.HlsConfig.EnableHttps {
+		sm.config.Httpfmp4Config.Enable || sm.config.Httpfmp4Config.EnableHttps ||
+		sm.config.HlsConfig.Enable || sm.config.HlsConfig.EnableHttps ||
+		sm.config.DashConfig.Enable || sm.config.DashConfig.EnableHttps {
 		sm.httpServerManager = base.NewHttpServerManager()
 		sm.httpServerHandler = NewHttpServerHandler(sm)
-		sm.hlsServerHandler = hls.NewServerHandler(sm.config.HlsConfig.OutPath)
+		sm.hlsServerHandler = hls.NewServerHandler(sm.config.HlsConfig.OutPath).WithVariantProvider(sm)
+		sm.dashServerHandler = dash.NewServerHandler(sm.config.HlsConfig.OutPath)
+		sm.httpflvVodServerHandler = httpflv.NewVodServerHandler(sm.config.HttpflvVodConfig.UrlPattern, sm.config.RecordConfig.FlvOutPath).WithAuthProvider(sm)
 	}
 
 	if sm.config.RtmpConfig.Enable {
@@ -194,12 +250,21 @@ func (sm *ServerManager) RunLoop() error {
 	if err := addMux(sm.config.HttpflvConfig.CommonHttpServerConfig, sm.httpServerHandler.ServeSubSession, "httpflv"); err != nil {
 		return err
 	}
+	if err := addMux(sm.config.HttpflvVodConfig.CommonHttpServerConfig, sm.httpflvVodServerHandler.ServeHTTP, "httpflv_vod"); err != nil {
+		return err
+	}
 	if err := addMux(sm.config.HttptsConfig.CommonHttpServerConfig, sm.httpServerHandler.ServeSubSession, "httpts"); err != nil {
 		return err
 	}
+	if err := addMux(sm.config.Httpfmp4Config.CommonHttpServerConfig, sm.httpServerHandler.ServeSubSession, "httpfmp4"); err != nil {
+		return err
+	}
 	if err := addMux(sm.config.HlsConfig.CommonHttpServerConfig, sm.serveHls, "hls"); err != nil {
 		return err
 	}
+	if err := addMux(sm.config.DashConfig.CommonHttpServerConfig, sm.serveDash, "dash"); err != nil {
+		return err
+	}
 
 	if sm.httpServerManager != nil {
 		go func() {
@@ -242,6 +307,10 @@ func (sm *ServerManager) RunLoop() error {
 		}()
 	}
 
+	if sm.recordRetention.isEnabled() {
+		go sm.recordRetention.RunLoop()
+	}
+
 	uis := uint32(sm.config.HttpNotifyConfig.UpdateIntervalSec)
 	var updateInfo base.UpdateInfo
 	updateInfo.Groups = sm.StatAllGroup()
@@ -290,6 +359,11 @@ func (sm *ServerManager) RunLoop() error {
 
 			sm.mutex.Unlock()
 
+			// 定时去掉录制文件索引中文件已经不存在的记录，检查文件比较耗时，所以在单独的协程中执行
+			if sm.recordCatalog != nil && tickCount%recordCatalogCompactIntervalSec == 0 {
+				go sm.recordCatalog.Compact()
+			}
+
 			// 定时通过http notify发送group相关的信息
 			if uis != 0 && (tickCount%uis) == 0 {
 				updateInfo.Groups = sm.StatAllGroup()
@@ -324,6 +398,10 @@ func (sm *ServerManager) Dispose() {
 	//	sm.hlsServer.Dispose()
 	//}
 
+	if sm.recordRetention.isEnabled() {
+		sm.recordRetention.Dispose()
+	}
+
 	sm.mutex.Lock()
 	sm.groupManager.Iterate(func(group *Group) bool {
 		group.Dispose()
@@ -331,6 +409,14 @@ func (sm *ServerManager) Dispose() {
 	})
 	sm.mutex.Unlock()
 
+	// 注意，放在group后面，group销毁时还会产生录制文件
+	if sm.recordCatalog != nil {
+		sm.recordCatalog.Dispose()
+	}
+	if sm.uploader != nil {
+		sm.uploader.Dispose()
+	}
+
 	sm.exitChan <- struct{}{}
 }
 
@@ -522,6 +608,44 @@ func (sm *ServerManager) OnDelHttptsSubSession(session *httpts.SubSession) {
 	sm.option.NotifyHandler.OnSubStop(info)
 }
 
+func (sm *ServerManager) OnNewHttpfmp4SubSession(session *httpfmp4.SubSession) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	info := base.Session2SubStartInfo(session)
+
+	if err := sm.simpleAuthCtx.OnSubStart(info); err != nil {
+		return err
+	}
+
+	group := sm.getOrCreateGroup(session.AppName(), session.StreamName())
+	group.AddHttpfmp4SubSession(session)
+
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+
+	sm.option.NotifyHandler.OnSubStart(info)
+
+	return nil
+}
+
+func (sm *ServerManager) OnDelHttpfmp4SubSession(session *httpfmp4.SubSession) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	group := sm.getGroup(session.AppName(), session.StreamName())
+	if group == nil {
+		return
+	}
+
+	group.DelHttpfmp4SubSession(session)
+
+	info := base.Session2SubStopInfo(session)
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+	sm.option.NotifyHandler.OnSubStop(info)
+}
+
 // ----- implement rtsp.IServerObserver interface -----------------------------------------------------------------------
 
 func (sm *ServerManager) OnNewRtspSessionConnect(session *rtsp.ServerCommandSession) {
@@ -621,7 +745,7 @@ func (sm *ServerManager) OnDelRtspSubSession(session *rtsp.SubSession) {
 // ----- implement IGroupCreator interface -----------------------------------------------------------------------------
 
 func (sm *ServerManager) CreateGroup(appName string, streamName string) *Group {
-	return NewGroup(appName, streamName, sm.config, sm)
+	return NewGroup(appName, streamName, sm.config, sm).WithSeiHandler(sm.option.SeiHandler)
 }
 
 // ----- implement IGroupObserver interface -----------------------------------------------------------------------------
@@ -629,8 +753,13 @@ func (sm *ServerManager) CreateGroup(appName string, streamName string) *Group {
 func (sm *ServerManager) CleanupHlsIfNeeded(appName string, streamName string, path string) {
 	if sm.config.HlsConfig.Enable &&
 		(sm.config.HlsConfig.CleanupMode == hls.CleanupModeInTheEnd || sm.config.HlsConfig.CleanupMode == hls.CleanupModeAsap) {
+		// 断线重连的宽限期内保留文件，重连后的流会继续使用
+		delayMs := sm.config.HlsConfig.FragmentDurationMs * (sm.config.HlsConfig.FragmentNum + sm.config.HlsConfig.DeleteThreshold)
+		if sm.config.HlsConfig.ReconnectGraceMs > delayMs {
+			delayMs = sm.config.HlsConfig.ReconnectGraceMs
+		}
 		defertaskthread.Go(
-			sm.config.HlsConfig.FragmentDurationMs*(sm.config.HlsConfig.FragmentNum+sm.config.HlsConfig.DeleteThreshold),
+			delayMs,
 			func(param ...interface{}) {
 				an := param[0].(string)
 				sn := param[1].(string)
@@ -655,6 +784,37 @@ func (sm *ServerManager) CleanupHlsIfNeeded(appName string, streamName string, p
 	}
 }
 
+// CleanupDashIfNeeded 与hls使用相同的清理策略
+//
+func (sm *ServerManager) CleanupDashIfNeeded(appName string, streamName string, path string) {
+	if sm.config.DashConfig.Enable &&
+		(sm.config.HlsConfig.CleanupMode == hls.CleanupModeInTheEnd || sm.config.HlsConfig.CleanupMode == hls.CleanupModeAsap) {
+		defertaskthread.Go(
+			sm.config.HlsConfig.FragmentDurationMs*(sm.config.HlsConfig.FragmentNum+sm.config.HlsConfig.DeleteThreshold),
+			func(param ...interface{}) {
+				an := param[0].(string)
+				sn := param[1].(string)
+				outPath := param[2].(string)
+
+				if g := sm.GetGroup(an, sn); g != nil {
+					if g.IsDashMuxerAlive() {
+						Log.Warnf("cancel cleanup dash file path since dash muxer still alive. streamName=%s", sn)
+						return
+					}
+				}
+
+				Log.Infof("cleanup dash file path. streamName=%s, path=%s", sn, outPath)
+				if err := dash.RemoveAll(outPath); err != nil {
+					Log.Warnf("cleanup dash file path error. path=%s, err=%+v", outPath, err)
+				}
+			},
+			appName,
+			streamName,
+			path,
+		)
+	}
+}
+
 func (sm *ServerManager) OnRelayPullStart(info base.PullStartInfo) {
 	sm.option.NotifyHandler.OnRelayPullStart(info)
 }
@@ -663,10 +823,98 @@ func (sm *ServerManager) OnRelayPullStop(info base.PullStopInfo) {
 	sm.option.NotifyHandler.OnRelayPullStop(info)
 }
 
+func (sm *ServerManager) OnDelHlsSubSession(info base.SubStopInfo) {
+	sm.option.NotifyHandler.OnSubStop(info)
+}
+
 func (sm *ServerManager) OnHlsMakeTs(info base.HlsMakeTsInfo) {
+	// hls分片会被自动删除时，不加入索引
+	if sm.recordCatalog != nil && info.Event == "close" && sm.config.HlsConfig.CleanupMode == hls.CleanupModeNever {
+		sm.recordCatalog.Add(recordHlsMakeTsInfo2Item(info, time.Now()))
+	}
 	sm.option.NotifyHandler.OnHlsMakeTs(info)
 }
 
+func (sm *ServerManager) OnOnvifMetadata(info base.OnvifMetadataInfo) {
+	sm.option.NotifyHandler.OnOnvifMetadata(info)
+}
+
+func (sm *ServerManager) OnRecordFileDone(info base.RecordFileDoneInfo) {
+	if sm.recordCatalog != nil {
+		sm.recordCatalog.Add(recordFileDoneInfo2Item(info))
+	}
+	if sm.uploader != nil && sm.config.StorageConfig.UploadRecord {
+		// 上传失败时保留本地文件
+		if err := sm.uploader.UploadFile(info.Path, sm.config.StorageConfig.RemoveLocalRecord); err != nil {
+			Log.Warnf("record upload failed. filename=%s, err=%+v", info.Path, err)
+		}
+	}
+	sm.option.NotifyHandler.OnRecordFileDone(info)
+}
+
+// ----- implement httpflv.IVodAuthProvider interface ------------------------------------------------------------------
+
+func (sm *ServerManager) OnVod(rel string, urlParam string) error {
+	return sm.simpleAuthCtx.OnVod(rel, urlParam)
+}
+
+// ----- implement IRecordRetentionObserver interface ------------------------------------------------------------------
+
+func (sm *ServerManager) GetRecordingFiles() map[string]struct{} {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	files := make(map[string]struct{})
+	sm.groupManager.Iterate(func(group *Group) bool {
+		for _, filename := range group.GetRecordingFiles() {
+			files[filepath.Clean(filename)] = struct{}{}
+		}
+		return true
+	})
+	for _, filename := range finalizingRecordMp4.list() {
+		files[filepath.Clean(filename)] = struct{}{}
+	}
+	return files
+}
+
+func (sm *ServerManager) OnRecordDiskGuard(info base.RecordDiskGuardInfo) {
+	sm.mutex.Lock()
+	sm.recordPaused = info.Event == base.RecordDiskGuardEventPause
+	sm.groupManager.Iterate(func(group *Group) bool {
+		if sm.recordPaused {
+			group.PauseRecord()
+		} else {
+			group.ResumeRecord()
+		}
+		return true
+	})
+	sm.mutex.Unlock()
+
+	sm.option.NotifyHandler.OnRecordDiskGuard(info)
+}
+
+// onUploadDone 录制文件上传成功并且删除了本地文件时，从录制文件索引中去掉，避免列表、导出指向不存在的本地文件
+//
+// 注意，在上传协程中回调
+//
+func (sm *ServerManager) onUploadDone(filename string, key string, err error) {
+	if err != nil || sm.recordCatalog == nil || !isRecordFile(filename) {
+		return
+	}
+	// 上传失败后的重试，以及没有开启 StorageConfig.RemoveLocalRecord 时，本地文件依然存在
+	if _, statErr := os.Stat(filename); !os.IsNotExist(statErr) {
+		return
+	}
+	Log.Infof("record uploaded and local file removed, remove from catalog. filename=%s, key=%s", filename, key)
+	sm.recordCatalog.Remove(filename)
+}
+
+func (sm *ServerManager) OnRecordFileRemoved(filename string) {
+	if sm.recordCatalog != nil {
+		sm.recordCatalog.Remove(filename)
+	}
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func (sm *ServerManager) Config() *Config {
@@ -685,6 +933,9 @@ func (sm *ServerManager) GetGroup(appName string, streamName string) *Group {
 func (sm *ServerManager) getOrCreateGroup(appName string, streamName string) *Group {
 	g, createFlag := sm.groupManager.GetOrCreateGroup(appName, streamName)
 	if createFlag {
+		if sm.recordPaused {
+			g.PauseRecord()
+		}
 		go g.RunLoop()
 	}
 	return g
@@ -700,18 +951,120 @@ func (sm *ServerManager) serveHls(writer http.ResponseWriter, req *http.Request)
 		Log.Errorf("parse url. err=%+v", err)
 		return
 	}
-	if urlCtx.GetFileType() == "m3u8" {
-		// TODO(chef): [refactor] 需要整理，这里使用 hls.PathStrategy 不太好 202207
-		streamName := hls.PathStrategy.GetRequestInfo(urlCtx, sm.config.HlsConfig.OutPath).StreamName
+	// TODO(chef): [refactor] 需要整理，这里使用 hls.PathStrategy 不太好 202207
+	streamName := hls.PathStrategy.GetRequestInfo(urlCtx, sm.config.HlsConfig.OutPath).StreamName
+	// 分片加密的key与m3u8使用相同的鉴权规则
+	if urlCtx.GetFileType() == "m3u8" || urlCtx.GetFileType() == "key" {
 		if err = sm.simpleAuthCtx.OnHls(streamName, urlCtx.RawQuery); err != nil {
 			Log.Errorf("simple auth failed. err=%+v", err)
 			return
 		}
 	}
 
+	if sm.config.HlsConfig.SubSessionTimeoutMs > 0 && streamName != "" {
+		session, err := sm.getOrCreateHlsSubSession(req, urlCtx, streamName)
+		if err != nil {
+			Log.Warnf("hls sub session rejected. stream=%s, err=%+v", streamName, err)
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if session != nil {
+			writer = session.WrapResponseWriter(writer)
+			if urlCtx.GetFileType() == "m3u8" {
+				sm.attachHlsSubSessionKey(writer, req, session.Key())
+			}
+		}
+	}
+
 	sm.hlsServerHandler.ServeHTTP(writer, req)
 }
 
+// getOrCreateHlsSubSession 将hls请求关联到播放者
+//
+// m3u8请求时如果播放者不存在则创建，其他请求只刷新已存在播放者的活跃时间
+//
+// @return session: 可能为nil，比如分片请求没有对应的播放者，或者请求的是多码率分组
+//
+func (sm *ServerManager) getOrCreateHlsSubSession(req *http.Request, urlCtx base.UrlContext, streamName string) (*hls.SubSession, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if _, ok := sm.hlsVariantGroups[streamName]; ok {
+		return nil, nil
+	}
+
+	key := hls.GetSubSessionKey(req, streamName)
+	group := sm.getGroup("", streamName)
+	if group != nil {
+		session, kicked := group.GetHlsSubSession(key)
+		if kicked {
+			return nil, fmt.Errorf("%w. key=%s", base.ErrHlsSubSessionKicked, key)
+		}
+		if session != nil {
+			session.KeepAlive()
+			return session, nil
+		}
+	}
+	if urlCtx.GetFileType() != "m3u8" {
+		return nil, nil
+	}
+
+	session := hls.NewSubSession(key, urlCtx, streamName, req.RemoteAddr, sm.config.HlsConfig.SubSessionTimeoutMs)
+	info := base.Session2SubStartInfo(session)
+	if err := sm.simpleAuthCtx.OnSubStart(info); err != nil {
+		return nil, err
+	}
+
+	group = sm.getOrCreateGroup("", streamName)
+	group.AddHlsSubSession(session)
+
+	info.HasInSession = group.HasInSession()
+	info.HasOutSession = group.HasOutSession()
+	sm.option.NotifyHandler.OnSubStart(info)
+	return session, nil
+}
+
+// attachHlsSubSessionKey 通过cookie以及m3u8中分片URI的参数，使后续请求携带播放者标识
+//
+func (sm *ServerManager) attachHlsSubSessionKey(writer http.ResponseWriter, req *http.Request, key string) {
+	http.SetCookie(writer, &http.Cookie{
+		Name:     hls.SubSessionKeyName,
+		Value:    key,
+		Path:     "/",
+		HttpOnly: true,
+	})
+
+	query := req.URL.Query()
+	if query.Get(hls.SubSessionKeyName) != "" {
+		return
+	}
+	param := hls.SubSessionKeyName + "=" + url.QueryEscape(key)
+	if req.URL.RawQuery == "" {
+		req.URL.RawQuery = param
+	} else {
+		req.URL.RawQuery += "&" + param
+	}
+	req.RequestURI = req.URL.RequestURI()
+}
+
+func (sm *ServerManager) serveDash(writer http.ResponseWriter, req *http.Request) {
+	urlCtx, err := base.ParseUrl(base.ParseHttpRequest(req), 80)
+	if err != nil {
+		Log.Errorf("parse url. err=%+v", err)
+		return
+	}
+	if urlCtx.GetFileType() == "mpd" {
+		// 与hls的m3u8使用相同的鉴权规则
+		streamName := dash.GetRequestInfo(urlCtx, sm.config.HlsConfig.OutPath).StreamName
+		if err = sm.simpleAuthCtx.OnHls(streamName, urlCtx.RawQuery); err != nil {
+			Log.Errorf("simple auth failed. err=%+v", err)
+			return
+		}
+	}
+
+	sm.dashServerHandler.ServeHTTPWithUrlCtx(writer, urlCtx)
+}
+
 // ---------------------------------------------------------------------------------------------------------------------
 
 func firstExistDefaultConfFilename() string {
@@ -726,3 +1079,18 @@ func firstExistDefaultConfFilename() string {
 	}
 	return ""
 }
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func newStorage(config *StorageConfig) (storage.IStorage, error) {
+	switch config.Type {
+	case StorageTypeLocal:
+		if config.LocalPath == "" {
+			return nil, fmt.Errorf("%w. storage local path empty", base.ErrStorage)
+		}
+		return storage.NewLocalStorage(config.LocalPath), nil
+	case StorageTypeS3:
+		return storage.NewS3Storage(config.S3)
+	}
+	return nil, fmt.Errorf("%w. invalid storage type. type=%s", base.ErrStorage, config.Type)
+}
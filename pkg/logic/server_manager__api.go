@@ -9,9 +9,14 @@
 package logic
 
 import (
+	"fmt"
 	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hls"
 	"github.com/q191201771/naza/pkg/bininfo"
 	"math"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 // server_manager__api.go
@@ -146,3 +151,231 @@ func (sm *ServerManager) CtrlStartRtpPub(info base.ApiCtrlStartRtpPubReq) (ret b
 
 	return
 }
+
+func (sm *ServerManager) CtrlSetHlsVariantGroup(info base.ApiCtrlSetHlsVariantGroupReq) (ret base.HttpResponseBasic) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if len(info.StreamNames) == 0 {
+		delete(sm.hlsVariantGroups, info.Name)
+	} else {
+		sm.hlsVariantGroups[info.Name] = info.StreamNames
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	return
+}
+
+func (sm *ServerManager) CtrlInjectTimedMetadata(info base.ApiCtrlInjectTimedMetadataReq) (ret base.HttpResponseBasic) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
+		return
+	}
+
+	if !g.InjectTimedMetadata(info.Description, info.Value) {
+		ret.ErrorCode = base.ErrorCodeTimedMetadataNotReady
+		ret.Desp = base.DespTimedMetadataNotReady
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	return
+}
+
+func (sm *ServerManager) CtrlSplice(info base.ApiCtrlSpliceReq) (ret base.HttpResponseBasic) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
+		return
+	}
+
+	if !g.ScheduleSplice(info) {
+		ret.ErrorCode = base.ErrorCodeSpliceNotReady
+		ret.Desp = base.DespSpliceNotReady
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	return
+}
+
+func (sm *ServerManager) CtrlStartRecord(info base.ApiCtrlStartRecordReq) (ret base.HttpResponseBasic) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
+		return
+	}
+
+	if err := g.StartRecord(info.Format, info.OutPath, info.MaxDurationSec); err != nil {
+		ret.ErrorCode = base.ErrorCodeRecordFail
+		ret.Desp = err.Error()
+		return
+	}
+	// hls的目录由hls自己管理
+	if info.Format != base.RecordFormatHls {
+		outPath := info.OutPath
+		if outPath == "" {
+			outPath = defaultRecordOutPath(sm.config, info.Format)
+		}
+		sm.recordRetention.AddOutPath(outPath)
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	return
+}
+
+func (sm *ServerManager) CtrlStopRecord(info base.ApiCtrlStopRecordReq) (ret base.HttpResponseBasic) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	g := sm.getGroup("", info.StreamName)
+	if g == nil {
+		ret.ErrorCode = base.ErrorCodeGroupNotFound
+		ret.Desp = base.DespGroupNotFound
+		return
+	}
+
+	if err := g.StopRecord(info.Format); err != nil {
+		ret.ErrorCode = base.ErrorCodeRecordFail
+		ret.Desp = err.Error()
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	return
+}
+
+func (sm *ServerManager) RecordList(info base.ApiRecordListReq) (ret base.ApiRecordList) {
+	if sm.recordCatalog == nil {
+		ret.ErrorCode = base.ErrorCodeRecordFail
+		ret.Desp = base.ErrRecordCatalogDisabled.Error()
+		return
+	}
+
+	filter := recordCatalogFilter{
+		streamName: info.StreamName,
+		format:     info.Format,
+	}
+	var err1, err2 error
+	if info.StartTime != "" {
+		filter.startTime, err1 = parseRecordTime(info.StartTime)
+	}
+	if info.EndTime != "" {
+		filter.endTime, err2 = parseRecordTime(info.EndTime)
+	}
+	if err1 != nil || err2 != nil {
+		ret.ErrorCode = base.ErrorCodeParamMissing
+		ret.Desp = base.DespParamMissing
+		return
+	}
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data.Records = sm.recordCatalog.List(filter)
+	return
+}
+
+// RecordExport
+//
+// 注意，导出在调用者的协程中同步执行，时间段较长时比较耗时
+//
+func (sm *ServerManager) RecordExport(info base.ApiRecordExportReq) (ret base.ApiRecordExport) {
+	if sm.recordCatalog == nil {
+		ret.ErrorCode = base.ErrorCodeRecordFail
+		ret.Desp = base.ErrRecordCatalogDisabled.Error()
+		return
+	}
+
+	start, err1 := parseRecordTime(info.StartTime)
+	end, err2 := parseRecordTime(info.EndTime)
+	if err1 != nil || err2 != nil || !end.After(start) {
+		ret.ErrorCode = base.ErrorCodeParamMissing
+		ret.Desp = base.DespParamMissing
+		return
+	}
+
+	sources := sm.recordCatalog.List(recordCatalogFilter{
+		streamName: info.StreamName,
+		format:     base.RecordFormatFlv,
+		startTime:  start,
+		endTime:    end,
+	})
+	if len(sources) == 0 {
+		// 只支持以flv录制文件为源，有其他格式的录制文件时，明确告知原因
+		others := sm.recordCatalog.List(recordCatalogFilter{
+			streamName: info.StreamName,
+			startTime:  start,
+			endTime:    end,
+		})
+		if len(others) == 0 {
+			ret.ErrorCode = base.ErrorCodeRecordNotFound
+			ret.Desp = base.DespRecordNotFound
+			return
+		}
+		formats := make(map[string]struct{})
+		for _, item := range others {
+			formats[item.Format] = struct{}{}
+		}
+		var found []string
+		for format := range formats {
+			found = append(found, format)
+		}
+		sort.Strings(found)
+		ret.ErrorCode = base.ErrorCodeRecordExportNoFlv
+		ret.Desp = fmt.Sprintf("%s. found=%s", base.ErrRecordExportNoFlv.Error(), strings.Join(found, ","))
+		return
+	}
+
+	const layout = "20060102150405"
+	filename := filepath.Join(sm.config.RecordConfig.ExportOutPath,
+		fmt.Sprintf("%s-%s-%s.%s", info.StreamName, start.Format(layout), end.Format(layout), info.Format))
+	item, err := exportRecordClip(sources, info.Format, start, end, filename)
+	if err != nil {
+		Log.Errorf("record export failed. req info=%+v, err=%+v", info, err)
+		ret.ErrorCode = base.ErrorCodeRecordFail
+		ret.Desp = err.Error()
+		return
+	}
+	Log.Infof("record export succ. item=%+v", item)
+
+	ret.ErrorCode = base.ErrorCodeSucc
+	ret.Desp = base.DespSucc
+	ret.Data = &item
+	return
+}
+
+// GetHlsVariantStreams 实现 hls.IVariantProvider
+//
+func (sm *ServerManager) GetHlsVariantStreams(name string) (streams []hls.VariantStreamInfo, exist bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	streamNames, exist := sm.hlsVariantGroups[name]
+	if !exist {
+		return nil, false
+	}
+	for _, streamName := range streamNames {
+		g := sm.getGroup("", streamName)
+		if g == nil {
+			continue
+		}
+		if info, ok := g.GetHlsVariantStreamInfo(); ok {
+			streams = append(streams, info)
+		}
+	}
+	return streams, true
+}
@@ -10,6 +10,7 @@ package logic
 
 import (
 	"net/url"
+	"path"
 	"strings"
 
 	"github.com/q191201771/lal/pkg/base"
@@ -48,6 +49,7 @@ func (s *SimpleAuthCtx) OnSubStart(info base.SubStartInfo) error {
 	if (s.config.SubRtmpEnable && info.Protocol == base.SessionProtocolRtmpStr) ||
 		(s.config.SubHttpflvEnable && info.Protocol == base.SessionProtocolFlvStr) ||
 		(s.config.SubHttptsEnable && info.Protocol == base.SessionProtocolTsStr) ||
+		(s.config.SubHttpfmp4Enable && info.Protocol == base.SessionProtocolFmp4Str) ||
 		(s.config.SubRtspEnable && info.Protocol == base.SessionProtocolRtspStr) {
 		return s.check(info.StreamName, info.UrlParam)
 	}
@@ -61,6 +63,18 @@ func (s *SimpleAuthCtx) OnHls(streamName string, urlParam string) error {
 	return nil
 }
 
+// OnVod 点播录制文件
+//
+// 录制文件名不一定包含流名称，所以使用文件相对于点播根目录的路径（不包含扩展名）代替流名称计算secret，
+// 比如 /vod/test110/1666590000.flv 使用 test110/1666590000
+//
+func (s *SimpleAuthCtx) OnVod(rel string, urlParam string) error {
+	if s.config.VodEnable {
+		return s.check(strings.TrimSuffix(rel, path.Ext(rel)), urlParam)
+	}
+	return nil
+}
+
 func (s *SimpleAuthCtx) check(streamName string, urlParam string) error {
 	q, err := url.ParseQuery(urlParam)
 	if err != nil {
@@ -54,3 +54,21 @@ func TestSimpleAuthCtx(t *testing.T) {
 	res = ctx.OnPubStart(info)
 	assert.Equal(t, base.ErrSimpleAuthFailed, res)
 }
+
+func TestSimpleAuthCtxOnVod(t *testing.T) {
+	ctx := NewSimpleAuthCtx(SimpleAuthConfig{
+		Key: "q191201771",
+	})
+	// 没有开启时不鉴权
+	assert.Equal(t, nil, ctx.OnVod("test110/1666590000.flv", ""))
+
+	ctx = NewSimpleAuthCtx(SimpleAuthConfig{
+		Key:       "q191201771",
+		VodEnable: true,
+	})
+	// 使用不包含扩展名的相对路径计算secret
+	secret := SimpleAuthCalcSecret("q191201771", "test110/1666590000")
+	assert.Equal(t, nil, ctx.OnVod("test110/1666590000.flv", "lal_secret="+secret+"&start=10"))
+	assert.Equal(t, base.ErrSimpleAuthFailed, ctx.OnVod("test110/1666590001.flv", "lal_secret="+secret))
+	assert.Equal(t, base.ErrSimpleAuthParamNotFound, ctx.OnVod("test110/1666590000.flv", "start=10"))
+}
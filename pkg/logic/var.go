@@ -27,4 +27,21 @@ var (
 	//   注意，这里既检查socket发送阻塞，又检查上层没有给session喂数据
 	//
 	checkSessionAliveIntervalSec uint32 = 10
+
+	// recordRetentionIntervalSec 检查录制文件保留策略以及磁盘剩余空间的时间间隔
+	//
+	recordRetentionIntervalSec = 10
+
+	// recordCatalogCompactIntervalSec 录制文件索引去掉文件已经不存在的记录的时间间隔
+	//
+	recordCatalogCompactIntervalSec uint32 = 3600
+
+	// recordCatalogRewriteMinRemoved 录制文件索引的持久化文件中，无效记录至少达到多少条，并且不少于有效记录时，重写持久化文件
+	//
+	recordCatalogRewriteMinRemoved = 1024
+
+	// seiRtpRepackerMaxCachedAu 输入为rtsp并且需要注入SEI时，最多缓存多少帧输入视频rtp的原始时间戳，
+	// 需要大于 rtsp.AvPacketQueue 中缓存的帧数
+	//
+	seiRtpRepackerMaxCachedAu = 256
 )
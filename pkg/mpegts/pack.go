@@ -32,6 +32,12 @@ type Frame struct {
 	// 音频AAC 格式为2字节ADTS头加raw frame
 	// 视频AVC 格式为Annexb
 	Raw []byte
+
+	// CaptureTimeMs 可选字段，帧的绝对采集时间，unix时间戳，单位毫秒，为0时表示未知。不会打包进mpegts流中
+	//
+	// 音频 缓存合并的多个packet时，为第一个packet的采集时间
+	//
+	CaptureTimeMs int64
 }
 
 // Pack annexb格式的流转换为mpegts流
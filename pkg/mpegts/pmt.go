@@ -9,6 +9,7 @@
 package mpegts
 
 import (
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/naza/pkg/nazabits"
 )
 
@@ -110,3 +111,105 @@ func (pmt *Pmt) SearchPid(pid uint16) *PmtProgramElement {
 	}
 	return nil
 }
+
+// HasPmtStream PMT中是否已经声明了pid
+//
+// @param patpmt: PAT和PMT，各一个ts packet，比如 FixedFragmentHeader
+//
+func HasPmtStream(patpmt []byte, pid uint16) bool {
+	if len(patpmt) != tsPacketSize*2 {
+		return false
+	}
+	pmt := patpmt[tsPacketSize:]
+	sectionStart := 5
+	sectionLength := int(pmt[sectionStart+1]&0x0F)<<8 | int(pmt[sectionStart+2])
+	sectionEnd := sectionStart + 3 + sectionLength - 4
+	if sectionEnd > tsPacketSize {
+		return false
+	}
+	programInfoPos := sectionStart + 10
+	programInfoLength := int(pmt[programInfoPos]&0x0F)<<8 | int(pmt[programInfoPos+1])
+	for pos := programInfoPos + 2 + programInfoLength; pos+5 <= sectionEnd; {
+		if uint16(pmt[pos+1]&0x1F)<<8|uint16(pmt[pos+2]) == pid {
+			return true
+		}
+		pos += 5 + (int(pmt[pos+3]&0x0F)<<8 | int(pmt[pos+4]))
+	}
+	return false
+}
+
+// Crc32Mpeg2 CRC-32/MPEG-2，PSI中使用
+//
+func Crc32Mpeg2(b []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, v := range b {
+		crc ^= uint32(v) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+const tsPacketSize = 188
+
+// appendPmtStream 在PMT中增加一个elementary stream
+//
+// @param patpmt:            PAT和PMT，各一个ts packet。已经声明了pid时，直接返回拷贝
+// @param programDescriptor: 追加到program_info中的descriptor，可以为nil
+// @param esDescriptor:      该elementary stream的descriptor，可以为nil
+//
+// @return: 内存块为独立申请
+//
+func appendPmtStream(patpmt []byte, programDescriptor []byte, streamType uint8, pid uint16, esDescriptor []byte) ([]byte, error) {
+	if len(patpmt) != tsPacketSize*2 {
+		return nil, base.ErrMpegts
+	}
+	if HasPmtStream(patpmt, pid) {
+		return append([]byte(nil), patpmt...), nil
+	}
+
+	// 跳过PMT ts packet的header以及pointer_field
+	pmt := patpmt[tsPacketSize:]
+	sectionStart := 5
+	sectionLength := int(pmt[sectionStart+1]&0x0F)<<8 | int(pmt[sectionStart+2])
+	sectionEnd := sectionStart + 3 + sectionLength - 4 // 不包含CRC
+	if sectionEnd > tsPacketSize || sectionEnd < sectionStart+12 {
+		return nil, base.ErrMpegts
+	}
+	programInfoPos := sectionStart + 10
+	programInfoLength := int(pmt[programInfoPos]&0x0F)<<8 | int(pmt[programInfoPos+1])
+
+	var section []byte
+	section = append(section, pmt[sectionStart:programInfoPos]...)
+	newProgramInfoLength := programInfoLength + len(programDescriptor)
+	section = append(section, 0xF0|uint8(newProgramInfoLength>>8), uint8(newProgramInfoLength))
+	section = append(section, pmt[programInfoPos+2:programInfoPos+2+programInfoLength]...)
+	section = append(section, programDescriptor...)
+	section = append(section, pmt[programInfoPos+2+programInfoLength:sectionEnd]...)
+	section = append(section, streamType, 0xE0|uint8(pid>>8), uint8(pid&0xFF), 0xF0|uint8(len(esDescriptor)>>8), uint8(len(esDescriptor)))
+	section = append(section, esDescriptor...)
+
+	if sectionStart+len(section)+4 > tsPacketSize {
+		return nil, base.ErrMpegts
+	}
+	newSectionLength := len(section) - 3 + 4
+	section[1] = 0xB0 | uint8(newSectionLength>>8)
+	section[2] = uint8(newSectionLength)
+	crc := Crc32Mpeg2(section)
+	section = append(section, uint8(crc>>24), uint8(crc>>16), uint8(crc>>8), uint8(crc))
+
+	out := make([]byte, tsPacketSize*2)
+	copy(out, patpmt[:tsPacketSize+sectionStart])
+	copy(out[tsPacketSize+sectionStart:], section)
+	for i := tsPacketSize + sectionStart + len(section); i < len(out); i++ {
+		out[i] = 0xFF
+	}
+	return out, nil
+}
@@ -0,0 +1,264 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package mpegts
+
+import (
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// SCTE-35 广告插入信令
+//
+// 参考《ANSI/SCTE 35 Digital Program Insertion Cueing Message》：
+// - splice_info_section 以PSI section的形式放在单独的PID中，stream_type为0x86
+// - PMT的program_info中添加registration_descriptor，format_identifier为`CUEI`
+//
+// 目前只支持 splice_insert 和 time_signal 两种命令，不携带splice_descriptor
+//
+
+const (
+	PidScte35 uint16 = 0x104
+
+	Scte35CommandSpliceInsert uint8 = 0x05
+	Scte35CommandTimeSignal   uint8 = 0x06
+
+	scte35TableId    uint8 = 0xFC
+	streamTypeScte35 uint8 = 0x86
+
+	descriptorTagRegistration uint8 = 0x05
+)
+
+// Scte35SpliceInfo splice_info_section中的关键信息
+//
+type Scte35SpliceInfo struct {
+	CommandType uint8 // Scte35CommandSpliceInsert 或 Scte35CommandTimeSignal
+
+	// 以下字段只对 splice_insert 有效
+	EventId      uint32
+	Cancel       bool   // 取消之前的同EventId的splice_insert，为true时忽略其他字段
+	OutOfNetwork bool   // true表示进入广告（cue-out），false表示返回节目（cue-in）
+	Immediate    bool   // 为true时忽略 PtsTime
+	Duration     uint64 // 广告时长，单位90kHz，为0表示不携带break_duration
+	AutoReturn   bool
+
+	PtsTime uint64 // 单位90kHz
+}
+
+// PackScte35Section 打包splice_info_section
+//
+// @return: 内存块为独立申请，包含CRC
+//
+func PackScte35Section(info Scte35SpliceInfo) []byte {
+	var command []byte
+	switch info.CommandType {
+	case Scte35CommandSpliceInsert:
+		command = append(command, uint8(info.EventId>>24), uint8(info.EventId>>16), uint8(info.EventId>>8), uint8(info.EventId))
+		if info.Cancel {
+			command = append(command, 0xFF) // splice_event_cancel_indicator 1, reserved
+			break
+		}
+		command = append(command, 0x7F) // splice_event_cancel_indicator 0, reserved
+
+		flags := uint8(0x40 | 0x0F) // program_splice_flag 1, reserved
+		if info.OutOfNetwork {
+			flags |= 0x80
+		}
+		if info.Duration > 0 {
+			flags |= 0x20
+		}
+		if info.Immediate {
+			flags |= 0x10
+		}
+		command = append(command, flags)
+		if !info.Immediate {
+			command = appendScte35SpliceTime(command, info.PtsTime)
+		}
+		if info.Duration > 0 {
+			b := uint8(0x7E) | uint8(info.Duration>>32)&0x01
+			if info.AutoReturn {
+				b |= 0x80
+			}
+			command = append(command, b, uint8(info.Duration>>24), uint8(info.Duration>>16), uint8(info.Duration>>8), uint8(info.Duration))
+		}
+		command = append(command, 0, 0, 0, 0) // unique_program_id, avail_num, avails_expected
+	case Scte35CommandTimeSignal:
+		command = appendScte35SpliceTime(command, info.PtsTime)
+	}
+
+	// section_length之后的部分：protocol_version(1) + encrypted_packet/pts_adjustment(5) + cw_index(1) + tier/splice_command_length(3)
+	// + splice_command_type(1) + command + descriptor_loop_length(2) + CRC(4)
+	sectionLength := 1 + 5 + 1 + 3 + 1 + len(command) + 2 + 4
+
+	out := make([]byte, 0, 3+sectionLength)
+	out = append(out, scte35TableId, 0x30|uint8(sectionLength>>8)&0x0F, uint8(sectionLength))
+	out = append(out, 0)             // protocol_version
+	out = append(out, 0, 0, 0, 0, 0) // encrypted_packet 0, encryption_algorithm 0, pts_adjustment 0
+	out = append(out, 0)             // cw_index
+	out = append(out, 0xFF, 0xF0|uint8(len(command)>>8)&0x0F, uint8(len(command)))
+	out = append(out, info.CommandType)
+	out = append(out, command...)
+	out = append(out, 0, 0) // descriptor_loop_length
+	crc := Crc32Mpeg2(out)
+	out = append(out, uint8(crc>>24), uint8(crc>>16), uint8(crc>>8), uint8(crc))
+	return out
+}
+
+// ParseScte35Section 解析splice_info_section
+//
+func ParseScte35Section(b []byte) (info Scte35SpliceInfo, err error) {
+	if len(b) < 18 || b[0] != scte35TableId {
+		return info, base.ErrMpegts
+	}
+	sectionLength := int(b[1]&0x0F)<<8 | int(b[2])
+	if 3+sectionLength > len(b) || Crc32Mpeg2(b[:3+sectionLength]) != 0 {
+		return info, base.ErrMpegts
+	}
+	if b[4]&0x80 != 0 {
+		// 不支持加密
+		return info, base.ErrMpegts
+	}
+	commandLength := int(b[11]&0x0F)<<8 | int(b[12])
+	info.CommandType = b[13]
+	command := b[14:]
+	if commandLength != 0xFFF {
+		if 14+commandLength > 3+sectionLength-4 {
+			return info, base.ErrMpegts
+		}
+		command = b[14 : 14+commandLength]
+	}
+
+	switch info.CommandType {
+	case Scte35CommandSpliceInsert:
+		if len(command) < 5 {
+			return info, base.ErrMpegts
+		}
+		info.EventId = uint32(command[0])<<24 | uint32(command[1])<<16 | uint32(command[2])<<8 | uint32(command[3])
+		if command[4]&0x80 != 0 {
+			info.Cancel = true
+			return info, nil
+		}
+		if len(command) < 6 {
+			return info, base.ErrMpegts
+		}
+		flags := command[5]
+		info.OutOfNetwork = flags&0x80 != 0
+		programSpliceFlag := flags&0x40 != 0
+		durationFlag := flags&0x20 != 0
+		info.Immediate = flags&0x10 != 0
+		pos := 6
+		if programSpliceFlag && !info.Immediate {
+			var n int
+			if info.PtsTime, n, err = parseScte35SpliceTime(command[pos:]); err != nil {
+				return info, err
+			}
+			pos += n
+		}
+		if !programSpliceFlag {
+			// component模式，不解析具体的component，只跳过
+			if len(command) < pos+1 {
+				return info, base.ErrMpegts
+			}
+			componentCount := int(command[pos])
+			pos++
+			for i := 0; i < componentCount; i++ {
+				pos++
+				if !info.Immediate {
+					_, n, err := parseScte35SpliceTime(command[pos:])
+					if err != nil {
+						return info, err
+					}
+					pos += n
+				}
+			}
+		}
+		if durationFlag {
+			if len(command) < pos+5 {
+				return info, base.ErrMpegts
+			}
+			info.AutoReturn = command[pos]&0x80 != 0
+			info.Duration = parsePts33(command[pos:])
+		}
+	case Scte35CommandTimeSignal:
+		info.PtsTime, _, err = parseScte35SpliceTime(command)
+	}
+	return info, err
+}
+
+// AppendScte35StreamToPatPmt 在PMT中声明SCTE-35的PID
+//
+// @param patpmt: PAT和PMT，各一个ts packet，比如 FixedFragmentHeader
+//
+// @return: 内存块为独立申请，pid与 PidScte35 相同
+//
+func AppendScte35StreamToPatPmt(patpmt []byte) ([]byte, error) {
+	registrationDescriptor := []byte{descriptorTagRegistration, 4, 'C', 'U', 'E', 'I'}
+	return appendPmtStream(patpmt, registrationDescriptor, streamTypeScte35, PidScte35, nil)
+}
+
+// HasScte35Stream PMT中是否已经声明了 PidScte35
+//
+func HasScte35Stream(patpmt []byte) bool {
+	return HasPmtStream(patpmt, PidScte35)
+}
+
+// PackSection 将PSI section打包成ts packet，section较大时拆分成多个ts packet
+//
+// @param cc: 第一个ts packet的continuity_counter
+//
+// @return packets: 内存块为独立申请
+// @return nextCc:  下一个ts packet应该使用的continuity_counter
+//
+func PackSection(pid uint16, cc uint8, section []byte) (packets []byte, nextCc uint8) {
+	payload := make([]byte, 0, 1+len(section))
+	payload = append(payload, 0) // pointer_field
+	payload = append(payload, section...)
+
+	for i := 0; len(payload) > 0; i++ {
+		packet := make([]byte, tsPacketSize)
+		packet[0] = syncByte
+		packet[1] = uint8(pid>>8) & 0x1F
+		if i == 0 {
+			packet[1] |= 0x40 // payload_unit_start_indicator
+		}
+		packet[2] = uint8(pid & 0xFF)
+		packet[3] = 0x10 | cc&0x0F
+		cc++
+
+		n := copy(packet[4:], payload)
+		payload = payload[n:]
+		for j := 4 + n; j < tsPacketSize; j++ {
+			packet[j] = 0xFF
+		}
+		packets = append(packets, packet...)
+	}
+	return packets, cc
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func appendScte35SpliceTime(out []byte, pts uint64) []byte {
+	// time_specified_flag 1, reserved, pts_time
+	return append(out, 0xFE|uint8(pts>>32)&0x01, uint8(pts>>24), uint8(pts>>16), uint8(pts>>8), uint8(pts))
+}
+
+func parseScte35SpliceTime(b []byte) (pts uint64, n int, err error) {
+	if len(b) < 1 {
+		return 0, 0, base.ErrMpegts
+	}
+	if b[0]&0x80 == 0 {
+		return 0, 1, nil
+	}
+	if len(b) < 5 {
+		return 0, 0, base.ErrMpegts
+	}
+	return parsePts33(b), 5, nil
+}
+
+func parsePts33(b []byte) uint64 {
+	return uint64(b[0]&0x01)<<32 | uint64(b[1])<<24 | uint64(b[2])<<16 | uint64(b[3])<<8 | uint64(b[4])
+}
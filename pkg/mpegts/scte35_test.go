@@ -0,0 +1,85 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package mpegts_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestScte35Section(t *testing.T) {
+	infos := []mpegts.Scte35SpliceInfo{
+		{
+			CommandType:  mpegts.Scte35CommandSpliceInsert,
+			EventId:      1207959695,
+			OutOfNetwork: true,
+			PtsTime:      1924989008,
+			Duration:     2700000,
+			AutoReturn:   true,
+		},
+		{
+			CommandType: mpegts.Scte35CommandSpliceInsert,
+			EventId:     2,
+			Immediate:   true,
+		},
+		{
+			CommandType: mpegts.Scte35CommandSpliceInsert,
+			EventId:     3,
+			Cancel:      true,
+		},
+		{
+			CommandType: mpegts.Scte35CommandTimeSignal,
+			PtsTime:     0x1FFFFFFFF,
+		},
+	}
+	for _, info := range infos {
+		section := mpegts.PackScte35Section(info)
+		assert.Equal(t, uint8(0xFC), section[0])
+		assert.Equal(t, uint32(0), mpegts.Crc32Mpeg2(section))
+
+		parsed, err := mpegts.ParseScte35Section(section)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, info, parsed)
+	}
+
+	// 《SCTE 35 2019》14.2 splice_insert的例子，携带了avail_descriptor
+	golden, _ := base64.StdEncoding.DecodeString("/DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo=")
+	info, err := mpegts.ParseScte35Section(golden)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(0x4800008f), info.EventId)
+	assert.Equal(t, true, info.OutOfNetwork)
+	assert.Equal(t, uint64(0x07369c02e), info.PtsTime)
+	assert.Equal(t, uint64(0x0052ccf5), info.Duration)
+	assert.Equal(t, true, info.AutoReturn)
+
+	_, err = mpegts.ParseScte35Section(golden[:10])
+	assert.IsNotNil(t, err)
+
+	// pmt
+	patpmt, err := mpegts.AppendScte35StreamToPatPmt(mpegts.FixedFragmentHeader)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, mpegts.HasScte35Stream(patpmt))
+	assert.Equal(t, false, mpegts.HasScte35Stream(mpegts.FixedFragmentHeader))
+	patpmt, err = mpegts.AppendMetadataStreamToPatPmt(patpmt)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, mpegts.HasScte35Stream(patpmt))
+	assert.Equal(t, true, mpegts.HasMetadataStream(patpmt))
+
+	// ts packet
+	section := mpegts.PackScte35Section(infos[0])
+	packets, cc := mpegts.PackSection(mpegts.PidScte35, 15, section)
+	assert.Equal(t, 188, len(packets))
+	assert.Equal(t, uint8(16), cc)
+	assert.Equal(t, []byte{0x47, 0x41, 0x04, 0x1F, 0x00}, packets[:5])
+	assert.Equal(t, section, packets[5:5+len(section)])
+	assert.Equal(t, uint8(0xFF), packets[187])
+}
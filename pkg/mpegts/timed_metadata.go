@@ -0,0 +1,125 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package mpegts
+
+// 定时元数据（timed metadata）
+//
+// 参考Apple的《Timed Metadata for HTTP Live Streaming》：
+// - 元数据为ID3v2.4格式，放在单独的PID中，PES的stream_id为private_stream_1
+// - PMT的program_info中添加metadata_pointer_descriptor，元数据的elementary stream使用stream_type 0x15，并添加metadata_descriptor
+//
+// hls.js、AVPlayer等播放器通过PMT识别该PID，并将ID3中的帧以cue的形式抛给上层
+//
+
+const (
+	PidMetadata uint16 = 0x103
+
+	// StreamIdMetadata private_stream_1
+	StreamIdMetadata uint8 = 0xBD
+
+	// streamTypeMetadata Metadata carried in PES packets
+	streamTypeMetadata uint8 = 0x15
+
+	descriptorTagMetadataPointer uint8 = 0x25
+	descriptorTagMetadata        uint8 = 0x26
+)
+
+// ID3的metadata_application_format_identifier以及metadata_format_identifier
+var id3FormatIdentifier = []byte{'I', 'D', '3', ' '}
+
+// Id3Frame ID3v2.4中的一个帧
+//
+type Id3Frame struct {
+	Id   string // 4字节的帧id，比如`TXXX`、`PRIV`
+	Body []byte
+}
+
+// NewId3TxxxFrame 用户自定义文本帧
+//
+// @param description: 描述，比如rtmp数据消息的名称`onCuePoint`
+// @param value:       文本内容，比如json
+//
+func NewId3TxxxFrame(description string, value string) Id3Frame {
+	body := make([]byte, 0, 1+len(description)+1+len(value))
+	body = append(body, 0x03) // UTF-8
+	body = append(body, description...)
+	body = append(body, 0)
+	body = append(body, value...)
+	return Id3Frame{
+		Id:   "TXXX",
+		Body: body,
+	}
+}
+
+// PackId3Tag 将多个帧打包成ID3v2.4的tag
+//
+// @return: 内存块为独立申请
+//
+func PackId3Tag(frames ...Id3Frame) []byte {
+	size := 0
+	for _, f := range frames {
+		size += 10 + len(f.Body)
+	}
+
+	out := make([]byte, 10, 10+size)
+	copy(out, []byte{'I', 'D', '3', 0x04, 0x00, 0x00})
+	packSyncSafeInt(out[6:], size)
+	for _, f := range frames {
+		header := make([]byte, 10)
+		copy(header, f.Id)
+		packSyncSafeInt(header[4:], len(f.Body))
+		out = append(out, header...)
+		out = append(out, f.Body...)
+	}
+	return out
+}
+
+// AppendMetadataStreamToPatPmt 在PMT中声明定时元数据的PID
+//
+// @param patpmt: PAT和PMT，各一个ts packet，比如 FixedFragmentHeader
+//
+// @return: 内存块为独立申请，pid与 PidMetadata 相同
+//
+func AppendMetadataStreamToPatPmt(patpmt []byte) ([]byte, error) {
+	pointerDescriptor := []byte{descriptorTagMetadataPointer, 15, 0xFF, 0xFF}
+	pointerDescriptor = append(pointerDescriptor, id3FormatIdentifier...)
+	pointerDescriptor = append(pointerDescriptor, 0xFF)
+	pointerDescriptor = append(pointerDescriptor, id3FormatIdentifier...)
+	pointerDescriptor = append(pointerDescriptor,
+		0,          // metadata_service_id
+		0x1F,       // metadata_locator_record_flag 0, MPEG_carriage_flags 0, reserved
+		0x00, 0x01) // program_number
+
+	esDescriptor := []byte{descriptorTagMetadata, 13, 0xFF, 0xFF}
+	esDescriptor = append(esDescriptor, id3FormatIdentifier...)
+	esDescriptor = append(esDescriptor, 0xFF)
+	esDescriptor = append(esDescriptor, id3FormatIdentifier...)
+	esDescriptor = append(esDescriptor,
+		0,    // metadata_service_id
+		0x0F) // decoder_config_flags 000, DSM-CC_flag 0, reserved
+
+	return appendPmtStream(patpmt, pointerDescriptor, streamTypeMetadata, PidMetadata, esDescriptor)
+}
+
+// HasMetadataStream PMT中是否已经声明了 PidMetadata
+//
+func HasMetadataStream(patpmt []byte) bool {
+	return HasPmtStream(patpmt, PidMetadata)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// packSyncSafeInt ID3中的synchsafe integer，每个字节只使用低7位
+//
+func packSyncSafeInt(out []byte, v int) {
+	out[0] = uint8(v>>21) & 0x7F
+	out[1] = uint8(v>>14) & 0x7F
+	out[2] = uint8(v>>7) & 0x7F
+	out[3] = uint8(v) & 0x7F
+}
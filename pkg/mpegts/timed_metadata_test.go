@@ -0,0 +1,45 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package mpegts_test
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestPackId3Tag(t *testing.T) {
+	tag := mpegts.PackId3Tag(mpegts.NewId3TxxxFrame("a", "bc"))
+	assert.Equal(t, []byte{
+		'I', 'D', '3', 4, 0, 0, 0, 0, 0, 15,
+		'T', 'X', 'X', 'X', 0, 0, 0, 5, 0, 0,
+		3, 'a', 0, 'b', 'c',
+	}, tag)
+}
+
+func TestAppendMetadataStreamToPatPmt(t *testing.T) {
+	for _, header := range [][]byte{mpegts.FixedFragmentHeader, mpegts.FixedFragmentHeaderHevc} {
+		assert.Equal(t, false, mpegts.HasMetadataStream(header))
+
+		out, err := mpegts.AppendMetadataStreamToPatPmt(header)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 188*2, len(out))
+		assert.Equal(t, header[:188], out[:188])
+		assert.Equal(t, true, mpegts.HasMetadataStream(out))
+
+		// 整个section（包含CRC）的CRC为0
+		sectionLength := int(out[188+6]&0x0F)<<8 | int(out[188+7])
+		assert.Equal(t, uint32(0), mpegts.Crc32Mpeg2(out[188+5:188+5+3+sectionLength]))
+
+		again, err := mpegts.AppendMetadataStreamToPatPmt(out)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, out, again)
+	}
+}
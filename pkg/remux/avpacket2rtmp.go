@@ -41,8 +41,12 @@ type AvPacket2RtmpRemuxer struct {
 	pps []byte
 
 	hasAdts2Asc bool
+
+	pendingNals []byte // 不包含视频数据的包中的nalu（avcc格式），和下一帧合并发送
 }
 
+const maxPendingNalsSize = 1024 * 1024
+
 func NewAvPacket2RtmpRemuxer() *AvPacket2RtmpRemuxer {
 	return &AvPacket2RtmpRemuxer{
 		option:    base.DefaultApsOption,
@@ -132,11 +136,11 @@ func (r *AvPacket2RtmpRemuxer) InitWithAvConfig(asc, vps, sps, pps []byte) {
 	}
 
 	if r.audioType != base.AvPacketPtUnknown {
-		r.emitRtmpAvMsg(true, bAsh, 0)
+		r.emitRtmpAvMsg(true, bAsh, 0, 0)
 	}
 
 	if r.videoType != base.AvPacketPtUnknown {
-		r.emitRtmpAvMsg(false, bVsh, 0)
+		r.emitRtmpAvMsg(false, bVsh, 0, 0)
 	}
 }
 
@@ -171,6 +175,7 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 		pos := 5
 		maxLength := len(pkt.Payload) + pos + 1
 		payload := make([]byte, maxLength)
+		var hasVcl, isKey bool
 
 		for _, nal := range nals {
 			if pkt.PayloadType == base.AvPacketPtAvc {
@@ -194,7 +199,7 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 							Log.Errorf("build avc seq header failed. err=%+v", err)
 							continue
 						}
-						r.emitRtmpAvMsg(false, bVsh, pkt.Timestamp)
+						r.emitRtmpAvMsg(false, bVsh, pkt.Timestamp, pkt.CaptureTimeMs)
 						//if !AvPacket2RtmpRemuxerAddSpsPps2KeyFrameFlag {
 						//	r.clearVideoSeqHeader()
 						//}
@@ -204,6 +209,7 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 					// 重组实际数据
 
 					if t == avc.NaluTypeIdrSlice {
+						isKey = true
 						//if AvPacket2RtmpRemuxerAddSpsPps2KeyFrameFlag {
 						//	// 关键帧 组合sps vps与数据帧
 						//	nal = append(append(avc.BuildSpsPps2Annexb(r.sps, r.pps)[4:], hevc.NaluStartCode4...), nal...)
@@ -213,11 +219,10 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 						//		payload = make([]byte, maxLength)
 						//	}
 						//}
-						payload[0] = base.RtmpAvcKeyFrame
-					} else {
-						payload[0] = base.RtmpAvcInterFrame
 					}
-					payload[1] = base.RtmpAvcPacketTypeNalu
+					if t >= avc.NaluTypeSlice && t <= avc.NaluTypeIdrSlice {
+						hasVcl = true
+					}
 					bele.BePutUint32(payload[pos:], uint32(len(nal)))
 					pos += 4
 					copy(payload[pos:], nal)
@@ -239,7 +244,7 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 							Log.Errorf("build hevc seq header failed. err=%+v", err)
 							continue
 						}
-						r.emitRtmpAvMsg(false, bVsh, pkt.Timestamp)
+						r.emitRtmpAvMsg(false, bVsh, pkt.Timestamp, pkt.CaptureTimeMs)
 						//if !AvPacket2RtmpRemuxerAddSpsPps2KeyFrameFlag {
 						//	r.clearVideoSeqHeader()
 						//}
@@ -247,6 +252,7 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 					}
 				} else {
 					if hevc.IsIrapNalu(t) {
+						isKey = true
 						//if AvPacket2RtmpRemuxerAddSpsPps2KeyFrameFlag {
 						//	// 关键帧 组合vps sps pps与数据帧
 						//	annexb, err := hevc.BuildVpsSpsPps2Annexb(r.vps, r.sps, r.pps)
@@ -260,11 +266,10 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 						//		payload = make([]byte, maxLength)
 						//	}
 						//}
-						payload[0] = base.RtmpHevcKeyFrame
-					} else {
-						payload[0] = base.RtmpHevcInterFrame
 					}
-					payload[1] = base.RtmpHevcPacketTypeNalu
+					if t < hevc.NaluTypeVps {
+						hasVcl = true
+					}
 					bele.BePutUint32(payload[pos:], uint32(len(nal)))
 					pos += 4
 					copy(payload[pos:], nal)
@@ -275,7 +280,43 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 
 		// 有实际数据
 		if pos > 5 {
-			r.emitRtmpAvMsg(false, payload[:pos], pkt.Timestamp)
+			if !hasVcl {
+				// 只有SEI等非视频数据的nalu（比如rtsp中SEI和视频帧的rtp时间戳不同，被合成了单独的包），
+				// 缓存起来和下一帧合并发送，避免单独发送导致字幕等SEI数据被播放端丢弃
+				if len(r.pendingNals)+pos-5 > maxPendingNalsSize {
+					Log.Warnf("pending nals too large, drop. size=%d", len(r.pendingNals))
+					r.pendingNals = r.pendingNals[:0]
+				}
+				r.pendingNals = append(r.pendingNals, payload[5:pos]...)
+				return
+			}
+
+			if len(r.pendingNals) > 0 {
+				merged := make([]byte, pos+len(r.pendingNals))
+				copy(merged[5:], r.pendingNals)
+				copy(merged[5+len(r.pendingNals):], payload[5:pos])
+				payload = merged
+				pos = len(merged)
+				r.pendingNals = r.pendingNals[:0]
+			}
+
+			// 注意，帧类型由整帧中是否包含关键帧nalu决定，而不是由最后一个nalu决定，因为关键帧后面可能还跟着SEI等nalu
+			if pkt.PayloadType == base.AvPacketPtAvc {
+				if isKey {
+					payload[0] = base.RtmpAvcKeyFrame
+				} else {
+					payload[0] = base.RtmpAvcInterFrame
+				}
+				payload[1] = base.RtmpAvcPacketTypeNalu
+			} else {
+				if isKey {
+					payload[0] = base.RtmpHevcKeyFrame
+				} else {
+					payload[0] = base.RtmpHevcInterFrame
+				}
+				payload[1] = base.RtmpHevcPacketTypeNalu
+			}
+			r.emitRtmpAvMsg(false, payload[:pos], pkt.Timestamp, pkt.CaptureTimeMs)
 		}
 
 	case base.AvPacketPtAac:
@@ -286,7 +327,7 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 			payload[0] = 0xAF
 			payload[1] = base.RtmpAacPacketTypeRaw
 			copy(payload[2:], pkt.Payload)
-			r.emitRtmpAvMsg(true, payload, pkt.Timestamp)
+			r.emitRtmpAvMsg(true, payload, pkt.Timestamp, pkt.CaptureTimeMs)
 		} else if r.option.AudioFormat == base.AvPacketStreamAudioFormatAdtsAac {
 			if !r.hasAdts2Asc {
 				adts, err := aac.MakeAudioDataSeqHeaderWithAdtsHeader(pkt.Payload)
@@ -294,7 +335,7 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 					Log.Errorf("%+v", err)
 				}
 
-				r.emitRtmpAvMsg(true, adts, pkt.Timestamp)
+				r.emitRtmpAvMsg(true, adts, pkt.Timestamp, pkt.CaptureTimeMs)
 
 				r.hasAdts2Asc = true
 			}
@@ -304,7 +345,7 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 			payload[0] = 0xAF
 			payload[1] = base.RtmpAacPacketTypeRaw
 			copy(payload[7:], pkt.Payload)
-			r.emitRtmpAvMsg(true, payload, pkt.Timestamp)
+			r.emitRtmpAvMsg(true, payload, pkt.Timestamp, pkt.CaptureTimeMs)
 		}
 
 	default:
@@ -314,7 +355,7 @@ func (r *AvPacket2RtmpRemuxer) FeedAvPacket(pkt base.AvPacket) {
 
 // ---------------------------------------------------------------------------------------------------------------------
 
-func (r *AvPacket2RtmpRemuxer) emitRtmpAvMsg(isAudio bool, payload []byte, timestamp int64) {
+func (r *AvPacket2RtmpRemuxer) emitRtmpAvMsg(isAudio bool, payload []byte, timestamp int64, captureTimeMs int64) {
 	if !r.hasEmittedMetadata {
 		// TODO(chef): 此处简化了从sps中获取宽高写入metadata的逻辑
 		audiocodecid := -1
@@ -360,6 +401,7 @@ func (r *AvPacket2RtmpRemuxer) emitRtmpAvMsg(isAudio bool, payload []byte, times
 	msg.Header.MsgLen = uint32(len(payload))
 	msg.Header.TimestampAbs = uint32(timestamp)
 	msg.Payload = payload
+	msg.CaptureTimeMs = captureTimeMs
 
 	r.onRtmpMsg(msg)
 }
@@ -0,0 +1,58 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hevc"
+)
+
+// RtmpMsgHasClosedCaptions rtmp视频消息中是否携带了CEA-608/708字幕（ATSC A/53格式的SEI）
+//
+func RtmpMsgHasClosedCaptions(msg base.RtmpMsg) bool {
+	if msg.Header.MsgTypeId != base.RtmpTypeIdVideo || len(msg.Payload) < 5 || msg.Payload[1] != base.RtmpAvcPacketTypeNalu {
+		return false
+	}
+	isHevc := msg.VideoCodecId() == base.RtmpCodecIdHevc
+
+	found := false
+	_ = avc.IterateNaluAvcc(msg.Payload[5:], func(nal []byte) {
+		if found {
+			return
+		}
+
+		var (
+			msgs []avc.SeiMessage
+			err  error
+		)
+		if isHevc {
+			t := hevc.ParseNaluType(nal[0])
+			if t != hevc.NaluTypeSei && t != hevc.NaluTypeSeiSuffix {
+				return
+			}
+			msgs, err = hevc.ParseSei(nal)
+		} else {
+			if avc.ParseNaluType(nal[0]) != avc.NaluTypeSei {
+				return
+			}
+			msgs, err = avc.ParseSei(nal)
+		}
+		if err != nil {
+			return
+		}
+		for _, m := range msgs {
+			if avc.IsA53CaptionSei(m) {
+				found = true
+				return
+			}
+		}
+	})
+	return found
+}
@@ -0,0 +1,65 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux_test
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+func TestClosedCaptions(t *testing.T) {
+	caption := avc.SeiMessage{
+		PayloadType: avc.SeiPayloadTypeUserDataRegisteredItuTT35,
+		Payload:     []byte{0xB5, 0x00, 0x31, 'G', 'A', '9', '4', 0x03, 0xC1, 0xFF, 0xFC, 0x94, 0x20, 0xFF},
+	}
+	assert.Equal(t, true, avc.IsA53CaptionSei(caption))
+	assert.Equal(t, false, avc.IsA53CaptionSei(remux.BuildLatencySei(remux.LatencySeiInfo{})))
+
+	avcc := func(nals ...[]byte) []byte {
+		var out []byte
+		for _, nal := range nals {
+			l := make([]byte, 4)
+			bele.BePutUint32(l, uint32(len(nal)))
+			out = append(append(out, l...), nal...)
+		}
+		return out
+	}
+	sei := avc.BuildSei([]avc.SeiMessage{caption})
+	idr := []byte{0x65, 0x88, 0x84, 0x00}
+
+	var msgs []base.RtmpMsg
+	remuxer := remux.NewAvPacket2RtmpRemuxer().WithOnRtmpMsg(func(msg base.RtmpMsg) {
+		if msg.Header.MsgTypeId == base.RtmpTypeIdVideo {
+			msgs = append(msgs, msg)
+		}
+	})
+
+	// 只包含SEI的包，和下一帧合并
+	remuxer.FeedAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAvc, Timestamp: 40, Payload: avcc(sei)})
+	assert.Equal(t, 0, len(msgs))
+
+	// 关键帧后面跟着SEI，依然是关键帧
+	remuxer.FeedAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAvc, Timestamp: 40, Payload: avcc(idr, sei)})
+	assert.Equal(t, 1, len(msgs))
+	assert.Equal(t, true, msgs[0].IsAvcKeyNalu())
+	assert.Equal(t, true, remux.RtmpMsgHasClosedCaptions(msgs[0]))
+	nals, err := avc.SplitNaluAvcc(msgs[0].Payload[5:])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]byte{sei, idr, sei}, nals)
+
+	remuxer.FeedAvPacket(base.AvPacket{PayloadType: base.AvPacketPtAvc, Timestamp: 80, Payload: avcc([]byte{0x41, 0x9a, 0x00, 0x01})})
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, false, msgs[1].IsAvcKeyNalu())
+	assert.Equal(t, false, remux.RtmpMsgHasClosedCaptions(msgs[1]))
+}
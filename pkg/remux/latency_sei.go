@@ -0,0 +1,96 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/hevc"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// 用于测量延迟的SEI。
+//
+// lalserver开启 debug.latency_sei_enable 后，在视频帧输入时注入该SEI，记录输入时的本地时间，以及帧的采集时间（如果已知），
+// 拉流端从任意协议的输出中读取该SEI，与本地时间比较即可得到延迟，见 app/demo/calcseidelay 。
+//
+// 格式为user_data_unregistered：
+// uuid(16字节) | ingest unix ms(8字节，大端) | capture unix ms(8字节，大端，0表示未知)
+//
+
+var LatencySeiUuid = [avc.SeiUuidLen]byte{'l', 'a', 'l', '-', 'l', 'a', 't', 'e', 'n', 'c', 'y', '-', 's', 'e', 'i', 0}
+
+const latencySeiDataLen = 16
+
+type LatencySeiInfo struct {
+	IngestUnixMs  int64
+	CaptureUnixMs int64
+}
+
+func BuildLatencySei(info LatencySeiInfo) avc.SeiMessage {
+	data := make([]byte, latencySeiDataLen)
+	bele.BePutUint64(data, uint64(info.IngestUnixMs))
+	bele.BePutUint64(data[8:], uint64(info.CaptureUnixMs))
+	return avc.BuildUserDataUnregistered(LatencySeiUuid, data)
+}
+
+// ParseLatencySei
+//
+// @return ok: 为false表示不是延迟测量SEI
+//
+func ParseLatencySei(msg avc.SeiMessage) (info LatencySeiInfo, ok bool) {
+	if msg.PayloadType != avc.SeiPayloadTypeUserDataUnregistered {
+		return info, false
+	}
+	uuid, data, err := avc.ParseUserDataUnregistered(msg.Payload)
+	if err != nil || uuid != LatencySeiUuid || len(data) < latencySeiDataLen {
+		return info, false
+	}
+	info.IngestUnixMs = int64(bele.BeUint64(data))
+	info.CaptureUnixMs = int64(bele.BeUint64(data[8:]))
+	return info, true
+}
+
+// FindLatencySei 在一帧视频数据中查找延迟测量SEI
+//
+// @param nals: 该帧的所有nalu，不包含start code或长度前缀
+//
+func FindLatencySei(nals [][]byte, isHevc bool) (info LatencySeiInfo, ok bool) {
+	for _, nal := range nals {
+		if len(nal) == 0 {
+			continue
+		}
+
+		var (
+			msgs []avc.SeiMessage
+			err  error
+		)
+		if isHevc {
+			t := hevc.ParseNaluType(nal[0])
+			if t != hevc.NaluTypeSei && t != hevc.NaluTypeSeiSuffix {
+				continue
+			}
+			msgs, err = hevc.ParseSei(nal)
+		} else {
+			if avc.ParseNaluType(nal[0]) != avc.NaluTypeSei {
+				continue
+			}
+			msgs, err = avc.ParseSei(nal)
+		}
+		if err != nil {
+			continue
+		}
+
+		for _, msg := range msgs {
+			if info, ok = ParseLatencySei(msg); ok {
+				return
+			}
+		}
+	}
+	return info, false
+}
@@ -0,0 +1,43 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux_test
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/hevc"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestLatencySei(t *testing.T) {
+	info := remux.LatencySeiInfo{
+		IngestUnixMs:  1660000000123,
+		CaptureUnixMs: 1660000000000,
+	}
+	msg := remux.BuildLatencySei(info)
+	other := avc.SeiMessage{PayloadType: avc.SeiPayloadTypeUserDataUnregistered, Payload: make([]byte, 32)}
+	idr := []byte{0x65, 0x88, 0x84, 0x00}
+
+	nals := [][]byte{avc.BuildSei([]avc.SeiMessage{other, msg}), idr}
+	ret, ok := remux.FindLatencySei(nals, false)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, info, ret)
+	_, ok = remux.FindLatencySei(nals, true)
+	assert.Equal(t, false, ok)
+
+	nals = [][]byte{hevc.BuildSei([]avc.SeiMessage{msg}, false)}
+	ret, ok = remux.FindLatencySei(nals, true)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, info, ret)
+
+	_, ok = remux.FindLatencySei([][]byte{avc.BuildSei([]avc.SeiMessage{other}), idr}, false)
+	assert.Equal(t, false, ok)
+}
@@ -0,0 +1,474 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"bytes"
+
+	"github.com/q191201771/lal/pkg/aac"
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
+	"github.com/q191201771/lal/pkg/hevc"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+const (
+	defaultFmp4FragmentDurationMs = 1000
+
+	defaultFmp4VideoSampleDuration = 40 * 90 // 无法计算时使用的视频帧时长，单位为视频轨道的timescale
+	defaultFmp4AudioSampleDuration = 1024    // aac一帧的采样数
+)
+
+type IRtmp2Fmp4RemuxerObserver interface {
+	// OnFmp4InitSegment
+	//
+	// 该回调一定发生在 OnFmp4Fragment 之前，流的编码参数发生变化时会再次回调
+	//
+	// @param b: 初始化分片（ftyp+moov），回调结束后，remux.Rtmp2Fmp4Remuxer 不再使用这块内存块
+	//
+	OnFmp4InitSegment(b []byte)
+
+	// OnFmp4Fragment
+	//
+	// @param b: 媒体分片（moof+mdat），回调结束后，remux.Rtmp2Fmp4Remuxer 不再使用这块内存块
+	//
+	// @param startTs: 分片中第一个sample的dts，单位（毫秒*90），与mpegts的时间戳保持一致
+	// @param endTs:   分片中最后一个sample结束的时间，单位（毫秒*90）
+	//
+	// @param boundary: 是否到达边界处，也即分片是否从视频关键帧开始（纯音频流总是为true），上层据此判断是否允许开启新的hls分段
+	//
+	OnFmp4Fragment(b []byte, startTs uint64, endTs uint64, boundary bool)
+}
+
+// IRtmp2Fmp4RemuxerCaptureTimeObserver 可选，observer实现该接口时，每次回调 OnFmp4Fragment 之前先回调该接口
+//
+type IRtmp2Fmp4RemuxerCaptureTimeObserver interface {
+	// OnFmp4FragmentCaptureTime
+	//
+	// @param captureTimeMs: 即将回调的媒体分片中第一帧的采集时间，见 base.RtmpMsg.CaptureTimeMs ，为0时表示未知
+	//
+	OnFmp4FragmentCaptureTime(captureTimeMs int64)
+}
+
+// Rtmp2Fmp4Remuxer 输入rtmp流，输出fmp4（CMAF）流
+//
+// 在视频关键帧处，以及缓存的数据时长达到阈值时，生成一个moof+mdat媒体分片
+//
+type Rtmp2Fmp4Remuxer struct {
+	UniqueKey string
+
+	observer           IRtmp2Fmp4RemuxerObserver
+	fragmentDurationMs uint32
+
+	// 生成初始化分片前，缓存流起始的数据，用于判断流中是否存在音频、视频
+	cache  []base.RtmpMsg
+	inited bool
+
+	videoSeqHeader []byte
+	audioSeqHeader []byte
+	videoTrack     *fmp4.VideoTrack
+	audioTrack     *fmp4.AudioTrack
+
+	video fmp4TrackCache
+	audio fmp4TrackCache
+
+	sequenceNumber        uint32
+	fragmentBoundary      bool // 当前正在缓存的分片是否从视频关键帧开始
+	fragmentStartMs       uint32
+	fragmentCaptureTimeMs int64 // 当前正在缓存的分片中第一帧的采集时间
+	fragmentHasStarted    bool
+
+	// 用于估算下一帧的时间戳，使得媒体分片的时长不超过fragmentDurationMs
+	prevFrameMs     uint32
+	hasPrevFrame    bool
+	frameIntervalMs uint32
+}
+
+// fmp4TrackCache 单个轨道缓存的sample
+//
+// 注意，sample的时长需要下一个sample到来时才能确定，所以最后一个sample单独缓存在last中
+//
+type fmp4TrackCache struct {
+	samples  []fmp4.Sample // 时长已确定的sample
+	firstDts uint64        // samples中第一个sample的dts，单位为轨道的timescale
+
+	last         fmp4.Sample
+	lastDts      uint64
+	hasLast      bool
+	lastDuration uint32
+}
+
+func NewRtmp2Fmp4Remuxer(observer IRtmp2Fmp4RemuxerObserver) *Rtmp2Fmp4Remuxer {
+	return &Rtmp2Fmp4Remuxer{
+		UniqueKey:          base.GenUkRtmp2Fmp4Remuxer(),
+		observer:           observer,
+		fragmentDurationMs: defaultFmp4FragmentDurationMs,
+	}
+}
+
+// WithFragmentDurationMs 设置非关键帧处生成媒体分片的最大时长，默认为1000毫秒
+//
+func (r *Rtmp2Fmp4Remuxer) WithFragmentDurationMs(v uint32) *Rtmp2Fmp4Remuxer {
+	r.fragmentDurationMs = v
+	return r
+}
+
+// FeedRtmpMessage
+//
+// @param msg: msg.Payload 调用结束后，函数内部不会持有这块内存
+//
+func (r *Rtmp2Fmp4Remuxer) FeedRtmpMessage(msg base.RtmpMsg) {
+	if msg.Header.MsgTypeId != base.RtmpTypeIdAudio && msg.Header.MsgTypeId != base.RtmpTypeIdVideo {
+		return
+	}
+	if len(msg.Payload) < 2 {
+		return
+	}
+
+	if r.inited {
+		r.feed(msg)
+		return
+	}
+
+	r.cacheSeqHeader(msg)
+	r.cache = append(r.cache, msg.Clone())
+	if (r.videoSeqHeader != nil && r.audioSeqHeader != nil) || len(r.cache) >= calcFragmentHeaderQueueSize {
+		if !r.init() {
+			// 缓存满了依然没有seq header，丢弃缓存继续等待
+			r.cache = nil
+			return
+		}
+		cache := r.cache
+		r.cache = nil
+		for _, m := range cache {
+			r.feed(m)
+		}
+	}
+}
+
+// Dispose 将内部缓存的数据全部吐出
+//
+func (r *Rtmp2Fmp4Remuxer) Dispose() {
+	if !r.inited {
+		return
+	}
+	r.video.finishLast(defaultFmp4VideoSampleDuration)
+	r.audio.finishLast(defaultFmp4AudioSampleDuration)
+	r.flush()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// cacheSeqHeader
+//
+// @return 是否是seq header
+//
+func (r *Rtmp2Fmp4Remuxer) cacheSeqHeader(msg base.RtmpMsg) bool {
+	if msg.IsAvcKeySeqHeader() || msg.IsHevcKeySeqHeader() {
+		r.videoSeqHeader = append([]byte(nil), msg.Payload...)
+		return true
+	}
+	if msg.IsAacSeqHeader() {
+		r.audioSeqHeader = append([]byte(nil), msg.Payload...)
+		return true
+	}
+	return false
+}
+
+// init 使用已缓存的seq header生成并回调初始化分片
+//
+// @return 没有任何可用的seq header时返回false
+//
+func (r *Rtmp2Fmp4Remuxer) init() bool {
+	r.videoTrack = nil
+	r.audioTrack = nil
+
+	if r.videoSeqHeader != nil {
+		track, err := r.makeVideoTrack(r.videoSeqHeader)
+		if err != nil {
+			Log.Errorf("[%s] make video track failed. err=%+v", r.UniqueKey, err)
+		} else {
+			r.videoTrack = track
+		}
+	}
+	if r.audioSeqHeader != nil {
+		track, err := r.makeAudioTrack(r.audioSeqHeader)
+		if err != nil {
+			Log.Errorf("[%s] make audio track failed. err=%+v", r.UniqueKey, err)
+		} else {
+			r.audioTrack = track
+		}
+	}
+
+	b, err := fmp4.BuildInitSegment(r.videoTrack, r.audioTrack)
+	if err != nil {
+		return false
+	}
+
+	r.inited = true
+	r.video = fmp4TrackCache{}
+	r.audio = fmp4TrackCache{}
+	r.fragmentBoundary = r.videoTrack == nil
+	r.fragmentHasStarted = false
+	r.observer.OnFmp4InitSegment(b)
+	return true
+}
+
+func (r *Rtmp2Fmp4Remuxer) makeVideoTrack(seqHeader []byte) (*fmp4.VideoTrack, error) {
+	track := &fmp4.VideoTrack{
+		DecoderConfigurationRecord: seqHeader[5:],
+	}
+	if seqHeader[0]&0xF == base.RtmpCodecIdHevc {
+		track.Codec = base.VideoCodecHevc
+		_, sps, _, err := hevc.ParseVpsSpsPpsFromSeqHeader(seqHeader)
+		if err != nil {
+			return nil, err
+		}
+		var ctx hevc.Context
+		if err = hevc.ParseSps(sps, &ctx); err != nil {
+			return nil, err
+		}
+		track.Width = int(ctx.PicWidthInLumaSamples)
+		track.Height = int(ctx.PicHeightInLumaSamples)
+	} else {
+		track.Codec = base.VideoCodecAvc
+		sps, _, err := avc.ParseSpsPpsFromSeqHeader(seqHeader)
+		if err != nil {
+			return nil, err
+		}
+		var ctx avc.Context
+		if err = avc.ParseSps(sps, &ctx); err != nil {
+			return nil, err
+		}
+		track.Width = int(ctx.Width)
+		track.Height = int(ctx.Height)
+	}
+	return track, nil
+}
+
+func (r *Rtmp2Fmp4Remuxer) makeAudioTrack(seqHeader []byte) (*fmp4.AudioTrack, error) {
+	asc := seqHeader[2:]
+	ascCtx, err := aac.NewAscContext(asc)
+	if err != nil {
+		return nil, err
+	}
+	sampleRate, err := ascCtx.GetSamplingFrequency()
+	if err != nil {
+		return nil, err
+	}
+	return &fmp4.AudioTrack{
+		SampleRate:   sampleRate,
+		ChannelCount: int(ascCtx.ChannelConfiguration),
+		Asc:          asc,
+	}, nil
+}
+
+func (r *Rtmp2Fmp4Remuxer) feed(msg base.RtmpMsg) {
+	if msg.IsAvcKeySeqHeader() || msg.IsHevcKeySeqHeader() || msg.IsAacSeqHeader() {
+		old := r.videoSeqHeader
+		if msg.Header.MsgTypeId == base.RtmpTypeIdAudio {
+			old = r.audioSeqHeader
+		}
+		if bytes.Equal(old, msg.Payload) {
+			return
+		}
+
+		// 编码参数发生变化，先把缓存的数据吐出，再重新生成初始化分片
+		Log.Infof("[%s] seq header changed, rebuild init segment.", r.UniqueKey)
+		r.Dispose()
+		r.cacheSeqHeader(msg)
+		r.init()
+		return
+	}
+
+	if msg.Header.MsgTypeId == base.RtmpTypeIdVideo {
+		r.feedVideo(msg)
+	} else {
+		r.feedAudio(msg)
+	}
+}
+
+func (r *Rtmp2Fmp4Remuxer) feedVideo(msg base.RtmpMsg) {
+	if r.videoTrack == nil || len(msg.Payload) <= 5 || msg.Payload[1] != base.RtmpAvcPacketTypeNalu {
+		return
+	}
+	codecId := msg.Payload[0] & 0xF
+	if (codecId == base.RtmpCodecIdHevc) != (r.videoTrack.Codec == base.VideoCodecHevc) {
+		return
+	}
+
+	dts := uint64(msg.Header.TimestampAbs) * 90
+	r.video.finishLastByNext(dts, defaultFmp4VideoSampleDuration)
+
+	r.updateFrameInterval(msg.Header.TimestampAbs)
+
+	isKey := msg.IsVideoKeyNalu()
+	if isKey {
+		r.flush()
+		r.fragmentBoundary = true
+	} else {
+		r.flushIfNeeded(msg.Header.TimestampAbs, false)
+	}
+	r.markFragmentStart(msg.Header.TimestampAbs, msg.CaptureTimeMs)
+
+	r.video.setLast(dts, fmp4.Sample{
+		CtsOffset: int32(bele.BeUint24(msg.Payload[2:])) * 90,
+		IsKey:     isKey,
+		Data:      append([]byte(nil), msg.Payload[5:]...),
+	})
+}
+
+func (r *Rtmp2Fmp4Remuxer) feedAudio(msg base.RtmpMsg) {
+	if r.audioTrack == nil || msg.Payload[0]>>4 != base.RtmpSoundFormatAac || msg.Payload[1] != base.RtmpAacPacketTypeRaw {
+		return
+	}
+
+	dts := uint64(msg.Header.TimestampAbs) * uint64(r.audioTrack.SampleRate) / 1000
+	r.audio.finishLastByNext(dts, defaultFmp4AudioSampleDuration)
+
+	// 有视频时，只在视频帧处判断是否生成分片
+	if r.videoTrack == nil {
+		r.updateFrameInterval(msg.Header.TimestampAbs)
+		r.flushIfNeeded(msg.Header.TimestampAbs, true)
+	}
+	r.markFragmentStart(msg.Header.TimestampAbs, msg.CaptureTimeMs)
+
+	r.audio.setLast(dts, fmp4.Sample{
+		IsKey: true,
+		Data:  append([]byte(nil), msg.Payload[2:]...),
+	})
+}
+
+func (r *Rtmp2Fmp4Remuxer) markFragmentStart(ts uint32, captureTimeMs int64) {
+	if !r.fragmentHasStarted {
+		r.fragmentStartMs = ts
+		r.fragmentCaptureTimeMs = captureTimeMs
+		r.fragmentHasStarted = true
+	}
+}
+
+func (r *Rtmp2Fmp4Remuxer) updateFrameInterval(ts uint32) {
+	if r.hasPrevFrame && ts > r.prevFrameMs {
+		r.frameIntervalMs = ts - r.prevFrameMs
+	}
+	r.prevFrameMs = ts
+	r.hasPrevFrame = true
+}
+
+// flushIfNeeded
+//
+// 如果加入下一帧后，分片时长将超过fragmentDurationMs，则在当前帧之前生成分片
+//
+// 注意，当前帧（时间戳为ts）不会包含在生成的分片中，所以分片的时长为 ts - fragmentStartMs
+//
+func (r *Rtmp2Fmp4Remuxer) flushIfNeeded(ts uint32, boundary bool) {
+	if r.fragmentHasStarted && ts-r.fragmentStartMs+r.frameIntervalMs > r.fragmentDurationMs {
+		r.flush()
+		r.fragmentBoundary = boundary
+	}
+}
+
+// flush 将时长已确定的sample打包成媒体分片并回调
+//
+func (r *Rtmp2Fmp4Remuxer) flush() {
+	r.fragmentHasStarted = false
+	if len(r.video.samples) == 0 && len(r.audio.samples) == 0 {
+		return
+	}
+
+	var (
+		tracks         []fmp4.TrackFragment
+		startTs, endTs uint64
+		hasTs          bool
+	)
+	updateTs := func(start, end uint64) {
+		if !hasTs || start < startTs {
+			startTs = start
+		}
+		if !hasTs || end > endTs {
+			endTs = end
+		}
+		hasTs = true
+	}
+	if len(r.video.samples) != 0 {
+		tracks = append(tracks, r.video.takeFragment(fmp4.TrackIdVideo))
+		updateTs(tracks[len(tracks)-1].BaseMediaDecodeTime, r.video.firstDts)
+	}
+	if len(r.audio.samples) != 0 {
+		tracks = append(tracks, r.audio.takeFragment(fmp4.TrackIdAudio))
+		rate := uint64(r.audioTrack.SampleRate)
+		updateTs(tracks[len(tracks)-1].BaseMediaDecodeTime*90000/rate, r.audio.firstDts*90000/rate)
+	}
+
+	r.sequenceNumber++
+	b := fmp4.BuildFragment(r.sequenceNumber, tracks)
+	if o, ok := r.observer.(IRtmp2Fmp4RemuxerCaptureTimeObserver); ok {
+		o.OnFmp4FragmentCaptureTime(r.fragmentCaptureTimeMs)
+	}
+	r.observer.OnFmp4Fragment(b, startTs, endTs, r.fragmentBoundary)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// finishLastByNext 下一个sample到来，确定last的时长
+//
+func (c *fmp4TrackCache) finishLastByNext(nextDts uint64, defaultDuration uint32) {
+	if !c.hasLast {
+		return
+	}
+	duration := c.lastDuration
+	if nextDts > c.lastDts {
+		duration = uint32(nextDts - c.lastDts)
+	}
+	if duration == 0 {
+		duration = defaultDuration
+	}
+	c.lastDuration = duration
+	c.finishLast(duration)
+}
+
+// finishLast 使用指定时长（如果之前已经计算过时长，则使用之前的时长）确定last的时长，并放入samples中
+//
+func (c *fmp4TrackCache) finishLast(defaultDuration uint32) {
+	if !c.hasLast {
+		return
+	}
+	duration := c.lastDuration
+	if duration == 0 {
+		duration = defaultDuration
+	}
+	if len(c.samples) == 0 {
+		c.firstDts = c.lastDts
+	}
+	c.last.Duration = duration
+	c.samples = append(c.samples, c.last)
+	c.hasLast = false
+}
+
+func (c *fmp4TrackCache) setLast(dts uint64, sample fmp4.Sample) {
+	c.last = sample
+	c.lastDts = dts
+	c.hasLast = true
+}
+
+// takeFragment 取出samples，调用后firstDts变为这些sample结束的时间
+//
+func (c *fmp4TrackCache) takeFragment(trackId uint32) fmp4.TrackFragment {
+	tf := fmp4.TrackFragment{
+		TrackId:             trackId,
+		BaseMediaDecodeTime: c.firstDts,
+		Samples:             c.samples,
+	}
+	for _, s := range c.samples {
+		c.firstDts += uint64(s.Duration)
+	}
+	c.samples = nil
+	return tf
+}
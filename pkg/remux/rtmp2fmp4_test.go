@@ -0,0 +1,164 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/fmp4"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type fmp4Fragment struct {
+	b        []byte
+	startTs  uint64
+	endTs    uint64
+	boundary bool
+}
+
+type mockFmp4Observer struct {
+	inits     [][]byte
+	fragments []fmp4Fragment
+}
+
+func (o *mockFmp4Observer) OnFmp4InitSegment(b []byte) {
+	o.inits = append(o.inits, b)
+}
+
+func (o *mockFmp4Observer) OnFmp4Fragment(b []byte, startTs uint64, endTs uint64, boundary bool) {
+	o.fragments = append(o.fragments, fmp4Fragment{b, startTs, endTs, boundary})
+}
+
+func TestRtmp2Fmp4Remuxer(t *testing.T) {
+	sps, _ := hex.DecodeString("67640032ad84010c20086100430802184010c200843b5014005ad370101014000003000400000300ca100002")
+	pps, _ := hex.DecodeString("68ee3cb0")
+	vsh, err := avc.BuildSeqHeaderFromSpsPps(sps, pps)
+	assert.Equal(t, nil, err)
+
+	makeMsg := func(typeId uint8, ts uint32, payload []byte) base.RtmpMsg {
+		var msg base.RtmpMsg
+		msg.Header.MsgTypeId = typeId
+		msg.Header.TimestampAbs = ts
+		msg.Header.MsgLen = uint32(len(payload))
+		msg.Payload = payload
+		return msg
+	}
+	video := func(ts uint32, key bool) base.RtmpMsg {
+		if key {
+			return makeMsg(base.RtmpTypeIdVideo, ts, []byte{base.RtmpAvcKeyFrame, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88})
+		}
+		return makeMsg(base.RtmpTypeIdVideo, ts, []byte{base.RtmpAvcInterFrame, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a})
+	}
+	audio := func(ts uint32) base.RtmpMsg {
+		return makeMsg(base.RtmpTypeIdAudio, ts, []byte{0xAF, base.RtmpAacPacketTypeRaw, 0x21, 0x00})
+	}
+
+	observer := &mockFmp4Observer{}
+	remuxer := remux.NewRtmp2Fmp4Remuxer(observer)
+	remuxer.FeedRtmpMessage(makeMsg(base.RtmpTypeIdVideo, 0, vsh))
+	assert.Equal(t, 0, len(observer.inits))
+	remuxer.FeedRtmpMessage(makeMsg(base.RtmpTypeIdAudio, 0, []byte{0xAF, base.RtmpAacPacketTypeSeqHeader, 0x12, 0x10}))
+	assert.Equal(t, 1, len(observer.inits))
+
+	remuxer.FeedRtmpMessage(video(0, true))
+	remuxer.FeedRtmpMessage(audio(0))
+	remuxer.FeedRtmpMessage(audio(23))
+	remuxer.FeedRtmpMessage(video(40, false))
+	remuxer.FeedRtmpMessage(audio(46))
+	assert.Equal(t, 0, len(observer.fragments))
+
+	// 关键帧到来，之前的数据生成一个分片
+	remuxer.FeedRtmpMessage(video(80, true))
+	assert.Equal(t, 1, len(observer.fragments))
+	f := observer.fragments[0]
+	assert.Equal(t, true, f.boundary)
+	assert.Equal(t, uint64(0), f.startTs)
+	assert.Equal(t, uint64(80*90), f.endTs)
+
+	var mdat []byte
+	_ = fmp4.IterateBox(f.b, func(typ string, payload []byte) {
+		if typ == "mdat" {
+			mdat = payload
+		}
+	})
+	// 2个视频帧，2个音频帧
+	assert.Equal(t, 2*6+2*2, len(mdat))
+
+	// 相同的seq header不会重新生成初始化分片
+	remuxer.FeedRtmpMessage(makeMsg(base.RtmpTypeIdVideo, 80, vsh))
+	assert.Equal(t, 1, len(observer.inits))
+
+	remuxer.Dispose()
+	assert.Equal(t, 2, len(observer.fragments))
+	f = observer.fragments[1]
+	assert.Equal(t, true, f.boundary)
+	// 注意，最后一个音频帧的时长在下一个音频帧到来前无法确定，所以被放入了下一个分片
+	assert.Equal(t, uint64(46*44100/1000*90000/44100), f.startTs)
+	assert.Equal(t, uint64(80*90+40*90), f.endTs)
+}
+
+type mockFmp4CaptureTimeObserver struct {
+	mockFmp4Observer
+	captureTimes []int64
+}
+
+func (o *mockFmp4CaptureTimeObserver) OnFmp4FragmentCaptureTime(captureTimeMs int64) {
+	// 在 OnFmp4Fragment 之前回调
+	if len(o.captureTimes) != len(o.fragments) {
+		panic("capture time callback out of order")
+	}
+	o.captureTimes = append(o.captureTimes, captureTimeMs)
+}
+
+func TestRtmp2Fmp4RemuxerCaptureTime(t *testing.T) {
+	sps, _ := hex.DecodeString("67640032ad84010c20086100430802184010c200843b5014005ad370101014000003000400000300ca100002")
+	pps, _ := hex.DecodeString("68ee3cb0")
+	vsh, err := avc.BuildSeqHeaderFromSpsPps(sps, pps)
+	assert.Equal(t, nil, err)
+
+	video := func(ts uint32, key bool, captureTimeMs int64) base.RtmpMsg {
+		var msg base.RtmpMsg
+		msg.Header.MsgTypeId = base.RtmpTypeIdVideo
+		msg.Header.TimestampAbs = ts
+		msg.Payload = []byte{base.RtmpAvcInterFrame, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a}
+		if key {
+			msg.Payload = []byte{base.RtmpAvcKeyFrame, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88}
+		}
+		msg.Header.MsgLen = uint32(len(msg.Payload))
+		msg.CaptureTimeMs = captureTimeMs
+		return msg
+	}
+
+	observer := &mockFmp4CaptureTimeObserver{}
+	remuxer := remux.NewRtmp2Fmp4Remuxer(observer)
+	var msh base.RtmpMsg
+	msh.Header.MsgTypeId = base.RtmpTypeIdVideo
+	msh.Payload = vsh
+	msh.Header.MsgLen = uint32(len(vsh))
+	remuxer.FeedRtmpMessage(msh)
+	var ash base.RtmpMsg
+	ash.Header.MsgTypeId = base.RtmpTypeIdAudio
+	ash.Payload = []byte{0xAF, base.RtmpAacPacketTypeSeqHeader, 0x12, 0x10}
+	ash.Header.MsgLen = uint32(len(ash.Payload))
+	remuxer.FeedRtmpMessage(ash)
+	assert.Equal(t, 1, len(observer.inits))
+
+	// 每个分片回调分片中第一帧的采集时间
+	startMs := int64(1666590000000)
+	for i := uint32(0); i < 6; i++ {
+		remuxer.FeedRtmpMessage(video(i*40, i%3 == 0, startMs+int64(i*40)))
+	}
+	remuxer.Dispose()
+	assert.Equal(t, 2, len(observer.fragments))
+	assert.Equal(t, []int64{startMs, startMs + 120}, observer.captureTimes)
+}
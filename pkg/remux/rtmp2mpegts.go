@@ -95,8 +95,20 @@ type Rtmp2MpegtsRemuxer struct {
 	//
 	//TODO chef: rename to DTS
 	//
-	audioCacheFrames        []byte
-	audioCacheFirstFramePts uint64
+	audioCacheFrames                  []byte
+	audioCacheFirstFramePts           uint64
+	audioCacheFirstFrameCaptureTimeMs int64
+
+	// 定时元数据，见 WithTimedMetadata
+	timedMetadata bool
+	metadataCc    uint8
+	lastDts       uint64 // 最近一帧音视频的时间戳，单位（毫秒*90），通过 FeedTimedMetadata 输入的元数据使用该时间戳
+
+	// SCTE-35广告插入信令，见 WithScte35
+	scte35         bool
+	scte35Cc       uint8
+	pendingSplices []mpegts.Scte35SpliceInfo // 等待在关键帧处输出的信令
+	forceBoundary  bool                      // 输出信令后，对应的关键帧强制作为边界
 
 	opened bool
 }
@@ -114,6 +126,24 @@ func NewRtmp2MpegtsRemuxer(observer IRtmp2MpegtsRemuxerObserver) *Rtmp2MpegtsRem
 	return r
 }
 
+// WithTimedMetadata 是否将rtmp数据消息（比如`onTextData`、`onCuePoint`）转换为ID3定时元数据，默认不转换
+//
+// 开启后，PMT中声明 mpegts.PidMetadata ，hls播放器可以获取到对应时间点的元数据
+//
+func (s *Rtmp2MpegtsRemuxer) WithTimedMetadata(enable bool) *Rtmp2MpegtsRemuxer {
+	s.timedMetadata = enable
+	return s
+}
+
+// WithScte35 是否支持SCTE-35广告插入信令，默认不支持
+//
+// 开启后，PMT中声明 mpegts.PidScte35 ，通过 ScheduleSplice 输入信令
+//
+func (s *Rtmp2MpegtsRemuxer) WithScte35(enable bool) *Rtmp2MpegtsRemuxer {
+	s.scte35 = enable
+	return s
+}
+
 // FeedRtmpMessage
 //
 // @param msg: msg.Payload 调用结束后，函数内部不会持有这块内存
@@ -122,6 +152,43 @@ func (s *Rtmp2MpegtsRemuxer) FeedRtmpMessage(msg base.RtmpMsg) {
 	s.filter.Push(msg)
 }
 
+// FeedTimedMetadata 输入自定义的定时元数据，使用最近一帧音视频的时间戳
+//
+// 注意，需要与 FeedRtmpMessage 在同一个协程中调用，或由调用方加锁保护
+//
+// @param description: ID3 TXXX帧的描述
+// @param value:       ID3 TXXX帧的内容，比如文本或json
+//
+// @return: 没有开启定时元数据，或者还没有开始输出mpegts流时，返回false
+//
+func (s *Rtmp2MpegtsRemuxer) FeedTimedMetadata(description string, value string) bool {
+	if !s.timedMetadata || !s.opened {
+		return false
+	}
+	s.feedId3(mpegts.PackId3Tag(mpegts.NewId3TxxxFrame(description, value)), s.lastDts, 0)
+	return true
+}
+
+// ScheduleSplice 预约输出SCTE-35信令
+//
+// 信令在 info.PtsTime 之后（info.Immediate 为true时，在最近）的第一个视频关键帧处输出，
+// 输出时 PtsTime 修改为该关键帧的pts，并且该关键帧作为边界，使得hls在此处开启新的分片。
+// splice_insert进入广告，并且设置了 AutoReturn 和 Duration 时，会再预约一个返回节目的信令
+//
+// 信令通过 IRtmp2MpegtsRemuxerObserver.OnTsPackets 回调，frame.Pid 为 mpegts.PidScte35 ，frame.Raw 为splice_info_section
+//
+// 注意，需要与 FeedRtmpMessage 在同一个协程中调用，或由调用方加锁保护
+//
+// @return: 没有开启SCTE-35，或者还没有开始输出mpegts流时，返回false
+//
+func (s *Rtmp2MpegtsRemuxer) ScheduleSplice(info mpegts.Scte35SpliceInfo) bool {
+	if !s.scte35 || !s.opened {
+		return false
+	}
+	s.pendingSplices = append(s.pendingSplices, info)
+	return true
+}
+
 func (s *Rtmp2MpegtsRemuxer) Dispose() {
 	s.FlushAudio()
 }
@@ -144,6 +211,7 @@ func (s *Rtmp2MpegtsRemuxer) FlushAudio() {
 	frame.Cc = s.audioCc
 	frame.Dts = s.audioCacheFirstFramePts
 	frame.Pts = s.audioCacheFirstFramePts
+	frame.CaptureTimeMs = s.audioCacheFirstFrameCaptureTimeMs
 	frame.Key = false
 	frame.Raw = s.audioCacheFrames
 	frame.Pid = mpegts.PidAudio
@@ -164,6 +232,22 @@ func (s *Rtmp2MpegtsRemuxer) FlushAudio() {
 // 实现 iRtmp2MpegtsFilterObserver
 //
 func (s *Rtmp2MpegtsRemuxer) onPatPmt(b []byte) {
+	if s.timedMetadata {
+		patpmt, err := mpegts.AppendMetadataStreamToPatPmt(b)
+		if err != nil {
+			Log.Errorf("[%s] append metadata stream to pmt failed. err=%+v", s.UniqueKey, err)
+		} else {
+			b = patpmt
+		}
+	}
+	if s.scte35 {
+		patpmt, err := mpegts.AppendScte35StreamToPatPmt(b)
+		if err != nil {
+			Log.Errorf("[%s] append scte35 stream to pmt failed. err=%+v", s.UniqueKey, err)
+		} else {
+			b = patpmt
+		}
+	}
 	s.observer.OnPatPmt(b)
 }
 
@@ -173,6 +257,10 @@ func (s *Rtmp2MpegtsRemuxer) onPop(msg base.RtmpMsg) {
 		s.feedAudio(msg)
 	case base.RtmpTypeIdVideo:
 		s.feedVideo(msg)
+	case base.RtmpTypeIdMetadata:
+		if s.timedMetadata {
+			s.feedDataMessage(msg)
+		}
 	}
 }
 
@@ -343,15 +431,22 @@ func (s *Rtmp2MpegtsRemuxer) feedVideo(msg base.RtmpMsg) {
 		s.FlushAudio()
 	}
 
+	s.lastDts = dts
+
 	var frame mpegts.Frame
 	frame.Cc = s.videoCc
 	frame.Dts = dts
 	frame.Pts = frame.Dts + uint64(cts)*90
 	frame.Key = msg.IsVideoKeyNalu()
 	frame.Raw = s.videoOut
+	frame.CaptureTimeMs = msg.CaptureTimeMs
 	frame.Pid = mpegts.PidVideo
 	frame.Sid = mpegts.StreamIdVideo
 
+	if frame.Key && len(s.pendingSplices) != 0 {
+		s.feedDueSplices(&frame)
+	}
+
 	s.onFrame(&frame)
 	s.videoCc = frame.Cc
 }
@@ -385,8 +480,11 @@ func (s *Rtmp2MpegtsRemuxer) feedAudio(msg base.RtmpMsg) {
 		s.FlushAudio()
 	}
 
+	s.lastDts = pts
+
 	if s.audioCacheEmpty() {
 		s.audioCacheFirstFramePts = pts
+		s.audioCacheFirstFrameCaptureTimeMs = msg.CaptureTimeMs
 	}
 
 	adtsHeader := s.ascCtx.PackAdtsHeader(int(msg.Header.MsgLen - 2))
@@ -443,7 +541,8 @@ func (s *Rtmp2MpegtsRemuxer) onFrame(frame *mpegts.Frame) {
 		//  (收到过音频seq header && fragment没有打开) || 说明 音视频都有，且都已ready
 		//  (收到过音频seq header && fragment已经打开 && 音频缓存数据不为空) 说明 为什么音频缓存需不为空？
 		// )
-		boundary = frame.Key && (!s.audioSeqHeaderCached() || !s.opened || !s.audioCacheEmpty())
+		boundary = frame.Key && (!s.audioSeqHeaderCached() || !s.opened || !s.audioCacheEmpty() || s.forceBoundary)
+		s.forceBoundary = false
 	}
 
 	if boundary {
@@ -0,0 +1,65 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"github.com/q191201771/lal/pkg/mpegts"
+)
+
+// feedDueSplices 在视频关键帧之前，输出所有已经到期的SCTE-35信令
+//
+// @param video: 视频关键帧，信令使用该帧的时间戳
+//
+func (s *Rtmp2MpegtsRemuxer) feedDueSplices(video *mpegts.Frame) {
+	var remain []mpegts.Scte35SpliceInfo
+	for _, info := range s.pendingSplices {
+		if !info.Immediate && info.PtsTime > video.Pts {
+			remain = append(remain, info)
+			continue
+		}
+
+		info.Immediate = false
+		info.PtsTime = video.Pts
+		s.feedScte35(info, video)
+
+		if info.CommandType == mpegts.Scte35CommandSpliceInsert && !info.Cancel &&
+			info.OutOfNetwork && info.AutoReturn && info.Duration > 0 {
+
+			remain = append(remain, mpegts.Scte35SpliceInfo{
+				CommandType:  mpegts.Scte35CommandSpliceInsert,
+				EventId:      info.EventId,
+				OutOfNetwork: false,
+				PtsTime:      video.Pts + info.Duration,
+			})
+		}
+		s.forceBoundary = true
+	}
+	s.pendingSplices = remain
+}
+
+func (s *Rtmp2MpegtsRemuxer) feedScte35(info mpegts.Scte35SpliceInfo, video *mpegts.Frame) {
+	Log.Infof("[%s] feed scte35. info=%+v", s.UniqueKey, info)
+
+	section := mpegts.PackScte35Section(info)
+
+	var frame mpegts.Frame
+	frame.Cc = s.scte35Cc
+	frame.Dts = video.Dts
+	frame.Pts = video.Pts
+	frame.CaptureTimeMs = video.CaptureTimeMs
+	frame.Key = false
+	frame.Raw = section
+	frame.Pid = mpegts.PidScte35
+
+	var packets []byte
+	packets, s.scte35Cc = mpegts.PackSection(mpegts.PidScte35, s.scte35Cc, section)
+	frame.Cc = s.scte35Cc
+
+	s.observer.OnTsPackets(packets, &frame, false)
+}
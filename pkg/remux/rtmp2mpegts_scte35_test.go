@@ -0,0 +1,107 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestRtmp2MpegtsRemuxerScte35(t *testing.T) {
+	sps, _ := hex.DecodeString("67640032ad84010c20086100430802184010c200843b5014005ad370101014000003000400000300ca100002")
+	pps, _ := hex.DecodeString("68ee3cb0")
+	vsh, err := avc.BuildSeqHeaderFromSpsPps(sps, pps)
+	assert.Equal(t, nil, err)
+
+	makeMsg := func(typeId uint8, ts uint32, payload []byte) base.RtmpMsg {
+		var msg base.RtmpMsg
+		msg.Header.MsgTypeId = typeId
+		msg.Header.TimestampAbs = ts
+		msg.Header.MsgLen = uint32(len(payload))
+		msg.Payload = payload
+		return msg
+	}
+	video := func(ts uint32, key bool) base.RtmpMsg {
+		if key {
+			return makeMsg(base.RtmpTypeIdVideo, ts, []byte{base.RtmpAvcKeyFrame, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88})
+		}
+		return makeMsg(base.RtmpTypeIdVideo, ts, []byte{base.RtmpAvcInterFrame, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x88})
+	}
+	audio := func(ts uint32) base.RtmpMsg {
+		return makeMsg(base.RtmpTypeIdAudio, ts, []byte{0xAF, base.RtmpAacPacketTypeRaw, 0x21, 0x10})
+	}
+	scte35Frames := func(o *mockMpegtsObserver) (frames []mpegts.Frame, next []bool) {
+		for i, f := range o.frames {
+			if f.Pid == mpegts.PidScte35 {
+				frames = append(frames, f)
+				// 信令后面紧跟的视频帧是否为边界
+				next = append(next, o.boundaries[i+1])
+			}
+		}
+		return
+	}
+
+	// 默认不开启
+	observer := &mockMpegtsObserver{}
+	remuxer := remux.NewRtmp2MpegtsRemuxer(observer)
+	remuxer.FeedRtmpMessage(makeMsg(base.RtmpTypeIdVideo, 0, vsh))
+	remuxer.FeedRtmpMessage(video(0, true))
+	assert.Equal(t, false, remuxer.ScheduleSplice(mpegts.Scte35SpliceInfo{CommandType: mpegts.Scte35CommandSpliceInsert, Immediate: true}))
+
+	observer = &mockMpegtsObserver{}
+	remuxer = remux.NewRtmp2MpegtsRemuxer(observer).WithScte35(true)
+	assert.Equal(t, false, remuxer.ScheduleSplice(mpegts.Scte35SpliceInfo{CommandType: mpegts.Scte35CommandSpliceInsert, Immediate: true}))
+	remuxer.FeedRtmpMessage(makeMsg(base.RtmpTypeIdVideo, 0, vsh))
+	remuxer.FeedRtmpMessage(makeMsg(base.RtmpTypeIdAudio, 0, []byte{0xAF, base.RtmpAacPacketTypeSeqHeader, 0x12, 0x10}))
+	remuxer.FeedRtmpMessage(video(0, true))
+	assert.Equal(t, true, mpegts.HasScte35Stream(observer.patpmt))
+
+	// 立即进入广告，2秒后自动返回
+	assert.Equal(t, true, remuxer.ScheduleSplice(mpegts.Scte35SpliceInfo{
+		CommandType:  mpegts.Scte35CommandSpliceInsert,
+		EventId:      1,
+		OutOfNetwork: true,
+		Immediate:    true,
+		Duration:     2000 * 90,
+		AutoReturn:   true,
+	}))
+	// 关键帧间隔1秒，音频缓存在关键帧之前已经输出，正常情况下关键帧不是边界
+	for ts := uint32(40); ts <= 4000; ts += 40 {
+		if ts%200 == 0 {
+			remuxer.FeedRtmpMessage(audio(ts - 10))
+			remuxer.FlushAudio()
+		}
+		remuxer.FeedRtmpMessage(video(ts, ts%1000 == 0))
+	}
+
+	frames, next := scte35Frames(observer)
+	assert.Equal(t, 2, len(frames))
+	assert.Equal(t, []bool{true, true}, next)
+
+	info, err := mpegts.ParseScte35Section(frames[0].Raw)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(1000*90), frames[0].Pts)
+	assert.Equal(t, uint64(1000*90), info.PtsTime)
+	assert.Equal(t, false, info.Immediate)
+	assert.Equal(t, true, info.OutOfNetwork)
+	assert.Equal(t, uint64(2000*90), info.Duration)
+
+	info, err = mpegts.ParseScte35Section(frames[1].Raw)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(3000*90), frames[1].Pts)
+	assert.Equal(t, uint32(1), info.EventId)
+	assert.Equal(t, false, info.OutOfNetwork)
+	assert.Equal(t, uint8(2), frames[1].Cc) // 下一个ts packet使用的continuity_counter
+}
@@ -0,0 +1,69 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux
+
+import (
+	"encoding/json"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/lal/pkg/rtmp"
+)
+
+// feedDataMessage 将rtmp数据消息转换为ID3定时元数据
+//
+// ID3中使用一个TXXX帧，描述为数据消息的名称，内容为名称后面的值转换成的json。
+// 只有一个值时（比如`onCuePoint`的object），直接使用该值，有多个值时使用json数组
+//
+func (s *Rtmp2MpegtsRemuxer) feedDataMessage(msg base.RtmpMsg) {
+	// metadata不是定时元数据，由其他逻辑处理
+	if rtmp.IsStreamMetadata(msg.Payload) {
+		return
+	}
+
+	name, values, err := rtmp.ParseDataMessage(msg.Payload)
+	if err != nil {
+		Log.Warnf("[%s] parse data message failed. name=%s, err=%+v", s.UniqueKey, name, err)
+		return
+	}
+	if name == "|RtmpSampleAccess" {
+		return
+	}
+
+	var v interface{} = values
+	if len(values) == 1 {
+		v = values[0]
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		Log.Warnf("[%s] marshal data message failed. name=%s, err=%+v", s.UniqueKey, name, err)
+		return
+	}
+
+	if !s.opened {
+		Log.Debugf("[%s] drop data message before mpegts stream opened. name=%s", s.UniqueKey, name)
+		return
+	}
+	s.feedId3(mpegts.PackId3Tag(mpegts.NewId3TxxxFrame(name, string(value))), uint64(msg.Header.TimestampAbs)*90, msg.CaptureTimeMs)
+}
+
+func (s *Rtmp2MpegtsRemuxer) feedId3(id3 []byte, pts uint64, captureTimeMs int64) {
+	var frame mpegts.Frame
+	frame.Cc = s.metadataCc
+	frame.Dts = pts
+	frame.Pts = pts
+	frame.CaptureTimeMs = captureTimeMs
+	frame.Key = false
+	frame.Raw = id3
+	frame.Pid = mpegts.PidMetadata
+	frame.Sid = mpegts.StreamIdMetadata
+
+	s.onFrame(&frame)
+	s.metadataCc = frame.Cc
+}
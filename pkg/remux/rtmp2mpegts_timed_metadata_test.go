@@ -0,0 +1,112 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package remux_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/mpegts"
+	"github.com/q191201771/lal/pkg/remux"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type mockMpegtsObserver struct {
+	patpmt     []byte
+	frames     []mpegts.Frame
+	boundaries []bool
+}
+
+func (o *mockMpegtsObserver) OnPatPmt(b []byte) {
+	o.patpmt = b
+}
+
+func (o *mockMpegtsObserver) OnTsPackets(tsPackets []byte, frame *mpegts.Frame, boundary bool) {
+	f := *frame
+	f.Raw = append([]byte(nil), frame.Raw...)
+	o.frames = append(o.frames, f)
+	o.boundaries = append(o.boundaries, boundary)
+}
+
+func (o *mockMpegtsObserver) metadataFrames() (frames []mpegts.Frame) {
+	for _, f := range o.frames {
+		if f.Pid == mpegts.PidMetadata {
+			frames = append(frames, f)
+		}
+	}
+	return
+}
+
+func TestRtmp2MpegtsRemuxerTimedMetadata(t *testing.T) {
+	sps, _ := hex.DecodeString("67640032ad84010c20086100430802184010c200843b5014005ad370101014000003000400000300ca100002")
+	pps, _ := hex.DecodeString("68ee3cb0")
+	vsh, err := avc.BuildSeqHeaderFromSpsPps(sps, pps)
+	assert.Equal(t, nil, err)
+
+	makeMsg := func(typeId uint8, ts uint32, payload []byte) base.RtmpMsg {
+		var msg base.RtmpMsg
+		msg.Header.MsgTypeId = typeId
+		msg.Header.TimestampAbs = ts
+		msg.Header.MsgLen = uint32(len(payload))
+		msg.Payload = payload
+		return msg
+	}
+	keyFrame := func(ts uint32) base.RtmpMsg {
+		return makeMsg(base.RtmpTypeIdVideo, ts, []byte{base.RtmpAvcKeyFrame, base.RtmpAvcPacketTypeNalu, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88})
+	}
+	dataMsg := func(ts uint32, name string, opa rtmp.ObjectPairArray) base.RtmpMsg {
+		buf := &bytes.Buffer{}
+		_ = rtmp.Amf0.WriteString(buf, name)
+		_ = rtmp.Amf0.WriteObject(buf, opa)
+		return makeMsg(base.RtmpTypeIdMetadata, ts, buf.Bytes())
+	}
+	metadata, err := rtmp.BuildMetadata(1280, 720, 10, 7)
+	assert.Equal(t, nil, err)
+
+	feed := func(remuxer *remux.Rtmp2MpegtsRemuxer) {
+		remuxer.FeedRtmpMessage(makeMsg(base.RtmpTypeIdMetadata, 0, metadata))
+		remuxer.FeedRtmpMessage(makeMsg(base.RtmpTypeIdVideo, 0, vsh))
+		remuxer.FeedRtmpMessage(makeMsg(base.RtmpTypeIdAudio, 0, []byte{0xAF, base.RtmpAacPacketTypeSeqHeader, 0x12, 0x10}))
+		remuxer.FeedRtmpMessage(keyFrame(0))
+		remuxer.FeedRtmpMessage(dataMsg(40, "onCuePoint", rtmp.ObjectPairArray{
+			{Key: "name", Value: "cue1"},
+			{Key: "time", Value: 0.04},
+		}))
+		remuxer.FeedRtmpMessage(keyFrame(80))
+	}
+
+	// 默认不开启
+	observer := &mockMpegtsObserver{}
+	remuxer := remux.NewRtmp2MpegtsRemuxer(observer)
+	feed(remuxer)
+	assert.Equal(t, mpegts.FixedFragmentHeader, observer.patpmt)
+	assert.Equal(t, 0, len(observer.metadataFrames()))
+	assert.Equal(t, false, remuxer.FeedTimedMetadata("", "hello"))
+
+	observer = &mockMpegtsObserver{}
+	remuxer = remux.NewRtmp2MpegtsRemuxer(observer).WithTimedMetadata(true)
+	feed(remuxer)
+	assert.Equal(t, true, mpegts.HasMetadataStream(observer.patpmt))
+	assert.Equal(t, true, remuxer.FeedTimedMetadata("ad", `{"id":1}`))
+
+	// onMetaData不转换，onCuePoint和注入的各一个
+	frames := observer.metadataFrames()
+	assert.Equal(t, 2, len(frames))
+	assert.Equal(t, uint64(40*90), frames[0].Pts)
+	assert.Equal(t, mpegts.StreamIdMetadata, frames[0].Sid)
+	assert.Equal(t, []byte("ID3"), frames[0].Raw[:3])
+	assert.Equal(t, true, strings.HasSuffix(string(frames[0].Raw), "onCuePoint\x00"+`{"name":"cue1","time":0.04}`))
+	assert.Equal(t, uint64(80*90), frames[1].Pts)
+	assert.Equal(t, true, strings.HasSuffix(string(frames[1].Raw), "TXXX\x00\x00\x00\x0c\x00\x00\x03ad\x00"+`{"id":1}`))
+}
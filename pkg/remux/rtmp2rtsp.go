@@ -176,9 +176,10 @@ func (r *Rtmp2RtspRemuxer) remux(msg base.RtmpMsg) {
 		packer = r.getAudioPacker()
 		if packer != nil {
 			rtppkts = packer.Pack(base.AvPacket{
-				Timestamp:   int64(msg.Header.TimestampAbs),
-				PayloadType: r.audioPt,
-				Payload:     msg.Payload[2:],
+				Timestamp:     int64(msg.Header.TimestampAbs),
+				PayloadType:   r.audioPt,
+				CaptureTimeMs: msg.CaptureTimeMs,
+				Payload:       msg.Payload[2:],
 			})
 		}
 	case base.RtmpTypeIdVideo:
@@ -186,18 +187,20 @@ func (r *Rtmp2RtspRemuxer) remux(msg base.RtmpMsg) {
 		if packer != nil {
 			payload := msg.Payload[5:]
 			if RtspRemuxerAddSpsPps2KeyFrameFlag {
+				// 注意，关键帧中可能包含多个nalu（比如字幕SEI + IDR），所以是在整帧前面追加参数集，而不是把整帧当作一个nalu
 				if msg.IsAvcKeyNalu() && r.sps != nil && r.pps != nil {
-					payload = h2645.JoinNaluAvcc(r.sps, r.pps, msg.Payload[9:])
+					payload = append(h2645.JoinNaluAvcc(r.sps, r.pps), msg.Payload[5:]...)
 				}
 				if msg.IsHevcKeyNalu() && r.vps != nil && r.sps != nil && r.pps != nil {
-					payload = h2645.JoinNaluAvcc(r.vps, r.sps, r.pps, msg.Payload[9:])
+					payload = append(h2645.JoinNaluAvcc(r.vps, r.sps, r.pps), msg.Payload[5:]...)
 				}
 			}
 
 			rtppkts = r.getVideoPacker().Pack(base.AvPacket{
-				Timestamp:   int64(msg.Header.TimestampAbs),
-				PayloadType: r.videoPt,
-				Payload:     payload,
+				Timestamp:     int64(msg.Header.TimestampAbs),
+				PayloadType:   r.videoPt,
+				CaptureTimeMs: msg.CaptureTimeMs,
+				Payload:       payload,
 			})
 		}
 	}
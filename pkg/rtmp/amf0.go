@@ -36,12 +36,14 @@ const (
 	Amf0TypeMarkerObjectEnd  = uint8(0x09)
 	Amf0TypeMarkerLongString = uint8(0x0c)
 
+	// 只在 ParseDataMessage 中使用的类型
+	amf0TypeMarkerUndefined   = uint8(0x06)
+	amf0TypeMarkerStrictArray = uint8(0x0a)
+	amf0TypeMarkerDate        = uint8(0x0b)
+
 	// 还没用到的类型
 	//Amf0TypeMarkerMovieclip   = uint8(0x04)
-	//Amf0TypeMarkerUndefined   = uint8(0x06)
 	//Amf0TypeMarkerReference   = uint8(0x07)
-	//Amf0TypeMarkerStrictArray = uint8(0x0a)
-	//Amf0TypeMarkerData        = uint8(0x0b)
 	//Amf0TypeMarkerUnsupported = uint8(0x0d)
 	//Amf0TypeMarkerRecordset   = uint8(0x0e)
 	//Amf0TypeMarkerXmlDocument = uint8(0x0f)
@@ -10,7 +10,11 @@ package rtmp
 
 import (
 	"bytes"
+	"time"
+
+	"github.com/q191201771/naza/pkg/bele"
 	"github.com/q191201771/naza/pkg/nazabytes"
+	"github.com/q191201771/naza/pkg/nazaerrors"
 	"github.com/q191201771/naza/pkg/nazalog"
 
 	"github.com/q191201771/lal/pkg/base"
@@ -157,3 +161,155 @@ func BuildMetadata(width int, height int, audiocodecid int, videocodecid int) ([
 
 	return buf.Bytes(), nil
 }
+
+// BuildCuePoint 构造`onCuePoint`数据消息
+//
+// @param opa: cue point的属性，比如`name`、`time`、`type`，注意，Amf0.WriteObject 不支持嵌套的object
+//
+func BuildCuePoint(opa ObjectPairArray) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := Amf0.WriteString(buf, "onCuePoint"); err != nil {
+		return nil, err
+	}
+	if err := Amf0.WriteObject(buf, opa); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// IsStreamMetadata 数据消息是否为流的metadata，也即`@setDataFrame`或`onMetaData`
+//
+// 推流端发送的其他数据消息，比如`onTextData`、`onCuePoint`，返回false
+//
+func IsStreamMetadata(b []byte) bool {
+	v, _, err := Amf0.ReadString(b)
+	if err != nil {
+		return false
+	}
+	return v == "@setDataFrame" || v == "onMetaData"
+}
+
+// ParseDataMessage 解析amf0数据消息
+//
+// 与 Amf0.ReadObject 不同，遇到不支持的类型时返回错误，而不是panic，用于解析推流端发送的任意数据消息
+//
+// @return name:   数据消息的名称，比如`onCuePoint`
+// @return values: 名称后面的各个值，object和ecma array转换为map[string]interface{}，strict array转换为[]interface{}
+//
+func ParseDataMessage(b []byte) (name string, values []interface{}, err error) {
+	name, pos, err := Amf0.ReadString(b)
+	if err != nil {
+		return "", nil, err
+	}
+	for pos < len(b) {
+		v, l, err := readAmf0Value(b[pos:])
+		if err != nil {
+			return name, values, err
+		}
+		values = append(values, v)
+		pos += l
+	}
+	return name, values, nil
+}
+
+// ParseOnFiWallTime 从推流端发送的`onFI`数据消息中解析推流端的系统时间
+//
+// `onFI`由FMLE、Wirecast等推流端周期性发送，`sd`为系统日期（`dd-mm-yyyy`，兼容`yyyy-mm-dd`），`st`为系统时间（`hh:mm:ss.SSS`，毫秒部分可省略）。
+// 消息中不携带时区，按本地时区解析。
+//
+// @return unixMs: 推流端的系统时间，unix时间戳，单位毫秒
+// @return ok:     不是`onFI`消息或者格式不正确时为false
+//
+func ParseOnFiWallTime(b []byte) (unixMs int64, ok bool) {
+	name, values, err := ParseDataMessage(b)
+	if err != nil || name != "onFI" || len(values) == 0 {
+		return 0, false
+	}
+	m, isMap := values[0].(map[string]interface{})
+	if !isMap {
+		return 0, false
+	}
+	sd, _ := m["sd"].(string)
+	st, _ := m["st"].(string)
+	if sd == "" || st == "" {
+		return 0, false
+	}
+	for _, layout := range []string{"02-01-2006 15:04:05", "2006-01-02 15:04:05"} {
+		t, err := time.ParseInLocation(layout, sd+" "+st, time.Local)
+		if err == nil {
+			return t.UnixNano() / 1e6, true
+		}
+	}
+	return 0, false
+}
+
+func readAmf0Value(b []byte) (interface{}, int, error) {
+	if len(b) < 1 {
+		return nil, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+	}
+	switch b[0] {
+	case Amf0TypeMarkerNumber:
+		return Amf0.ReadNumber(b)
+	case Amf0TypeMarkerBoolean:
+		return Amf0.ReadBoolean(b)
+	case Amf0TypeMarkerString, Amf0TypeMarkerLongString:
+		return Amf0.ReadString(b)
+	case Amf0TypeMarkerNull, amf0TypeMarkerUndefined:
+		return nil, 1, nil
+	case Amf0TypeMarkerObject:
+		return readAmf0Properties(b, 1)
+	case Amf0TypeMarkerEcmaArray:
+		// ecma array的数量字段不一定准确，统一以结束标记为准
+		if len(b) < 5 {
+			return nil, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+		}
+		return readAmf0Properties(b, 5)
+	case amf0TypeMarkerStrictArray:
+		if len(b) < 5 {
+			return nil, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+		}
+		count := int(bele.BeUint32(b[1:]))
+		index := 5
+		var arr []interface{}
+		for i := 0; i < count; i++ {
+			v, l, err := readAmf0Value(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, v)
+			index += l
+		}
+		return arr, index, nil
+	case amf0TypeMarkerDate:
+		// 8字节的毫秒时间戳，2字节的时区
+		if len(b) < 11 {
+			return nil, 0, nazaerrors.Wrap(base.ErrAmfTooShort)
+		}
+		return bele.BeFloat64(b[1:]), 11, nil
+	}
+	return nil, 0, base.NewErrAmfInvalidType(b[0])
+}
+
+func readAmf0Properties(b []byte, index int) (map[string]interface{}, int, error) {
+	m := make(map[string]interface{})
+	for {
+		if len(b)-index >= 3 && bytes.Equal(b[index:index+3], Amf0TypeMarkerObjectEndBytes) {
+			return m, index + 3, nil
+		}
+		// 部分ecma array没有结束标记
+		if index == len(b) {
+			return m, index, nil
+		}
+		k, l, err := Amf0.ReadStringWithoutType(b[index:])
+		if err != nil {
+			return nil, 0, err
+		}
+		index += l
+		v, l, err := readAmf0Value(b[index:])
+		if err != nil {
+			return nil, 0, err
+		}
+		m[k] = v
+		index += l
+	}
+}
@@ -9,8 +9,10 @@
 package rtmp_test
 
 import (
+	"bytes"
 	"encoding/hex"
 	"testing"
+	"time"
 
 	"github.com/q191201771/lal/pkg/base"
 
@@ -56,3 +58,38 @@ func TestMetadata(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, b, wo)
 }
+
+func TestParseOnFiWallTime(t *testing.T) {
+	build := func(name string, opa rtmp.ObjectPairArray) []byte {
+		var buf bytes.Buffer
+		_ = rtmp.Amf0.WriteString(&buf, name)
+		_ = rtmp.Amf0.WriteObject(&buf, opa)
+		return buf.Bytes()
+	}
+	expected := time.Date(2022, 7, 15, 10, 20, 30, 123*1e6, time.Local).UnixNano() / 1e6
+
+	unixMs, ok := rtmp.ParseOnFiWallTime(build("onFI", rtmp.ObjectPairArray{
+		{Key: "sd", Value: "15-07-2022"},
+		{Key: "st", Value: "10:20:30.123"},
+	}))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, expected, unixMs)
+
+	unixMs, ok = rtmp.ParseOnFiWallTime(build("onFI", rtmp.ObjectPairArray{
+		{Key: "sd", Value: "2022-07-15"},
+		{Key: "st", Value: "10:20:30.123"},
+	}))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, expected, unixMs)
+
+	_, ok = rtmp.ParseOnFiWallTime(build("onFI", rtmp.ObjectPairArray{
+		{Key: "st", Value: "10:20:30.123"},
+	}))
+	assert.Equal(t, false, ok)
+
+	_, ok = rtmp.ParseOnFiWallTime(build("onTextData", rtmp.ObjectPairArray{
+		{Key: "sd", Value: "15-07-2022"},
+		{Key: "st", Value: "10:20:30.123"},
+	}))
+	assert.Equal(t, false, ok)
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/q191201771/lal/pkg/rtprtcp"
+	"github.com/q191201771/naza/pkg/assert"
 )
 
 func TestMswLsw2UnixNano(t *testing.T) {
@@ -21,3 +22,32 @@ func TestMswLsw2UnixNano(t *testing.T) {
 	tt := time.Unix(int64(u/1e9), int64(u%1e9))
 	rtprtcp.Log.Debug(tt.String())
 }
+
+func TestSrClock(t *testing.T) {
+	unixNano := uint64(1660000000123) * 1e6
+	ntp := rtprtcp.UnixNano2Ntp(unixNano)
+	assert.Equal(t, uint64(1660000000123), rtprtcp.Ntp2UnixNano(ntp)/1e6)
+
+	c := rtprtcp.NewSrClock(90000)
+	assert.Equal(t, int64(0), c.CalcUnixMs(1000))
+
+	c.FeedSr(rtprtcp.Sr{
+		Msw:       uint32(ntp >> 32),
+		Lsw:       uint32(ntp),
+		Timestamp: 90000,
+	})
+	assert.Equal(t, int64(1660000000123), c.CalcUnixMs(1000))
+	assert.Equal(t, int64(1660000000163), c.CalcUnixMs(1040))
+
+	// sr的rtp时间戳即将回绕，帧的时间戳已经回绕
+	c.FeedSr(rtprtcp.Sr{
+		Msw:       uint32(ntp >> 32),
+		Lsw:       uint32(ntp),
+		Timestamp: 0xFFFFFFFF - 90*10,
+	})
+	assert.Equal(t, int64(1660000000123+20), c.CalcUnixMs(10))
+
+	// sr包打包后再解析，结果一致
+	sr := rtprtcp.Sr{SenderSsrc: 1, Msw: 2, Lsw: 3, Timestamp: 4, PktCnt: 5, OctetCnt: 6}
+	assert.Equal(t, sr, rtprtcp.ParseSr(sr.Pack()))
+}
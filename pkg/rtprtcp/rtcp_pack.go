@@ -22,6 +22,30 @@ type Rr struct {
 	dlsr        uint32 // default 0
 }
 
+// Pack 打包SR，注意，不包含report block
+func (s *Sr) Pack() []byte {
+	const lenInWords = 7
+
+	b := make([]byte, lenInWords*4)
+
+	var h RtcpHeader
+	h.Version = RtcpVersion
+	h.Padding = 0
+	h.CountOrFormat = 0
+	h.PacketType = RtcpPacketTypeSr
+	h.Length = lenInWords - 1
+	h.PackTo(b)
+
+	bele.BePutUint32(b[4:], s.SenderSsrc)
+	bele.BePutUint32(b[8:], s.Msw)
+	bele.BePutUint32(b[12:], s.Lsw)
+	bele.BePutUint32(b[16:], s.Timestamp)
+	bele.BePutUint32(b[20:], s.PktCnt)
+	bele.BePutUint32(b[24:], s.OctetCnt)
+
+	return b
+}
+
 func (r *Rr) Pack() []byte {
 	const lenInWords = 8
 
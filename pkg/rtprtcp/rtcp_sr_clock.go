@@ -0,0 +1,60 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp
+
+// SrClock 根据RTCP SR中NTP时间和RTP时间戳的对应关系，计算帧的绝对采集时间
+//
+// 一路音频或一路视频各对应一个对象
+//
+type SrClock struct {
+	clockRate int
+
+	fed      bool
+	srTsMs   int64 // SR中的RTP时间戳换算成毫秒，换算方式和unpacker保持一致
+	srUnixMs int64 // SR中的NTP时间换算成unix时间戳，单位毫秒
+}
+
+func NewSrClock(clockRate int) *SrClock {
+	return &SrClock{
+		clockRate: clockRate,
+	}
+}
+
+// FeedSr 每收到一个SR都可以喂入，使用最新的对应关系
+//
+func (c *SrClock) FeedSr(sr Sr) {
+	if c.clockRate < 1000 {
+		return
+	}
+	c.fed = true
+	c.srTsMs = int64(sr.Timestamp / uint32(c.clockRate/1000))
+	c.srUnixMs = int64(MswLsw2UnixNano(uint64(sr.Msw), uint64(sr.Lsw)) / 1e6)
+}
+
+// CalcUnixMs
+//
+// @param timestamp: 同 OnAvPacket 中的 pkt.Timestamp ，由RTP时间戳换算得到，单位毫秒
+//
+// @return 绝对时间，unix时间戳，单位毫秒。如果还没有收到过SR，则返回0
+//
+func (c *SrClock) CalcUnixMs(timestamp int64) int64 {
+	if !c.fed {
+		return 0
+	}
+
+	// RTP时间戳是32位的，需要处理回绕
+	period := int64(0x100000000) / int64(c.clockRate/1000)
+	diff := (timestamp - c.srTsMs) % period
+	if diff >= period/2 {
+		diff -= period
+	} else if diff < -period/2 {
+		diff += period
+	}
+	return c.srUnixMs + diff
+}
@@ -56,6 +56,14 @@ func NewRtpPacker(payloadPacker IRtpPackerPayload, clockRate int, ssrc uint32, m
 //             pkt.PayloadType rtp包头中的packet type
 //
 func (r *RtpPacker) Pack(pkt base.AvPacket) (out []RtpPacket) {
+	return r.PackWithRtpTimestamp(pkt, uint32(float64(pkt.Timestamp)*float64(r.clockRate)/1000))
+}
+
+// PackWithRtpTimestamp 与 Pack 相同，但是rtp包头中的时间戳直接使用`rtpTimestamp`，而不是由pkt.Timestamp换算得到
+//
+// 用于重新打包时沿用输入rtp的原始时间戳，保持与其他透传轨道的时间戳对应关系
+//
+func (r *RtpPacker) PackWithRtpTimestamp(pkt base.AvPacket, rtpTimestamp uint32) (out []RtpPacket) {
 	payloads := r.payloadPacker.Pack(pkt.Payload, r.option.MaxPayloadSize)
 	for i, payload := range payloads {
 		h := MakeDefaultRtpHeader()
@@ -64,10 +72,11 @@ func (r *RtpPacker) Pack(pkt base.AvPacket) (out []RtpPacket) {
 		}
 		h.PacketType = uint8(pkt.PayloadType)
 		h.Seq = r.genSeq()
-		h.Timestamp = uint32(float64(pkt.Timestamp) * float64(r.clockRate) / 1000)
+		h.Timestamp = rtpTimestamp
 		h.Ssrc = r.ssrc
-		pkt := MakeRtpPacket(h, payload)
-		out = append(out, pkt)
+		rtpPkt := MakeRtpPacket(h, payload)
+		rtpPkt.CaptureTimeMs = pkt.CaptureTimeMs
+		out = append(out, rtpPkt)
 	}
 	return
 }
@@ -65,6 +65,10 @@ type RtpPacket struct {
 	Header RtpHeader
 	Raw    []byte // 包含header内存
 
+	// CaptureTimeMs 可选字段，所属帧的绝对采集时间，unix时间戳，单位毫秒，为0时表示未知。
+	// 只在lal内部流转，不会序列化到rtp包中。目前由 RtpPacker 根据 base.AvPacket 的同名字段填写
+	CaptureTimeMs int64
+
 	positionType uint8
 }
 
@@ -20,6 +20,7 @@ var (
 	_ IRtpUnpackContainer  = &RtpUnpackContainer{}
 	_ IRtpUnpackerProtocol = &RtpUnpackerAac{}
 	_ IRtpUnpackerProtocol = &RtpUnpackerAvcHevc{}
+	_ IRtpUnpackerProtocol = &RtpUnpackerOnvifMetadata{}
 )
 
 type IRtpUnpacker interface {
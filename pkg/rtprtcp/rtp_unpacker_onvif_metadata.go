@@ -0,0 +1,91 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtprtcp
+
+// OnOnvifMetadata
+//
+// @param timestamp: RTP包头中的时间戳经过clockrate换算后的时间戳，单位毫秒
+// @param b:         一个完整的xml文档（tt:MetadataStream），新申请的内存块，回调结束后，内部不再使用该内存块
+//
+type OnOnvifMetadata func(timestamp int64, b []byte)
+
+// RtpUnpackerOnvifMetadata onvif metadata数据轨道的合包
+//
+// ONVIF Streaming Spec 5.2.1.1 RTP for Metadata stream
+//
+// 一个xml文档可能被拆分到多个RTP包中，这些RTP包的时间戳相同，最后一个包的marker位为1。
+// RTP负载就是xml文本本身，没有额外的负载头。
+//
+type RtpUnpackerOnvifMetadata struct {
+	clockRate  int
+	onMetadata OnOnvifMetadata
+}
+
+func NewRtpUnpackerOnvifMetadata(clockRate int, onMetadata OnOnvifMetadata) *RtpUnpackerOnvifMetadata {
+	if clockRate <= 0 {
+		clockRate = 90000
+	}
+	return &RtpUnpackerOnvifMetadata{
+		clockRate:  clockRate,
+		onMetadata: onMetadata,
+	}
+}
+
+// DefaultOnvifMetadataUnpacker 和 DefaultRtpUnpackerFactory 类似，返回一个带乱序重排功能的合包对象
+//
+func DefaultOnvifMetadataUnpacker(clockRate int, maxSize int, onMetadata OnOnvifMetadata) IRtpUnpacker {
+	return NewRtpUnpackContainer(maxSize, NewRtpUnpackerOnvifMetadata(clockRate, onMetadata))
+}
+
+func (unpacker *RtpUnpackerOnvifMetadata) CalcPositionIfNeeded(pkt *RtpPacket) {
+	// noop
+}
+
+func (unpacker *RtpUnpackerOnvifMetadata) TryUnpackOne(list *RtpPacketList) (unpackedFlag bool, unpackedSeq uint16) {
+	first := list.Head.Next
+	if first == nil {
+		return false, 0
+	}
+
+	// 从第一个包开始，找到连续的、时间戳相同的、以marker位为1结尾的一组包
+	totalSize := 0
+	var prev *RtpPacketListItem
+	p := first
+	for ; p != nil; p = p.Next {
+		if prev != nil {
+			if SubSeq(p.Packet.Header.Seq, prev.Packet.Header.Seq) != 1 ||
+				p.Packet.Header.Timestamp != first.Packet.Header.Timestamp {
+				return false, 0
+			}
+		}
+		totalSize += len(p.Packet.Body())
+		if p.Packet.Header.Mark == 1 {
+			break
+		}
+		prev = p
+	}
+	if p == nil {
+		return false, 0
+	}
+	last := p
+
+	b := make([]byte, 0, totalSize)
+	for p = first; ; p = p.Next {
+		b = append(b, p.Packet.Body()...)
+		list.Size--
+		if p == last {
+			break
+		}
+	}
+	list.Head.Next = last.Next
+
+	timestamp := int64(float64(first.Packet.Header.Timestamp) * 1000 / float64(unpacker.clockRate))
+	unpacker.onMetadata(timestamp, b)
+	return true, last.Packet.Header.Seq
+}
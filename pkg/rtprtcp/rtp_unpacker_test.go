@@ -232,3 +232,31 @@ func testHelperHexstream2rtppacket(hexPacket string) (pkt RtpPacket, err error)
 	pkt, err = ParseRtpPacket(raw)
 	return
 }
+
+func TestOnvifMetadata(t *testing.T) {
+	var out []string
+	var outTs []int64
+	unpacker := DefaultOnvifMetadataUnpacker(90000, 128, func(timestamp int64, b []byte) {
+		outTs = append(outTs, timestamp)
+		out = append(out, string(b))
+	})
+
+	mk := func(seq uint16, timestamp uint32, mark uint8, payload string) RtpPacket {
+		h := MakeDefaultRtpHeader()
+		h.PacketType = 107
+		h.Seq = seq
+		h.Timestamp = timestamp
+		h.Mark = mark
+		return MakeRtpPacket(h, []byte(payload))
+	}
+
+	// 乱序到达的一个跨3个包的文档，以及一个单包文档
+	unpacker.Feed(mk(1, 90000, 0, "<tt:MetadataStream>"))
+	unpacker.Feed(mk(3, 90000, 1, "</tt:MetadataStream>"))
+	assert.Equal(t, 0, len(out))
+	unpacker.Feed(mk(2, 90000, 0, "<tt:Event/>"))
+	unpacker.Feed(mk(4, 180000, 1, "<tt:MetadataStream/>"))
+
+	assert.Equal(t, []string{"<tt:MetadataStream><tt:Event/></tt:MetadataStream>", "<tt:MetadataStream/>"}, out)
+	assert.Equal(t, []int64{1000, 2000}, outTs)
+}
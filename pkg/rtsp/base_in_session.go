@@ -46,6 +46,21 @@ type IBaseInSessionObserver interface {
 	OnAvPacket(pkt base.AvPacket)
 }
 
+// IOnvifMetadataObserver
+//
+// 可选接口。
+// IBaseInSessionObserver 的实现方如果同时实现了该接口，则可以收到onvif metadata数据轨道合包后的完整xml文档。
+// 注意，metadata的原始rtp包依然会通过 IBaseInSessionObserver.OnRtpPacket 回调，方便透传给rtsp sub session。
+//
+type IOnvifMetadataObserver interface {
+	// OnOnvifMetadata
+	//
+	// @param timestamp: 单位毫秒，由rtp时间戳换算而来
+	// @param b:         完整的xml文档，回调结束后内部不再使用该内存块
+	//
+	OnOnvifMetadata(timestamp int64, b []byte)
+}
+
 type BaseInSession struct {
 	cmdSession IInterleavedPacketWriter
 
@@ -60,6 +75,11 @@ type BaseInSession struct {
 	videoRtpChannel  int
 	videoRtcpChannel int
 
+	metadataRtpConn     *nazanet.UdpConnection
+	metadataRtcpConn    *nazanet.UdpConnection
+	metadataRtpChannel  int
+	metadataRtcpChannel int
+
 	sessionStat base.BasicSessionStat
 
 	mu              sync.Mutex
@@ -67,9 +87,12 @@ type BaseInSession struct {
 	avPacketQueue   *AvPacketQueue
 	audioRrProducer *rtprtcp.RrProducer
 	videoRrProducer *rtprtcp.RrProducer
+	audioSrClock    *rtprtcp.SrClock
+	videoSrClock    *rtprtcp.SrClock
 
-	audioUnpacker rtprtcp.IRtpUnpacker
-	videoUnpacker rtprtcp.IRtpUnpacker
+	audioUnpacker    rtprtcp.IRtpUnpacker
+	videoUnpacker    rtprtcp.IRtpUnpacker
+	metadataUnpacker rtprtcp.IRtpUnpacker
 
 	audioSsrc nazaatomic.Uint32
 	videoSsrc nazaatomic.Uint32
@@ -85,12 +108,14 @@ type BaseInSession struct {
 
 func NewBaseInSession(sessionType base.SessionType, cmdSession IInterleavedPacketWriter) *BaseInSession {
 	s := &BaseInSession{
-		sessionStat:      base.NewBasicSessionStat(sessionType, ""),
-		cmdSession:       cmdSession,
-		waitChan:         make(chan error, 1),
-		dumpReadAudioRtp: base.NewLogDump(Log, 1),
-		dumpReadVideoRtp: base.NewLogDump(Log, 1),
-		dumpReadSr:       base.NewLogDump(Log, 2),
+		sessionStat:         base.NewBasicSessionStat(sessionType, ""),
+		cmdSession:          cmdSession,
+		metadataRtpChannel:  -1,
+		metadataRtcpChannel: -1,
+		waitChan:            make(chan error, 1),
+		dumpReadAudioRtp:    base.NewLogDump(Log, 1),
+		dumpReadVideoRtp:    base.NewLogDump(Log, 1),
+		dumpReadSr:          base.NewLogDump(Log, 2),
 	}
 	Log.Infof("[%s] lifecycle new rtsp BaseInSession. session=%p", s.UniqueKey(), s)
 	return s
@@ -118,8 +143,14 @@ func (session *BaseInSession) InitWithSdp(sdpCtx sdp.LogicContext) {
 		Log.Warnf("[%s] video unpacker not support this type yet. logicCtx=%+v", session.UniqueKey(), session.sdpCtx)
 	}
 
+	if session.sdpCtx.HasMetadata() {
+		session.metadataUnpacker = rtprtcp.DefaultOnvifMetadataUnpacker(session.sdpCtx.MetadataClockRate, unpackerItemMaxSize, session.onOnvifMetadata)
+	}
+
 	session.audioRrProducer = rtprtcp.NewRrProducer(session.sdpCtx.AudioClockRate)
 	session.videoRrProducer = rtprtcp.NewRrProducer(session.sdpCtx.VideoClockRate)
+	session.audioSrClock = rtprtcp.NewSrClock(session.sdpCtx.AudioClockRate)
+	session.videoSrClock = rtprtcp.NewSrClock(session.sdpCtx.VideoClockRate)
 
 	if session.sdpCtx.IsAudioUnpackable() && session.sdpCtx.IsVideoUnpackable() {
 		session.mu.Lock()
@@ -150,6 +181,9 @@ func (session *BaseInSession) SetupWithConn(uri string, rtpConn, rtcpConn *nazan
 	} else if session.sdpCtx.IsVideoUri(uri) {
 		session.videoRtpConn = rtpConn
 		session.videoRtcpConn = rtcpConn
+	} else if session.sdpCtx.IsMetadataUri(uri) {
+		session.metadataRtpConn = rtpConn
+		session.metadataRtcpConn = rtcpConn
 	} else {
 		return nazaerrors.Wrap(base.ErrRtsp)
 	}
@@ -169,6 +203,10 @@ func (session *BaseInSession) SetupWithChannel(uri string, rtpChannel, rtcpChann
 		session.videoRtpChannel = rtpChannel
 		session.videoRtcpChannel = rtcpChannel
 		return nil
+	} else if session.sdpCtx.IsMetadataUri(uri) {
+		session.metadataRtpChannel = rtpChannel
+		session.metadataRtcpChannel = rtcpChannel
+		return nil
 	}
 	return nazaerrors.Wrap(base.ErrRtsp)
 }
@@ -204,10 +242,14 @@ func (session *BaseInSession) HandleInterleavedPacket(b []byte, channel int) {
 	case session.audioRtpChannel:
 		fallthrough
 	case session.videoRtpChannel:
+		fallthrough
+	case session.metadataRtpChannel:
 		_ = session.handleRtpPacket(b)
 	case session.audioRtcpChannel:
 		fallthrough
 	case session.videoRtcpChannel:
+		fallthrough
+	case session.metadataRtcpChannel:
 		_ = session.handleRtcpPacket(b, nil)
 	default:
 		Log.Errorf("[%s] read interleaved packet but channel invalid. channel=%d", session.UniqueKey(), channel)
@@ -228,6 +270,12 @@ func (session *BaseInSession) WriteRtpRtcpDummy() {
 	if session.audioRtcpConn != nil {
 		_ = session.audioRtcpConn.Write(dummyRtcpPacket)
 	}
+	if session.metadataRtpConn != nil {
+		_ = session.metadataRtpConn.Write(dummyRtpPacket)
+	}
+	if session.metadataRtcpConn != nil {
+		_ = session.metadataRtcpConn.Write(dummyRtcpPacket)
+	}
 }
 
 // ----- ISessionStat --------------------------------------------------------------------------------------------------
@@ -255,6 +303,13 @@ func (session *BaseInSession) onAvPacketUnpacked(pkt base.AvPacket) {
 	session.mu.Lock()
 	defer session.mu.Unlock()
 
+	// 注意，需要在avPacketQueue修改时间戳之前计算
+	if pkt.IsAudio() {
+		pkt.CaptureTimeMs = session.audioSrClock.CalcUnixMs(pkt.Timestamp)
+	} else if pkt.IsVideo() {
+		pkt.CaptureTimeMs = session.videoSrClock.CalcUnixMs(pkt.Timestamp)
+	}
+
 	if session.avPacketQueue != nil {
 		session.avPacketQueue.Feed(pkt)
 	} else {
@@ -267,6 +322,13 @@ func (session *BaseInSession) onAvPacket(pkt base.AvPacket) {
 	session.observer.OnAvPacket(pkt)
 }
 
+// callback by onvif metadata RTPUnpacker
+func (session *BaseInSession) onOnvifMetadata(timestamp int64, b []byte) {
+	if o, ok := session.observer.(IOnvifMetadataObserver); ok {
+		o.OnOnvifMetadata(timestamp, b)
+	}
+}
+
 // callback by UDPConnection
 func (session *BaseInSession) onReadRtpPacket(b []byte, rAddr *net.UDPAddr, err error) bool {
 	if err != nil {
@@ -314,6 +376,7 @@ func (session *BaseInSession) handleRtcpPacket(b []byte, rAddr *net.UDPAddr) err
 		case session.audioSsrc.Load():
 			session.mu.Lock()
 			rrBuf = session.audioRrProducer.Produce(sr.GetMiddleNtp())
+			session.audioSrClock.FeedSr(sr)
 			session.mu.Unlock()
 			if rrBuf != nil {
 				if rAddr != nil {
@@ -326,6 +389,7 @@ func (session *BaseInSession) handleRtcpPacket(b []byte, rAddr *net.UDPAddr) err
 		case session.videoSsrc.Load():
 			session.mu.Lock()
 			rrBuf = session.videoRrProducer.Produce(sr.GetMiddleNtp())
+			session.videoSrClock.FeedSr(sr)
 			session.mu.Unlock()
 			if rrBuf != nil {
 				if rAddr != nil {
@@ -406,6 +470,12 @@ func (session *BaseInSession) handleRtpPacket(b []byte) error {
 		if session.videoUnpacker != nil {
 			session.videoUnpacker.Feed(pkt)
 		}
+	} else if session.sdpCtx.IsMetadataPayloadTypeOrigin(packetType) {
+		session.observer.OnRtpPacket(pkt)
+
+		if session.metadataUnpacker != nil {
+			session.metadataUnpacker.Feed(pkt)
+		}
 	} else {
 		// noop 因为前面已经判断过type了，所以永远不会走到这
 	}
@@ -417,7 +487,7 @@ func (session *BaseInSession) dispose(err error) error {
 	var retErr error
 	session.disposeOnce.Do(func() {
 		Log.Infof("[%s] lifecycle dispose rtsp BaseInSession. session=%p", session.UniqueKey(), session)
-		var e1, e2, e3, e4 error
+		var e1, e2, e3, e4, e5, e6 error
 		if session.audioRtpConn != nil {
 			e1 = session.audioRtpConn.Dispose()
 		}
@@ -430,10 +500,16 @@ func (session *BaseInSession) dispose(err error) error {
 		if session.videoRtcpConn != nil {
 			e4 = session.videoRtcpConn.Dispose()
 		}
+		if session.metadataRtpConn != nil {
+			e5 = session.metadataRtpConn.Dispose()
+		}
+		if session.metadataRtcpConn != nil {
+			e6 = session.metadataRtcpConn.Dispose()
+		}
 
 		session.waitChan <- nil
 
-		retErr = nazaerrors.CombineErrors(e1, e2, e3, e4)
+		retErr = nazaerrors.CombineErrors(e1, e2, e3, e4, e5, e6)
 	})
 	return retErr
 }
@@ -12,6 +12,7 @@ import (
 	"encoding/hex"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/q191201771/naza/pkg/nazaatomic"
 
@@ -40,8 +41,17 @@ type BaseOutSession struct {
 	videoRtpChannel  int
 	videoRtcpChannel int
 
+	metadataRtpConn     *nazanet.UdpConnection
+	metadataRtcpConn    *nazanet.UdpConnection
+	metadataRtpChannel  int
+	metadataRtcpChannel int
+
 	sessionStat base.BasicSessionStat
 
+	// 发送SR使用，数据包中携带了绝对采集时间时才会发送
+	audioSrCtx srContext
+	videoSrCtx srContext
+
 	// only for debug log
 	debugLogMaxCount         int
 	loggedWriteAudioRtpCount int
@@ -53,14 +63,24 @@ type BaseOutSession struct {
 	waitChan    chan error
 }
 
+type srContext struct {
+	pktCnt       uint32
+	octetCnt     uint32
+	lastSentTick int64 // 上次发送SR的本地时间，unix时间戳，单位毫秒
+}
+
 func NewBaseOutSession(sessionType base.SessionType, cmdSession IInterleavedPacketWriter) *BaseOutSession {
 	s := &BaseOutSession{
-		cmdSession:       cmdSession,
-		sessionStat:      base.NewBasicSessionStat(sessionType, ""),
-		audioRtpChannel:  -1,
-		videoRtpChannel:  -1,
-		debugLogMaxCount: 3,
-		waitChan:         make(chan error, 1),
+		cmdSession:          cmdSession,
+		sessionStat:         base.NewBasicSessionStat(sessionType, ""),
+		audioRtpChannel:     -1,
+		audioRtcpChannel:    -1,
+		videoRtpChannel:     -1,
+		videoRtcpChannel:    -1,
+		metadataRtpChannel:  -1,
+		metadataRtcpChannel: -1,
+		debugLogMaxCount:    3,
+		waitChan:            make(chan error, 1),
 	}
 	Log.Infof("[%s] lifecycle new rtsp BaseOutSession. session=%p", s.UniqueKey(), s)
 	return s
@@ -77,6 +97,9 @@ func (session *BaseOutSession) SetupWithConn(uri string, rtpConn, rtcpConn *naza
 	} else if session.sdpCtx.IsVideoUri(uri) {
 		session.videoRtpConn = rtpConn
 		session.videoRtcpConn = rtcpConn
+	} else if session.sdpCtx.IsMetadataUri(uri) {
+		session.metadataRtpConn = rtpConn
+		session.metadataRtcpConn = rtcpConn
 	} else {
 		return nazaerrors.Wrap(base.ErrRtsp)
 	}
@@ -96,6 +119,10 @@ func (session *BaseOutSession) SetupWithChannel(uri string, rtpChannel, rtcpChan
 		session.videoRtpChannel = rtpChannel
 		session.videoRtcpChannel = rtcpChannel
 		return nil
+	} else if session.sdpCtx.IsMetadataUri(uri) {
+		session.metadataRtpChannel = rtpChannel
+		session.metadataRtcpChannel = rtcpChannel
+		return nil
 	}
 
 	return nazaerrors.Wrap(base.ErrRtsp)
@@ -126,10 +153,14 @@ func (session *BaseOutSession) HandleInterleavedPacket(b []byte, channel int) {
 	case session.audioRtpChannel:
 		fallthrough
 	case session.videoRtpChannel:
+		fallthrough
+	case session.metadataRtpChannel:
 		Log.Warnf("[%s] not supposed to read packet in rtp channel of BaseOutSession. channel=%d, len=%d", session.UniqueKey(), channel, len(b))
 	case session.audioRtcpChannel:
 		fallthrough
 	case session.videoRtcpChannel:
+		fallthrough
+	case session.metadataRtcpChannel:
 		Log.Debugf("[%s] read interleaved rtcp packet. b=%s", session.UniqueKey(), hex.Dump(nazabytes.Prefix(b, 32)))
 	default:
 		Log.Errorf("[%s] read interleaved packet but channel invalid. channel=%d", session.UniqueKey(), channel)
@@ -153,6 +184,7 @@ func (session *BaseOutSession) WriteRtpPacket(packet rtprtcp.RtpPacket) error {
 		if session.audioRtpChannel != -1 {
 			err = session.cmdSession.WriteInterleavedPacket(packet.Raw, session.audioRtpChannel)
 		}
+		session.writeSrIfNeeded(packet, &session.audioSrCtx, session.audioRtcpConn, session.audioRtcpChannel)
 	} else if session.sdpCtx.IsVideoPayloadTypeOrigin(t) {
 		if session.loggedWriteVideoRtpCount < session.debugLogMaxCount {
 			Log.Debugf("[%s] LOGPACKET. write video rtp=%+v", session.UniqueKey(), packet.Header)
@@ -165,6 +197,15 @@ func (session *BaseOutSession) WriteRtpPacket(packet rtprtcp.RtpPacket) error {
 		if session.videoRtpChannel != -1 {
 			err = session.cmdSession.WriteInterleavedPacket(packet.Raw, session.videoRtpChannel)
 		}
+		session.writeSrIfNeeded(packet, &session.videoSrCtx, session.videoRtcpConn, session.videoRtcpChannel)
+	} else if session.sdpCtx.IsMetadataPayloadTypeOrigin(t) {
+		// 数据轨道，订阅方没有SETUP该轨道时直接丢弃
+		if session.metadataRtpConn != nil {
+			err = session.metadataRtpConn.Write(packet.Raw)
+		}
+		if session.metadataRtpChannel != -1 {
+			err = session.cmdSession.WriteInterleavedPacket(packet.Raw, session.metadataRtpChannel)
+		}
 	} else {
 		Log.Errorf("[%s] write rtp packet but type invalid. type=%d", session.UniqueKey(), t)
 		err = nazaerrors.Wrap(base.ErrRtsp)
@@ -216,11 +257,51 @@ func (session *BaseOutSession) onReadRtcpPacket(b []byte, rAddr *net.UDPAddr, er
 	return true
 }
 
+// writeSrIfNeeded
+//
+// 如果rtp包携带了绝对采集时间，则定期发送SR，使得对端可以通过NTP时间和RTP时间戳的对应关系还原出采集时间
+//
+func (session *BaseOutSession) writeSrIfNeeded(packet rtprtcp.RtpPacket, ctx *srContext, rtcpConn *nazanet.UdpConnection, rtcpChannel int) {
+	ctx.pktCnt++
+	ctx.octetCnt += uint32(len(packet.Raw) - rtprtcp.RtpFixedHeaderLength)
+
+	if packet.CaptureTimeMs == 0 {
+		return
+	}
+	now := time.Now().UnixNano() / 1e6
+	if ctx.lastSentTick != 0 && now-ctx.lastSentTick < srIntervalMs {
+		return
+	}
+	ctx.lastSentTick = now
+
+	ntp := rtprtcp.UnixNano2Ntp(uint64(packet.CaptureTimeMs) * 1e6)
+	sr := rtprtcp.Sr{
+		SenderSsrc: packet.Header.Ssrc,
+		Msw:        uint32(ntp >> 32),
+		Lsw:        uint32(ntp),
+		Timestamp:  packet.Header.Timestamp,
+		PktCnt:     ctx.pktCnt,
+		OctetCnt:   ctx.octetCnt,
+	}
+	b := sr.Pack()
+
+	var err error
+	if rtcpConn != nil {
+		err = rtcpConn.Write(b)
+	}
+	if rtcpChannel != -1 {
+		err = session.cmdSession.WriteInterleavedPacket(b, rtcpChannel)
+	}
+	if err == nil {
+		session.sessionStat.AddWriteBytes(len(b))
+	}
+}
+
 func (session *BaseOutSession) dispose(err error) error {
 	var retErr error
 	session.disposeOnce.Do(func() {
 		Log.Infof("[%s] lifecycle dispose rtsp BaseOutSession. session=%p", session.UniqueKey(), session)
-		var e1, e2, e3, e4 error
+		var e1, e2, e3, e4, e5, e6 error
 		if session.audioRtpConn != nil {
 			e1 = session.audioRtpConn.Dispose()
 		}
@@ -233,10 +314,16 @@ func (session *BaseOutSession) dispose(err error) error {
 		if session.videoRtcpConn != nil {
 			e4 = session.videoRtcpConn.Dispose()
 		}
+		if session.metadataRtpConn != nil {
+			e5 = session.metadataRtpConn.Dispose()
+		}
+		if session.metadataRtcpConn != nil {
+			e6 = session.metadataRtcpConn.Dispose()
+		}
 
 		session.waitChan <- nil
 
-		retErr = nazaerrors.CombineErrors(e1, e2, e3, e4)
+		retErr = nazaerrors.CombineErrors(e1, e2, e3, e4, e5, e6)
 	})
 	return retErr
 }
@@ -42,11 +42,15 @@ const (
 type ClientCommandSessionOption struct {
 	DoTimeoutMs int
 	OverTcp     bool
+
+	// SetupOnvifMetadata sdp中存在onvif metadata数据轨道时，是否SETUP该轨道，only for PullSession
+	SetupOnvifMetadata bool
 }
 
 var defaultClientCommandSessionOption = ClientCommandSessionOption{
-	DoTimeoutMs: 10000,
-	OverTcp:     false,
+	DoTimeoutMs:        10000,
+	OverTcp:            false,
+	SetupOnvifMetadata: false,
 }
 
 type IClientCommandSessionObserver interface {
@@ -416,6 +420,18 @@ func (session *ClientCommandSession) writeSetup() error {
 			}
 		}
 	}
+	if session.t == CcstPullSession && session.option.SetupOnvifMetadata && session.sdpCtx.HasMetadataAControl() {
+		uri := session.sdpCtx.MakeMetadataSetupUri(session.urlCtx.RawUrlWithoutUserInfo)
+		if session.option.OverTcp {
+			if err := session.writeOneSetupTcp(uri); err != nil {
+				return err
+			}
+		} else {
+			if err := session.writeOneSetup(uri); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
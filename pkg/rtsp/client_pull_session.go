@@ -27,11 +27,19 @@ type PullSessionOption struct {
 	PullTimeoutMs int
 
 	OverTcp bool // 是否使用interleaved模式，也即是否通过rtsp command tcp连接传输rtp/rtcp数据
+
+	// EnableOnvifMetadata 对端sdp中存在onvif metadata（application/vnd.onvif.metadata）数据轨道时，是否SETUP该轨道
+	//
+	// 开启后，合包得到的xml文档通过 IOnvifMetadataObserver 回调（observer需要实现该接口），
+	// 原始rtp包通过 IBaseInSessionObserver.OnRtpPacket 回调
+	//
+	EnableOnvifMetadata bool
 }
 
 var defaultPullSessionOption = PullSessionOption{
-	PullTimeoutMs: 10000,
-	OverTcp:       false,
+	PullTimeoutMs:       10000,
+	OverTcp:             false,
+	EnableOnvifMetadata: false,
 }
 
 type PullSession struct {
@@ -62,6 +70,7 @@ func NewPullSession(observer IPullSessionObserver, modOptions ...ModPullSessionO
 	cmdSession := NewClientCommandSession(CcstPullSession, baseInSession.UniqueKey(), s, func(opt *ClientCommandSessionOption) {
 		opt.DoTimeoutMs = option.PullTimeoutMs
 		opt.OverTcp = option.OverTcp
+		opt.SetupOnvifMetadata = option.EnableOnvifMetadata
 	})
 	s.baseInSession = baseInSession
 	s.cmdSession = cmdSession
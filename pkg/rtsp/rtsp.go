@@ -87,6 +87,9 @@ var (
 
 	unpackerItemMaxSize = 1024
 
+	// 输出rtp时，发送SR的间隔，单位毫秒
+	srIntervalMs int64 = 5000
+
 	serverCommandSessionReadBufSize   = 256
 	serverCommandSessionWriteChanSize = 1024
 
@@ -34,6 +34,12 @@ type LogicContext struct {
 	audioAControl          string
 	videoAControl          string
 
+	// onvif metadata数据轨道，没有时 hasMetadata 为false
+	MetadataClockRate         int
+	metadataPayloadTypeOrigin int
+	metadataAControl          string
+	hasMetadata               bool
+
 	// 没有用上的
 	hasAudio bool
 	hasVideo bool
@@ -47,8 +53,12 @@ func (lc *LogicContext) IsVideoPayloadTypeOrigin(t int) bool {
 	return lc.videoPayloadTypeOrigin == t
 }
 
+func (lc *LogicContext) IsMetadataPayloadTypeOrigin(t int) bool {
+	return lc.hasMetadata && lc.metadataPayloadTypeOrigin == t
+}
+
 func (lc *LogicContext) IsPayloadTypeOrigin(t int) bool {
-	return lc.audioPayloadTypeOrigin == t || lc.videoPayloadTypeOrigin == t
+	return lc.audioPayloadTypeOrigin == t || lc.videoPayloadTypeOrigin == t || lc.IsMetadataPayloadTypeOrigin(t)
 }
 
 func (lc *LogicContext) IsAudioUnpackable() bool {
@@ -68,6 +78,18 @@ func (lc *LogicContext) IsVideoUri(uri string) bool {
 	return lc.videoAControl != "" && strings.HasSuffix(uri, lc.videoAControl)
 }
 
+func (lc *LogicContext) IsMetadataUri(uri string) bool {
+	return lc.metadataAControl != "" && strings.HasSuffix(uri, lc.metadataAControl)
+}
+
+func (lc *LogicContext) HasMetadata() bool {
+	return lc.hasMetadata
+}
+
+func (lc *LogicContext) HasMetadataAControl() bool {
+	return lc.hasMetadata && lc.metadataAControl != ""
+}
+
 func (lc *LogicContext) HasAudioAControl() bool {
 	return lc.audioAControl != ""
 }
@@ -84,6 +106,10 @@ func (lc *LogicContext) MakeVideoSetupUri(uri string) string {
 	return lc.makeSetupUri(uri, lc.videoAControl)
 }
 
+func (lc *LogicContext) MakeMetadataSetupUri(uri string) string {
+	return lc.makeSetupUri(uri, lc.metadataAControl)
+}
+
 func (lc *LogicContext) GetAudioPayloadTypeBase() base.AvPacketPt {
 	return lc.audioPayloadTypeBase
 }
@@ -158,6 +184,14 @@ func ParseSdp2LogicContext(b []byte) (LogicContext, error) {
 			default:
 				ret.videoPayloadTypeBase = base.AvPacketPtUnknown
 			}
+		case "application":
+			// 目前只关心onvif metadata，其他类型的数据轨道忽略
+			if strings.EqualFold(md.ARtpMap.EncodingName, ARtpMapEncodingNameOnvifMetadata) {
+				ret.hasMetadata = true
+				ret.MetadataClockRate = md.ARtpMap.ClockRate
+				ret.metadataAControl = md.AControl.Value
+				ret.metadataPayloadTypeOrigin = md.ARtpMap.PayloadType
+			}
 		}
 	}
 
@@ -597,3 +597,38 @@ a=control:streamid=1
 	assert.Equal(t, nil, err)
 	_ = ctx
 }
+
+func TestCase15(t *testing.T) {
+	// onvif摄像头，带metadata数据轨道
+	golden := `v=0
+o=- 1650000000000000 1 IN IP4 192.168.1.64
+s=Media Presentation
+c=IN IP4 0.0.0.0
+t=0 0
+a=control:*
+m=video 0 RTP/AVP 96
+a=rtpmap:96 H264/90000
+a=fmtp:96 profile-level-id=420029; packetization-mode=1; sprop-parameter-sets=Z2QAH6zZQFAEX5P/AycDJmoCAgKAAAADAIAAABkHjBjL,aOk5csA=
+a=control:rtsp://192.168.1.64:554/Streaming/Channels/101/trackID=1
+m=application 0 RTP/AVP 107
+a=rtpmap:107 vnd.onvif.metadata/90000
+a=control:rtsp://192.168.1.64:554/Streaming/Channels/101/trackID=3
+`
+	golden = strings.ReplaceAll(golden, "\n", "\r\n")
+	ctx, err := ParseSdp2LogicContext([]byte(golden))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ctx.HasMetadata())
+	assert.Equal(t, true, ctx.HasMetadataAControl())
+	assert.Equal(t, 90000, ctx.MetadataClockRate)
+	assert.Equal(t, true, ctx.IsMetadataPayloadTypeOrigin(107))
+	assert.Equal(t, true, ctx.IsPayloadTypeOrigin(107))
+	assert.Equal(t, false, ctx.IsVideoPayloadTypeOrigin(107))
+	assert.Equal(t, true, ctx.IsMetadataUri("rtsp://192.168.1.64:554/Streaming/Channels/101/trackID=3"))
+	assert.Equal(t, false, ctx.IsVideoUri("rtsp://192.168.1.64:554/Streaming/Channels/101/trackID=3"))
+	assert.Equal(t, "rtsp://192.168.1.64:554/Streaming/Channels/101/trackID=3", ctx.MakeMetadataSetupUri("rtsp://192.168.1.64:554/Streaming/Channels/101"))
+
+	ctx, err = ParseSdp2LogicContext([]byte(goldenSdp))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ctx.HasMetadata())
+	assert.Equal(t, false, ctx.IsMetadataPayloadTypeOrigin(0))
+}
@@ -14,4 +14,7 @@ const (
 	ARtpMapEncodingNameH265 = "H265"
 	ARtpMapEncodingNameH264 = "H264"
 	ARtpMapEncodingNameAac  = "MPEG4-GENERIC"
+
+	// ARtpMapEncodingNameOnvifMetadata onvif设备的分析事件数据轨道，`m=application`，内容为xml
+	ARtpMapEncodingNameOnvifMetadata = "vnd.onvif.metadata"
 )
@@ -0,0 +1,254 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+const (
+	minS3PartSizeMb     = 5 // S3要求除最后一段外，每段至少5MB
+	defaultS3PartSizeMb = 16
+	defaultS3TimeoutMs  = 60000
+)
+
+type S3Config struct {
+	Endpoint   string `json:"endpoint"`     // 比如 "https://s3.us-east-1.amazonaws.com"，"http://127.0.0.1:9000"
+	Region     string `json:"region"`       // 比如 "us-east-1"，MinIO等一般也使用 "us-east-1"
+	Bucket     string `json:"bucket"`       //
+	AccessKey  string `json:"access_key"`   //
+	SecretKey  string `json:"secret_key"`   //
+	PathStyle  bool   `json:"path_style"`   // true: <endpoint>/<bucket>/<key>，MinIO等一般使用这种方式；false: <bucket>.<endpoint host>/<key>
+	PartSizeMb int    `json:"part_size_mb"` // 文件大于该值时分段上传，每段的大小，最小为5
+	TimeoutMs  int    `json:"timeout_ms"`   // 单个http请求的超时时间
+}
+
+// S3Storage 兼容S3协议的对象存储，比如AWS S3，MinIO，以及各家云厂商的对象存储
+//
+type S3Storage struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || config.Bucket == "" {
+		return nil, fmt.Errorf("%w. invalid s3 config. endpoint=%s, bucket=%s", base.ErrStorage, config.Endpoint, config.Bucket)
+	}
+	if config.PartSizeMb <= 0 {
+		config.PartSizeMb = defaultS3PartSizeMb
+	} else if config.PartSizeMb < minS3PartSizeMb {
+		config.PartSizeMb = minS3PartSizeMb
+	}
+	if config.TimeoutMs <= 0 {
+		config.TimeoutMs = defaultS3TimeoutMs
+	}
+	return &S3Storage{
+		config:   config,
+		endpoint: u,
+		client: &http.Client{
+			Timeout: time.Duration(config.TimeoutMs) * time.Millisecond,
+		},
+	}, nil
+}
+
+func (s *S3Storage) PutObject(key string, content []byte) error {
+	_, _, err := s.do("PUT", key, nil, content, contentTypeOf(key))
+	return err
+}
+
+// PutFile 文件大于 S3Config.PartSizeMb 时，使用multipart分段上传
+//
+func (s *S3Storage) PutFile(key string, filename string) error {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	fi, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+
+	partSize := int64(s.config.PartSizeMb) * 1024 * 1024
+	if fi.Size() <= partSize {
+		content, err := ioutil.ReadAll(fp)
+		if err != nil {
+			return err
+		}
+		return s.PutObject(key, content)
+	}
+	return s.putMultipart(key, fp, fi.Size(), partSize)
+}
+
+func (s *S3Storage) DeleteObject(key string) error {
+	_, _, err := s.do("DELETE", key, nil, nil, "")
+	return err
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+type s3InitiateMultipartUploadResult struct {
+	UploadId string `xml:"UploadId"`
+}
+
+type s3CompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletePart `xml:"Part"`
+}
+
+func (s *S3Storage) putMultipart(key string, r io.Reader, size int64, partSize int64) (err error) {
+	_, body, err := s.do("POST", key, url.Values{"uploads": {""}}, nil, contentTypeOf(key))
+	if err != nil {
+		return err
+	}
+	var initResult s3InitiateMultipartUploadResult
+	if err = xml.Unmarshal(body, &initResult); err != nil || initResult.UploadId == "" {
+		return fmt.Errorf("%w. s3 initiate multipart upload invalid response. key=%s, body=%s", base.ErrStorage, key, string(body))
+	}
+	uploadId := initResult.UploadId
+
+	defer func() {
+		if err != nil {
+			// 失败时取消，使得服务端释放已上传的分段
+			if _, _, abortErr := s.do("DELETE", key, url.Values{"uploadId": {uploadId}}, nil, ""); abortErr != nil {
+				Log.Warnf("s3 abort multipart upload failed. key=%s, upload id=%s, err=%+v", key, uploadId, abortErr)
+			}
+		}
+	}()
+
+	var complete s3CompleteMultipartUpload
+	buf := make([]byte, partSize)
+	for partNumber := 1; size > 0; partNumber++ {
+		n := partSize
+		if size < n {
+			n = size
+		}
+		if _, err = io.ReadFull(r, buf[:n]); err != nil {
+			return err
+		}
+		size -= n
+
+		query := url.Values{
+			"partNumber": {strconv.Itoa(partNumber)},
+			"uploadId":   {uploadId},
+		}
+		var header http.Header
+		if header, _, err = s.do("PUT", key, query, buf[:n], ""); err != nil {
+			return err
+		}
+		complete.Parts = append(complete.Parts, s3CompletePart{
+			PartNumber: partNumber,
+			ETag:       header.Get("ETag"),
+		})
+	}
+
+	content, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	_, body, err = s.do("POST", key, url.Values{"uploadId": {uploadId}}, content, "application/xml")
+	if err != nil {
+		return err
+	}
+	// 注意，完成请求可能返回200，但是body中为错误信息
+	if bytes.Contains(body, []byte("<Error>")) {
+		return fmt.Errorf("%w. s3 complete multipart upload failed. key=%s, body=%s", base.ErrStorage, key, string(body))
+	}
+	return nil
+}
+
+// do 发送签名后的请求，非2xx的状态码作为错误返回，删除不存在的对象不作为错误
+//
+func (s *S3Storage) do(method string, key string, query url.Values, content []byte, contentType string) (http.Header, []byte, error) {
+	u := *s.endpoint
+	key = strings.TrimLeft(key, "/")
+	if s.config.PathStyle {
+		u.Path = path.Join("/", u.Path, s.config.Bucket, key)
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = path.Join("/", u.Path, key)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.ContentLength = int64(len(content))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	payloadHash := EmptyPayloadHash
+	if len(content) > 0 {
+		h := sha256.Sum256(content)
+		payloadHash = hex.EncodeToString(h[:])
+	}
+	SignV4(req, payloadHash, s.config.AccessKey, s.config.SecretKey, s.config.Region, Clock.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 && !(method == "DELETE" && resp.StatusCode == http.StatusNotFound) {
+		if len(body) > 512 {
+			body = body[:512]
+		}
+		return nil, nil, fmt.Errorf("%w. s3 request failed. method=%s, key=%s, status=%d, body=%s",
+			base.ErrStorage, method, key, resp.StatusCode, string(body))
+	}
+	return resp.Header, body, nil
+}
+
+// contentTypeOf 设置对象的Content-Type，使得可以直接通过对象存储（或者cdn）播放
+//
+func contentTypeOf(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".m4s":
+		return "video/iso.segment"
+	case ".mp4":
+		return "video/mp4"
+	case ".flv":
+		return "video/x-flv"
+	}
+	return "application/octet-stream"
+}
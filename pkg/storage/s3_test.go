@@ -0,0 +1,283 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package storage_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/storage"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+// fakeS3 一个简单的兼容S3协议的服务端，用于测试，只支持path style
+//
+// 校验签名，支持PutObject、DeleteObject，以及multipart上传
+//
+type fakeS3 struct {
+	accessKey string
+	secretKey string
+	bucket    string
+
+	mutex         sync.Mutex
+	objects       map[string][]byte
+	contentTypes  map[string]string
+	uploads       map[string]map[int][]byte
+	uploadId      int
+	aborted       int
+	failPartTimes int // 上传分段时失败的次数
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{
+		accessKey:    "minioadmin",
+		secretKey:    "minioadmin",
+		bucket:       bucket,
+		objects:      make(map[string][]byte),
+		contentTypes: make(map[string]string),
+		uploads:      make(map[string]map[int][]byte),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if !f.checkSignature(r, body) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("<Error><Code>SignatureDoesNotMatch</Code></Error>"))
+		return
+	}
+
+	prefix := "/" + f.bucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+	q := r.URL.Query()
+	_, initiate := q["uploads"]
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	switch {
+	case r.Method == "POST" && initiate:
+		f.uploadId++
+		id := strconv.Itoa(f.uploadId)
+		f.uploads[id] = make(map[int][]byte)
+		f.contentTypes[key] = r.Header.Get("Content-Type")
+		_, _ = fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", f.bucket, key, id)
+	case r.Method == "PUT" && q.Get("uploadId") != "":
+		parts, ok := f.uploads[q.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if f.failPartTimes > 0 {
+			f.failPartTimes--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		parts[n] = body
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case r.Method == "POST" && q.Get("uploadId") != "":
+		parts, ok := f.uploads[q.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var content []byte
+		for _, p := range complete.Parts {
+			sum := md5.Sum(parts[p.PartNumber])
+			if p.ETag != `"`+hex.EncodeToString(sum[:])+`"` {
+				_, _ = w.Write([]byte("<Error><Code>InvalidPart</Code></Error>"))
+				return
+			}
+			content = append(content, parts[p.PartNumber]...)
+		}
+		delete(f.uploads, q.Get("uploadId"))
+		f.objects[key] = content
+		_, _ = w.Write([]byte("<CompleteMultipartUploadResult></CompleteMultipartUploadResult>"))
+	case r.Method == "DELETE" && q.Get("uploadId") != "":
+		delete(f.uploads, q.Get("uploadId"))
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PUT":
+		f.objects[key] = body
+		f.contentTypes[key] = r.Header.Get("Content-Type")
+	case r.Method == "DELETE":
+		if _, ok := f.objects[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// checkSignature 使用请求中的签名头重新计算签名，与请求中的签名比较
+//
+func (f *fakeS3) checkSignature(r *http.Request, body []byte) bool {
+	auth := r.Header.Get("Authorization")
+	idx := strings.Index(auth, "SignedHeaders=")
+	if idx == -1 {
+		return false
+	}
+	signedHeaders := strings.Split(strings.SplitN(auth[idx+len("SignedHeaders="):], ",", 2)[0], ";")
+
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		return false
+	}
+	t, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+
+	check, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	for _, h := range signedHeaders {
+		if h != "host" {
+			check.Header.Set(h, r.Header.Get(h))
+		}
+	}
+	storage.SignV4(check, r.Header.Get("X-Amz-Content-Sha256"), f.accessKey, f.secretKey, "us-east-1", t)
+	return check.Header.Get("Authorization") == auth
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := newFakeS3("lal")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	config := storage.S3Config{
+		Endpoint:   srv.URL,
+		Region:     "us-east-1",
+		Bucket:     "lal",
+		AccessKey:  "minioadmin",
+		SecretKey:  "minioadmin",
+		PathStyle:  true,
+		PartSizeMb: 5,
+	}
+	s, err := storage.NewS3Storage(config)
+	assert.Equal(t, nil, err)
+
+	// 普通上传，key中的特殊字符
+	assert.Equal(t, nil, s.PutObject("hls/test/playlist.m3u8", []byte("#EXTM3U\n")))
+	assert.Equal(t, nil, s.PutObject("hls/test/a b+c=d.ts", []byte{1, 2, 3}))
+	assert.Equal(t, []byte("#EXTM3U\n"), fake.objects["hls/test/playlist.m3u8"])
+	assert.Equal(t, "application/vnd.apple.mpegurl", fake.contentTypes["hls/test/playlist.m3u8"])
+	assert.Equal(t, []byte{1, 2, 3}, fake.objects["hls/test/a b+c=d.ts"])
+
+	// 小文件直接上传，大文件分段上传
+	dir, err := ioutil.TempDir("", "lal_storage_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	small := filepath.Join(dir, "small.flv")
+	assert.Equal(t, nil, ioutil.WriteFile(small, []byte("flv"), 0666))
+	assert.Equal(t, nil, s.PutFile("record/small.flv", small))
+	assert.Equal(t, []byte("flv"), fake.objects["record/small.flv"])
+
+	big := filepath.Join(dir, "big.mp4")
+	content := make([]byte, 11*1024*1024+100)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	assert.Equal(t, nil, ioutil.WriteFile(big, content, 0666))
+	assert.Equal(t, nil, s.PutFile("record/big.mp4", big))
+	assert.Equal(t, true, bytes.Equal(content, fake.objects["record/big.mp4"]))
+	assert.Equal(t, "video/mp4", fake.contentTypes["record/big.mp4"])
+	assert.Equal(t, 0, len(fake.uploads))
+
+	// 分段上传失败时取消
+	fake.failPartTimes = 1
+	err = s.PutFile("record/big2.mp4", big)
+	assert.IsNotNil(t, err)
+	assert.Equal(t, 1, fake.aborted)
+	assert.Equal(t, 0, len(fake.uploads))
+
+	// 删除，不存在的对象不作为错误
+	assert.Equal(t, nil, s.DeleteObject("record/small.flv"))
+	assert.Equal(t, nil, s.DeleteObject("record/small.flv"))
+	_, ok := fake.objects["record/small.flv"]
+	assert.Equal(t, false, ok)
+
+	// 签名错误
+	config.SecretKey = "invalid"
+	s, err = storage.NewS3Storage(config)
+	assert.Equal(t, nil, err)
+	assert.IsNotNil(t, s.PutObject("a.ts", []byte{1}))
+
+	_, err = storage.NewS3Storage(storage.S3Config{Endpoint: "127.0.0.1:9000", Bucket: "lal"})
+	assert.IsNotNil(t, err)
+}
+
+func TestLocalStorage(t *testing.T) {
+	assert.Equal(t, "lal_record/hls/test/1.ts", storage.FilenameToKey("", "./lal_record/hls/test/1.ts"))
+	assert.Equal(t, "origin1/data/record/1.flv", storage.FilenameToKey("/origin1/", "/data/record/1.flv"))
+	assert.Equal(t, "origin1/record/1.flv", storage.FilenameToKey("origin1", "../record/1.flv"))
+
+	dir, err := ioutil.TempDir("", "lal_storage_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	s := storage.NewLocalStorage(filepath.Join(dir, "archive"))
+	assert.Equal(t, nil, s.PutObject("hls/test/1.ts", []byte{1, 2, 3}))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "archive/hls/test/1.ts"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{1, 2, 3}, b)
+
+	src := filepath.Join(dir, "1.flv")
+	assert.Equal(t, nil, ioutil.WriteFile(src, []byte("flv"), 0666))
+	assert.Equal(t, nil, s.PutFile("record/1.flv", src))
+	b, err = ioutil.ReadFile(filepath.Join(dir, "archive/record/1.flv"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("flv"), b)
+
+	assert.Equal(t, nil, s.DeleteObject("record/1.flv"))
+	assert.Equal(t, nil, s.DeleteObject("record/1.flv"))
+	_, err = os.Stat(filepath.Join(dir, "archive/record/1.flv"))
+	assert.Equal(t, true, os.IsNotExist(err))
+
+	// 没有残留临时文件
+	fis, err := ioutil.ReadDir(filepath.Join(dir, "archive/hls/test"))
+	assert.Equal(t, nil, err)
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"1.ts"}, names)
+}
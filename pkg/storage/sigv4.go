@@ -0,0 +1,160 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4，参考：
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+//
+
+const (
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	sigV4Service     = "s3"
+	sigV4TimeLayout  = "20060102T150405Z"
+	sigV4DateLayout  = "20060102"
+	EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// SignV4 对请求进行签名，设置`x-amz-date`、`x-amz-content-sha256`以及`Authorization`头
+//
+// 签名的头包括Host，以及请求中已设置的`x-amz-*`、Content-Type、Content-Md5、Range、Date
+// 注意，会将请求的path和query改写为签名时使用的规范形式，保证发送的内容与签名一致
+//
+// @param payloadHash: body的sha256的hex形式，body为空时为 EmptyPayloadHash
+//
+func SignV4(req *http.Request, payloadHash string, accessKey string, secretKey string, region string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format(sigV4TimeLayout)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// 规范的path和query
+	canonicalUri := awsUriEncode(req.URL.Path, false)
+	if canonicalUri == "" {
+		canonicalUri = "/"
+	}
+	req.URL.RawPath = canonicalUri
+	canonicalQuery := canonicalQueryString(req.URL.Query())
+	req.URL.RawQuery = canonicalQuery
+
+	// 规范的header
+	headers := map[string]string{
+		"host": host,
+	}
+	for k, vs := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" || lk == "content-md5" || lk == "range" || lk == "date" {
+			for i := range vs {
+				vs[i] = strings.Join(strings.Fields(vs[i]), " ")
+			}
+			headers[lk] = strings.Join(vs, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k)
+		canonicalHeaders.WriteByte(':')
+		canonicalHeaders.WriteString(headers[k])
+		canonicalHeaders.WriteByte('\n')
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalUri,
+		canonicalQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", t.Format(sigV4DateLayout), region, sigV4Service)
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+secretKey), []byte(t.Format(sigV4DateLayout)))
+	key = hmacSha256(key, []byte(region))
+	key = hmacSha256(key, []byte(sigV4Service))
+	key = hmacSha256(key, []byte("aws4_request"))
+	signature := hex.EncodeToString(hmacSha256(key, []byte(stringToSign)))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, accessKey, scope, signedHeaders, signature))
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var out []string
+	for _, k := range keys {
+		vs := append([]string(nil), query[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			out = append(out, awsUriEncode(k, true)+"="+awsUriEncode(v, true))
+		}
+	}
+	return strings.Join(out, "&")
+}
+
+// awsUriEncode 除了`A-Za-z0-9-._~`，其他字符都编码为%XX，encodeSlash为false时保留`/`
+//
+func awsUriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSha256(key []byte, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,127 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IStorage 存储hls的分片、m3u8，以及录制文件的后端
+//
+// key为对象的名称，使用`/`分隔的相对路径，见 FilenameToKey
+//
+// 注意，实现需要保证并发安全，Uploader 会在多个协程中同时调用
+//
+type IStorage interface {
+	// PutObject 写入一个内容已在内存中的对象，已存在时覆盖
+	//
+	PutObject(key string, content []byte) error
+
+	// PutFile 将本地文件写入为一个对象，已存在时覆盖。文件较大时，实现可以分段上传
+	//
+	PutFile(key string, filename string) error
+
+	DeleteObject(key string) error
+}
+
+// FilenameToKey 本地文件名转换为对象的key
+//
+// 去掉`./`、`../`以及开头的`/`等，使用`/`分隔，并加上前缀
+// 比如 "./lal_record/hls/test/test-1.ts" 对应 "<prefix>/lal_record/hls/test/test-1.ts"
+//
+func FilenameToKey(prefix string, filename string) string {
+	key := filepath.ToSlash(filepath.Clean(filename))
+	if vol := filepath.VolumeName(filename); vol != "" {
+		key = key[len(vol):]
+	}
+	key = strings.TrimLeft(key, "/")
+	for strings.HasPrefix(key, "../") {
+		key = key[len("../"):]
+	}
+	if prefix == "" {
+		return key
+	}
+	return path.Join(strings.Trim(prefix, "/"), key)
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// LocalStorage 以本地目录（比如挂载的网络存储）作为存储后端，key对应目录下的相对路径
+//
+type LocalStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{
+		root: root,
+	}
+}
+
+func (s *LocalStorage) PutObject(key string, content []byte) error {
+	return s.write(key, func(fp *os.File) error {
+		_, err := fp.Write(content)
+		return err
+	})
+}
+
+func (s *LocalStorage) PutFile(key string, filename string) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return s.write(key, func(fp *os.File) error {
+		_, err := io.Copy(fp, src)
+		return err
+	})
+}
+
+func (s *LocalStorage) DeleteObject(key string) error {
+	err := os.Remove(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// write 先写入临时文件再重命名，保证读取者不会读到写了一半的文件
+//
+func (s *LocalStorage) write(key string, fn func(fp *os.File) error) error {
+	filename := s.filename(key)
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	fp, err := ioutil.TempFile(dir, filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err = fn(fp); err == nil {
+		err = fp.Close()
+	} else {
+		_ = fp.Close()
+	}
+	if err == nil {
+		err = os.Rename(fp.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(fp.Name())
+	}
+	return err
+}
+
+func (s *LocalStorage) filename(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(FilenameToKey("", key)))
+}
@@ -0,0 +1,354 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package storage
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// Uploader 将本地生成的文件异步上传到 IStorage
+//
+// - 调用方（hls muxer、录制等）只负责投递任务，不会被上传阻塞
+// - 由多个协程并发上传，失败时按指数退避重试
+// - 背压：排队中的数据总量超过 UploaderConfig.QueueMaxMb 时，拒绝新的任务，由调用方决定如何处理（比如保留本地文件）
+// - 同一个key还在排队中的内存内容（比如不断更新的m3u8），只保留最新的一份
+//
+type Uploader struct {
+	storage IStorage
+	config  UploaderConfig
+	onDone  OnUploadDone
+
+	mutex        sync.Mutex
+	cond         *sync.Cond
+	queue        []*uploadTask
+	queued       map[string]*uploadTask // 排队中的内存内容任务，key为对象的key，用于合并
+	pendingBytes int64                  // 排队中以及正在上传的数据总量
+	stat         UploaderStat
+	disposed     bool
+
+	exitChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+type UploaderConfig struct {
+	KeyPrefix       string `json:"key_prefix"`        // 对象key的前缀，见 FilenameToKey
+	WorkerNum       int    `json:"worker_num"`        // 并发上传的协程数量
+	QueueMaxMb      int    `json:"queue_max_mb"`      // 排队中的数据总量上限，为0表示不限制
+	MaxRetry        int    `json:"max_retry"`         // 失败后的最大重试次数
+	RetryIntervalMs int    `json:"retry_interval_ms"` // 第一次重试前的等待时间，之后每次翻倍，最多30秒
+}
+
+type UploaderStat struct {
+	Pending      int   `json:"pending"`       // 排队中以及正在上传的任务数量
+	PendingBytes int64 `json:"pending_bytes"` //
+	Succ         int64 `json:"succ"`          // 上传成功的任务数量
+	Fail         int64 `json:"fail"`          // 重试后仍然失败而放弃的任务数量
+	Rejected     int64 `json:"rejected"`      // 因为队列已满而拒绝的任务数量
+}
+
+// OnUploadDone 一个上传任务成功，或者重试后仍然失败，删除任务（见 Uploader.Delete ）不回调
+//
+// 注意，在上传协程中回调
+//
+type OnUploadDone func(filename string, key string, err error)
+
+const maxRetryIntervalMs = 30000
+
+type uploadTask struct {
+	filename    string
+	key         string
+	content     []byte // 为nil时上传本地文件
+	size        int64
+	removeLocal bool // 上传成功后删除本地文件
+	deleteObj   bool // 删除对象，而不是上传
+	retry       int
+}
+
+func NewUploader(storage IStorage, config UploaderConfig) *Uploader {
+	if config.WorkerNum <= 0 {
+		config.WorkerNum = 1
+	}
+	if config.RetryIntervalMs <= 0 {
+		config.RetryIntervalMs = 1000
+	}
+	u := &Uploader{
+		storage:  storage,
+		config:   config,
+		queued:   make(map[string]*uploadTask),
+		exitChan: make(chan struct{}),
+	}
+	u.cond = sync.NewCond(&u.mutex)
+	for i := 0; i < config.WorkerNum; i++ {
+		u.wg.Add(1)
+		go u.runWorker()
+	}
+	return u
+}
+
+// WithOnUploadDone 需要在投递任务之前调用
+//
+func (u *Uploader) WithOnUploadDone(fn OnUploadDone) *Uploader {
+	u.onDone = fn
+	return u
+}
+
+// UploadContent 上传内存中的内容，对象的key由文件名得到
+//
+// @param content: 调用后由 Uploader 持有，调用方不能再修改
+//
+func (u *Uploader) UploadContent(filename string, content []byte) error {
+	key := FilenameToKey(u.config.KeyPrefix, filename)
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.disposed {
+		return base.ErrStorageDisposed
+	}
+	if t, ok := u.queued[key]; ok {
+		// 还没开始上传，使用最新的内容替换
+		u.pendingBytes += int64(len(content)) - t.size
+		t.content = content
+		t.size = int64(len(content))
+		t.retry = 0
+		return nil
+	}
+	t := &uploadTask{
+		filename: filename,
+		key:      key,
+		content:  content,
+		size:     int64(len(content)),
+	}
+	if err := u.pushWithLock(t); err != nil {
+		return err
+	}
+	u.queued[key] = t
+	return nil
+}
+
+// UploadFile 上传本地文件
+//
+// 注意，上传之前文件不能被删除、修改
+//
+// @param removeLocal: 上传成功后是否删除本地文件
+//
+func (u *Uploader) UploadFile(filename string, removeLocal bool) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.disposed {
+		return base.ErrStorageDisposed
+	}
+	return u.pushWithLock(&uploadTask{
+		filename:    filename,
+		key:         FilenameToKey(u.config.KeyPrefix, filename),
+		size:        fi.Size(),
+		removeLocal: removeLocal,
+	})
+}
+
+// Delete 删除本地文件名对应的对象，比如本地删除了过期的hls分片
+//
+// 同一个key还在排队中的内存内容会被丢弃。
+// 注意，不保证与同一个key正在上传中的任务的先后顺序，调用方应该在上传完成一段时间后再删除
+//
+func (u *Uploader) Delete(filename string) error {
+	key := FilenameToKey(u.config.KeyPrefix, filename)
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.disposed {
+		return base.ErrStorageDisposed
+	}
+	if t, ok := u.queued[key]; ok {
+		u.dropWithLock(t)
+	}
+	// 删除任务不占用排队的数据总量，总是接受
+	u.queue = append(u.queue, &uploadTask{
+		filename:  filename,
+		key:       key,
+		deleteObj: true,
+	})
+	u.updateStatWithLock(1)
+	u.cond.Signal()
+	return nil
+}
+
+func (u *Uploader) Stat() UploaderStat {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.stat
+}
+
+// Dispose 不再接受新的任务，等待正在上传的任务完成，排队中的任务被丢弃
+//
+func (u *Uploader) Dispose() {
+	u.mutex.Lock()
+	if u.disposed {
+		u.mutex.Unlock()
+		return
+	}
+	u.disposed = true
+	if len(u.queue) > 0 {
+		Log.Warnf("uploader dispose, drop pending tasks. num=%d", len(u.queue))
+	}
+	u.queue = nil
+	u.queued = nil
+	close(u.exitChan)
+	u.cond.Broadcast()
+	u.mutex.Unlock()
+
+	u.wg.Wait()
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+func (u *Uploader) pushWithLock(t *uploadTask) error {
+	// 队列为空时总是接受，使得单个大文件也能上传
+	if u.config.QueueMaxMb > 0 && u.pendingBytes > 0 && u.pendingBytes+t.size > int64(u.config.QueueMaxMb)*1024*1024 {
+		u.stat.Rejected++
+		return base.ErrStorageQueueFull
+	}
+	u.queue = append(u.queue, t)
+	u.pendingBytes += t.size
+	u.updateStatWithLock(1)
+	u.cond.Signal()
+	return nil
+}
+
+// dropWithLock 丢弃排队中的任务
+//
+func (u *Uploader) dropWithLock(t *uploadTask) {
+	for i, qt := range u.queue {
+		if qt == t {
+			u.queue = append(u.queue[:i], u.queue[i+1:]...)
+			break
+		}
+	}
+	if u.queued[t.key] == t {
+		delete(u.queued, t.key)
+	}
+	u.pendingBytes -= t.size
+	u.updateStatWithLock(-1)
+}
+
+func (u *Uploader) runWorker() {
+	defer u.wg.Done()
+	for {
+		u.mutex.Lock()
+		for len(u.queue) == 0 && !u.disposed {
+			u.cond.Wait()
+		}
+		if u.disposed {
+			u.mutex.Unlock()
+			return
+		}
+		t := u.queue[0]
+		u.queue = u.queue[1:]
+		if u.queued[t.key] == t {
+			delete(u.queued, t.key)
+		}
+		u.mutex.Unlock()
+
+		err := u.upload(t)
+		if err == nil || t.retry >= u.config.MaxRetry {
+			u.finish(t, err)
+			continue
+		}
+
+		t.retry++
+		interval := u.config.RetryIntervalMs << uint(t.retry-1)
+		if interval > maxRetryIntervalMs || interval <= 0 {
+			interval = maxRetryIntervalMs
+		}
+		Log.Warnf("%s failed, retry later. key=%s, retry=%d, interval=%dms, err=%+v", t.op(), t.key, t.retry, interval, err)
+		select {
+		case <-u.exitChan:
+			return
+		case <-time.After(time.Duration(interval) * time.Millisecond):
+		}
+		u.retry(t)
+	}
+}
+
+func (u *Uploader) upload(t *uploadTask) error {
+	if t.deleteObj {
+		return u.storage.DeleteObject(t.key)
+	}
+	if t.content != nil {
+		return u.storage.PutObject(t.key, t.content)
+	}
+	return u.storage.PutFile(t.key, t.filename)
+}
+
+// retry 重新放入队列，同一个key已经有更新的内容在排队时，放弃旧的内容
+//
+func (u *Uploader) retry(t *uploadTask) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.disposed {
+		return
+	}
+	if t.content != nil {
+		if _, ok := u.queued[t.key]; ok {
+			u.pendingBytes -= t.size
+			u.updateStatWithLock(-1)
+			return
+		}
+		u.queued[t.key] = t
+	}
+	u.queue = append(u.queue, t)
+	u.cond.Signal()
+}
+
+func (u *Uploader) finish(t *uploadTask, err error) {
+	if err == nil && t.removeLocal {
+		if removeErr := os.Remove(t.filename); removeErr != nil {
+			Log.Warnf("upload succ but remove local file failed. filename=%s, err=%+v", t.filename, removeErr)
+		}
+	}
+	if err != nil {
+		Log.Errorf("%s failed, give up. filename=%s, key=%s, err=%+v", t.op(), t.filename, t.key, err)
+	} else {
+		Log.Debugf("%s succ. filename=%s, key=%s, size=%d", t.op(), t.filename, t.key, t.size)
+	}
+
+	u.mutex.Lock()
+	u.pendingBytes -= t.size
+	u.updateStatWithLock(-1)
+	if err == nil {
+		u.stat.Succ++
+	} else {
+		u.stat.Fail++
+	}
+	u.mutex.Unlock()
+
+	if u.onDone != nil && !t.deleteObj {
+		u.onDone(t.filename, t.key, err)
+	}
+}
+
+func (u *Uploader) updateStatWithLock(delta int) {
+	u.stat.Pending += delta
+	u.stat.PendingBytes = u.pendingBytes
+}
+
+func (t *uploadTask) op() string {
+	if t.deleteObj {
+		return "delete"
+	}
+	return "upload"
+}
@@ -0,0 +1,178 @@
+// Copyright 2022, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package storage_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/storage"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type mockStorage struct {
+	mutex    sync.Mutex
+	objects  map[string][]byte
+	puts     int
+	deletes  int
+	failLeft int           // 前n次调用失败
+	block    chan struct{} // 不为nil时，每次调用等待该chan
+}
+
+func (m *mockStorage) PutObject(key string, content []byte) error {
+	if m.block != nil {
+		<-m.block
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.puts++
+	if m.failLeft > 0 {
+		m.failLeft--
+		return errors.New("mock fail")
+	}
+	m.objects[key] = content
+	return nil
+}
+
+func (m *mockStorage) PutFile(key string, filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return m.PutObject(key, content)
+}
+
+func (m *mockStorage) DeleteObject(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.deletes++
+	delete(m.objects, key)
+	return nil
+}
+
+type uploadDone struct {
+	key string
+	err error
+}
+
+func TestUploader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_uploader_test")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	// 失败后重试，上传成功后删除本地文件
+	s := &mockStorage{objects: make(map[string][]byte), failLeft: 2}
+	doneChan := make(chan uploadDone, 16)
+	u := storage.NewUploader(s, storage.UploaderConfig{
+		KeyPrefix:       "origin1",
+		WorkerNum:       2,
+		MaxRetry:        2,
+		RetryIntervalMs: 1,
+	}).WithOnUploadDone(func(filename string, key string, err error) {
+		doneChan <- uploadDone{key: key, err: err}
+	})
+	filename := filepath.Join(dir, "1.flv")
+	assert.Equal(t, nil, ioutil.WriteFile(filename, []byte("flv"), 0666))
+	assert.Equal(t, nil, u.UploadFile(filename, true))
+	done := <-doneChan
+	assert.Equal(t, nil, done.err)
+	assert.Equal(t, storage.FilenameToKey("origin1", filename), done.key)
+	assert.Equal(t, []byte("flv"), s.objects[done.key])
+	assert.Equal(t, 3, s.puts)
+	_, err = os.Stat(filename)
+	assert.Equal(t, true, os.IsNotExist(err))
+
+	// 超过重试次数后放弃
+	s.failLeft = 3
+	assert.Equal(t, nil, u.UploadContent("hls/test/1.ts", []byte{1}))
+	done = <-doneChan
+	assert.IsNotNil(t, done.err)
+	stat := u.Stat()
+	assert.Equal(t, int64(1), stat.Succ)
+	assert.Equal(t, int64(1), stat.Fail)
+	assert.Equal(t, 0, stat.Pending)
+	assert.Equal(t, int64(0), stat.PendingBytes)
+	u.Dispose()
+	assert.Equal(t, base.ErrStorageDisposed, u.UploadContent("hls/test/1.ts", []byte{1}))
+}
+
+func TestUploaderBackpressure(t *testing.T) {
+	s := &mockStorage{objects: make(map[string][]byte), block: make(chan struct{})}
+	doneChan := make(chan uploadDone, 16)
+	u := storage.NewUploader(s, storage.UploaderConfig{
+		WorkerNum:  1,
+		QueueMaxMb: 1,
+	}).WithOnUploadDone(func(filename string, key string, err error) {
+		doneChan <- uploadDone{key: key, err: err}
+	})
+	defer u.Dispose()
+
+	// 第一个任务阻塞在上传中
+	assert.Equal(t, nil, u.UploadContent("hls/test/1.ts", make([]byte, 512*1024)))
+
+	// 排队中的m3u8只保留最新的内容
+	assert.Equal(t, nil, u.UploadContent("hls/test/playlist.m3u8", []byte("v1")))
+	assert.Equal(t, nil, u.UploadContent("hls/test/playlist.m3u8", []byte("v2")))
+	assert.Equal(t, 2, u.Stat().Pending)
+
+	// 超过上限时拒绝
+	assert.Equal(t, base.ErrStorageQueueFull, u.UploadContent("hls/test/2.ts", make([]byte, 600*1024)))
+	assert.Equal(t, int64(1), u.Stat().Rejected)
+
+	s.block <- struct{}{}
+	s.block <- struct{}{}
+	assert.Equal(t, "hls/test/1.ts", (<-doneChan).key)
+	assert.Equal(t, "hls/test/playlist.m3u8", (<-doneChan).key)
+	assert.Equal(t, []byte("v2"), s.objects["hls/test/playlist.m3u8"])
+	assert.Equal(t, 2, s.puts)
+
+	// 队列空了之后可以再次投递
+	assert.Equal(t, nil, u.UploadContent("hls/test/2.ts", make([]byte, 600*1024)))
+	s.block <- struct{}{}
+	assert.Equal(t, "hls/test/2.ts", (<-doneChan).key)
+}
+
+func TestUploaderDelete(t *testing.T) {
+	s := &mockStorage{objects: make(map[string][]byte), block: make(chan struct{})}
+	doneChan := make(chan uploadDone, 16)
+	u := storage.NewUploader(s, storage.UploaderConfig{
+		WorkerNum: 1,
+	}).WithOnUploadDone(func(filename string, key string, err error) {
+		doneChan <- uploadDone{key: key, err: err}
+	})
+	defer u.Dispose()
+
+	// 排队中的内容被删除时直接丢弃
+	assert.Equal(t, nil, u.UploadContent("hls/test/1.ts", []byte{1}))
+	assert.Equal(t, nil, u.UploadContent("hls/test/2.ts", []byte{2}))
+	assert.Equal(t, nil, u.Delete("hls/test/2.ts"))
+	assert.Equal(t, 2, u.Stat().Pending)
+	assert.Equal(t, int64(1), u.Stat().PendingBytes)
+
+	// 删除任务不回调
+	s.block <- struct{}{}
+	assert.Equal(t, "hls/test/1.ts", (<-doneChan).key)
+	assert.Equal(t, nil, u.Delete("hls/test/1.ts"))
+	for i := 0; i < 100 && u.Stat().Pending != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, u.Stat().Pending)
+	assert.Equal(t, 0, len(doneChan))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	assert.Equal(t, 1, s.puts)
+	assert.Equal(t, 2, s.deletes)
+	assert.Equal(t, 0, len(s.objects))
+}